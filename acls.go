@@ -27,6 +27,10 @@ const (
 	errInvalidTag        = Error("invalid tag")
 	errInvalidPortFormat = Error("invalid port format")
 	errWildcardIsNeeded  = Error("wildcard as port is required for the protocol")
+
+	// oidcGroupPrefix starts the aliases of the groups of the identity
+	// providers, as stored on the users at login: group:oidc:<name>
+	oidcGroupPrefix = "group:oidc:"
 )
 
 const (
@@ -100,22 +104,34 @@ func (h *Headscale) LoadACLPolicy(path string) error {
 	}
 	defer policyFile.Close()
 
-	var policy ACLPolicy
 	policyBytes, err := io.ReadAll(policyFile)
 	if err != nil {
 		return err
 	}
 
-	switch filepath.Ext(path) {
+	policy, err := parseACLPolicy(policyBytes, filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+
+	h.aclPolicy = policy
+
+	return h.UpdateACLRules()
+}
+
+// parseACLPolicy reads a policy in YAML, for the .yml and .yaml
+// extensions, or in HuJSON.
+func parseACLPolicy(policyBytes []byte, ext string) (*ACLPolicy, error) {
+	var policy ACLPolicy
+	switch ext {
 	case ".yml", ".yaml":
 		log.Debug().
-			Str("path", path).
 			Bytes("file", policyBytes).
 			Msg("Loading ACLs from YAML")
 
 		err := yaml.Unmarshal(policyBytes, &policy)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		log.Trace().
@@ -125,55 +141,126 @@ func (h *Headscale) LoadACLPolicy(path string) error {
 	default:
 		ast, err := hujson.Parse(policyBytes)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		ast.Standardize()
 		policyBytes = ast.Pack()
 		err = json.Unmarshal(policyBytes, &policy)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	if policy.IsZero() {
-		return errEmptyPolicy
+		return nil, errEmptyPolicy
 	}
 
-	h.aclPolicy = &policy
+	if err := validatePostures(policy.Postures); err != nil {
+		return nil, err
+	}
 
-	return h.UpdateACLRules()
+	return &policy, nil
+}
+
+// organizationACL is the policy applying to an organization, and the rules
+// generated from it for the machines of the organization.
+type organizationACL struct {
+	policy    *ACLPolicy
+	rules     []tailcfg.FilterRule
+	sshPolicy *tailcfg.SSHPolicy
+}
+
+// aclFor returns the ACL of an organization. Until the rules are generated,
+// the global policy applies and every machine of the organization can
+// reach the others.
+func (h *Headscale) aclFor(organizationID uint) *organizationACL {
+	h.organizationACLsMutex.RLock()
+	defer h.organizationACLsMutex.RUnlock()
+
+	if acl, ok := h.organizationACLs[organizationID]; ok {
+		return acl
+	}
+
+	return &organizationACL{
+		policy: h.aclPolicy,
+		rules:  tailcfg.FilterAllowAll,
+	}
 }
 
+// UpdateACLRules generates the rules of every organization from its own
+// policy, or from the global one, over the machines of the organization
+// only. errEmptyPolicy is returned when no organization has a policy.
 func (h *Headscale) UpdateACLRules() error {
-	machines, err := h.ListMachines()
+	organizations, err := h.ListOrganizations()
 	if err != nil {
 		return err
 	}
 
-	if h.aclPolicy == nil {
-		return errEmptyPolicy
-	}
-
-	rules, err := generateACLRules(machines, *h.aclPolicy, h.cfg.OIDC.StripEmaildomain)
+	machines, err := h.ListMachines()
 	if err != nil {
 		return err
 	}
-	log.Trace().Interface("ACL", rules).Msg("ACL rules generated")
-	h.aclRules = rules
 
-	if featureEnableSSH() {
-		sshRules, err := h.generateSSHRules()
+	acls := make(map[uint]*organizationACL, len(organizations))
+	for index := range organizations {
+		organization := &organizations[index]
+		policy, err := h.organizationPolicy(organization)
+		if err != nil {
+			return err
+		}
+		if policy == nil {
+			acls[organization.ID] = &organizationACL{rules: tailcfg.FilterAllowAll}
+
+			continue
+		}
+
+		organizationMachines := make([]Machine, 0)
+		for _, machine := range machines {
+			if machine.User.OrganizationID == organization.ID {
+				organizationMachines = append(organizationMachines, machine)
+			}
+		}
+
+		rules, err := generateACLRules(organizationMachines, *policy, h.cfg.OIDC.StripEmaildomain)
 		if err != nil {
 			return err
 		}
-		log.Trace().Interface("SSH", sshRules).Msg("SSH rules generated")
-		if h.sshPolicy == nil {
-			h.sshPolicy = &tailcfg.SSHPolicy{}
+		log.Trace().
+			Str("organization", organization.Name).
+			Interface("ACL", rules).
+			Msg("ACL rules generated")
+		acl := &organizationACL{policy: policy, rules: rules}
+
+		if featureEnableSSH() {
+			sshRules, err := h.generateSSHRules(organizationMachines, *policy)
+			if err != nil {
+				return err
+			}
+			log.Trace().
+				Str("organization", organization.Name).
+				Interface("SSH", sshRules).
+				Msg("SSH rules generated")
+			acl.sshPolicy = &tailcfg.SSHPolicy{Rules: sshRules}
+		} else if len(policy.SSHs) > 0 {
+			log.Info().Msg("SSH ACLs has been defined, but HEADSCALE_EXPERIMENTAL_FEATURE_SSH is not enabled, this is a unstable feature, check docs before activating")
 		}
-		h.sshPolicy.Rules = sshRules
-	} else if h.aclPolicy != nil && len(h.aclPolicy.SSHs) > 0 {
-		log.Info().Msg("SSH ACLs has been defined, but HEADSCALE_EXPERIMENTAL_FEATURE_SSH is not enabled, this is a unstable feature, check docs before activating")
+
+		acls[organization.ID] = acl
+	}
+
+	h.organizationACLsMutex.Lock()
+	h.organizationACLs = acls
+	h.organizationACLsMutex.Unlock()
+
+	if h.aclPolicy == nil {
+		for _, acl := range acls {
+			if acl.policy != nil {
+				return nil
+			}
+		}
+
+		return errEmptyPolicy
 	}
 
 	return nil
@@ -239,18 +326,12 @@ func generateACLRules(
 	return rules, nil
 }
 
-func (h *Headscale) generateSSHRules() ([]*tailcfg.SSHRule, error) {
+func (h *Headscale) generateSSHRules(
+	machines []Machine,
+	aclPolicy ACLPolicy,
+) ([]*tailcfg.SSHRule, error) {
 	rules := []*tailcfg.SSHRule{}
 
-	if h.aclPolicy == nil {
-		return nil, errEmptyPolicy
-	}
-
-	machines, err := h.ListMachines()
-	if err != nil {
-		return nil, err
-	}
-
 	acceptAction := tailcfg.SSHAction{
 		Message:                  "",
 		Reject:                   false,
@@ -271,7 +352,7 @@ func (h *Headscale) generateSSHRules() ([]*tailcfg.SSHRule, error) {
 		AllowLocalPortForwarding: false,
 	}
 
-	for index, sshACL := range h.aclPolicy.SSHs {
+	for index, sshACL := range aclPolicy.SSHs {
 		action := rejectAction
 		switch sshACL.Action {
 		case "accept":
@@ -288,14 +369,14 @@ func (h *Headscale) generateSSHRules() ([]*tailcfg.SSHRule, error) {
 			log.Error().
 				Msgf("Error parsing SSH %d, unknown action '%s'", index, sshACL.Action)
 
-			return nil, err
+			return nil, errInvalidAction
 		}
 
 		principals := make([]*tailcfg.SSHPrincipal, 0, len(sshACL.Sources))
 		for innerIndex, rawSrc := range sshACL.Sources {
 			expandedSrcs, err := expandAlias(
 				machines,
-				*h.aclPolicy,
+				aclPolicy,
 				rawSrc,
 				h.cfg.OIDC.StripEmaildomain,
 			)
@@ -361,7 +442,11 @@ func generateACLPolicyDest(
 	stripEmaildomain bool,
 ) ([]tailcfg.NetPortRange, error) {
 	tokens := strings.Split(dest, ":")
-	if len(tokens) < expectedTokenItems || len(tokens) > 3 {
+	maxTokens := 3
+	if strings.HasPrefix(dest, oidcGroupPrefix) {
+		maxTokens = 4
+	}
+	if len(tokens) < expectedTokenItems || len(tokens) > maxTokens {
 		return nil, errInvalidPortFormat
 	}
 
@@ -371,11 +456,12 @@ func generateACLPolicyDest(
 	// 192.168.1.0/24:22
 	// tag:montreal-webserver:80,443
 	// tag:api-server:443
+	// group:oidc:engineering:22
 	// example-host-1:*
 	if len(tokens) == expectedTokenItems {
 		alias = tokens[0]
 	} else {
-		alias = fmt.Sprintf("%s:%s", tokens[0], tokens[1])
+		alias = strings.Join(tokens[:len(tokens)-1], ":")
 	}
 
 	expanded, err := expandAlias(
@@ -477,6 +563,17 @@ func expandAlias(
 		Str("alias", alias).
 		Msg("Expanding")
 
+	if strings.HasPrefix(alias, oidcGroupPrefix) {
+		group := strings.TrimPrefix(alias, oidcGroupPrefix)
+		for _, machine := range machines {
+			if contains(machine.User.Groups, group) {
+				ips = append(ips, machine.IPAddresses.ToStringSlice()...)
+			}
+		}
+
+		return ips, nil
+	}
+
 	if strings.HasPrefix(alias, "group:") {
 		users, err := expandGroup(aclPolicy, alias, stripEmailDomain)
 		if err != nil {
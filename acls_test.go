@@ -138,15 +138,15 @@ func (s *Suite) TestSshRules(c *check.C) {
 	err = app.UpdateACLRules()
 
 	c.Assert(err, check.IsNil)
-	c.Assert(app.sshPolicy, check.NotNil)
-	c.Assert(app.sshPolicy.Rules, check.HasLen, 2)
-	c.Assert(app.sshPolicy.Rules[0].SSHUsers, check.HasLen, 1)
-	c.Assert(app.sshPolicy.Rules[0].Principals, check.HasLen, 1)
-	c.Assert(app.sshPolicy.Rules[0].Principals[0].NodeIP, check.Matches, "100.64.0.1")
-
-	c.Assert(app.sshPolicy.Rules[1].SSHUsers, check.HasLen, 1)
-	c.Assert(app.sshPolicy.Rules[1].Principals, check.HasLen, 1)
-	c.Assert(app.sshPolicy.Rules[1].Principals[0].NodeIP, check.Matches, "*")
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy, check.NotNil)
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy.Rules, check.HasLen, 2)
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy.Rules[0].SSHUsers, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy.Rules[0].Principals, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy.Rules[0].Principals[0].NodeIP, check.Matches, "100.64.0.1")
+
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy.Rules[1].SSHUsers, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy.Rules[1].Principals, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).sshPolicy.Rules[1].Principals[0].NodeIP, check.Matches, "*")
 }
 
 func (s *Suite) TestInvalidGroupInGroup(c *check.C) {
@@ -228,9 +228,9 @@ func (s *Suite) TestValidExpandTagOwnersInSources(c *check.C) {
 	}
 	err = app.UpdateACLRules()
 	c.Assert(err, check.IsNil)
-	c.Assert(app.aclRules, check.HasLen, 1)
-	c.Assert(app.aclRules[0].SrcIPs, check.HasLen, 1)
-	c.Assert(app.aclRules[0].SrcIPs[0], check.Equals, "100.64.0.1")
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].SrcIPs, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].SrcIPs[0], check.Equals, "100.64.0.1")
 }
 
 // this test should validate that we can expand a group in a TagOWner section and
@@ -278,9 +278,9 @@ func (s *Suite) TestValidExpandTagOwnersInDestinations(c *check.C) {
 	}
 	err = app.UpdateACLRules()
 	c.Assert(err, check.IsNil)
-	c.Assert(app.aclRules, check.HasLen, 1)
-	c.Assert(app.aclRules[0].DstPorts, check.HasLen, 1)
-	c.Assert(app.aclRules[0].DstPorts[0].IP, check.Equals, "100.64.0.1")
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts[0].IP, check.Equals, "100.64.0.1")
 }
 
 // need a test with:
@@ -327,9 +327,9 @@ func (s *Suite) TestInvalidTagValidUser(c *check.C) {
 	}
 	err = app.UpdateACLRules()
 	c.Assert(err, check.IsNil)
-	c.Assert(app.aclRules, check.HasLen, 1)
-	c.Assert(app.aclRules[0].SrcIPs, check.HasLen, 1)
-	c.Assert(app.aclRules[0].SrcIPs[0], check.Equals, "100.64.0.1")
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].SrcIPs, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].SrcIPs[0], check.Equals, "100.64.0.1")
 }
 
 // tag on a host is owned by a tag owner, the tag is valid.
@@ -395,16 +395,16 @@ func (s *Suite) TestValidTagInvalidUser(c *check.C) {
 	}
 	err = app.UpdateACLRules()
 	c.Assert(err, check.IsNil)
-	c.Assert(app.aclRules, check.HasLen, 1)
-	c.Assert(app.aclRules[0].SrcIPs, check.HasLen, 1)
-	c.Assert(app.aclRules[0].SrcIPs[0], check.Equals, "100.64.0.2")
-	c.Assert(app.aclRules[0].DstPorts, check.HasLen, 2)
-	c.Assert(app.aclRules[0].DstPorts[0].Ports.First, check.Equals, uint16(80))
-	c.Assert(app.aclRules[0].DstPorts[0].Ports.Last, check.Equals, uint16(80))
-	c.Assert(app.aclRules[0].DstPorts[0].IP, check.Equals, "100.64.0.1")
-	c.Assert(app.aclRules[0].DstPorts[1].Ports.First, check.Equals, uint16(443))
-	c.Assert(app.aclRules[0].DstPorts[1].Ports.Last, check.Equals, uint16(443))
-	c.Assert(app.aclRules[0].DstPorts[1].IP, check.Equals, "100.64.0.1")
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].SrcIPs, check.HasLen, 1)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].SrcIPs[0], check.Equals, "100.64.0.2")
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts, check.HasLen, 2)
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts[0].Ports.First, check.Equals, uint16(80))
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts[0].Ports.Last, check.Equals, uint16(80))
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts[0].IP, check.Equals, "100.64.0.1")
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts[1].Ports.First, check.Equals, uint16(443))
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts[1].Ports.Last, check.Equals, uint16(443))
+	c.Assert(app.aclFor(app.defaultOrganizationID).rules[0].DstPorts[1].IP, check.Equals, "100.64.0.1")
 }
 
 func (s *Suite) TestPortRange(c *check.C) {
@@ -976,6 +976,36 @@ func Test_expandAlias(t *testing.T) {
 			want:    []string{"100.64.0.1", "100.64.0.2", "100.64.0.3"},
 			wantErr: false,
 		},
+		{
+			name: "oidc group",
+			args: args{
+				alias: "group:oidc:accounting",
+				machines: []Machine{
+					{
+						IPAddresses: MachineAddresses{
+							netip.MustParseAddr("100.64.0.1"),
+						},
+						User: User{Name: "joe", Groups: StringList{"accounting", "sales"}},
+					},
+					{
+						IPAddresses: MachineAddresses{
+							netip.MustParseAddr("100.64.0.2"),
+						},
+						User: User{Name: "marc", Groups: StringList{"sales"}},
+					},
+					{
+						IPAddresses: MachineAddresses{
+							netip.MustParseAddr("100.64.0.3"),
+						},
+						User: User{Name: "mickael"},
+					},
+				},
+				aclPolicy:        ACLPolicy{},
+				stripEmailDomain: true,
+			},
+			want:    []string{"100.64.0.1"},
+			wantErr: false,
+		},
 		{
 			name: "wrong group",
 			args: args{
@@ -18,6 +18,7 @@ type ACLPolicy struct {
 	Tests         []ACLTest     `json:"tests"         yaml:"tests"`
 	AutoApprovers AutoApprovers `json:"autoApprovers" yaml:"autoApprovers"`
 	SSHs          []SSH         `json:"ssh"           yaml:"ssh"`
+	Postures      []Posture     `json:"postures"      yaml:"postures"`
 }
 
 // ACL is a basic rule for the ACL Policy.
@@ -60,6 +61,16 @@ type SSH struct {
 	CheckPeriod  string   `json:"checkPeriod,omitempty" yaml:"checkPeriod,omitempty"`
 }
 
+// Posture describes the requirements a machine has to fulfil to join the tailnet.
+// Every non empty condition has to be met, otherwise Action is applied.
+type Posture struct {
+	Name       string   `json:"name"                 yaml:"name"`
+	Action     string   `json:"action"               yaml:"action"`
+	OS         []string `json:"os,omitempty"         yaml:"os,omitempty"`
+	MinVersion string   `json:"minVersion,omitempty" yaml:"minVersion,omitempty"`
+	Hostname   string   `json:"hostname,omitempty"   yaml:"hostname,omitempty"`
+}
+
 // UnmarshalJSON allows to parse the Hosts directly into netip objects.
 func (hosts *Hosts) UnmarshalJSON(data []byte) error {
 	newHosts := Hosts{}
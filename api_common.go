@@ -57,13 +57,17 @@ func (h *Headscale) generateMapResponse(
 	}
 
 	//cgao6: use User's DNSconfig instead
-	dnsConfig := getMapResponseDNSConfig(
-		h.cfg.IPPrefixes, //
+	organizationID := h.machineOrganizationID(machine)
+	acl := h.aclFor(organizationID)
+
+	dnsConfig := h.getMapResponseDNSConfig(
+		h.organizationIPPrefixes(organizationID), //
 		//		h.cfg.DNSConfig,
 		//		h.cfg.BaseDomain,
 		*machine,
 		peers,
 	)
+	baseDomain, _ := h.userMagicDNS(&machine.User)
 
 	now := time.Now()
 
@@ -89,22 +93,24 @@ func (h *Headscale) generateMapResponse(
 		DNSConfig: dnsConfig,
 
 		// TODO: Only send if updated
-		Domain: h.cfg.BaseDomain,
+		Domain: baseDomain,
 
 		// Do not instruct clients to collect services, we do not
 		// support or do anything with them
 		CollectServices: "false",
 
 		// TODO: Only send if updated
-		PacketFilter: h.aclRules,
+		PacketFilter: acl.rules,
 
 		UserProfiles: profiles,
 
 		// TODO: Only send if updated
-		SSHPolicy: h.sshPolicy,
+		SSHPolicy: acl.sshPolicy,
 
 		ControlTime: &now,
 
+		Health: h.machineHealth(*machine),
+
 		Debug: &tailcfg.Debug{
 			DisableLogTail:      !h.cfg.LogTail.Enabled,
 			RandomizeClientPort: h.cfg.RandomizeClientPort,
@@ -15,8 +15,131 @@ const (
 	apiKeyLength    = 32
 
 	ErrAPIKeyFailedToParse = Error("Failed to parse ApiKey")
+	ErrInvalidAPIKeyScope  = Error("Invalid ApiKey scope")
+	ErrAPIKeyScopeDenied   = Error("ApiKey scope does not allow the method")
 )
 
+// APIKeyScope limits the gRPC methods an API key can call, on top of the
+// role of the user owning the key. A write scope includes the read scope
+// of the same resource.
+type APIKeyScope string
+
+const (
+	APIKeyScopeAll           APIKeyScope = "all"
+	APIKeyScopeUsersRead     APIKeyScope = "users:read"
+	APIKeyScopeUsersWrite    APIKeyScope = "users:write"
+	APIKeyScopeRolesWrite    APIKeyScope = "roles:write"
+	APIKeyScopeKeysRead      APIKeyScope = "keys:read"
+	APIKeyScopeKeysWrite     APIKeyScope = "keys:write"
+	APIKeyScopeMachinesRead  APIKeyScope = "machines:read"
+	APIKeyScopeMachinesWrite APIKeyScope = "machines:write"
+	APIKeyScopeRoutesRead    APIKeyScope = "routes:read"
+	APIKeyScopeRoutesWrite   APIKeyScope = "routes:write"
+	APIKeyScopeAPIKeysRead   APIKeyScope = "apikeys:read"
+	APIKeyScopeAPIKeysWrite  APIKeyScope = "apikeys:write"
+	APIKeyScopeSessionsRead  APIKeyScope = "sessions:read"
+	APIKeyScopeSessionsWrite APIKeyScope = "sessions:write"
+	APIKeyScopeDNSRead       APIKeyScope = "dns:read"
+	APIKeyScopeDNSWrite      APIKeyScope = "dns:write"
+)
+
+var apiKeyScopes = []APIKeyScope{
+	APIKeyScopeAll,
+	APIKeyScopeUsersRead,
+	APIKeyScopeUsersWrite,
+	APIKeyScopeRolesWrite,
+	APIKeyScopeKeysRead,
+	APIKeyScopeKeysWrite,
+	APIKeyScopeMachinesRead,
+	APIKeyScopeMachinesWrite,
+	APIKeyScopeRoutesRead,
+	APIKeyScopeRoutesWrite,
+	APIKeyScopeAPIKeysRead,
+	APIKeyScopeAPIKeysWrite,
+	APIKeyScopeSessionsRead,
+	APIKeyScopeSessionsWrite,
+	APIKeyScopeDNSRead,
+	APIKeyScopeDNSWrite,
+}
+
+// grpcMethodScopes is the scope required by each gRPC method, methods not
+// listed need the all scope and ACLPingPong needs none.
+var grpcMethodScopes = map[string]APIKeyScope{
+	"ACLPingPong":           "",
+	"GetUser":               APIKeyScopeUsersRead,
+	"ListUsers":             APIKeyScopeUsersRead,
+	"CreateUser":            APIKeyScopeUsersWrite,
+	"RenameUser":            APIKeyScopeUsersWrite,
+	"DeleteUser":            APIKeyScopeUsersWrite,
+	"SetUserQuota":          APIKeyScopeUsersWrite,
+	"SetUserPassword":       APIKeyScopeUsersWrite,
+	"SetUserMagicDNS":       APIKeyScopeUsersWrite,
+	"SetUserRole":           APIKeyScopeRolesWrite,
+	"ListPreAuthKeys":       APIKeyScopeKeysRead,
+	"CreatePreAuthKey":      APIKeyScopeKeysWrite,
+	"ExpirePreAuthKey":      APIKeyScopeKeysWrite,
+	"RevokePreAuthKey":      APIKeyScopeKeysWrite,
+	"GetMachine":            APIKeyScopeMachinesRead,
+	"ListMachines":          APIKeyScopeMachinesRead,
+	"SetTags":               APIKeyScopeMachinesWrite,
+	"RegisterMachine":       APIKeyScopeMachinesWrite,
+	"DeleteMachine":         APIKeyScopeMachinesWrite,
+	"ExpireMachine":         APIKeyScopeMachinesWrite,
+	"RenameMachine":         APIKeyScopeMachinesWrite,
+	"MoveMachine":           APIKeyScopeMachinesWrite,
+	"GetMachineHistory":     APIKeyScopeMachinesRead,
+	"GetRoutes":             APIKeyScopeRoutesRead,
+	"GetMachineRoutes":      APIKeyScopeRoutesRead,
+	"EnableRoute":           APIKeyScopeRoutesWrite,
+	"DisableRoute":          APIKeyScopeRoutesWrite,
+	"ListApiKeys":           APIKeyScopeAPIKeysRead,
+	"CreateApiKey":          APIKeyScopeAPIKeysWrite,
+	"ExpireApiKey":          APIKeyScopeAPIKeysWrite,
+	"ListConsoleSessions":   APIKeyScopeSessionsRead,
+	"RevokeConsoleSessions": APIKeyScopeSessionsWrite,
+	"ListDNSRecords":        APIKeyScopeDNSRead,
+	"CreateDNSRecord":       APIKeyScopeDNSWrite,
+	"UpdateDNSRecord":       APIKeyScopeDNSWrite,
+	"DeleteDNSRecord":       APIKeyScopeDNSWrite,
+}
+
+// ParseAPIKeyScopes returns the scopes named in s, all when s is empty.
+func ParseAPIKeyScopes(s []string) ([]APIKeyScope, error) {
+	if len(s) == 0 {
+		return []APIKeyScope{APIKeyScopeAll}, nil
+	}
+
+	scopes := make([]APIKeyScope, 0, len(s))
+	for _, name := range s {
+		scope := APIKeyScope(strings.TrimSpace(name))
+		valid := false
+		for _, known := range apiKeyScopes {
+			if scope == known {
+				valid = true
+
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAPIKeyScope, name)
+		}
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
+
+// includes reports whether the scope grants the required one.
+func (scope APIKeyScope) includes(required APIKeyScope) bool {
+	if scope == APIKeyScopeAll || required == "" || scope == required {
+		return true
+	}
+
+	resource, access, _ := strings.Cut(string(required), ":")
+
+	return access == "read" && scope == APIKeyScope(resource+":write")
+}
+
 // APIKey describes the datamodel for API keys used to remotely authenticate with
 // headscale.
 type APIKey struct {
@@ -24,14 +147,91 @@ type APIKey struct {
 	Prefix string `gorm:"uniqueIndex"`
 	Hash   []byte
 
+	// User owning the key, whose role applies to the requests. Keys
+	// without a user are created by the server administrator and act as
+	// owners.
+	UserID uint `gorm:"index"`
+	Scopes StringList
+
+	// OAuth client which issued the key as a short-lived access token
+	OauthClientID uint64 `gorm:"index"`
+
 	CreatedAt  *time.Time
 	Expiration *time.Time
 	LastSeen   *time.Time
 }
 
-// CreateAPIKey creates a new ApiKey in a user, and returns it.
+// scopes returns the scopes of the key, keys created before the scopes
+// existed have them all.
+func (key *APIKey) scopes() []APIKeyScope {
+	if len(key.Scopes) == 0 {
+		return []APIKeyScope{APIKeyScopeAll}
+	}
+	scopes := make([]APIKeyScope, len(key.Scopes))
+	for index, scope := range key.Scopes {
+		scopes[index] = APIKeyScope(scope)
+	}
+
+	return scopes
+}
+
+// allows reports whether the scopes of the key grant the scope.
+func (key *APIKey) allows(required APIKeyScope) bool {
+	for _, scope := range key.scopes() {
+		if scope.includes(required) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizeAPIKeyMethod checks the scopes of a key against the full gRPC
+// method name, /headscale.v1.HeadscaleService/ListUsers.
+func authorizeAPIKeyMethod(key *APIKey, fullMethod string) error {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+
+	required, ok := grpcMethodScopes[method]
+	if !ok {
+		required = APIKeyScopeAll
+	}
+	if !key.allows(required) {
+		return fmt.Errorf("%w: %s requires the %s scope", ErrAPIKeyScopeDenied, method, required)
+	}
+
+	return nil
+}
+
+// CreateAPIKey creates a new ApiKey acting as an owner, and returns it.
 func (h *Headscale) CreateAPIKey(
 	expiration *time.Time,
+) (string, *APIKey, error) {
+	return h.createAPIKey(nil, nil, nil, expiration)
+}
+
+// CreateUserAPIKey creates a new ApiKey of a user, limited to the scopes
+// and to the role of the user, and returns it.
+func (h *Headscale) CreateUserAPIKey(
+	userName string,
+	scopes []APIKeyScope,
+	expiration *time.Time,
+) (string, *APIKey, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return "", nil, err
+	}
+	if user.Disabled {
+		return "", nil, ErrUserDisabled
+	}
+
+	return h.createAPIKey(user, nil, scopes, expiration)
+}
+
+func (h *Headscale) createAPIKey(
+	user *User,
+	client *OAuthClient,
+	scopes []APIKeyScope,
+	expiration *time.Time,
 ) (string, *APIKey, error) {
 	prefix, err := GenerateRandomStringURLSafe(apiPrefixLength)
 	if err != nil {
@@ -56,6 +256,20 @@ func (h *Headscale) CreateAPIKey(
 		Hash:       hash,
 		Expiration: expiration,
 	}
+	if user != nil {
+		key.UserID = user.ID
+	}
+	if client != nil {
+		key.OauthClientID = client.ID
+	}
+	for _, scope := range scopes {
+		if scope == APIKeyScopeAll {
+			key.Scopes = nil
+
+			break
+		}
+		key.Scopes = append(key.Scopes, string(scope))
+	}
 
 	if err := h.db.Save(&key).Error; err != nil {
 		return "", nil, fmt.Errorf("failed to save API key to database: %w", err)
@@ -64,7 +278,7 @@ func (h *Headscale) CreateAPIKey(
 	return keyStr, &key, nil
 }
 
-// ListAPIKeys returns the list of ApiKeys for a user.
+// ListAPIKeys returns the list of ApiKeys.
 func (h *Headscale) ListAPIKeys() ([]APIKey, error) {
 	keys := []APIKey{}
 	if err := h.db.Find(&keys).Error; err != nil {
@@ -74,6 +288,22 @@ func (h *Headscale) ListAPIKeys() ([]APIKey, error) {
 	return keys, nil
 }
 
+// ListUserAPIKeys returns the list of ApiKeys of a user, without the
+// access tokens of their OAuth clients.
+func (h *Headscale) ListUserAPIKeys(userName string) ([]APIKey, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	if err := h.db.Where("user_id = ? AND oauth_client_id = 0", user.ID).Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
 // GetAPIKey returns a ApiKey for a given key.
 func (h *Headscale) GetAPIKey(prefix string) (*APIKey, error) {
 	key := APIKey{}
@@ -114,25 +344,32 @@ func (h *Headscale) ExpireAPIKey(key *APIKey) error {
 }
 
 func (h *Headscale) ValidateAPIKey(keyStr string) (bool, error) {
+	key, err := h.checkAPIKey(keyStr)
+
+	return key != nil, err
+}
+
+// checkAPIKey returns the ApiKey of keyStr, nil if it has expired.
+func (h *Headscale) checkAPIKey(keyStr string) (*APIKey, error) {
 	prefix, hash, found := strings.Cut(keyStr, ".")
 	if !found {
-		return false, ErrAPIKeyFailedToParse
+		return nil, ErrAPIKeyFailedToParse
 	}
 
 	key, err := h.GetAPIKey(prefix)
 	if err != nil {
-		return false, fmt.Errorf("failed to validate api key: %w", err)
+		return nil, fmt.Errorf("failed to validate api key: %w", err)
 	}
 
 	if key.Expiration.Before(time.Now()) {
-		return false, nil
+		return nil, nil
 	}
 
 	if err := bcrypt.CompareHashAndPassword(key.Hash, []byte(hash)); err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return true, nil
+	return key, nil
 }
 
 func (key *APIKey) toProto() *v1.ApiKey {
@@ -140,6 +377,9 @@ func (key *APIKey) toProto() *v1.ApiKey {
 		Id:     key.ID,
 		Prefix: key.Prefix,
 	}
+	for _, scope := range key.scopes() {
+		protoKey.Scopes = append(protoKey.Scopes, string(scope))
+	}
 
 	if key.Expiration != nil {
 		protoKey.Expiration = timestamppb.New(*key.Expiration)
@@ -1,8 +1,13 @@
 package headscale
 
 import (
+	"context"
+	"errors"
+	"testing"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gopkg.in/check.v1"
 )
 
@@ -87,3 +92,114 @@ func (*Suite) TestExpireAPIKey(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(notValid, check.Equals, false)
 }
+
+func Test_authorizeAPIKeyMethod(t *testing.T) {
+	tests := []struct {
+		scopes  StringList
+		method  string
+		wantErr bool
+	}{
+		{nil, "/headscale.v1.HeadscaleService/DebugCreateMachine", false},
+		{StringList{"all"}, "/headscale.v1.HeadscaleService/DeleteUser", false},
+		{StringList{"machines:read"}, "/headscale.v1.HeadscaleService/ListMachines", false},
+		{StringList{"machines:read"}, "/headscale.v1.HeadscaleService/ExpireMachine", true},
+		{StringList{"machines:write"}, "/headscale.v1.HeadscaleService/ListMachines", false},
+		{StringList{"routes:write"}, "/headscale.v1.HeadscaleService/EnableRoute", false},
+		{StringList{"routes:write"}, "/headscale.v1.HeadscaleService/ListMachines", true},
+		{StringList{"keys:write"}, "/headscale.v1.HeadscaleService/CreatePreAuthKey", false},
+		{StringList{"keys:write"}, "/headscale.v1.HeadscaleService/CreateApiKey", true},
+		{StringList{"users:read"}, "/headscale.v1.HeadscaleService/ACLPingPong", false},
+		{StringList{"users:write"}, "/headscale.v1.HeadscaleService/DebugCreateMachine", true},
+	}
+
+	for _, test := range tests {
+		key := APIKey{Scopes: test.scopes}
+		err := authorizeAPIKeyMethod(&key, test.method)
+		if (err != nil) != test.wantErr {
+			t.Errorf("authorizeAPIKeyMethod(%v, %s) error = %v, wantErr %v",
+				test.scopes, test.method, err, test.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrAPIKeyScopeDenied) {
+			t.Errorf("authorizeAPIKeyMethod(%v, %s) error = %v, want ErrAPIKeyScopeDenied",
+				test.scopes, test.method, err)
+		}
+	}
+}
+
+func TestParseAPIKeyScopes(t *testing.T) {
+	scopes, err := ParseAPIKeyScopes(nil)
+	if err != nil || len(scopes) != 1 || scopes[0] != APIKeyScopeAll {
+		t.Errorf("ParseAPIKeyScopes(nil) = %v, %v, want [all]", scopes, err)
+	}
+
+	scopes, err = ParseAPIKeyScopes([]string{"machines:read", " routes:write"})
+	if err != nil || len(scopes) != 2 || scopes[1] != APIKeyScopeRoutesWrite {
+		t.Errorf("ParseAPIKeyScopes() = %v, %v", scopes, err)
+	}
+
+	if _, err := ParseAPIKeyScopes([]string{"machines:delete"}); !errors.Is(err, ErrInvalidAPIKeyScope) {
+		t.Errorf("ParseAPIKeyScopes() error = %v, want ErrInvalidAPIKeyScope", err)
+	}
+}
+
+func (*Suite) TestUserAPIKey(c *check.C) {
+	user, err := app.CreateUser("ci", "ci-uid", "CI")
+	c.Assert(err, check.IsNil)
+	user, err = app.SetUserServiceAccount(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.ServiceAccount, check.Equals, true)
+	_, err = app.SetUserRole(RoleOwner, user.Name, RoleITAdmin)
+	c.Assert(err, check.IsNil)
+
+	expiration := time.Now().Add(time.Hour)
+	keyStr, key, err := app.CreateUserAPIKey(
+		user.Name,
+		[]APIKeyScope{APIKeyScopeMachinesRead, APIKeyScopeRoutesWrite},
+		&expiration,
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.UserID, check.Equals, user.ID)
+
+	keys, err := app.ListUserAPIKeys(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	c.Assert([]string(keys[0].Scopes), check.DeepEquals, []string{"machines:read", "routes:write"})
+
+	authorize := func(method string) codes.Code {
+		ctx, err := app.authorizeGRPCToken(
+			context.Background(), keyStr, "/headscale.v1.HeadscaleService/"+method, "test",
+		)
+		if err != nil {
+			return status.Code(err)
+		}
+		caller, ok := grpcCallerFromContext(ctx)
+		c.Assert(ok, check.Equals, true)
+		c.Assert(caller.User.ID, check.Equals, user.ID)
+		c.Assert(caller.Role, check.Equals, RoleITAdmin)
+
+		return codes.OK
+	}
+
+	c.Assert(authorize("ListMachines"), check.Equals, codes.OK)
+	// Allowed by the scopes but not by the role of the user
+	c.Assert(authorize("EnableRoute"), check.Equals, codes.PermissionDenied)
+	// Allowed by the role of the user but not by the scopes
+	c.Assert(authorize("ExpireMachine"), check.Equals, codes.PermissionDenied)
+
+	// Service accounts cannot log in
+	_, err = app.findOrCreateUserWithInvite(&Identity{
+		Provider: "default",
+		Subject:  "ci-subject",
+		UserName: user.Name,
+	}, nil)
+	c.Assert(err, check.Equals, ErrServiceAccount)
+
+	c.Assert(app.DisableUser(user.Name), check.IsNil)
+	c.Assert(authorize("ListMachines"), check.Equals, codes.Unauthenticated)
+
+	_, err = app.DeleteUser(user.Name, "", false)
+	c.Assert(err, check.IsNil)
+	allKeys, err := app.ListAPIKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(allKeys, check.HasLen, 0)
+}
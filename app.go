@@ -19,7 +19,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gorilla/mux"
 	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
@@ -32,7 +31,6 @@ import (
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
-	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -89,17 +87,21 @@ type Headscale struct {
 	DERPMap    *tailcfg.DERPMap
 	DERPServer *DERPServer
 
+	// aclPolicy is the global policy, from acl_policy_path
 	aclPolicy *ACLPolicy
-	aclRules  []tailcfg.FilterRule
-	sshPolicy *tailcfg.SSHPolicy
+
+	organizationACLs      map[uint]*organizationACL
+	organizationACLsMutex sync.RWMutex
+	defaultOrganizationID uint
 
 	lastStateChange *xsync.MapOf[string, time.Time]
 
-	oidcProvider *oidc.Provider
-	oauth2Config *oauth2.Config
+	identityProviders []*identityProvider
 
-	loginCache   *cache.Cache
-	smsCodeCache *cache.Cache
+	loginCache    *cache.Cache
+	smsVerifier   *smsVerifier
+	userDirectory UserDirectory
+	rateLimits    *rateLimits
 
 	registrationCache *cache.Cache
 
@@ -164,10 +166,10 @@ func NewHeadscale(cfg *Config) (*Headscale, error) {
 		registerCacheExpiration,
 		registerCacheCleanup,
 	)
-	smsCodeCache := cache.New(
-		smsCacheExpiration,
-		smsCacheCleanup,
-	)
+	smsSender, err := newSMSSender(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	app := Headscale{
 		cfg:                cfg,
@@ -175,21 +177,33 @@ func NewHeadscale(cfg *Config) (*Headscale, error) {
 		dbString:           dbString,
 		privateKey:         privateKey,
 		noisePrivateKey:    noisePrivateKey,
-		aclRules:           tailcfg.FilterAllowAll, // default allowall
 		loginCache:         loginCache,
-		smsCodeCache:       smsCodeCache,
+		smsVerifier:        newSMSVerifier(smsSender, smsCacheExpiration, smsCacheCleanup),
 		registrationCache:  registrationCache,
+		rateLimits:         newRateLimits(cfg.RateLimit),
 		pollNetMapStreamWG: sync.WaitGroup{},
 		lastStateChange:    xsync.NewMapOf[time.Time](),
 	}
 
+	app.userDirectory, err = newUserDirectory(&app)
+	if err != nil {
+		return nil, err
+	}
+
 	err = app.initDB()
 	if err != nil {
 		return nil, err
 	}
 
-	if cfg.OIDC.Issuer != "" {
-		err = app.initOIDC()
+	// The policies of the organizations live in the database, they apply
+	// from the start, with or without a policy file
+	err = app.UpdateACLRules()
+	if err != nil && !errors.Is(err, errEmptyPolicy) {
+		return nil, err
+	}
+
+	if cfg.OIDC.Issuer != "" || len(cfg.IdentityProviders) > 0 {
+		err = app.initIdentityProviders()
 		if err != nil {
 			if cfg.OIDC.OnlyStartIfOIDCIsAvailable {
 				return nil, err
@@ -284,7 +298,7 @@ func (h *Headscale) expireEphemeralNodesWorker() {
 					Str("machine", machine.Hostname).
 					Msg("Ephemeral client removed from database")
 
-				err = h.db.Unscoped().Delete(machine).Error
+				err = h.HardDeleteMachine(&machine)
 				if err != nil {
 					log.Error().
 						Err(err).
@@ -404,25 +418,137 @@ func (h *Headscale) grpcAuthenticationInterceptor(ctx context.Context,
 		)
 	}
 
-	valid, err := h.ValidateAPIKey(strings.TrimPrefix(token, AuthPrefix))
+	clientIP := client.Addr.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	if locked, _ := h.rateLimits.lockedOut(apiKeyEndpoint, clientIP); locked {
+		return ctx, status.Error(codes.ResourceExhausted, "too many failed attempts")
+	}
+
+	ctx, err := h.authorizeGRPCToken(
+		ctx,
+		strings.TrimPrefix(token, AuthPrefix),
+		info.FullMethod,
+		client.Addr.String(),
+	)
+	switch status.Code(err) {
+	case codes.OK:
+		h.rateLimits.success(apiKeyEndpoint, clientIP)
+	case codes.Unauthenticated, codes.Internal:
+		h.rateLimits.failure(apiKeyEndpoint, clientIP)
+	}
+	if err != nil {
+		return ctx, err
+	}
+
+	return handler(ctx, req)
+}
+
+// authorizeGRPCToken checks the API key of a gRPC request against the role
+// of the user owning it and against its scopes, and sets the caller of the
+// request. Keys without a user act as owners.
+func (h *Headscale) authorizeGRPCToken(
+	ctx context.Context,
+	token string,
+	fullMethod string,
+	clientAddress string,
+) (context.Context, error) {
+	key, err := h.checkAPIKey(token)
 	if err != nil {
 		log.Error().
 			Caller().
 			Err(err).
-			Str("client_address", client.Addr.String()).
+			Str("client_address", clientAddress).
 			Msg("failed to validate token")
 
 		return ctx, status.Error(codes.Internal, "failed to validate token")
 	}
 
-	if !valid {
+	if key == nil {
 		log.Info().
-			Str("client_address", client.Addr.String()).
+			Str("client_address", clientAddress).
 			Msg("invalid token")
 
 		return ctx, status.Error(codes.Unauthenticated, "invalid token")
 	}
 
+	caller := grpcCaller{Role: RoleOwner, APIKey: key}
+	if key.UserID != 0 {
+		user := &User{}
+		if err := h.db.First(user, key.UserID).Error; err != nil || user.Disabled {
+			log.Info().
+				Str("client_address", clientAddress).
+				Str("prefix", key.Prefix).
+				Msg("token of an unknown or disabled user")
+
+			return ctx, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		// The gRPC API manages the whole server, the keys of the users
+		// of the other organizations are not accepted
+		role, ok := h.organizationRole(user, h.defaultOrganizationID)
+		if !ok {
+			return ctx, status.Error(codes.PermissionDenied, ErrPermissionDenied.Error())
+		}
+		caller.Role = role
+		caller.User = user
+	}
+
+	if err := authorizeGRPCMethod(caller.Role, fullMethod); err != nil {
+		log.Info().
+			Caller().
+			Str("client_address", clientAddress).
+			Str("method", fullMethod).
+			Msg("permission denied")
+
+		return ctx, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := authorizeAPIKeyMethod(key, fullMethod); err != nil {
+		log.Info().
+			Caller().
+			Str("client_address", clientAddress).
+			Str("method", fullMethod).
+			Str("prefix", key.Prefix).
+			Msg("scope denied")
+
+		return ctx, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return context.WithValue(ctx, grpcCallerKey{}, caller), nil
+}
+
+// grpcSocketInterceptor authorizes the requests forwarded by the gateway
+// with the API key of the HTTP request. The local CLI sends no token and
+// is trusted.
+func (h *Headscale) grpcSocketInterceptor(ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	meta, _ := metadata.FromIncomingContext(ctx)
+	authHeader := meta.Get("authorization")
+	if len(authHeader) == 0 {
+		return handler(ctx, req)
+	}
+
+	if !strings.HasPrefix(authHeader[0], AuthPrefix) {
+		return ctx, status.Error(
+			codes.Unauthenticated,
+			`missing "Bearer " prefix in "Authorization" header`,
+		)
+	}
+
+	ctx, err := h.authorizeGRPCToken(
+		ctx,
+		strings.TrimPrefix(authHeader[0], AuthPrefix),
+		info.FullMethod,
+		"unix",
+	)
+	if err != nil {
+		return ctx, err
+	}
+
 	return handler(ctx, req)
 }
 
@@ -436,6 +562,13 @@ func (h *Headscale) httpAuthenticationMiddleware(next http.Handler) http.Handler
 			Str("client_address", req.RemoteAddr).
 			Msg("HTTP authentication invoked")
 
+		clientIP := h.clientIP(req)
+		if locked, retryAfter := h.rateLimits.lockedOut(apiKeyEndpoint, clientIP); locked {
+			writeTooManyRequests(writer, retryAfter)
+
+			return
+		}
+
 		authHeader := req.Header.Get("authorization")
 
 		if !strings.HasPrefix(authHeader, AuthPrefix) {
@@ -456,6 +589,9 @@ func (h *Headscale) httpAuthenticationMiddleware(next http.Handler) http.Handler
 		}
 
 		valid, err := h.ValidateAPIKey(strings.TrimPrefix(authHeader, AuthPrefix))
+		if err != nil || !valid {
+			h.rateLimits.failure(apiKeyEndpoint, clientIP)
+		}
 		if err != nil {
 			log.Error().
 				Caller().
@@ -491,6 +627,7 @@ func (h *Headscale) httpAuthenticationMiddleware(next http.Handler) http.Handler
 
 			return
 		}
+		h.rateLimits.success(apiKeyEndpoint, clientIP)
 
 		next.ServeHTTP(writer, req)
 	})
@@ -532,8 +669,18 @@ func (h *Headscale) createRouter(grpcMux *runtime.ServeMux) *mux.Router {
 		log.Fatal().Msg(err.Error())
 	}
 
-	router.PathPrefix("/login").HandlerFunc(h.doLogin).Methods(http.MethodPost)
-	router.PathPrefix("/api/register").HandlerFunc(h.RegisterUserAPI).Methods(http.MethodPost)
+	router.Use(h.securityHeaders)
+
+	router.HandleFunc("/login/providers", h.ListIdentityProvidersAPI).Methods(http.MethodGet)
+	router.HandleFunc("/login/local", h.sameOriginOnly(h.rateLimited("login", h.LocalLoginAPI))).
+		Methods(http.MethodPost)
+	router.PathPrefix("/login").HandlerFunc(h.sameOriginOnly(h.rateLimited("login", h.doLogin))).
+		Methods(http.MethodPost)
+	router.PathPrefix("/api/register").
+		HandlerFunc(h.sameOriginOnly(h.rateLimited("register", h.RegisterUserAPI))).
+		Methods(http.MethodPost)
+	router.HandleFunc(inviteJoinPath, h.InviteJoinHandler).Methods(http.MethodGet)
+	router.HandleFunc("/invite/{code}", h.InviteHandler).Methods(http.MethodGet)
 	login_router := router.PathPrefix("/login").Subrouter()
 	login_router.PathPrefix("").Handler(http.StripPrefix("/login", http.FileServer(http.FS(loginDir))))
 
@@ -542,18 +689,41 @@ func (h *Headscale) createRouter(grpcMux *runtime.ServeMux) *mux.Router {
 
 	console_router := router.PathPrefix("/admin").Subrouter()
 	console_router.Use(h.ConsoleAuth)
+	console_router.Use(h.ConsoleCSRF)
 
 	console_router.HandleFunc("/api/self", h.ConsoleSelfAPI).Methods(http.MethodGet)
 	console_router.HandleFunc("/api/machines", h.ConsoleMachinesAPI).Methods(http.MethodGet)
 	console_router.HandleFunc("/api/dns", h.CAPIGetDNS).Methods(http.MethodGet)
+	console_router.HandleFunc("/api/dns/records", h.CAPIGetDNSRecords).Methods(http.MethodGet)
 	console_router.HandleFunc("/api/netsettings", h.getNetSettingAPI).Methods(http.MethodGet)
 	console_router.HandleFunc("/api/keys", h.CAPIGetKeys).Methods(http.MethodGet)
+	console_router.HandleFunc("/api/machine/history", h.CAPIGetMachineHistory).Methods(http.MethodGet)
+	console_router.HandleFunc("/api/account", h.CAPIGetAccount).Methods(http.MethodGet)
+	console_router.HandleFunc("/api/sessions", h.CAPIGetSessions).Methods(http.MethodGet)
+	console_router.HandleFunc("/api/users", h.CAPIGetUsers).Methods(http.MethodGet)
+	console_router.HandleFunc("/api/organizations", h.CAPIGetOrganizations).Methods(http.MethodGet)
+	console_router.HandleFunc("/api/invites", h.CAPIGetInvites).Methods(http.MethodGet)
 
 	console_router.HandleFunc("/api/machines", h.ConsoleMachinesUpdateAPI).Methods(http.MethodPost)
 	console_router.HandleFunc("/api/machine/remove", h.ConsoleRemoveMachineAPI).Methods(http.MethodPost)
 	console_router.HandleFunc("/api/netsetting/updatekeyexpiry", h.ConsoleUpdateKeyExpiryAPI).Methods(http.MethodPost)
 	console_router.HandleFunc("/api/keys", h.CAPIPostKeys).Methods(http.MethodPost)
 	console_router.HandleFunc("/api/dns", h.CAPIPostDNS).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/dns/domain", h.CAPIPostDNSDomain).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/dns/records", h.CAPIPostDNSRecord).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/dns/records/remove", h.CAPIRemoveDNSRecord).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/account/password", h.CAPIPostPassword).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/account/totp", h.CAPIPostTOTPEnroll).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/account/totp/confirm", h.CAPIPostTOTPConfirm).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/account/totp/disable", h.CAPIPostTOTPDisable).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/sessions/revoke", h.CAPIRevokeSession).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/sessions/logoutall", h.CAPILogoutAllSessions).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/users/role", h.CAPIPostUserRole).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/users/disable", h.CAPIPostUserDisable).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/users/quota", h.CAPIPostUserQuota).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/organizations/switch", h.CAPIPostOrganizationSwitch).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/invites", h.CAPIPostInvite).Methods(http.MethodPost)
+	console_router.HandleFunc("/api/invites/revoke", h.CAPIRevokeInvite).Methods(http.MethodPost)
 
 	console_router.PathPrefix("/api/keys/").HandlerFunc(h.CAPIDelKeys).Methods(http.MethodDelete)
 
@@ -563,7 +733,7 @@ func (h *Headscale) createRouter(grpcMux *runtime.ServeMux) *mux.Router {
 
 	//console_router.HandleFunc("", h.ConsolePanel).Methods(http.MethodGet)
 
-	router.HandleFunc("/login/callback", h.ConsoleLogin).Methods(http.MethodGet)
+	router.HandleFunc("/login/callback", h.rateLimited("login", h.ConsoleLogin)).Methods(http.MethodGet)
 	//	router.HandleFunc("/logout/callback", h.ConsoleLogoutCallback).Methods(http.MethodGet)
 	//	router.HandleFunc("/", h.ConsoleWelcome).Methods(http.MethodGet)
 
@@ -571,11 +741,19 @@ func (h *Headscale) createRouter(grpcMux *runtime.ServeMux) *mux.Router {
 
 	router.HandleFunc("/health", h.HealthHandler).Methods(http.MethodGet)
 	router.HandleFunc("/key", h.KeyHandler).Methods(http.MethodGet)
-	router.HandleFunc("/register/{nkey}", h.RegisterWebAPI).Methods(http.MethodGet)
+	router.HandleFunc("/register/{nkey}", h.rateLimited("register_machine", h.RegisterWebAPI)).
+		Methods(http.MethodGet)
 	h.addLegacyHandlers(router)
+	h.addSCIMHandlers(router)
+	router.HandleFunc(oauthTokenPath, h.rateLimited("oauth_token", h.OAuthTokenHandler)).
+		Methods(http.MethodPost)
 
-	router.HandleFunc("/oidc/register/{nkey}", h.RegisterOIDC).Methods(http.MethodGet)
-	router.HandleFunc("/oidc/callback", h.OIDCCallback).Methods(http.MethodGet)
+	router.HandleFunc("/oidc/register/{nkey}", h.rateLimited("register_machine", h.RegisterOIDC)).
+		Methods(http.MethodGet)
+	router.HandleFunc("/oidc/callback", h.rateLimited("oidc_callback", h.OIDCCallback)).
+		Methods(http.MethodGet)
+	router.HandleFunc("/oidc/callback/{provider}", h.rateLimited("oidc_callback", h.OIDCCallback)).
+		Methods(http.MethodGet)
 	router.HandleFunc("/apple", h.AppleConfigMessage).Methods(http.MethodGet)
 	router.HandleFunc("/apple/{platform}", h.ApplePlatformConfig).
 		Methods(http.MethodGet)
@@ -629,6 +807,11 @@ func (h *Headscale) Serve() error {
 
 	go h.expireEphemeralNodes(updateInterval)
 	go h.expireExpiredMachines(updateInterval)
+	go h.expireConsoleSessions(consoleSessionCleanupInterval)
+
+	if h.cfg.ExpiryWarning.Days > 0 {
+		go h.warnExpiringMachines(h.cfg.ExpiryWarning.CheckInterval)
+	}
 
 	go h.failoverSubnetRoutes(updateInterval)
 
@@ -686,8 +869,16 @@ func (h *Headscale) Serve() error {
 		return err
 	}
 
-	// Start the local gRPC server without TLS and without authentication
-	grpcSocket := grpc.NewServer(zerolog.UnaryInterceptor())
+	// Start the local gRPC server without TLS, the API keys forwarded by
+	// the gateway are checked
+	grpcSocket := grpc.NewServer(
+		grpc.UnaryInterceptor(
+			grpcMiddleware.ChainUnaryServer(
+				zerolog.NewUnaryServerInterceptor(),
+				h.grpcSocketInterceptor,
+			),
+		),
+	)
 
 	v1.RegisterHeadscaleServiceServer(grpcSocket, newHeadscaleV1APIServer(h))
 	reflection.Register(grpcSocket)
@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/juanfont/headscale"
@@ -22,9 +23,14 @@ const (
 func init() {
 	rootCmd.AddCommand(apiKeysCmd)
 	apiKeysCmd.AddCommand(listAPIKeys)
+	listAPIKeys.Flags().StringP("user", "u", "", "Only list the keys of this user")
 
 	createAPIKeyCmd.Flags().
 		StringP("expiration", "e", DefaultAPIKeyExpiry, "Human-readable expiration of the key (e.g. 30m, 24h)")
+	createAPIKeyCmd.Flags().
+		StringP("user", "u", "", "User owning the key, whose role applies to the key. The key acts as an owner when empty")
+	createAPIKeyCmd.Flags().
+		StringSlice("scopes", []string{}, "Scopes of the key (e.g. machines:read,routes:write), all when empty")
 
 	apiKeysCmd.AddCommand(createAPIKeyCmd)
 
@@ -48,12 +54,13 @@ var listAPIKeys = &cobra.Command{
 	Aliases: []string{"ls", "show"},
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
+		user, _ := cmd.Flags().GetString("user")
 
 		ctx, client, conn, cancel := getHeadscaleCLIClient()
 		defer cancel()
 		defer conn.Close()
 
-		request := &v1.ListApiKeysRequest{}
+		request := &v1.ListApiKeysRequest{User: user}
 
 		response, err := client.ListApiKeys(ctx, request)
 		if err != nil {
@@ -73,7 +80,7 @@ var listAPIKeys = &cobra.Command{
 		}
 
 		tableData := pterm.TableData{
-			{"ID", "Prefix", "Expiration", "Created"},
+			{"ID", "Prefix", "User", "Scopes", "Expiration", "Created"},
 		}
 		for _, key := range response.ApiKeys {
 			expiration := "-"
@@ -85,6 +92,8 @@ var listAPIKeys = &cobra.Command{
 			tableData = append(tableData, []string{
 				strconv.FormatUint(key.GetId(), headscale.Base10),
 				key.GetPrefix(),
+				key.GetUser(),
+				strings.Join(key.GetScopes(), ","),
 				expiration,
 				key.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
 			})
@@ -117,7 +126,9 @@ If you loose a key, create a new one and revoke (expire) the old one.`,
 		log.Trace().
 			Msg("Preparing to create ApiKey")
 
-		request := &v1.CreateApiKeyRequest{}
+		user, _ := cmd.Flags().GetString("user")
+		scopes, _ := cmd.Flags().GetStringSlice("scopes")
+		request := &v1.CreateApiKeyRequest{User: user, Scopes: scopes}
 
 		durationStr, _ := cmd.Flags().GetString("expiration")
 
@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	survey "github.com/AlecAivazis/survey/v2"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(dnsCmd)
+	dnsCmd.AddCommand(dnsRecordsCmd)
+
+	dnsRecordsCmd.PersistentFlags().String("organization", "", "Organization of the records, the default one when empty")
+	dnsRecordsCmd.AddCommand(listDNSRecordsCmd)
+
+	for _, cmd := range []*cobra.Command{createDNSRecordCmd, updateDNSRecordCmd} {
+		cmd.Flags().StringP("type", "t", "A", "Record type, A or AAAA")
+		cmd.Flags().String("value", "", "IP address of the record")
+		cmd.Flags().Uint64("machine", 0, "Node identifier (ID) whose address the record follows, instead of --value")
+	}
+	dnsRecordsCmd.AddCommand(createDNSRecordCmd)
+	dnsRecordsCmd.AddCommand(updateDNSRecordCmd)
+	dnsRecordsCmd.AddCommand(deleteDNSRecordCmd)
+}
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Manage the DNS of Headscale",
+}
+
+var dnsRecordsCmd = &cobra.Command{
+	Use:     "records",
+	Short:   "Manage the custom DNS records served by MagicDNS",
+	Aliases: []string{"record", "rec"},
+}
+
+var listDNSRecordsCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the DNS records of an organization",
+	Aliases: []string{"ls", "show"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		organization, _ := cmd.Flags().GetString("organization")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ListDNSRecords(ctx, &v1.ListDNSRecordsRequest{
+			Organization: organization,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get DNS records: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response.Records, "", output)
+
+			return
+		}
+
+		tableData := pterm.TableData{
+			{"ID", "Name", "Type", "Value", "Machine", "Created"},
+		}
+		for _, record := range response.Records {
+			machine := ""
+			if record.MachineId != nil {
+				machine = fmt.Sprintf("%s (%d)", record.GetMachineName(), record.GetMachineId())
+			}
+			tableData = append(tableData, []string{
+				strconv.FormatUint(record.GetId(), Base10),
+				record.GetName(),
+				record.GetType(),
+				record.GetValue(),
+				machine,
+				record.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
+			})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+
+			return
+		}
+	},
+}
+
+// dnsRecordTarget returns the value and the machine of a record from the
+// flags, exactly one of them must be given.
+func dnsRecordTarget(cmd *cobra.Command) (string, *uint64, error) {
+	value, _ := cmd.Flags().GetString("value")
+	if !cmd.Flags().Changed("machine") {
+		if value == "" {
+			return "", nil, errMissingParameter
+		}
+
+		return value, nil, nil
+	}
+	if value != "" {
+		return "", nil, errMissingParameter
+	}
+	machineID, _ := cmd.Flags().GetUint64("machine")
+
+	return "", &machineID, nil
+}
+
+var createDNSRecordCmd = &cobra.Command{
+	Use:     "create NAME",
+	Short:   "Creates a DNS record pointing to --value or to the address of --machine",
+	Aliases: []string{"c", "new"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		organization, _ := cmd.Flags().GetString("organization")
+		recordType, _ := cmd.Flags().GetString("type")
+
+		value, machineID, err := dnsRecordTarget(cmd)
+		if err != nil {
+			ErrorOutput(err, "Either --value or --machine is required", output)
+
+			return
+		}
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.CreateDNSRecord(ctx, &v1.CreateDNSRecordRequest{
+			Organization: organization,
+			Name:         args[0],
+			Type:         recordType,
+			Value:        value,
+			MachineId:    machineID,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot create DNS record: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.Record, "DNS record created", output)
+	},
+}
+
+var updateDNSRecordCmd = &cobra.Command{
+	Use:   "update ID NAME",
+	Short: "Replaces the name, type and target of a DNS record",
+	Args: func(cmd *cobra.Command, args []string) error {
+		expectedArguments := 2
+		if len(args) < expectedArguments {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		recordType, _ := cmd.Flags().GetString("type")
+
+		id, err := strconv.ParseUint(args[0], Base10, 64)
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Invalid DNS record identifier: %s", err), output)
+
+			return
+		}
+
+		value, machineID, err := dnsRecordTarget(cmd)
+		if err != nil {
+			ErrorOutput(err, "Either --value or --machine is required", output)
+
+			return
+		}
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.UpdateDNSRecord(ctx, &v1.UpdateDNSRecordRequest{
+			Id:        id,
+			Name:      args[1],
+			Type:      recordType,
+			Value:     value,
+			MachineId: machineID,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot update DNS record: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.Record, "DNS record updated", output)
+	},
+}
+
+var deleteDNSRecordCmd = &cobra.Command{
+	Use:     "delete ID",
+	Short:   "Deletes a DNS record",
+	Aliases: []string{"remove", "rm", "destroy"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		id, err := strconv.ParseUint(args[0], Base10, 64)
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Invalid DNS record identifier: %s", err), output)
+
+			return
+		}
+
+		confirm := false
+		force, _ := cmd.Flags().GetBool("force")
+		if !force {
+			prompt := &survey.Confirm{
+				Message: fmt.Sprintf("Do you want to delete the DNS record %d?", id),
+			}
+			err := survey.AskOne(prompt, &confirm)
+			if err != nil {
+				return
+			}
+		}
+
+		if !confirm && !force {
+			SuccessOutput(map[string]string{"Result": "DNS record not deleted"}, "DNS record not deleted", output)
+
+			return
+		}
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.DeleteDNSRecord(ctx, &v1.DeleteDNSRecordRequest{Id: id})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot delete DNS record: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response, "DNS record deleted", output)
+	},
+}
@@ -88,6 +88,13 @@ func init() {
 	}
 	nodeCmd.AddCommand(moveNodeCmd)
 
+	historyNodeCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	err = historyNodeCmd.MarkFlagRequired("identifier")
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	nodeCmd.AddCommand(historyNodeCmd)
+
 	tagCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
 
 	err = tagCmd.MarkFlagRequired("identifier")
@@ -477,6 +484,71 @@ var moveNodeCmd = &cobra.Command{
 	},
 }
 
+var historyNodeCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the recorded changes of a node, newest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := cmd.Flags().GetUint64("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.GetMachineHistoryRequest{
+			MachineId: identifier,
+		}
+
+		response, err := client.GetMachineHistory(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get node history: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response.History, "", output)
+
+			return
+		}
+
+		tableData := pterm.TableData{{"Time", "Event", "Old value", "New value"}}
+		for _, entry := range response.History {
+			tableData = append(tableData, []string{
+				entry.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
+				entry.GetEvent(),
+				entry.GetOldValue(),
+				entry.GetNewValue(),
+			})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+
+			return
+		}
+	},
+}
+
 func nodesToPtables(
 	currentUser string,
 	showTags bool,
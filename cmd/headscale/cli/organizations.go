@@ -0,0 +1,291 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(organizationsCmd)
+	organizationsCmd.AddCommand(createOrganizationCmd)
+	createOrganizationCmd.Flags().String("display-name", "", "Name shown in the web console")
+	createOrganizationCmd.Flags().
+		StringSlice("prefix", []string{}, "IP prefix of the machines, the global ip_prefixes when not given")
+
+	organizationsCmd.AddCommand(listOrganizationsCmd)
+	organizationsCmd.AddCommand(deleteOrganizationCmd)
+
+	organizationsCmd.AddCommand(policyOrganizationCmd)
+	policyOrganizationCmd.Flags().StringP("file", "f", "", "Path to the HuJSON policy")
+	policyOrganizationCmd.Flags().Bool("clear", false, "Fall back to the global policy")
+
+	organizationsCmd.AddCommand(magicDNSOrganizationCmd)
+	magicDNSOrganizationCmd.Flags().String("base-domain", "", "MagicDNS base domain, the global dns_config.base_domain when empty")
+	magicDNSOrganizationCmd.Flags().Bool("omit-user-label", false, "Leave the users out of the MagicDNS names of the machines")
+}
+
+var organizationsCmd = &cobra.Command{
+	Use:     "organizations",
+	Short:   "Manage the organizations, each with its own tailnet",
+	Aliases: []string{"organization", "orgs", "org"},
+}
+
+var createOrganizationCmd = &cobra.Command{
+	Use:     "create NAME",
+	Short:   "Creates a new organization",
+	Aliases: []string{"c", "new"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		displayName, _ := cmd.Flags().GetString("display-name")
+		prefixes, _ := cmd.Flags().GetStringSlice("prefix")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.CreateOrganizationRequest{
+			Name:        args[0],
+			DisplayName: displayName,
+			IpPrefixes:  prefixes,
+		}
+
+		response, err := client.CreateOrganization(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot create organization: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.Organization, "Organization created", output)
+	},
+}
+
+var listOrganizationsCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List all the organizations",
+	Aliases: []string{"ls", "show"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ListOrganizations(ctx, &v1.ListOrganizationsRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get organizations: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response.Organizations, "", output)
+
+			return
+		}
+
+		tableData := pterm.TableData{
+			{"ID", "Name", "Display name", "IP prefixes", "Own policy", "Created"},
+		}
+		for _, organization := range response.GetOrganizations() {
+			name := organization.GetName()
+			if organization.GetIsDefault() {
+				name += " (default)"
+			}
+			prefixes := strings.Join(organization.GetIpPrefixes(), ", ")
+			if prefixes == "" {
+				prefixes = "-"
+			}
+			tableData = append(tableData, []string{
+				organization.GetId(),
+				name,
+				organization.GetDisplayName(),
+				prefixes,
+				fmt.Sprintf("%t", organization.GetHasPolicy()),
+				organization.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
+			})
+		}
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+
+			return
+		}
+	},
+}
+
+var deleteOrganizationCmd = &cobra.Command{
+	Use:     "delete NAME",
+	Short:   "Deletes an organization without users",
+	Aliases: []string{"destroy", "rm"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.DeleteOrganizationRequest{Name: args[0]}
+
+		response, err := client.DeleteOrganization(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot delete organization: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response, "Organization deleted", output)
+	},
+}
+
+var policyOrganizationCmd = &cobra.Command{
+	Use:   "policy NAME",
+	Short: "Sets the ACL policy of an organization",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		file, _ := cmd.Flags().GetString("file")
+		clearPolicy, _ := cmd.Flags().GetBool("clear")
+
+		if (file == "") == !clearPolicy {
+			ErrorOutput(
+				errMissingParameter,
+				"Either --file or --clear is required",
+				output,
+			)
+
+			return
+		}
+
+		var policy string
+		if file != "" {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf("Cannot read policy file: %s", err),
+					output,
+				)
+
+				return
+			}
+			policy = string(content)
+		}
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetOrganizationPolicyRequest{
+			Name:   args[0],
+			Policy: policy,
+		}
+
+		response, err := client.SetOrganizationPolicy(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set organization policy: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response, "Organization policy updated", output)
+	},
+}
+
+var magicDNSOrganizationCmd = &cobra.Command{
+	Use:   "magicdns NAME",
+	Short: "Sets the MagicDNS base domain of the users of an organization",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		baseDomain, _ := cmd.Flags().GetString("base-domain")
+		omitUserLabel, _ := cmd.Flags().GetBool("omit-user-label")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetOrganizationMagicDNSRequest{
+			Name:          args[0],
+			BaseDomain:    baseDomain,
+			OmitUserLabel: omitUserLabel,
+		}
+
+		response, err := client.SetOrganizationMagicDNS(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set organization MagicDNS settings: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.Organization, "Organization MagicDNS settings updated", output)
+	},
+}
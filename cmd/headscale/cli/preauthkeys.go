@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	survey "github.com/AlecAivazis/survey/v2"
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"github.com/prometheus/common/model"
 	"github.com/pterm/pterm"
@@ -34,6 +35,11 @@ func init() {
 	preauthkeysCmd.AddCommand(listPreAuthKeys)
 	preauthkeysCmd.AddCommand(createPreAuthKeyCmd)
 	preauthkeysCmd.AddCommand(expirePreAuthKeyCmd)
+	preauthkeysCmd.AddCommand(revokePreAuthKeyCmd)
+	revokePreAuthKeyCmd.Flags().
+		Bool("cascade", false, "Also expire the machines registered with the key")
+	revokePreAuthKeyCmd.Flags().
+		Bool("delete-machines", false, "With --cascade, delete the machines and their routes instead of expiring them")
 	createPreAuthKeyCmd.PersistentFlags().
 		Bool("reusable", false, "Make the preauthkey reusable")
 	createPreAuthKeyCmd.PersistentFlags().
@@ -42,6 +48,10 @@ func init() {
 		StringP("expiration", "e", DefaultPreAuthKeyExpiry, "Human-readable expiration of the key (e.g. 30m, 24h)")
 	createPreAuthKeyCmd.Flags().
 		StringSlice("tags", []string{}, "Tags to automatically assign to node")
+	createPreAuthKeyCmd.Flags().
+		String("description", "", "Description of the key")
+	createPreAuthKeyCmd.Flags().
+		Uint32("max-uses", 0, "Maximum number of registrations with the key, implies --reusable when above 1")
 }
 
 var preauthkeysCmd = &cobra.Command{
@@ -92,13 +102,17 @@ var listPreAuthKeys = &cobra.Command{
 		tableData := pterm.TableData{
 			{
 				"ID",
-				"Key",
+				"Prefix",
 				"Reusable",
 				"Ephemeral",
 				"Used",
+				"Uses",
 				"Expiration",
 				"Created",
+				"Last used",
 				"Tags",
+				"Machines",
+				"Description",
 			},
 		}
 		for _, key := range response.PreAuthKeys {
@@ -122,15 +136,29 @@ var listPreAuthKeys = &cobra.Command{
 
 			aclTags = strings.TrimLeft(aclTags, ",")
 
+			uses := strconv.FormatUint(uint64(key.GetUseCount()), 10)
+			if key.GetMaxUses() > 0 {
+				uses += "/" + strconv.FormatUint(uint64(key.GetMaxUses()), 10)
+			}
+
+			lastUsed := "-"
+			if key.GetLastUsed() != nil {
+				lastUsed = key.GetLastUsed().AsTime().Format("2006-01-02 15:04:05")
+			}
+
 			tableData = append(tableData, []string{
 				key.GetId(),
-				key.GetKey(),
+				key.GetPrefix(),
 				reusable,
 				strconv.FormatBool(key.GetEphemeral()),
 				strconv.FormatBool(key.GetUsed()),
+				uses,
 				expiration,
 				key.GetCreatedAt().AsTime().Format("2006-01-02 15:04:05"),
+				lastUsed,
 				aclTags,
+				strings.Join(key.GetMachines(), ","),
+				key.GetDescription(),
 			})
 
 		}
@@ -164,6 +192,8 @@ var createPreAuthKeyCmd = &cobra.Command{
 		reusable, _ := cmd.Flags().GetBool("reusable")
 		ephemeral, _ := cmd.Flags().GetBool("ephemeral")
 		tags, _ := cmd.Flags().GetStringSlice("tags")
+		description, _ := cmd.Flags().GetString("description")
+		maxUses, _ := cmd.Flags().GetUint32("max-uses")
 
 		log.Trace().
 			Bool("reusable", reusable).
@@ -172,10 +202,12 @@ var createPreAuthKeyCmd = &cobra.Command{
 			Msg("Preparing to create preauthkey")
 
 		request := &v1.CreatePreAuthKeyRequest{
-			User:      user,
-			Reusable:  reusable,
-			Ephemeral: ephemeral,
-			AclTags:   tags,
+			User:        user,
+			Reusable:    reusable,
+			Ephemeral:   ephemeral,
+			AclTags:     tags,
+			Description: description,
+			MaxUses:     maxUses,
 		}
 
 		durationStr, _ := cmd.Flags().GetString("expiration")
@@ -220,8 +252,8 @@ var createPreAuthKeyCmd = &cobra.Command{
 
 var expirePreAuthKeyCmd = &cobra.Command{
 	Use:     "expire KEY",
-	Short:   "Expire a preauthkey",
-	Aliases: []string{"revoke", "exp", "e"},
+	Short:   "Expire a preauthkey, by key or by the prefix listed",
+	Aliases: []string{"exp", "e"},
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errMissingParameter
@@ -261,3 +293,94 @@ var expirePreAuthKeyCmd = &cobra.Command{
 		SuccessOutput(response, "Key expired", output)
 	},
 }
+
+var revokePreAuthKeyCmd = &cobra.Command{
+	Use:   "revoke KEY",
+	Short: "Revoke a preauthkey, by key or by the prefix listed, and optionally the machines registered with it",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		user, err := cmd.Flags().GetString("user")
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error getting user: %s", err), output)
+
+			return
+		}
+
+		cascade, _ := cmd.Flags().GetBool("cascade")
+		deleteMachines, _ := cmd.Flags().GetBool("delete-machines")
+		if deleteMachines && !cascade {
+			ErrorOutput(
+				errMissingParameter,
+				"--delete-machines requires --cascade",
+				output,
+			)
+
+			return
+		}
+
+		if cascade {
+			message := "Do you want to revoke the key and expire the machines registered with it?"
+			if deleteMachines {
+				message = "Do you want to revoke the key and delete the machines registered with it, along with their routes?"
+			}
+
+			confirm := false
+			force, _ := cmd.Flags().GetBool("force")
+			if !force {
+				prompt := &survey.Confirm{
+					Message: message,
+				}
+				err := survey.AskOne(prompt, &confirm)
+				if err != nil {
+					return
+				}
+			}
+
+			if !confirm && !force {
+				SuccessOutput(map[string]string{"Result": "Key not revoked"}, "Key not revoked", output)
+
+				return
+			}
+		}
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.RevokePreAuthKeyRequest{
+			User:           user,
+			Key:            args[0],
+			Cascade:        cascade,
+			DeleteMachines: deleteMachines,
+		}
+
+		response, err := client.RevokePreAuthKey(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot revoke Pre Auth Key: %s\n", err),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf(
+				"Key revoked: %d machine(s) expired, %d machine(s) deleted, %d route(s) deleted",
+				len(response.GetExpiredMachines()),
+				len(response.GetDeletedMachines()),
+				len(response.GetDeletedRoutes()),
+			),
+			output,
+		)
+	},
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/juanfont/headscale"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(listSessionsCmd)
+	listSessionsCmd.Flags().StringP("user", "u", "", "Filter by user")
+
+	sessionsCmd.AddCommand(revokeSessionsCmd)
+	revokeSessionsCmd.Flags().Uint64P("identifier", "i", 0, "Session identifier (ID)")
+	revokeSessionsCmd.Flags().StringP("user", "u", "", "Revoke all the sessions of this user")
+}
+
+var sessionsCmd = &cobra.Command{
+	Use:     "sessions",
+	Short:   "Manage the web console sessions",
+	Aliases: []string{"session"},
+}
+
+var listSessionsCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the active web console sessions",
+	Aliases: []string{"ls", "show"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		user, _ := cmd.Flags().GetString("user")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.ListConsoleSessionsRequest{User: user}
+
+		response, err := client.ListConsoleSessions(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get console sessions: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response.Sessions, "", output)
+
+			return
+		}
+
+		tableData := pterm.TableData{
+			{"ID", "User", "Provider", "Address", "Created", "Last seen", "Expiration"},
+		}
+		for _, session := range response.Sessions {
+			tableData = append(tableData, []string{
+				strconv.FormatUint(session.GetId(), headscale.Base10),
+				session.GetUser(),
+				session.GetProvider(),
+				session.GetIpAddress(),
+				session.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
+				session.GetLastSeen().AsTime().Format(HeadscaleDateTimeFormat),
+				ColourTime(session.GetExpiration().AsTime()),
+			})
+		}
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+
+			return
+		}
+	},
+}
+
+var revokeSessionsCmd = &cobra.Command{
+	Use:     "revoke",
+	Short:   "Log out a session, or all the sessions of a user",
+	Aliases: []string{"logout", "rm"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		identifier, _ := cmd.Flags().GetUint64("identifier")
+		user, _ := cmd.Flags().GetString("user")
+
+		if identifier == 0 && user == "" {
+			ErrorOutput(
+				errMissingParameter,
+				"Either --identifier or --user is required",
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.RevokeConsoleSessionsRequest{
+			Id:   identifier,
+			User: user,
+		}
+
+		response, err := client.RevokeConsoleSessions(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot revoke console sessions: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf("%d session(s) revoked", response.GetRevoked()),
+			output,
+		)
+	},
+}
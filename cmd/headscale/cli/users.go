@@ -15,9 +15,26 @@ import (
 func init() {
 	rootCmd.AddCommand(userCmd)
 	userCmd.AddCommand(createUserCmd)
+	createUserCmd.Flags().String("organization", "", "Organization of the user, the default one when empty")
+	createUserCmd.Flags().Bool("service-account", false, "Create a service account, owning API keys but unable to log in")
 	userCmd.AddCommand(listUsersCmd)
+	listUsersCmd.Flags().String("organization", "", "Filter by organization")
 	userCmd.AddCommand(destroyUserCmd)
+	destroyUserCmd.Flags().String("transfer-to", "", "Move the machines of the user to this user")
+	destroyUserCmd.Flags().Bool("delete-machines", false, "Delete the machines of the user")
 	userCmd.AddCommand(renameUserCmd)
+	userCmd.AddCommand(quotaUserCmd)
+	quotaUserCmd.Flags().Uint64("max-machines", 0, "Maximum number of machines (0 for unlimited)")
+	quotaUserCmd.Flags().Uint64("max-reusable-keys", 0, "Maximum number of reusable preauthkeys (0 for unlimited)")
+	quotaUserCmd.Flags().Uint64("max-routes", 0, "Maximum number of advertised subnet routes (0 for unlimited)")
+	userCmd.AddCommand(setPasswordUserCmd)
+	setPasswordUserCmd.Flags().String("password", "", "New password, prompted for when not given")
+	setPasswordUserCmd.Flags().Bool("reset-totp", false, "Remove the TOTP second factor and the recovery codes")
+	userCmd.AddCommand(roleUserCmd)
+	userCmd.AddCommand(organizationUserCmd)
+	userCmd.AddCommand(magicDNSUserCmd)
+	magicDNSUserCmd.Flags().String("base-domain", "", "MagicDNS base domain, the one of the organization when empty")
+	magicDNSUserCmd.Flags().Bool("omit-user-label", false, "Leave the user out of the MagicDNS names of the machines, the setting of the organization when unset")
 }
 
 const (
@@ -43,6 +60,8 @@ var createUserCmd = &cobra.Command{
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
+		organization, _ := cmd.Flags().GetString("organization")
+		serviceAccount, _ := cmd.Flags().GetBool("service-account")
 
 		userName := args[0]
 
@@ -52,7 +71,11 @@ var createUserCmd = &cobra.Command{
 
 		log.Trace().Interface("client", client).Msg("Obtained gRPC client")
 
-		request := &v1.CreateUserRequest{Name: userName}
+		request := &v1.CreateUserRequest{
+			Name:           userName,
+			Organization:   organization,
+			ServiceAccount: serviceAccount,
+		}
 
 		log.Trace().Interface("request", request).Msg("Sending CreateUser request")
 		response, err := client.CreateUser(ctx, request)
@@ -108,14 +131,31 @@ var destroyUserCmd = &cobra.Command{
 			return
 		}
 
+		transferTo, _ := cmd.Flags().GetString("transfer-to")
+		deleteMachines, _ := cmd.Flags().GetBool("delete-machines")
+
+		message := fmt.Sprintf(
+			"Do you want to remove the user '%s' and any associated preauthkeys?",
+			userName,
+		)
+		if transferTo != "" {
+			message = fmt.Sprintf(
+				"Do you want to remove the user '%s' and move its machines and preauthkeys to '%s'?",
+				userName,
+				transferTo,
+			)
+		} else if deleteMachines {
+			message = fmt.Sprintf(
+				"Do you want to remove the user '%s' along with its machines, routes and preauthkeys?",
+				userName,
+			)
+		}
+
 		confirm := false
 		force, _ := cmd.Flags().GetBool("force")
 		if !force {
 			prompt := &survey.Confirm{
-				Message: fmt.Sprintf(
-					"Do you want to remove the user '%s' and any associated preauthkeys?",
-					userName,
-				),
+				Message: message,
 			}
 			err := survey.AskOne(prompt, &confirm)
 			if err != nil {
@@ -124,7 +164,11 @@ var destroyUserCmd = &cobra.Command{
 		}
 
 		if confirm || force {
-			request := &v1.DeleteUserRequest{Name: userName}
+			request := &v1.DeleteUserRequest{
+				Name:           userName,
+				TransferTo:     transferTo,
+				DeleteMachines: deleteMachines,
+			}
 
 			response, err := client.DeleteUser(ctx, request)
 			if err != nil {
@@ -139,7 +183,19 @@ var destroyUserCmd = &cobra.Command{
 
 				return
 			}
-			SuccessOutput(response, "User destroyed", output)
+			SuccessOutput(
+				response,
+				fmt.Sprintf(
+					"User destroyed: %d machine(s) transferred, %d machine(s) deleted, "+
+						"%d route(s) deleted, %d preauthkey(s) transferred, %d preauthkey(s) deleted",
+					len(response.GetTransferredMachines()),
+					len(response.GetDeletedMachines()),
+					len(response.GetDeletedRoutes()),
+					len(response.GetTransferredPreAuthKeys()),
+					len(response.GetDeletedPreAuthKeys()),
+				),
+				output,
+			)
 		} else {
 			SuccessOutput(map[string]string{"Result": "User not destroyed"}, "User not destroyed", output)
 		}
@@ -152,12 +208,13 @@ var listUsersCmd = &cobra.Command{
 	Aliases: []string{"ls", "show"},
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
+		organization, _ := cmd.Flags().GetString("organization")
 
 		ctx, client, conn, cancel := getHeadscaleCLIClient()
 		defer cancel()
 		defer conn.Close()
 
-		request := &v1.ListUsersRequest{}
+		request := &v1.ListUsersRequest{Organization: organization}
 
 		response, err := client.ListUsers(ctx, request)
 		if err != nil {
@@ -176,13 +233,15 @@ var listUsersCmd = &cobra.Command{
 			return
 		}
 
-		tableData := pterm.TableData{{"ID", "Name", "Created"}}
+		tableData := pterm.TableData{{"ID", "Name", "Organization", "Role", "Created"}}
 		for _, user := range response.GetUsers() {
 			tableData = append(
 				tableData,
 				[]string{
 					user.GetId(),
 					user.GetName(),
+					user.GetOrganization(),
+					user.GetRole(),
 					user.GetCreatedAt().AsTime().Format("2006-01-02 15:04:05"),
 				},
 			)
@@ -241,3 +300,236 @@ var renameUserCmd = &cobra.Command{
 		SuccessOutput(response.User, "User renamed", output)
 	},
 }
+
+var quotaUserCmd = &cobra.Command{
+	Use:   "quota NAME",
+	Short: "Sets the quotas of a user, unset flags fall back to the server defaults",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		optionalFlag := func(name string) *uint64 {
+			if !cmd.Flags().Changed(name) {
+				return nil
+			}
+			value, _ := cmd.Flags().GetUint64(name)
+
+			return &value
+		}
+
+		request := &v1.SetUserQuotaRequest{
+			Name: args[0],
+			Quota: &v1.UserQuota{
+				MaxMachines:            optionalFlag("max-machines"),
+				MaxReusablePreAuthKeys: optionalFlag("max-reusable-keys"),
+				MaxRoutes:              optionalFlag("max-routes"),
+			},
+		}
+
+		response, err := client.SetUserQuota(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set user quota: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.Quota, "User quota updated", output)
+	},
+}
+
+var setPasswordUserCmd = &cobra.Command{
+	Use:   "set-password NAME",
+	Short: "Sets the password of the local account of a user",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		password, _ := cmd.Flags().GetString("password")
+		if password == "" {
+			prompt := &survey.Password{
+				Message: fmt.Sprintf("New password of user %s:", args[0]),
+			}
+			err := survey.AskOne(prompt, &password)
+			if err != nil {
+				return
+			}
+		}
+		resetTOTP, _ := cmd.Flags().GetBool("reset-totp")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetUserPasswordRequest{
+			Name:      args[0],
+			Password:  password,
+			ResetTotp: resetTOTP,
+		}
+
+		response, err := client.SetUserPassword(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set user password: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response, "User password updated", output)
+	},
+}
+
+var roleUserCmd = &cobra.Command{
+	Use:   "role NAME ROLE",
+	Short: "Sets the role of a user: owner, admin, network-admin, it-admin, auditor or member",
+	Args: func(cmd *cobra.Command, args []string) error {
+		expectedArguments := 2
+		if len(args) < expectedArguments {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetUserRoleRequest{
+			Name: args[0],
+			Role: args[1],
+		}
+
+		response, err := client.SetUserRole(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set user role: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.User, "User role updated", output)
+	},
+}
+
+var organizationUserCmd = &cobra.Command{
+	Use:   "organization NAME ORGANIZATION",
+	Short: "Moves a user without machines to another organization",
+	Args: func(cmd *cobra.Command, args []string) error {
+		expectedArguments := 2
+		if len(args) < expectedArguments {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetUserOrganizationRequest{
+			Name:         args[0],
+			Organization: args[1],
+		}
+
+		response, err := client.SetUserOrganization(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set user organization: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.User, "User moved to organization", output)
+	},
+}
+
+var magicDNSUserCmd = &cobra.Command{
+	Use:   "magicdns NAME",
+	Short: "Sets the MagicDNS base domain of a user, unset flags fall back to the organization",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		baseDomain, _ := cmd.Flags().GetString("base-domain")
+
+		ctx, client, conn, cancel := getHeadscaleCLIClient()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetUserMagicDNSRequest{
+			Name:       args[0],
+			BaseDomain: baseDomain,
+		}
+		if cmd.Flags().Changed("omit-user-label") {
+			omitUserLabel, _ := cmd.Flags().GetBool("omit-user-label")
+			request.OmitUserLabel = &omitUserLabel
+		}
+
+		response, err := client.SetUserMagicDNS(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set user MagicDNS settings: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.User, "User MagicDNS settings updated", output)
+	},
+}
@@ -74,6 +74,8 @@ type Config struct {
 
 	OIDC OIDCConfig
 
+	IdentityProviders []IdentityProviderConfig
+
 	LogTail             LogTailConfig
 	RandomizeClientPort bool
 
@@ -81,6 +83,30 @@ type Config struct {
 
 	ACL ACLConfig
 
+	ExpiryWarning ExpiryWarningConfig
+
+	Quotas QuotaConfig
+
+	SMS SMSConfig
+
+	// UserDirectory is where the self-registration creates the accounts,
+	// see newUserDirectory
+	UserDirectory string
+
+	LocalAccounts LocalAccountsConfig
+
+	SCIM SCIMConfig
+
+	ConsoleSessions ConsoleSessionsConfig
+
+	RateLimit RateLimitConfig
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For,
+	// X-Forwarded-Proto and Forwarded headers are honoured
+	TrustedProxies []netip.Prefix
+
+	Roles RolesConfig
+
 	ali_IDaaS ALIConfig
 
 	org_name string
@@ -128,6 +154,33 @@ type OIDCConfig struct {
 	UseExpiryFromToken         bool
 }
 
+// IdentityProviderConfig describes one of the OpenID Connect providers
+// users can log in with.
+type IdentityProviderConfig struct {
+	Name             string
+	DisplayName      string
+	Type             string
+	Issuer           string
+	ClientID         string
+	ClientSecret     string
+	LogoutURL        string
+	Scope            []string
+	ExtraParams      map[string]string
+	AllowedDomains   []string
+	AllowedUsers     []string
+	AllowedGroups    []string
+	StripEmaildomain bool
+	Claims           ClaimMapping
+}
+
+// ClaimMapping names the ID token claims a User is built from.
+type ClaimMapping struct {
+	Username    string
+	DisplayName string
+	Email       string
+	Groups      string
+}
+
 type DERPConfig struct {
 	ServerEnabled    bool
 	ServerRegionID   int
@@ -144,6 +197,91 @@ type LogTailConfig struct {
 	Enabled bool
 }
 
+type ExpiryWarningConfig struct {
+	Days          int
+	CheckInterval time.Duration
+	WebhookURL    string
+	SMTP          SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// QuotaConfig holds the default limits of every user, 0 meaning unlimited.
+type QuotaConfig struct {
+	MaxMachines            uint
+	MaxReusablePreAuthKeys uint
+	MaxRoutes              uint
+}
+
+// SMSConfig chooses how the verification codes of the self-registration
+// are sent. The aliyun provider uses the ali_access_id, ali_access_key,
+// ali_sms_sign and ali_sms_template settings.
+type SMSConfig struct {
+	Provider   string
+	WebhookURL string
+	LogPath    string
+}
+
+// LocalAccountsConfig enables console logins with a password and an
+// optional TOTP second factor, for deployments without an identity provider.
+type LocalAccountsConfig struct {
+	Enabled    bool
+	TOTPIssuer string
+}
+
+// SCIMConfig enables the /scim/v2 endpoints, where the identity provider
+// provisions the users and groups with the bearer Token.
+type SCIMConfig struct {
+	Enabled bool
+	Token   string
+}
+
+// ConsoleSessionsConfig limits the lifetime of the console sessions,
+// a session ends after IdleTimeout without requests or AbsoluteTimeout
+// after the login, whichever comes first.
+type ConsoleSessionsConfig struct {
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+// RateLimitConfig throttles the public endpoints with token buckets per
+// client address and per identity (mobile, user name, API key or OAuth
+// client), and locks a key out for LockoutDuration after MaxFailures
+// wrong API keys or verification codes within FailureWindow.
+type RateLimitConfig struct {
+	Enabled         bool
+	PerIP           RateLimit
+	PerIdentity     RateLimit
+	MaxFailures     int
+	FailureWindow   time.Duration
+	LockoutDuration time.Duration
+}
+
+// RateLimit allows RequestsPerMinute on average and up to Burst at once.
+type RateLimit struct {
+	RequestsPerMinute float64
+	Burst             int
+}
+
+// RolesConfig assigns roles to the users, see GetRolesConfig.
+type RolesConfig struct {
+	// Default is the role of the users without any other role
+	Default Role
+	// GroupMappings gives a role to the members of an OIDC group
+	GroupMappings []RoleGroupMapping
+}
+
+type RoleGroupMapping struct {
+	Group string
+	Role  Role
+}
+
 type CLIConfig struct {
 	Address  string
 	APIKey   string
@@ -213,6 +351,31 @@ func LoadConfig(path string, isFile bool) error {
 
 	viper.SetDefault("ephemeral_node_inactivity_timeout", "120s")
 
+	viper.SetDefault("expiry_warning.days", 0)
+	viper.SetDefault("expiry_warning.check_interval", "1h")
+	viper.SetDefault("expiry_warning.smtp.port", 587)
+
+	viper.SetDefault("sms.provider", SMSProviderAliyun)
+
+	viper.SetDefault("local_accounts.enabled", false)
+	viper.SetDefault("local_accounts.totp_issuer", "Mirage")
+
+	viper.SetDefault("scim.enabled", false)
+
+	viper.SetDefault("console_sessions.idle_timeout", "2h")
+	viper.SetDefault("console_sessions.absolute_timeout", "24h")
+
+	viper.SetDefault("rate_limit.enabled", true)
+	viper.SetDefault("rate_limit.per_ip.requests_per_minute", 60)
+	viper.SetDefault("rate_limit.per_ip.burst", 20)
+	viper.SetDefault("rate_limit.per_identity.requests_per_minute", 10)
+	viper.SetDefault("rate_limit.per_identity.burst", 5)
+	viper.SetDefault("rate_limit.lockout.max_failures", 10)
+	viper.SetDefault("rate_limit.lockout.window", "15m")
+	viper.SetDefault("rate_limit.lockout.duration", "15m")
+
+	viper.SetDefault("roles.default", string(RoleMember))
+
 	viper.SetDefault("node_update_check_interval", "10s")
 
 	if IsCLIConfigured() {
@@ -274,6 +437,14 @@ func LoadConfig(path string, isFile bool) error {
 		)
 	}
 
+	if viper.GetInt("expiry_warning.days") > 0 &&
+		viper.GetDuration("expiry_warning.check_interval") <= 0 {
+		errorText += fmt.Sprintf(
+			"Fatal config error: expiry_warning.check_interval (%s) must be more than 0\n",
+			viper.GetString("expiry_warning.check_interval"),
+		)
+	}
+
 	if errorText != "" {
 		//nolint
 		return errors.New(strings.TrimSuffix(errorText, "\n"))
@@ -354,6 +525,163 @@ func GetLogTailConfig() LogTailConfig {
 	}
 }
 
+func GetExpiryWarningConfig() ExpiryWarningConfig {
+	return ExpiryWarningConfig{
+		Days:          viper.GetInt("expiry_warning.days"),
+		CheckInterval: viper.GetDuration("expiry_warning.check_interval"),
+		WebhookURL:    viper.GetString("expiry_warning.webhook_url"),
+		SMTP: SMTPConfig{
+			Host:     viper.GetString("expiry_warning.smtp.host"),
+			Port:     viper.GetInt("expiry_warning.smtp.port"),
+			Username: viper.GetString("expiry_warning.smtp.username"),
+			Password: viper.GetString("expiry_warning.smtp.password"),
+			From:     viper.GetString("expiry_warning.smtp.from"),
+		},
+	}
+}
+
+// GetIdentityProvidersConfig reads the identity_providers list. Claims not
+// mapped in the configuration fall back to the defaults of the provider type.
+func GetIdentityProvidersConfig() ([]IdentityProviderConfig, error) {
+	var rawProviders []struct {
+		Name             string            `mapstructure:"name"`
+		DisplayName      string            `mapstructure:"display_name"`
+		Type             string            `mapstructure:"type"`
+		Issuer           string            `mapstructure:"issuer"`
+		ClientID         string            `mapstructure:"client_id"`
+		ClientSecret     string            `mapstructure:"client_secret"`
+		ClientSecretPath string            `mapstructure:"client_secret_path"`
+		LogoutURL        string            `mapstructure:"logout_url"`
+		Scope            []string          `mapstructure:"scope"`
+		ExtraParams      map[string]string `mapstructure:"extra_params"`
+		AllowedDomains   []string          `mapstructure:"allowed_domains"`
+		AllowedUsers     []string          `mapstructure:"allowed_users"`
+		AllowedGroups    []string          `mapstructure:"allowed_groups"`
+		StripEmaildomain *bool             `mapstructure:"strip_email_domain"`
+		Claims           struct {
+			Username    string `mapstructure:"username"`
+			DisplayName string `mapstructure:"display_name"`
+			Email       string `mapstructure:"email"`
+			Groups      string `mapstructure:"groups"`
+		} `mapstructure:"claims"`
+	}
+
+	if err := viper.UnmarshalKey("identity_providers", &rawProviders); err != nil {
+		return nil, fmt.Errorf("failed to parse identity_providers: %w", err)
+	}
+
+	providers := make([]IdentityProviderConfig, 0, len(rawProviders))
+	for _, raw := range rawProviders {
+		clientSecret := raw.ClientSecret
+		if raw.ClientSecretPath != "" {
+			if clientSecret != "" {
+				return nil, errOidcMutuallyExclusive
+			}
+			secretBytes, err := os.ReadFile(os.ExpandEnv(raw.ClientSecretPath))
+			if err != nil {
+				return nil, err
+			}
+			clientSecret = strings.TrimSpace(string(secretBytes))
+		}
+
+		provider := IdentityProviderConfig{
+			Name:             raw.Name,
+			DisplayName:      raw.DisplayName,
+			Type:             raw.Type,
+			Issuer:           raw.Issuer,
+			ClientID:         raw.ClientID,
+			ClientSecret:     clientSecret,
+			LogoutURL:        raw.LogoutURL,
+			Scope:            raw.Scope,
+			ExtraParams:      raw.ExtraParams,
+			AllowedDomains:   raw.AllowedDomains,
+			AllowedUsers:     raw.AllowedUsers,
+			AllowedGroups:    raw.AllowedGroups,
+			StripEmaildomain: raw.StripEmaildomain == nil || *raw.StripEmaildomain,
+			Claims: ClaimMapping{
+				Username:    raw.Claims.Username,
+				DisplayName: raw.Claims.DisplayName,
+				Email:       raw.Claims.Email,
+				Groups:      raw.Claims.Groups,
+			},
+		}
+		if provider.Type == "" {
+			provider.Type = IdentityProviderTypeOIDC
+		}
+		if provider.DisplayName == "" {
+			provider.DisplayName = provider.Name
+		}
+		if len(provider.Scope) == 0 {
+			provider.Scope = []string{oidc.ScopeOpenID, "profile", "email"}
+		}
+		provider.Claims = provider.Claims.withDefaults(provider.Type)
+
+		providers = append(providers, provider)
+	}
+
+	if err := validateIdentityProviders(providers); err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
+
+// GetRolesConfig reads the roles section and checks the role names.
+func GetRolesConfig() (RolesConfig, error) {
+	var rawMappings []struct {
+		Group string `mapstructure:"group"`
+		Role  string `mapstructure:"role"`
+	}
+	if err := viper.UnmarshalKey("roles.group_mappings", &rawMappings); err != nil {
+		return RolesConfig{}, fmt.Errorf("failed to parse roles.group_mappings: %w", err)
+	}
+
+	cfg := RolesConfig{
+		Default: Role(viper.GetString("roles.default")),
+	}
+	if !cfg.Default.valid() {
+		return RolesConfig{}, fmt.Errorf("%w: roles.default %q", ErrInvalidRole, cfg.Default)
+	}
+	for _, raw := range rawMappings {
+		mapping := RoleGroupMapping{Group: raw.Group, Role: Role(raw.Role)}
+		if !mapping.Role.valid() {
+			return RolesConfig{}, fmt.Errorf(
+				"%w: %q for group %q", ErrInvalidRole, raw.Role, raw.Group)
+		}
+		cfg.GroupMappings = append(cfg.GroupMappings, mapping)
+	}
+
+	return cfg, nil
+}
+
+// GetTrustedProxiesConfig reads trusted_proxies, CIDRs or single addresses.
+func GetTrustedProxiesConfig() ([]netip.Prefix, error) {
+	configured := viper.GetStringSlice("trusted_proxies")
+	proxies := make([]netip.Prefix, 0, len(configured))
+	for i, value := range configured {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted_proxies[%d]: %w", i, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func GetSMSConfig() SMSConfig {
+	return SMSConfig{
+		Provider:   viper.GetString("sms.provider"),
+		WebhookURL: viper.GetString("sms.webhook_url"),
+		LogPath:    AbsolutePathFromConfigPath(viper.GetString("sms.log_path")),
+	}
+}
+
 func GetACLConfig() ACLConfig {
 	policyPath := viper.GetString("acl_policy_path")
 
@@ -570,6 +898,21 @@ func GetHeadscaleConfig() (*Config, error) {
 		oidcClientSecret = string(secretBytes)
 	}
 
+	identityProviders, err := GetIdentityProvidersConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rolesConfig, err := GetRolesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := GetTrustedProxiesConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		ServerURL:          viper.GetString("server_url"),
 		Addr:               viper.GetString("listen_addr"),
@@ -649,11 +992,59 @@ func GetHeadscaleConfig() (*Config, error) {
 			UseExpiryFromToken: viper.GetBool("oidc.use_expiry_from_token"),
 		},
 
+		IdentityProviders: identityProviders,
+
 		LogTail:             logConfig,
 		RandomizeClientPort: randomizeClientPort,
 
 		ACL: GetACLConfig(),
 
+		ExpiryWarning: GetExpiryWarningConfig(),
+
+		Quotas: QuotaConfig{
+			MaxMachines:            viper.GetUint("quotas.max_machines"),
+			MaxReusablePreAuthKeys: viper.GetUint("quotas.max_reusable_preauth_keys"),
+			MaxRoutes:              viper.GetUint("quotas.max_routes"),
+		},
+
+		SMS: GetSMSConfig(),
+
+		UserDirectory: viper.GetString("user_directory"),
+
+		LocalAccounts: LocalAccountsConfig{
+			Enabled:    viper.GetBool("local_accounts.enabled"),
+			TOTPIssuer: viper.GetString("local_accounts.totp_issuer"),
+		},
+
+		SCIM: SCIMConfig{
+			Enabled: viper.GetBool("scim.enabled"),
+			Token:   viper.GetString("scim.token"),
+		},
+
+		ConsoleSessions: ConsoleSessionsConfig{
+			IdleTimeout:     viper.GetDuration("console_sessions.idle_timeout"),
+			AbsoluteTimeout: viper.GetDuration("console_sessions.absolute_timeout"),
+		},
+
+		RateLimit: RateLimitConfig{
+			Enabled: viper.GetBool("rate_limit.enabled"),
+			PerIP: RateLimit{
+				RequestsPerMinute: viper.GetFloat64("rate_limit.per_ip.requests_per_minute"),
+				Burst:             viper.GetInt("rate_limit.per_ip.burst"),
+			},
+			PerIdentity: RateLimit{
+				RequestsPerMinute: viper.GetFloat64("rate_limit.per_identity.requests_per_minute"),
+				Burst:             viper.GetInt("rate_limit.per_identity.burst"),
+			},
+			MaxFailures:     viper.GetInt("rate_limit.lockout.max_failures"),
+			FailureWindow:   viper.GetDuration("rate_limit.lockout.window"),
+			LockoutDuration: viper.GetDuration("rate_limit.lockout.duration"),
+		},
+
+		TrustedProxies: trustedProxies,
+
+		Roles: rolesConfig,
+
 		CLI: CLIConfig{
 			Address:  viper.GetString("cli.address"),
 			APIKey:   viper.GetString("cli.api_key"),
@@ -68,6 +68,7 @@ type machineItem struct {
 	MIPv6        string `json:"mipv6"`
 	OS           string `json:"os"`
 	Hostname     string `json:"hostname"`
+	Domain       string `json:"domain"` //幻域名称中设备名之后的部分
 	Version      string `json:"version"`
 	IfOnline     bool   `json:"ifonline"`
 	LastSeen     string `json:"lastseen"`
@@ -98,6 +99,10 @@ type machineItem struct {
 	DERPs             map[string]int `json:"derps"`
 	PrefferDERP       string         `json:"usederp"`
 	AutomaticNameMode bool           `json:"automaticNameMode"`
+
+	ExpiringSoon  bool   `json:"expiringSoon"`  //密钥即将过期
+	PostureAction string `json:"postureAction"` //设备合规检查未通过时的处置方式
+	PostureReason string `json:"postureReason"` //设备合规检查未通过的原因
 }
 type adminTemplateConfig struct {
 	ErrorMsg     string                 `json:"errormsg"`
@@ -106,6 +111,8 @@ type adminTemplateConfig struct {
 	UserNameHead string                 `json:"usernamehead"`
 	UserAccount  string                 `json:"useraccount"`
 	OrgName      string                 `json:"orgname"`
+	Role         Role                   `json:"role"`
+	Permissions  []Permission           `json:"permissions"` //角色在他人资源上的权限，自己的资源始终可管理
 	MList        map[string]machineItem `json:"mlist"`
 }
 
@@ -114,35 +121,9 @@ func (h *Headscale) ConsoleSelfAPI(
 	writer http.ResponseWriter,
 	req *http.Request,
 ) {
-	tokenCookie, _ := req.Cookie("OIDC_Token")
-	rawToken := tokenCookie.Value
-	idToken, err := h.verifyIDTokenForOIDCCallback(req.Context(), writer, rawToken)
-	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "验证Token失败"}
-		err = json.NewEncoder(writer).Encode(&errRes)
-		if err != nil {
-			log.Error().
-				Caller().
-				Err(err).
-				Msg("Failed to write response")
-		}
-		return
-	}
-	claims, err := extractIDTokenClaims(writer, idToken)
+	identity, err := h.getConsoleIdentity(req)
 	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "解析用户信息失败"}
-		err = json.NewEncoder(writer).Encode(&errRes)
-		if err != nil {
-			log.Error().
-				Caller().
-				Err(err).
-				Msg("Failed to write response")
-		}
-		return
-	}
-	userName, _ /*UID*/, userDisName, err := getUserName(writer, claims, h.cfg.OIDC.StripEmaildomain)
-	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "提取用户信息失败"}
+		errRes := adminTemplateConfig{ErrorMsg: "验证会话失败"}
 		err = json.NewEncoder(writer).Encode(&errRes)
 		if err != nil {
 			log.Error().
@@ -152,6 +133,8 @@ func (h *Headscale) ConsoleSelfAPI(
 		}
 		return
 	}
+	userName := identity.UserName
+	userDisName := identity.DisplayName
 	userNameHead := string([]rune(userDisName)[0])
 
 	userOrgName := userName
@@ -165,6 +148,16 @@ func (h *Headscale) ConsoleSelfAPI(
 		UserName:     userDisName,
 		UserAccount:  userName,
 		OrgName:      userOrgName,
+		Permissions:  []Permission{},
+	}
+	if user, err := h.GetUser(userName); err == nil {
+		organization, role := h.consoleOrganization(req, user)
+		if !organization.IsDefault {
+			renderData.OrgName = organization.DisplayName
+		}
+		renderData.Role = role
+		renderData.Permissions = renderData.Role.Permissions()
+		renderData.Basedomain, _ = h.userMagicDNS(user)
 	}
 
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -183,35 +176,9 @@ func (h *Headscale) verifyTokenIDandGetUser(
 	writer http.ResponseWriter,
 	req *http.Request,
 ) string {
-	tokenCookie, _ := req.Cookie("OIDC_Token")
-	rawToken := tokenCookie.Value
-	idToken, err := h.verifyIDTokenForOIDCCallback(req.Context(), writer, rawToken)
-	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "验证Token失败"}
-		err = json.NewEncoder(writer).Encode(&errRes)
-		if err != nil {
-			log.Error().
-				Caller().
-				Err(err).
-				Msg("Failed to write response")
-		}
-		return ""
-	}
-	claims, err := extractIDTokenClaims(writer, idToken)
+	identity, err := h.getConsoleIdentity(req)
 	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "解析用户信息失败"}
-		err = json.NewEncoder(writer).Encode(&errRes)
-		if err != nil {
-			log.Error().
-				Caller().
-				Err(err).
-				Msg("Failed to write response")
-		}
-		return ""
-	}
-	userName, _ /*UID*/, _ /*userDisName*/, err := getUserName(writer, claims, h.cfg.OIDC.StripEmaildomain)
-	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "提取用户信息失败"}
+		errRes := adminTemplateConfig{ErrorMsg: "验证会话失败"}
 		err = json.NewEncoder(writer).Encode(&errRes)
 		if err != nil {
 			log.Error().
@@ -221,6 +188,7 @@ func (h *Headscale) verifyTokenIDandGetUser(
 		}
 		return ""
 	}
+	userName := identity.UserName
 	return userName
 }
 
@@ -229,11 +197,9 @@ func (h *Headscale) ConsoleMachinesAPI(
 	writer http.ResponseWriter,
 	req *http.Request,
 ) {
-	tokenCookie, _ := req.Cookie("OIDC_Token")
-	rawToken := tokenCookie.Value
-	idToken, err := h.verifyIDTokenForOIDCCallback(req.Context(), writer, rawToken)
+	identity, err := h.getConsoleIdentity(req)
 	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "验证Token失败"}
+		errRes := adminTemplateConfig{ErrorMsg: "验证会话失败"}
 		err = json.NewEncoder(writer).Encode(&errRes)
 		if err != nil {
 			log.Error().
@@ -243,21 +209,10 @@ func (h *Headscale) ConsoleMachinesAPI(
 		}
 		return
 	}
-	claims, err := extractIDTokenClaims(writer, idToken)
-	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "解析用户信息失败"}
-		err = json.NewEncoder(writer).Encode(&errRes)
-		if err != nil {
-			log.Error().
-				Caller().
-				Err(err).
-				Msg("Failed to write response")
-		}
-		return
-	}
-	userName, _ /*UID*/, _ /*userDisName*/, err := getUserName(writer, claims, h.cfg.OIDC.StripEmaildomain)
+	userName := identity.UserName
+	user, err := h.GetUser(userName)
 	if err != nil {
-		errRes := adminTemplateConfig{ErrorMsg: "提取用户信息失败"}
+		errRes := adminTemplateConfig{ErrorMsg: "查询用户失败"}
 		err = json.NewEncoder(writer).Encode(&errRes)
 		if err != nil {
 			log.Error().
@@ -268,7 +223,13 @@ func (h *Headscale) ConsoleMachinesAPI(
 		return
 	}
 
-	UserMachines, err := h.ListMachinesByUser(userName)
+	// 有查看全网权限的角色可以看到所在组织所有用户的设备
+	var UserMachines []Machine
+	if organization, role := h.consoleOrganization(req, user); role.Can(PermissionReadTailnet) {
+		UserMachines, err = h.ListOrganizationMachines(organization.ID)
+	} else {
+		UserMachines, err = h.ListMachinesByUser(userName)
+	}
 	if err != nil {
 		errRes := adminTemplateConfig{ErrorMsg: "查询用户节点列表失败"}
 		err = json.NewEncoder(writer).Encode(&errRes)
@@ -281,16 +242,35 @@ func (h *Headscale) ConsoleMachinesAPI(
 		return
 	}
 
+	userBaseDomain, _ := h.userMagicDNS(user)
+	onlyExpiringSoon := req.URL.Query().Get("filter") == "expiring"
 	mlist := make(map[string]machineItem)
 	for _, machine := range UserMachines {
+		expiringSoon := machine.isExpiringSoon(h.expiryWarningPeriod())
+		if onlyExpiringSoon && !expiringSoon {
+			continue
+		}
 		IPNver := machine.HostInfo.IPNVersion
 		if strings.Contains(IPNver, "-") {
 			IPNver = strings.Split(machine.HostInfo.IPNVersion, "-")[0]
 		}
 		tz, _ := time.LoadLocation("Asia/Shanghai")
 
+		machineDomain, err := h.machineMagicDNSName(&machine)
+		if err != nil {
+			errRes := adminTemplateConfig{ErrorMsg: "查询设备域名失败"}
+			err = json.NewEncoder(writer).Encode(&errRes)
+			if err != nil {
+				log.Error().
+					Caller().
+					Err(err).
+					Msg("Failed to write response")
+			}
+			return
+		}
 		tmpMachine := machineItem{
 			Name:         machine.GivenName,
+			Domain:       machineDomain,
 			UserAccount:  machine.User.Name,
 			UserNameHead: string([]rune(machine.User.Display_Name)[0]),
 			OS:           machine.HostInfo.OS,
@@ -310,6 +290,10 @@ func (h *Headscale) ConsoleMachinesAPI(
 			CanPMP:            machine.HostInfo.NetInfo.PMP.EqualBool(true),
 			Endpoints:         machine.Endpoints,
 			AutomaticNameMode: machine.AutoGenName,
+
+			ExpiringSoon:  expiringSoon,
+			PostureAction: machine.PostureAction,
+			PostureReason: machine.PostureReason,
 		}
 
 		machineRoutes, err := h.GetMachineRoutes(&machine)
@@ -402,7 +386,7 @@ func (h *Headscale) ConsoleMachinesAPI(
 	}
 
 	renderData := adminTemplateConfig{
-		Basedomain: h.cfg.BaseDomain,
+		Basedomain: userBaseDomain,
 		MList:      mlist,
 	}
 
@@ -426,6 +410,15 @@ type NetSettingResData struct {
 	MachineAuthNeeded  bool   `json:"machineAuthNeeded"`
 	MaxKeyDurationDays int    `json:"maxKeyDurationDays"`
 	NetworkLockEnabled bool   `json:"networkLockEnabled"`
+
+	Quotas QuotaResData `json:"quotas"` //用户配额，0表示不限制
+}
+
+type QuotaResData struct {
+	MaxMachines     uint  `json:"maxMachines"`
+	Machines        int64 `json:"machines"`
+	MaxReusableKeys uint  `json:"maxReusableKeys"`
+	MaxRoutes       uint  `json:"maxRoutes"`
 }
 
 // 查询网络设置API
@@ -453,6 +446,13 @@ func (h *Headscale) getNetSettingAPI(
 		NetworkLockEnabled: false, //未实现
 	}
 	netsettingData.MaxKeyDurationDays = int(user.ExpiryDuration)
+	quota := h.effectiveQuota(user)
+	netsettingData.Quotas = QuotaResData{
+		MaxMachines:     quota.MaxMachines,
+		MaxReusableKeys: quota.MaxReusablePreAuthKeys,
+		MaxRoutes:       quota.MaxRoutes,
+	}
+	h.db.Model(&Machine{}).Where("user_id = ?", user.ID).Count(&netsettingData.Quotas.Machines)
 	h.doAPIResponse(writer, "", netsettingData)
 }
 
@@ -518,15 +518,24 @@ func (h *Headscale) ConsoleMachinesUpdateAPI(
 		h.doAPIResponse(writer, "查询用户设备失败", nil)
 		return
 	}
-	if toUpdateMachine.User.Name != userName {
-		h.doAPIResponse(writer, "用户没有该权限", nil)
-		return
-	}
 	reqState, ok := reqData["state"].(string)
 	if !ok {
 		h.doAPIResponse(writer, "用户请求state解析失败", nil)
 		return
 	}
+	user, err := h.GetUser(userName)
+	if err != nil {
+		h.doAPIResponse(writer, "查询用户失败", nil)
+		return
+	}
+	permission := PermissionManageMachines
+	if reqState == "set-route-settings" {
+		permission = PermissionManageRoutes
+	}
+	if !h.userCan(user, &toUpdateMachine.User, permission) {
+		h.doAPIResponse(writer, "用户没有该权限", nil)
+		return
+	}
 
 	switch reqState {
 	case "set-expires": //切换密钥永不过期设置
@@ -637,7 +646,16 @@ func (h *Headscale) ConsoleRemoveMachineAPI(
 		}
 		return
 	}
-	UserMachines, err := h.ListMachinesByUser(userName)
+	// 有设备管理权限的角色可以删除所在组织所有用户的设备
+	var UserMachines []Machine
+	user, err := h.GetUser(userName)
+	if err == nil {
+		if organization, role := h.consoleOrganization(req, user); role.Can(PermissionManageMachines) {
+			UserMachines, err = h.ListOrganizationMachines(organization.ID)
+		} else {
+			UserMachines, err = h.ListMachinesByUser(userName)
+		}
+	}
 	if err != nil {
 		resData.Status = "Error"
 		resData.ErrMsg = "用户设备检索失败"
@@ -0,0 +1,170 @@
+package headscale
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type AccountData struct {
+	HasPassword   bool `json:"hasPassword"`
+	TOTPEnabled   bool `json:"totpEnabled"`
+	RecoveryCodes int  `json:"recoveryCodes"` //剩余恢复码数量
+}
+
+type TOTPEnrollData struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type accountREQ struct {
+	Password    string `json:"password"`
+	NewPassword string `json:"newPassword"`
+	Code        string `json:"code"`
+}
+
+// 接受/admin/api/account的Get请求，查询本地账号的安全设置
+func (h *Headscale) CAPIGetAccount(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	user, err := h.GetUser(userName)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户失败", nil)
+		return
+	}
+	h.doAPIResponse(w, "", AccountData{
+		HasPassword:   len(user.PasswordHash) > 0,
+		TOTPEnabled:   user.TOTPEnabled,
+		RecoveryCodes: len(user.RecoveryCodeHashes),
+	})
+}
+
+// 接受/admin/api/account/password的Post请求，设置或修改本地账号密码
+func (h *Headscale) CAPIPostPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	reqData := accountREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	if !h.checkAccountPassword(userName, reqData.Password) {
+		h.doAPIResponse(w, "原密码错误", nil)
+		return
+	}
+	err := h.SetUserPassword(userName, reqData.NewPassword)
+	if errors.Is(err, ErrPasswordTooShort) {
+		h.doAPIResponse(w, "密码长度不能少于8位", nil)
+		return
+	}
+	if err != nil {
+		h.doAPIResponse(w, "密码设置失败", nil)
+		return
+	}
+	h.doAPIResponse(w, "", "密码设置成功")
+}
+
+// 接受/admin/api/account/totp的Post请求，生成待确认的TOTP密钥
+func (h *Headscale) CAPIPostTOTPEnroll(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	secret, uri, err := h.EnrollUserTOTP(userName)
+	if errors.Is(err, ErrTOTPAlreadyEnabled) {
+		h.doAPIResponse(w, "已启用二次验证，请先关闭", nil)
+		return
+	}
+	if err != nil {
+		h.doAPIResponse(w, "生成二次验证密钥失败", nil)
+		return
+	}
+	h.doAPIResponse(w, "", TOTPEnrollData{Secret: secret, URI: uri})
+}
+
+// 接受/admin/api/account/totp/confirm的Post请求，校验动态验证码后启用二次验证并返回恢复码
+func (h *Headscale) CAPIPostTOTPConfirm(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	reqData := accountREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	codes, err := h.ConfirmUserTOTP(userName, reqData.Code)
+	switch {
+	case errors.Is(err, ErrInvalidTOTPCode):
+		h.doAPIResponse(w, "动态验证码错误", nil)
+	case errors.Is(err, ErrTOTPNotEnrolled):
+		h.doAPIResponse(w, "请先生成二次验证密钥", nil)
+	case errors.Is(err, ErrTOTPAlreadyEnabled):
+		h.doAPIResponse(w, "已启用二次验证", nil)
+	case err != nil:
+		h.doAPIResponse(w, "启用二次验证失败", nil)
+	default:
+		h.doAPIResponse(w, "", codes)
+	}
+}
+
+// 接受/admin/api/account/totp/disable的Post请求，校验密码后关闭二次验证
+func (h *Headscale) CAPIPostTOTPDisable(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	reqData := accountREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	if !h.checkAccountPassword(userName, reqData.Password) {
+		h.doAPIResponse(w, "密码错误", nil)
+		return
+	}
+	if err := h.ResetUserTOTP(userName); err != nil {
+		h.doAPIResponse(w, "关闭二次验证失败", nil)
+		return
+	}
+	h.doAPIResponse(w, "", "已关闭二次验证")
+}
+
+// 校验本地账号密码，未设置过密码的账号（如仅通过OIDC登录）无需校验
+func (h *Headscale) checkAccountPassword(userName string, password string) bool {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return false
+	}
+	if len(user.PasswordHash) == 0 {
+		return true
+	}
+
+	return bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) == nil
+}
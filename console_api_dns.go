@@ -3,7 +3,9 @@ package headscale
 import (
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -16,6 +18,14 @@ type DNSData struct {
 	MagicDNS          bool                `json:"magicDNS"`          //是否启用幻域
 	HasNextDNS        bool                `json:"hasNextDNS"`        // TODO:未实现
 	MagicDNSDomains   []string            `json:"magicDNSDomains"`   //幻域域列表
+	BaseDomain        string              `json:"baseDomain"`        //幻域基础域名
+	OmitUserLabel     bool                `json:"omitUserLabel"`     //设备幻域名称是否不含用户名
+	CanManageDomain   bool                `json:"canManageDomain"`   //能否修改幻域基础域名
+}
+
+type magicDNSDomainREQ struct {
+	BaseDomain    string `json:"baseDomain"`
+	OmitUserLabel bool   `json:"omitUserLabel"`
 }
 
 // 接受/admin/api/dns的Get请求，用于查询DNS
@@ -33,7 +43,7 @@ func (h *Headscale) CAPIGetDNS(
 		h.doAPIResponse(w, "查询用户失败:"+err.Error(), nil)
 		return
 	}
-	userDNSCfg, userBaseDomain := user.GetDNSConfig(h.cfg.IPPrefixes)
+	userDNSCfg := user.GetDNSConfig(h.organizationIPPrefixes(user.OrganizationID))
 	dnsData := DNSData{
 		Domains:           make([]string, 0),
 		Resolvers:         make([]string, 0),
@@ -57,7 +67,10 @@ func (h *Headscale) CAPIGetDNS(
 		}
 	}
 	dnsData.MagicDNSDomains = make([]string, 0)
-	dnsData.MagicDNSDomains = append(dnsData.MagicDNSDomains, userBaseDomain)
+	dnsData.MagicDNSDomains = append(dnsData.MagicDNSDomains, h.userMagicDNSDomain(user))
+	dnsData.BaseDomain, dnsData.OmitUserLabel = h.userMagicDNS(user)
+	_, role := h.consoleOrganization(r, user)
+	dnsData.CanManageDomain = role.Can(PermissionManageDNS)
 	if len(userDNSCfg.Routes) > 0 {
 		for domain, nsl := range userDNSCfg.Routes {
 			if strings.HasSuffix(domain, "in-addr.arpa") || strings.HasSuffix(domain, "ip6.arpa") {
@@ -99,35 +112,216 @@ func (h *Headscale) CAPIPostDNS(
 
 }
 
-// 注销Key执行DELETE方法api/keys/:Id
-func (h *Headscale) CAPIDelDNS(
+// 接受/admin/api/dns/domain的Post请求，用于修改用户的幻域基础域名
+func (h *Headscale) CAPIPostDNSDomain(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, _, _ := h.consoleUserWithPermission(w, r, PermissionManageDNS)
+	if current == nil {
+		return
+	}
+	reqData := magicDNSDomainREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	_, err := h.SetUserMagicDNS(current.Name, MagicDNSSettings{
+		BaseDomain:    reqData.BaseDomain,
+		OmitUserLabel: &reqData.OmitUserLabel,
+	})
+	switch {
+	case errors.Is(err, ErrInvalidBaseDomain):
+		h.doAPIResponse(w, "基础域名无效", nil)
+		return
+	case errors.Is(err, ErrMagicDNSNameConflict):
+		h.doAPIResponse(w, "存在重名设备，请先重命名设备", nil)
+		return
+	case err != nil:
+		h.doAPIResponse(w, "更新幻域设置失败", nil)
+		return
+	}
+	h.CAPIGetDNS(w, r)
+}
+
+type DNSRecordItem struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Value       string `json:"value"`       //指向设备时为设备当前的地址
+	MachineID   string `json:"machineId"`   //为空时指向固定地址
+	MachineName string `json:"machineName"` //指向的设备名称
+}
+
+type DNSRecordMachine struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	User string `json:"user"`
+}
+
+type DNSRecordsData struct {
+	Records   []DNSRecordItem    `json:"records"`
+	Machines  []DNSRecordMachine `json:"machines"` //可作为记录目标的设备
+	CanManage bool               `json:"canManage"`
+}
+
+type dnsRecordREQ struct {
+	ID        string `json:"id"` //为空时新建记录
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	MachineID string `json:"machineId"`
+}
+
+// 接受/admin/api/dns/records的Get请求，查询当前组织的自定义DNS记录
+func (h *Headscale) CAPIGetDNSRecords(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
 	userName := h.verifyTokenIDandGetUser(w, r)
-	targetKeyID := strings.TrimPrefix(r.URL.Path, "/admin/api/keys/")
-	allKeys, err := h.ListPreAuthKeys(userName)
+	if userName == "" {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	user, err := h.GetUser(userName)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户失败", nil)
+		return
+	}
+	organization, role := h.consoleOrganization(r, user)
+	records, err := h.ListDNSRecords(organization.ID)
 	if err != nil {
-		h.doAPIResponse(w, "查询用户密钥信息失败", nil)
+		h.doAPIResponse(w, "查询DNS记录失败", nil)
 		return
 	}
-	toDelKeys := make([]PreAuthKey, 0)
-	for _, key := range allKeys {
-		if key.Key[:12] == targetKeyID {
-			toDelKeys = append(toDelKeys, key)
+	machines, err := h.dnsRecordMachines(records)
+	if err != nil {
+		h.doAPIResponse(w, "查询DNS记录失败", nil)
+		return
+	}
+	resData := DNSRecordsData{
+		Records:   make([]DNSRecordItem, 0, len(records)),
+		Machines:  make([]DNSRecordMachine, 0),
+		CanManage: role.Can(PermissionManageDNS),
+	}
+	for index := range records {
+		record := &records[index]
+		item := DNSRecordItem{
+			ID:    strconv.FormatUint(record.ID, Base10),
+			Name:  record.Name,
+			Type:  record.Type,
+			Value: strings.Join(record.resolve(machines), ", "),
+		}
+		if record.MachineID != nil {
+			item.MachineID = strconv.FormatUint(*record.MachineID, Base10)
+			if machine, found := machines[*record.MachineID]; found {
+				item.MachineName = machine.GivenName
+			}
+		}
+		resData.Records = append(resData.Records, item)
+	}
+	if resData.CanManage {
+		organizationMachines, err := h.ListOrganizationMachines(organization.ID)
+		if err != nil {
+			h.doAPIResponse(w, "查询设备列表失败", nil)
+			return
+		}
+		for _, machine := range organizationMachines {
+			resData.Machines = append(resData.Machines, DNSRecordMachine{
+				ID:   strconv.FormatUint(machine.ID, Base10),
+				Name: machine.GivenName,
+				User: machine.User.Name,
+			})
 		}
 	}
-	if len(toDelKeys) == 0 {
-		h.doAPIResponse(w, "该密钥不存在", nil)
+	h.doAPIResponse(w, "", resData)
+}
+
+// 接受/admin/api/dns/records的Post请求，新建或修改自定义DNS记录
+func (h *Headscale) CAPIPostDNSRecord(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, _ := h.consoleUserWithPermission(w, r, PermissionManageDNS)
+	if current == nil {
 		return
-	} else if len(toDelKeys) > 1 {
-		h.doAPIResponse(w, "存在多个密钥具备相同短形式（ID），请联系工作人员", nil)
+	}
+	reqData := dnsRecordREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
 		return
 	}
-	err = h.DestroyPreAuthKey(toDelKeys[0])
+	record := DNSRecord{
+		Name:  reqData.Name,
+		Type:  reqData.Type,
+		Value: reqData.Value,
+	}
+	if reqData.MachineID != "" {
+		machineID, err := strconv.ParseUint(reqData.MachineID, Base10, 64)
+		if err != nil {
+			h.doAPIResponse(w, "目标设备无效", nil)
+			return
+		}
+		record.MachineID = &machineID
+	}
+
+	var err error
+	if reqData.ID == "" {
+		_, err = h.CreateDNSRecord(organization.ID, record)
+	} else {
+		var id uint64
+		var existing *DNSRecord
+		id, err = strconv.ParseUint(reqData.ID, Base10, 64)
+		if err == nil {
+			existing, err = h.GetDNSRecord(id)
+		}
+		if err != nil || existing.OrganizationID != organization.ID {
+			h.doAPIResponse(w, "该DNS记录不存在", nil)
+			return
+		}
+		_, err = h.UpdateDNSRecord(id, record)
+	}
+	switch {
+	case errors.Is(err, ErrInvalidDNSRecord):
+		h.doAPIResponse(w, "DNS记录无效，请检查名称、类型及地址与类型是否匹配", nil)
+		return
+	case errors.Is(err, ErrDNSRecordExists):
+		h.doAPIResponse(w, "该DNS记录已存在", nil)
+		return
+	case err != nil:
+		h.doAPIResponse(w, "保存DNS记录失败", nil)
+		return
+	}
+	h.CAPIGetDNSRecords(w, r)
+}
+
+// 接受/admin/api/dns/records/remove的Post请求，删除自定义DNS记录
+func (h *Headscale) CAPIRemoveDNSRecord(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, _ := h.consoleUserWithPermission(w, r, PermissionManageDNS)
+	if current == nil {
+		return
+	}
+	reqData := dnsRecordREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	id, err := strconv.ParseUint(reqData.ID, Base10, 64)
 	if err != nil {
-		h.doAPIResponse(w, "执行密钥删除失败", nil)
+		h.doAPIResponse(w, "该DNS记录不存在", nil)
+		return
+	}
+	record, err := h.GetDNSRecord(id)
+	if err != nil || record.OrganizationID != organization.ID {
+		h.doAPIResponse(w, "该DNS记录不存在", nil)
+		return
+	}
+	if err := h.DeleteDNSRecord(id); err != nil {
+		h.doAPIResponse(w, "删除DNS记录失败", nil)
 		return
 	}
-	h.doAPIResponse(w, "", targetKeyID)
+	h.CAPIGetDNSRecords(w, r)
 }
@@ -0,0 +1,59 @@
+package headscale
+
+import (
+	"net/http"
+	"strconv"
+)
+
+type MachineHistoryItem struct {
+	Event    string `json:"event"`    //变更类型
+	OldValue string `json:"oldValue"` //变更前的值
+	NewValue string `json:"newValue"` //变更后的值
+	Created  string `json:"created"`  //变更时间
+}
+
+// 接受/admin/api/machine/history的Get请求，用于查询设备变更记录
+func (h *Headscale) CAPIGetMachineHistory(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	machineID, err := strconv.ParseUint(r.URL.Query().Get("mid"), 0, 64)
+	if err != nil {
+		h.doAPIResponse(w, "用户请求mid处理失败", nil)
+		return
+	}
+	machine, err := h.GetMachineByID(machineID)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户设备失败", nil)
+		return
+	}
+	user, err := h.GetUser(userName)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户失败", nil)
+		return
+	}
+	if !h.userCan(user, &machine.User, PermissionReadTailnet) {
+		h.doAPIResponse(w, "用户没有该权限", nil)
+		return
+	}
+	history, err := h.GetMachineHistory(machine.ID)
+	if err != nil {
+		h.doAPIResponse(w, "查询设备变更记录失败:"+err.Error(), nil)
+		return
+	}
+	historyData := make([]MachineHistoryItem, 0, len(history))
+	for _, entry := range history {
+		historyData = append(historyData, MachineHistoryItem{
+			Event:    entry.Event,
+			OldValue: entry.OldValue,
+			NewValue: entry.NewValue,
+			Created:  Time2SHString(entry.CreatedAt),
+		})
+	}
+	h.doAPIResponse(w, "", historyData)
+}
@@ -0,0 +1,172 @@
+package headscale
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultInviteExpiryDays = 7
+	maxInviteExpiryDays     = 90
+)
+
+type InvitesData struct {
+	Invites        []InviteItem `json:"invites"`
+	Roles          []Role       `json:"roles"`
+	CanManageRoles bool         `json:"canManageRoles"`
+}
+
+type InviteItem struct {
+	ID        uint64   `json:"id"`
+	Link      string   `json:"link"`
+	Role      Role     `json:"role"`
+	Tags      []string `json:"tags"`
+	CreatedBy string   `json:"createdBy"`
+	Created   string   `json:"created"`
+	Expiry    string   `json:"expiry"`
+	Status    string   `json:"status"` //pending、used、expired或revoked
+	UsedBy    string   `json:"usedBy"`
+}
+
+type inviteCreateREQ struct {
+	Role       string   `json:"role"`
+	Tags       []string `json:"tags"`
+	ExpiryDays int      `json:"expiryDays"`
+}
+
+type inviteRevokeREQ struct {
+	ID uint64 `json:"id"`
+}
+
+func (h *Headscale) inviteItem(invite *Invite) InviteItem {
+	item := InviteItem{
+		ID:        invite.ID,
+		Role:      invite.Role,
+		Tags:      invite.ACLTags,
+		CreatedBy: invite.CreatedBy,
+		Status:    invite.status(),
+		UsedBy:    invite.UsedBy,
+	}
+	if item.Tags == nil {
+		item.Tags = []string{}
+	}
+	if item.Status == "pending" {
+		item.Link = invite.link(h.cfg.ServerURL)
+	}
+	if invite.CreatedAt != nil {
+		item.Created = Time2SHString(*invite.CreatedAt)
+	}
+	if invite.Expiration != nil {
+		item.Expiry = Time2SHString(*invite.Expiration)
+	}
+
+	return item
+}
+
+// 接受/admin/api/invites的Get请求，查询当前组织的邀请
+func (h *Headscale) CAPIGetInvites(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, currentRole := h.consoleUserWithPermission(w, r, PermissionManageUsers)
+	if current == nil {
+		return
+	}
+	invites, err := h.ListInvites(organization.ID)
+	if err != nil {
+		h.doAPIResponse(w, "查询邀请列表失败", nil)
+		return
+	}
+	resData := InvitesData{
+		Invites:        make([]InviteItem, 0, len(invites)),
+		Roles:          roles,
+		CanManageRoles: currentRole.Can(PermissionManageRoles),
+	}
+	for index := range invites {
+		resData.Invites = append(resData.Invites, h.inviteItem(&invites[index]))
+	}
+	h.doAPIResponse(w, "", resData)
+}
+
+// 接受/admin/api/invites的Post请求，创建一次性的邀请链接
+func (h *Headscale) CAPIPostInvite(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, currentRole := h.consoleUserWithPermission(w, r, PermissionManageUsers)
+	if current == nil {
+		return
+	}
+	reqData := inviteCreateREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	var role Role
+	if reqData.Role != "" {
+		parsed, err := ParseRole(reqData.Role)
+		if err != nil {
+			h.doAPIResponse(w, "未知的角色", nil)
+			return
+		}
+		role = parsed
+	}
+	if reqData.ExpiryDays == 0 {
+		reqData.ExpiryDays = defaultInviteExpiryDays
+	}
+	if reqData.ExpiryDays < 0 || reqData.ExpiryDays > maxInviteExpiryDays {
+		h.doAPIResponse(w, "有效期需在1到90天之间", nil)
+		return
+	}
+	tags := make([]string, 0, len(reqData.Tags))
+	for _, tag := range reqData.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	expiration := time.Now().UTC().AddDate(0, 0, reqData.ExpiryDays)
+	invite, err := h.CreateInvite(current, currentRole, organization.ID, role, tags, expiration)
+	switch {
+	case errors.Is(err, ErrOwnerRoleRequired):
+		h.doAPIResponse(w, "只有所有者可以邀请所有者", nil)
+	case errors.Is(err, ErrPermissionDenied):
+		h.doAPIResponse(w, "用户没有授予该角色的权限", nil)
+	case errors.Is(err, ErrPreAuthKeyACLTagInvalid):
+		h.doAPIResponse(w, "标签需以tag:开头", nil)
+	case err != nil:
+		h.doAPIResponse(w, "创建邀请失败", nil)
+	default:
+		h.doAPIResponse(w, "", h.inviteItem(invite))
+	}
+}
+
+// 接受/admin/api/invites/revoke的Post请求，撤销未使用的邀请
+func (h *Headscale) CAPIRevokeInvite(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, _ := h.consoleUserWithPermission(w, r, PermissionManageUsers)
+	if current == nil {
+		return
+	}
+	reqData := inviteRevokeREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	err := h.RevokeInvite(organization.ID, reqData.ID)
+	switch {
+	case errors.Is(err, ErrInviteNotFound):
+		h.doAPIResponse(w, "未找到该邀请", nil)
+	case errors.Is(err, ErrInviteUsed):
+		h.doAPIResponse(w, "该邀请已被使用", nil)
+	case err != nil:
+		h.doAPIResponse(w, "撤销邀请失败", nil)
+	default:
+		h.doAPIResponse(w, "", "邀请已撤销")
+	}
+}
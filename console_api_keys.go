@@ -3,6 +3,7 @@ package headscale
 import (
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -10,19 +11,32 @@ import (
 
 type KeysData struct {
 	AuthKeys            []Key                `json:"authKeys"`
-	InvalidAuthKeys     []InvalidKey         `json:"invalidAuthKeys"`     // 未实现
-	ApiKeys             []Key                `json:"apiKeys"`             //未实现
-	InvalidApiKeys      []InvalidKey         `json:"invalidApiKeys"`      //未实现
-	OauthClients        []OauthClient        `json:"oauthClients"`        //未实现
-	InvalidOauthClients []InvalidOauthClient `json:"invalidOauthClients"` //未实现
+	InvalidAuthKeys     []InvalidKey         `json:"invalidAuthKeys"`
+	ApiKeys             []Key                `json:"apiKeys"`
+	InvalidApiKeys      []InvalidKey         `json:"invalidApiKeys"`
+	ApiKeyScopes        []APIKeyScope        `json:"apiKeyScopes"`
+	OauthClients        []OauthClient        `json:"oauthClients"`
+	InvalidOauthClients []InvalidOauthClient `json:"invalidOauthClients"`
 }
 
-type InvalidOauthClient struct{} //未实现
-type OauthClient struct{}        //未实现
+type InvalidOauthClient struct {
+	ClientData OauthClient `json:"clientData"`
+	Revoked    string      `json:"revoked"`
+}
+
+type OauthClient struct {
+	Id          string   `json:"id"` //client_id
+	Description string   `json:"description"`
+	Created     string   `json:"created"`
+	LastUsed    string   `json:"lastUsed"`
+	Scopes      []string `json:"scopes"` //"all"为全部权限
+	Tags        []string `json:"tags"`
+}
 
 type InvalidKey struct {
 	KeyData Key    `json:"keyData"`
 	Revoked string `json:"revoked"`
+	Reason  string `json:"reason"` //"revoked"、"expired"或"exhausted"
 }
 
 type Key struct {
@@ -35,13 +49,117 @@ type Key struct {
 	Apikey  ApiKeyTypes  `json:"apikey"`
 }
 type AuthKeyTypes struct {
-	Reusable      bool `json:"reusable"`
-	Ephemeral     bool `json:"ephemeral"`
-	Preauthorized bool `json:"preauthorized"` //未实现，建议true
-	ForAdminPanel bool `json:"forAdminPanel"` //未实现，未知含义，建议false
+	Reusable      bool     `json:"reusable"`
+	Ephemeral     bool     `json:"ephemeral"`
+	Preauthorized bool     `json:"preauthorized"` //未实现，建议true
+	ForAdminPanel bool     `json:"forAdminPanel"` //未实现，未知含义，建议false
+	Description   string   `json:"description"`
+	MaxUses       uint     `json:"maxUses"` //0为不限次数
+	Uses          uint     `json:"uses"`
+	LastUsed      string   `json:"lastUsed"`
+	Machines      []string `json:"machines"` //使用该密钥注册的设备名
 }
 type ApiKeyTypes struct {
-	Api string `json:"api"` //"control"
+	Api    string   `json:"api"`    //"control"
+	Scopes []string `json:"scopes"` //"all"为全部权限
+}
+
+const maxAPIKeyExpiry = 90 * 24 * time.Hour
+
+func oauthClientItem(client *OAuthClient) OauthClient {
+	item := OauthClient{
+		Id:          client.ClientID,
+		Description: client.Description,
+		Scopes:      []string{},
+		Tags:        client.ACLTags,
+	}
+	if item.Tags == nil {
+		item.Tags = []string{}
+	}
+	if client.CreatedAt != nil {
+		item.Created = Time2SHString(*client.CreatedAt)
+	}
+	if client.LastUsed != nil {
+		item.LastUsed = Time2SHString(*client.LastUsed)
+	}
+	for _, scope := range client.scopes() {
+		item.Scopes = append(item.Scopes, string(scope))
+	}
+
+	return item
+}
+
+func authKeyItem(key *PreAuthKey, machines []Machine) Key {
+	item := Key{
+		Id:      key.Prefix, //key.ID,
+		Creator: key.User.Name,
+		Type:    "authkey",
+		Authkey: AuthKeyTypes{
+			Reusable:      key.Reusable,
+			Ephemeral:     key.Ephemeral,
+			Preauthorized: true,  //TODO
+			ForAdminPanel: false, //TODO
+			Description:   key.Description,
+			MaxUses:       key.MaxUses,
+			Uses:          key.UseCount,
+			Machines:      make([]string, 0, len(machines)),
+		},
+	}
+	if key.CreatedAt != nil {
+		item.Created = Time2SHString(*key.CreatedAt)
+	}
+	if key.Expiration != nil {
+		item.Expiry = Time2SHString(*key.Expiration)
+	}
+	if key.LastUsed != nil {
+		item.Authkey.LastUsed = Time2SHString(*key.LastUsed)
+	}
+	for _, machine := range machines {
+		item.Authkey.Machines = append(item.Authkey.Machines, machine.GivenName)
+	}
+
+	return item
+}
+
+// 失效授权密钥的失效时间：撤销时间、过期时间或最后使用时间
+func authKeyInvalidTime(key *PreAuthKey, reason string, item Key) string {
+	switch {
+	case reason == PreAuthKeyRevoked:
+		return Time2SHString(*key.RevokedAt)
+	case reason == PreAuthKeyExpired:
+		return item.Expiry
+	case key.LastUsed != nil:
+		return item.Authkey.LastUsed
+	}
+
+	return ""
+}
+
+func apiKeyItem(key *APIKey, creator string) Key {
+	item := Key{
+		Id:      key.Prefix,
+		Creator: creator,
+		Type:    "apikey",
+		Apikey:  ApiKeyTypes{Api: "control", Scopes: []string{}},
+	}
+	if key.CreatedAt != nil {
+		item.Created = Time2SHString(*key.CreatedAt)
+	}
+	if key.Expiration != nil {
+		item.Expiry = Time2SHString(*key.Expiration)
+	}
+	for _, scope := range key.scopes() {
+		item.Apikey.Scopes = append(item.Apikey.Scopes, string(scope))
+	}
+
+	return item
+}
+
+// 连带撤销授权密钥时，随之过期或删除的设备名
+type RevokedAuthKey struct {
+	Id              string   `json:"id"`
+	ExpiredMachines []string `json:"expiredMachines"`
+	DeletedMachines []string `json:"deletedMachines"`
 }
 
 type GenKeyData struct {
@@ -67,38 +185,93 @@ func (h *Headscale) CAPIGetKeys(
 		h.doAPIResponse(w, "授权密钥查询失败", nil)
 		return
 	}
+	authKeyMachines, err := h.ListPreAuthKeyMachines(authKeys)
+	if err != nil {
+		h.doAPIResponse(w, "授权密钥查询失败", nil)
+		return
+	}
 	resData := KeysData{}
 	resData.AuthKeys = make([]Key, 0)
-	for _, key := range authKeys {
-		tmpAuthKey := Key{
-			Id:      key.Key[:12], //key.ID,
-			Created: Time2SHString(*key.CreatedAt),
-			Creator: key.User.Name,
-			Expiry:  Time2SHString(*key.Expiration),
-			Type:    "authkey",
-			Authkey: AuthKeyTypes{
-				Reusable:      key.Reusable,
-				Ephemeral:     key.Ephemeral,
-				Preauthorized: true,  //TODO
-				ForAdminPanel: false, //TODO
-			},
+	resData.InvalidAuthKeys = make([]InvalidKey, 0)
+	now := time.Now()
+	for index := range authKeys {
+		key := &authKeys[index]
+		machines := authKeyMachines[key.ID]
+		item := authKeyItem(key, machines)
+		if reason := key.invalidReason(now, len(machines)); reason != "" {
+			resData.InvalidAuthKeys = append(resData.InvalidAuthKeys, InvalidKey{
+				KeyData: item,
+				Revoked: authKeyInvalidTime(key, reason, item),
+				Reason:  reason,
+			})
+			continue
 		}
-		resData.AuthKeys = append(resData.AuthKeys, tmpAuthKey)
+		resData.AuthKeys = append(resData.AuthKeys, item)
+	}
+
+	apiKeys, err := h.ListUserAPIKeys(userName)
+	if err != nil {
+		h.doAPIResponse(w, "API密钥查询失败", nil)
+		return
+	}
+	resData.ApiKeys = make([]Key, 0)
+	resData.InvalidApiKeys = make([]InvalidKey, 0)
+	resData.ApiKeyScopes = apiKeyScopes
+	for index := range apiKeys {
+		item := apiKeyItem(&apiKeys[index], userName)
+		if apiKeys[index].Expiration != nil && apiKeys[index].Expiration.Before(now) {
+			resData.InvalidApiKeys = append(resData.InvalidApiKeys, InvalidKey{
+				KeyData: item,
+				Revoked: item.Expiry,
+				Reason:  PreAuthKeyExpired,
+			})
+			continue
+		}
+		resData.ApiKeys = append(resData.ApiKeys, item)
+	}
+
+	oauthClients, err := h.ListUserOAuthClients(userName)
+	if err != nil {
+		h.doAPIResponse(w, "OAuth客户端查询失败", nil)
+		return
+	}
+	resData.OauthClients = make([]OauthClient, 0)
+	resData.InvalidOauthClients = make([]InvalidOauthClient, 0)
+	for index := range oauthClients {
+		item := oauthClientItem(&oauthClients[index])
+		if revokedAt := oauthClients[index].RevokedAt; revokedAt != nil {
+			resData.InvalidOauthClients = append(resData.InvalidOauthClients, InvalidOauthClient{
+				ClientData: item,
+				Revoked:    Time2SHString(*revokedAt),
+			})
+			continue
+		}
+		resData.OauthClients = append(resData.OauthClients, item)
 	}
 	h.doAPIResponse(w, "", resData)
 }
 
-// 请求报文：{"keyData":{"type":"authkey","expirySeconds":7776000,"authkey":{"ephemeral":false,"reusable":false,"preauthorized":false}}}
+// 请求报文：{"keyData":{"type":"authkey","expirySeconds":7776000,"authkey":{"ephemeral":false,"reusable":false,"preauthorized":false,"description":"","maxUses":0}}}
+// 或{"keyData":{"type":"apikey","expirySeconds":7776000,"apikey":{"scopes":["machines:read"]}}}
+// 或{"keyData":{"type":"oauthclient","oauthclient":{"description":"CI","scopes":["keys:write"],"tags":["tag:ci"]}}}
 type GenKeyREQ struct {
 	KeyData REQKeyData `json:"keyData"`
 }
 type REQKeyData struct {
-	Type          string       `json:"type"` //"authkey"
-	ExpirySeconds uint64       `json:"expirySeconds"`
-	Authkey       AuthKeyTypes `json:"authkey"`
+	Type          string         `json:"type"` //"authkey"、"apikey"或"oauthclient"
+	ExpirySeconds uint64         `json:"expirySeconds"`
+	Authkey       AuthKeyTypes   `json:"authkey"`
+	Apikey        ApiKeyTypes    `json:"apikey"`
+	OauthClient   REQOauthClient `json:"oauthclient"`
+}
+
+type REQOauthClient struct {
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+	Tags        []string `json:"tags"`
 }
 
-// 接受/admin/api/keys的Post请求，用于创建AuthKey
+// 接受/admin/api/keys的Post请求，用于创建AuthKey、APIKey或OAuth客户端
 func (h *Headscale) CAPIPostKeys(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -119,18 +292,82 @@ func (h *Headscale) CAPIPostKeys(
 	case "authkey":
 		keyCfg := reqData.KeyData.Authkey
 		keyExpiration := time.Now().Add(time.Duration(reqData.KeyData.ExpirySeconds) * time.Second)
-		genedAuthKey, err := h.CreatePreAuthKey(userName, keyCfg.Reusable, keyCfg.Ephemeral, &keyExpiration, nil)
+		genedAuthKey, err := h.CreatePreAuthKeyWithOptions(
+			userName,
+			keyCfg.Reusable,
+			keyCfg.Ephemeral,
+			&keyExpiration,
+			nil,
+			PreAuthKeyOptions{
+				Description: strings.TrimSpace(keyCfg.Description),
+				MaxUses:     keyCfg.MaxUses,
+			},
+		)
 		if err != nil {
 			h.doAPIResponse(w, "授权密钥创建失败", nil)
 			return
 		}
 		resData := GenKeyData{
-			Id:      genedAuthKey.Key[:12], //genedAuthKey.ID,
+			Id:      genedAuthKey.Prefix, //genedAuthKey.ID,
 			FullKey: genedAuthKey.Key,
 			Created: Time2SHString(*genedAuthKey.CreatedAt),
 			Expiry:  Time2SHString(*genedAuthKey.Expiration),
 		}
 		h.doAPIResponse(w, "", resData)
+	case "apikey":
+		keyExpiry := time.Duration(reqData.KeyData.ExpirySeconds) * time.Second
+		if keyExpiry <= 0 || keyExpiry > maxAPIKeyExpiry {
+			h.doAPIResponse(w, "有效期需在1到90天之间", nil)
+			return
+		}
+		scopes, err := ParseAPIKeyScopes(reqData.KeyData.Apikey.Scopes)
+		if err != nil {
+			h.doAPIResponse(w, "未知的API密钥权限范围", nil)
+			return
+		}
+		keyExpiration := time.Now().Add(keyExpiry)
+		fullKey, genedAPIKey, err := h.CreateUserAPIKey(userName, scopes, &keyExpiration)
+		if err != nil {
+			h.doAPIResponse(w, "API密钥创建失败", nil)
+			return
+		}
+		resData := GenKeyData{
+			Id:      genedAPIKey.Prefix,
+			FullKey: fullKey,
+			Created: Time2SHString(time.Now()),
+			Expiry:  Time2SHString(*genedAPIKey.Expiration),
+		}
+		h.doAPIResponse(w, "", resData)
+	case "oauthclient":
+		scopes, err := ParseAPIKeyScopes(reqData.KeyData.OauthClient.Scopes)
+		if err != nil {
+			h.doAPIResponse(w, "未知的API密钥权限范围", nil)
+			return
+		}
+		tags := make([]string, 0, len(reqData.KeyData.OauthClient.Tags))
+		for _, tag := range reqData.KeyData.OauthClient.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		secret, client, err := h.CreateOAuthClient(
+			userName,
+			strings.TrimSpace(reqData.KeyData.OauthClient.Description),
+			scopes,
+			tags,
+		)
+		if err != nil {
+			h.doAPIResponse(w, "OAuth客户端创建失败:"+err.Error(), nil)
+			return
+		}
+		resData := GenKeyData{
+			Id:      client.ClientID,
+			FullKey: secret,
+			Created: Time2SHString(*client.CreatedAt),
+		}
+		h.doAPIResponse(w, "", resData)
+	default:
+		h.doAPIResponse(w, "未知的密钥类型", nil)
 	}
 }
 
@@ -148,21 +385,95 @@ func (h *Headscale) CAPIDelKeys(
 	}
 	toDelKeys := make([]PreAuthKey, 0)
 	for _, key := range allKeys {
-		if key.Key[:12] == targetKeyID {
+		if key.Prefix == targetKeyID {
 			toDelKeys = append(toDelKeys, key)
 		}
 	}
 	if len(toDelKeys) == 0 {
-		h.doAPIResponse(w, "该密钥不存在", nil)
+		h.revokeUserAPIKey(w, userName, targetKeyID)
 		return
 	} else if len(toDelKeys) > 1 {
 		h.doAPIResponse(w, "存在多个密钥具备相同短形式（ID），请联系工作人员", nil)
 		return
 	}
-	err = h.DestroyPreAuthKey(toDelKeys[0])
+	// cascade=expire使该密钥注册的设备过期，cascade=delete删除这些设备及其路由
+	cascade := r.URL.Query().Get("cascade")
+	if cascade != "" && cascade != "expire" && cascade != "delete" {
+		h.doAPIResponse(w, "未知的设备处理方式", nil)
+		return
+	}
+	if toDelKeys[0].RevokedAt != nil && cascade == "" {
+		h.doAPIResponse(w, "该密钥已撤销", nil)
+		return
+	}
+	// 撤销后保留记录，在失效密钥中显示
+	if cascade == "" {
+		err = h.RevokePreAuthKey(&toDelKeys[0])
+		if err != nil {
+			h.doAPIResponse(w, "执行密钥撤销失败", nil)
+			return
+		}
+		h.doAPIResponse(w, "", targetKeyID)
+		return
+	}
+	keyMachines, err := h.ListPreAuthKeyMachines(toDelKeys)
+	if err != nil {
+		h.doAPIResponse(w, "查询密钥注册设备失败", nil)
+		return
+	}
+	machineNames := make(map[uint64]string)
+	for _, machine := range keyMachines[toDelKeys[0].ID] {
+		machineNames[machine.ID] = machine.GivenName
+	}
+	report, err := h.RevokePreAuthKeyCascade(&toDelKeys[0], cascade == "delete")
 	if err != nil {
-		h.doAPIResponse(w, "执行密钥删除失败", nil)
+		h.doAPIResponse(w, "执行密钥撤销失败", nil)
 		return
 	}
-	h.doAPIResponse(w, "", targetKeyID)
+	resData := RevokedAuthKey{
+		Id:              targetKeyID,
+		ExpiredMachines: []string{},
+		DeletedMachines: []string{},
+	}
+	for _, id := range report.ExpiredMachines {
+		resData.ExpiredMachines = append(resData.ExpiredMachines, machineNames[id])
+	}
+	for _, id := range report.DeletedMachines {
+		resData.DeletedMachines = append(resData.DeletedMachines, machineNames[id])
+	}
+	h.doAPIResponse(w, "", resData)
+}
+
+// 注销用户自己的APIKey或OAuth客户端，APIKey以前缀、OAuth客户端以client_id作为ID，注销后保留记录
+func (h *Headscale) revokeUserAPIKey(
+	w http.ResponseWriter,
+	userName string,
+	prefix string,
+) {
+	apiKeys, err := h.ListUserAPIKeys(userName)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户密钥信息失败", nil)
+		return
+	}
+	for index := range apiKeys {
+		if apiKeys[index].Prefix != prefix {
+			continue
+		}
+		if err := h.ExpireAPIKey(&apiKeys[index]); err != nil {
+			h.doAPIResponse(w, "执行密钥注销失败", nil)
+			return
+		}
+		h.doAPIResponse(w, "", prefix)
+		return
+	}
+
+	err = h.RevokeOAuthClient(userName, prefix)
+	switch {
+	case errors.Is(err, ErrOAuthClientNotFound):
+		h.doAPIResponse(w, "该密钥不存在", nil)
+	case err != nil:
+		h.doAPIResponse(w, "执行密钥注销失败", nil)
+	default:
+		h.doAPIResponse(w, "", prefix)
+	}
 }
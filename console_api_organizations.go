@@ -0,0 +1,108 @@
+package headscale
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type OrganizationItem struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+	IPPrefixes  []string `json:"ipPrefixes"`
+	HasPolicy   bool     `json:"hasPolicy"`
+	IsDefault   bool     `json:"isDefault"`
+	Current     bool     `json:"current"`
+}
+
+type organizationSwitchREQ struct {
+	Name string `json:"name"`
+}
+
+// 获取控制台会话当前所在的组织及用户在其中的角色，
+// 会话未切换过组织或已无权访问时为用户自己的组织
+func (h *Headscale) consoleOrganization(r *http.Request, user *User) (*Organization, Role) {
+	if session, err := h.getConsoleSession(r); err == nil && session.OrganizationID != 0 {
+		if role, ok := h.organizationRole(user, session.OrganizationID); ok {
+			if organization, err := h.getOrganizationByID(session.OrganizationID); err == nil {
+				return organization, role
+			}
+		}
+	}
+
+	organization, err := h.getOrganizationByID(user.OrganizationID)
+	if err != nil {
+		organization = &Organization{Name: defaultOrganizationName, IsDefault: true}
+		organization.ID = h.defaultOrganizationID
+	}
+
+	return organization, h.userRole(user)
+}
+
+// 接受/admin/api/organizations的Get请求，查询用户可以切换的组织
+func (h *Headscale) CAPIGetOrganizations(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		return
+	}
+	user, err := h.GetUser(userName)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户失败", nil)
+		return
+	}
+	current, _ := h.consoleOrganization(r, user)
+	organizations, err := h.ListOrganizations()
+	if err != nil {
+		h.doAPIResponse(w, "查询组织列表失败", nil)
+		return
+	}
+	resData := make([]OrganizationItem, 0, len(organizations))
+	for _, organization := range organizations {
+		if _, ok := h.organizationRole(user, organization.ID); !ok {
+			continue
+		}
+		resData = append(resData, OrganizationItem{
+			Name:        organization.Name,
+			DisplayName: organization.DisplayName,
+			IPPrefixes:  organization.IPPrefixes,
+			HasPolicy:   organization.ACLPolicy != "",
+			IsDefault:   organization.IsDefault,
+			Current:     organization.ID == current.ID,
+		})
+	}
+	h.doAPIResponse(w, "", resData)
+}
+
+// 接受/admin/api/organizations/switch的Post请求，切换控制台会话所在的组织
+func (h *Headscale) CAPIPostOrganizationSwitch(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	session, err := h.getConsoleSession(r)
+	if err != nil {
+		h.doAPIResponse(w, "验证会话失败", nil)
+		return
+	}
+	reqData := organizationSwitchREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	organization, err := h.GetOrganization(reqData.Name)
+	if err != nil {
+		h.doAPIResponse(w, "未找到该组织", nil)
+		return
+	}
+	if _, ok := h.organizationRole(&session.User, organization.ID); !ok {
+		h.doAPIResponse(w, "用户没有该权限", nil)
+		return
+	}
+	session.OrganizationID = organization.ID
+	if err := h.db.Model(session).Update("organization_id", organization.ID).Error; err != nil {
+		h.doAPIResponse(w, "切换组织失败", nil)
+		return
+	}
+	h.doAPIResponse(w, "", organization.DisplayName)
+}
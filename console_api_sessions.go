@@ -0,0 +1,114 @@
+package headscale
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type SessionItem struct {
+	Id        string `json:"id"`
+	Provider  string `json:"provider"`
+	IPAddress string `json:"ipAddress"`
+	UserAgent string `json:"userAgent"`
+	Created   string `json:"created"`
+	LastSeen  string `json:"lastSeen"`
+	Expiry    string `json:"expiry"`
+	Current   bool   `json:"current"` //是否为本次请求所用的会话
+}
+
+type revokeSessionREQ struct {
+	Id string `json:"id"`
+}
+
+// 接受/admin/api/sessions的Get请求，查询当前用户的控制台会话
+func (h *Headscale) CAPIGetSessions(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, err := h.getConsoleSession(r)
+	if err != nil {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	sessions, err := h.ListConsoleSessions(current.User.Name)
+	if err != nil {
+		h.doAPIResponse(w, "会话查询失败", nil)
+		return
+	}
+	resData := make([]SessionItem, 0, len(sessions))
+	for _, session := range sessions {
+		resData = append(resData, SessionItem{
+			Id:        strconv.FormatUint(session.ID, Base10),
+			Provider:  session.Provider,
+			IPAddress: session.IPAddress,
+			UserAgent: session.UserAgent,
+			Created:   Time2SHString(session.CreatedAt),
+			LastSeen:  Time2SHString(session.LastSeen),
+			Expiry:    Time2SHString(session.Expiration),
+			Current:   session.ID == current.ID,
+		})
+	}
+	h.doAPIResponse(w, "", resData)
+}
+
+// 接受/admin/api/sessions/revoke的Post请求，注销当前用户的某个会话
+func (h *Headscale) CAPIRevokeSession(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, err := h.getConsoleSession(r)
+	if err != nil {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	reqData := revokeSessionREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	sessionID, err := strconv.ParseUint(reqData.Id, Base10, 64)
+	if err != nil {
+		h.doAPIResponse(w, "会话ID错误", nil)
+		return
+	}
+	sessions, err := h.ListConsoleSessions(current.User.Name)
+	if err != nil {
+		h.doAPIResponse(w, "会话查询失败", nil)
+		return
+	}
+	for _, session := range sessions {
+		if session.ID != sessionID {
+			continue
+		}
+		if err := h.RevokeConsoleSession(sessionID); err != nil {
+			h.doAPIResponse(w, "会话注销失败", nil)
+			return
+		}
+		if sessionID == current.ID {
+			h.setConsoleSessionCookie(w, r, "", time.Time{})
+		}
+		h.doAPIResponse(w, "", "会话已注销")
+		return
+	}
+	h.doAPIResponse(w, "未找到该会话", nil)
+}
+
+// 接受/admin/api/sessions/logoutall的Post请求，注销当前用户的全部会话
+func (h *Headscale) CAPILogoutAllSessions(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, err := h.getConsoleSession(r)
+	if err != nil {
+		h.doAPIResponse(w, "用户信息核对失败", nil)
+		return
+	}
+	if _, err := h.RevokeUserConsoleSessions(current.User.Name); err != nil {
+		h.doAPIResponse(w, "会话注销失败", nil)
+		return
+	}
+	h.setConsoleSessionCookie(w, r, "", time.Time{})
+	h.doAPIResponse(w, "", "已注销全部会话")
+}
@@ -0,0 +1,231 @@
+package headscale
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type UsersData struct {
+	Users          []UserItem `json:"users"`
+	Roles          []Role     `json:"roles"`
+	CanManageRoles bool       `json:"canManageRoles"`
+	CanManageUsers bool       `json:"canManageUsers"`
+	DefaultQuota   QuotaItem  `json:"defaultQuota"` //配置文件中的全局默认配额
+}
+
+type UserItem struct {
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+	Email       string    `json:"email"`
+	Role        Role      `json:"role"`
+	RoleSynced  bool      `json:"roleSynced"` //角色由OIDC组同步，手动修改会在下次登录时被覆盖
+	Disabled    bool      `json:"disabled"`
+	Machines    int64     `json:"machines"`
+	Created     string    `json:"created"`
+	Self        bool      `json:"self"`
+	Quota       QuotaItem `json:"quota"`
+}
+
+// 用户配额，为null时使用全局默认值，0表示不限制
+type QuotaItem struct {
+	MaxMachines            *uint `json:"maxMachines"`
+	MaxReusablePreAuthKeys *uint `json:"maxReusablePreAuthKeys"`
+	MaxRoutes              *uint `json:"maxRoutes"`
+}
+
+type userRoleREQ struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type userDisableREQ struct {
+	Name string `json:"name"`
+}
+
+type userQuotaREQ struct {
+	Name  string    `json:"name"`
+	Quota QuotaItem `json:"quota"`
+}
+
+// 核对会话用户并检查其在当前组织中的角色是否具有该权限
+func (h *Headscale) consoleUserWithPermission(
+	w http.ResponseWriter,
+	r *http.Request,
+	permission Permission,
+) (*User, *Organization, Role) {
+	userName := h.verifyTokenIDandGetUser(w, r)
+	if userName == "" {
+		return nil, nil, ""
+	}
+	user, err := h.GetUser(userName)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户失败", nil)
+		return nil, nil, ""
+	}
+	organization, role := h.consoleOrganization(r, user)
+	if !role.Can(permission) {
+		h.doAPIResponse(w, "用户没有该权限", nil)
+		return nil, nil, ""
+	}
+	return user, organization, role
+}
+
+// 接受/admin/api/users的Get请求，查询全部用户及其角色
+func (h *Headscale) CAPIGetUsers(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, currentRole := h.consoleUserWithPermission(w, r, PermissionReadTailnet)
+	if current == nil {
+		return
+	}
+	users, err := h.ListOrganizationUsers(organization.ID)
+	if err != nil {
+		h.doAPIResponse(w, "查询用户列表失败", nil)
+		return
+	}
+	resData := UsersData{
+		Users:          make([]UserItem, 0, len(users)),
+		Roles:          roles,
+		CanManageRoles: currentRole.Can(PermissionManageRoles),
+		CanManageUsers: currentRole.Can(PermissionManageUsers),
+		DefaultQuota: QuotaItem{
+			MaxMachines:            &h.cfg.Quotas.MaxMachines,
+			MaxReusablePreAuthKeys: &h.cfg.Quotas.MaxReusablePreAuthKeys,
+			MaxRoutes:              &h.cfg.Quotas.MaxRoutes,
+		},
+	}
+	for _, user := range users {
+		item := UserItem{
+			Name:        user.Name,
+			DisplayName: user.Display_Name,
+			Email:       user.Email,
+			Role:        h.userRole(&user),
+			RoleSynced:  user.RoleSynced,
+			Disabled:    user.Disabled,
+			Created:     Time2SHString(user.CreatedAt),
+			Self:        user.ID == current.ID,
+			Quota: QuotaItem{
+				MaxMachines:            user.MaxMachines,
+				MaxReusablePreAuthKeys: user.MaxReusablePreAuthKeys,
+				MaxRoutes:              user.MaxRoutes,
+			},
+		}
+		h.db.Model(&Machine{}).Where("user_id = ?", user.ID).Count(&item.Machines)
+		resData.Users = append(resData.Users, item)
+	}
+	h.doAPIResponse(w, "", resData)
+}
+
+// 接受/admin/api/users/role的Post请求，修改用户角色
+func (h *Headscale) CAPIPostUserRole(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, currentRole := h.consoleUserWithPermission(w, r, PermissionManageRoles)
+	if current == nil {
+		return
+	}
+	reqData := userRoleREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	role, err := ParseRole(reqData.Role)
+	if err != nil {
+		h.doAPIResponse(w, "未知的角色", nil)
+		return
+	}
+	target, err := h.GetUser(reqData.Name)
+	if err != nil || target.OrganizationID != organization.ID {
+		h.doAPIResponse(w, "未找到该用户", nil)
+		return
+	}
+	user, err := h.SetUserRole(currentRole, target.Name, role)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		h.doAPIResponse(w, "未找到该用户", nil)
+	case errors.Is(err, ErrOwnerRoleRequired):
+		h.doAPIResponse(w, "只有所有者可以授予或撤销所有者角色", nil)
+	case errors.Is(err, ErrLastOwner):
+		h.doAPIResponse(w, "不能撤销最后一个所有者的角色", nil)
+	case err != nil:
+		h.doAPIResponse(w, "修改用户角色失败", nil)
+	default:
+		h.doAPIResponse(w, "", h.userRole(user))
+	}
+}
+
+// 接受/admin/api/users/disable的Post请求，停用用户并使其设备、密钥及会话失效
+func (h *Headscale) CAPIPostUserDisable(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, currentRole := h.consoleUserWithPermission(w, r, PermissionManageUsers)
+	if current == nil {
+		return
+	}
+	reqData := userDisableREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	if reqData.Name == current.Name {
+		h.doAPIResponse(w, "不能停用自己", nil)
+		return
+	}
+	user, err := h.GetUser(reqData.Name)
+	if err != nil || user.OrganizationID != organization.ID {
+		h.doAPIResponse(w, "未找到该用户", nil)
+		return
+	}
+	if h.userRole(user) == RoleOwner && currentRole != RoleOwner {
+		h.doAPIResponse(w, "只有所有者可以停用所有者", nil)
+		return
+	}
+	if err := h.DisableUser(user.Name); err != nil {
+		h.doAPIResponse(w, "停用用户失败", nil)
+		return
+	}
+	h.doAPIResponse(w, "", "用户已停用")
+}
+
+// 接受/admin/api/users/quota的Post请求，修改用户的设备、可复用密钥及子网路由配额
+func (h *Headscale) CAPIPostUserQuota(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	current, organization, currentRole := h.consoleUserWithPermission(w, r, PermissionManageUsers)
+	if current == nil {
+		return
+	}
+	reqData := userQuotaREQ{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	user, err := h.GetUser(reqData.Name)
+	if err != nil || user.OrganizationID != organization.ID {
+		h.doAPIResponse(w, "未找到该用户", nil)
+		return
+	}
+	if h.userRole(user) == RoleOwner && currentRole != RoleOwner {
+		h.doAPIResponse(w, "只有所有者可以修改所有者的配额", nil)
+		return
+	}
+	user, err = h.SetUserQuota(user.Name, UserQuota{
+		MaxMachines:            reqData.Quota.MaxMachines,
+		MaxReusablePreAuthKeys: reqData.Quota.MaxReusablePreAuthKeys,
+		MaxRoutes:              reqData.Quota.MaxRoutes,
+	})
+	if err != nil {
+		h.doAPIResponse(w, "修改用户配额失败", nil)
+		return
+	}
+	h.doAPIResponse(w, "", QuotaItem{
+		MaxMachines:            user.MaxMachines,
+		MaxReusablePreAuthKeys: user.MaxReusablePreAuthKeys,
+		MaxRoutes:              user.MaxRoutes,
+	})
+}
@@ -4,28 +4,35 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2"
 )
 
+// 控制台登录时缓存的身份源及回调地址
+type consoleLoginState struct {
+	Provider    string
+	RedirectURL string
+}
+
 func (h *Headscale) doLogin(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
-	provider := r.Form["provider"][0]
-	nextURL := r.Form["next_url"][0]
-	switch provider {
-	case "Ali":
-		h.doAliLogin(w, r, nextURL)
+	provider, err := h.getIdentityProvider(r.Form.Get("provider"))
+	if err != nil {
+		log.Error().Str("provider", r.Form.Get("provider")).Msg("未知的身份源")
+		http.Error(w, "未知的身份源", http.StatusBadRequest)
+		return
 	}
+	h.doProviderLogin(w, r, provider, r.Form.Get("next_url"))
 }
-func (h *Headscale) doAliLogin(w http.ResponseWriter, r *http.Request, nextURL string) {
+func (h *Headscale) doProviderLogin(w http.ResponseWriter, r *http.Request, provider *identityProvider, nextURL string) {
 	randomBlob := make([]byte, randomByteSize)
 	if _, err := rand.Read(randomBlob); err != nil {
 		log.Error().
@@ -35,12 +42,7 @@ func (h *Headscale) doAliLogin(w http.ResponseWriter, r *http.Request, nextURL s
 		return
 	}
 	stateStr := hex.EncodeToString(randomBlob)[:32]
-	h.loginCache.Set(stateStr, time.Now(), registerCacheExpiration)
 
-	extras := make([]oauth2.AuthCodeOption, 0, len(h.cfg.OIDC.ExtraParams))
-	for k, v := range h.cfg.OIDC.ExtraParams {
-		extras = append(extras, oauth2.SetAuthURLParam(k, v))
-	}
 	nextURL, err := url.PathUnescape(nextURL)
 	toDistPath := "/"
 	if strings.Contains(nextURL, "#") {
@@ -51,58 +53,154 @@ func (h *Headscale) doAliLogin(w http.ResponseWriter, r *http.Request, nextURL s
 	if err != nil {
 		log.Error().Msg("Next URL处理失败：" + err.Error())
 	}
-	log.Error().Msg("之后会跳转到：" + fmt.Sprintf(
+	redirectURL := fmt.Sprintf(
 		"%s/%s",
 		strings.TrimSuffix(h.cfg.ServerURL, "/"),
 		strings.TrimPrefix(nextURL, "/"),
-	))
+	)
+	log.Debug().Msg("之后会跳转到：" + redirectURL)
 
-	adminOauth2Config := &oauth2.Config{
-		ClientID:     h.cfg.OIDC.ClientID,
-		ClientSecret: h.cfg.OIDC.ClientSecret,
-		Endpoint:     h.oidcProvider.Endpoint(),
-		RedirectURL: fmt.Sprintf(
-			"%s/%s",
-			strings.TrimSuffix(h.cfg.ServerURL, "/"),
-			strings.TrimPrefix(nextURL, "/"),
-		),
-		Scopes: h.cfg.OIDC.Scope,
-	}
+	h.loginCache.Set(stateStr, consoleLoginState{
+		Provider:    provider.cfg.Name,
+		RedirectURL: redirectURL,
+	}, registerCacheExpiration)
 
-	authURL := adminOauth2Config.AuthCodeURL(stateStr, extras...)
+	authURL := provider.authCodeURL(stateStr, redirectURL)
 	log.Debug().Msgf("Redirecting to %s for authentication", authURL)
 	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
-// 处理可能来自OIDC的callback
+// 控制台OIDC登录回调得到的Token
+type consoleOIDCLogin struct {
+	provider   *identityProvider
+	token      *oauth2.Token
+	rawIDToken string
+	idToken    *oidc.IDToken
+}
+
+// 处理可能来自OIDC的callback，身份源由登录时缓存的state确定
 func (h *Headscale) getIDTokenFromOIDCCallback(
 	w http.ResponseWriter,
 	r *http.Request,
-) (string, *oidc.IDToken) {
+) *consoleOIDCLogin {
 	code := r.URL.Query().Get("code")
 	state := r.URL.Query().Get("state")
 	if code == "" || state == "" {
-		return "", nil
+		return nil
+	}
+	cached, ok := h.loginCache.Get(state)
+	if !ok {
+		return nil
 	}
-	oauth2Token, err := h.oauth2Config.Exchange(r.Context(), code)
+	h.loginCache.Delete(state)
+	loginState, ok := cached.(consoleLoginState)
+	if !ok {
+		return nil
+	}
+	provider, err := h.getIdentityProvider(loginState.Provider)
+	if err != nil {
+		return nil
+	}
+
+	oauth2Config := *provider.oauth2Config
+	oauth2Config.RedirectURL = loginState.RedirectURL
+	oauth2Token, err := oauth2Config.Exchange(r.Context(), code)
 	if err != nil {
-		return "", nil
+		return nil
 	}
 	log.Trace().
 		Caller().
 		Str("code", code).
 		Str("state", state).
+		Str("provider", provider.cfg.Name).
 		Msg("Got oidc callback")
 	rawIDToken, rawIDTokenOK := oauth2Token.Extra("id_token").(string)
 	if !rawIDTokenOK {
-		return "", nil
+		return nil
+	}
+	idToken, err := provider.verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil
+	}
+	return &consoleOIDCLogin{
+		provider:   provider,
+		token:      oauth2Token,
+		rawIDToken: rawIDToken,
+		idToken:    idToken,
+	}
+}
+
+// 校验登录用户是否被身份源允许，关联（或创建）对应的用户并创建控制台会话，
+// 返回本次登录创建用户时接受的邀请
+func (h *Headscale) startConsoleOIDCSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	login *consoleOIDCLogin,
+) (*Invite, error) {
+	identity, err := login.provider.identity(login.idToken)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("could not map claims")
+		http.Error(w, "OIDC Token解析Claim错误！", http.StatusBadRequest)
+		return nil, err
+	}
+	if err := login.provider.cfg.authorize(identity); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Str("provider", login.provider.cfg.Name).
+			Str("user", identity.UserName).
+			Msg("authenticated principal is not allowed")
+		http.Error(w, "该账号不允许登录", http.StatusForbidden)
+		return nil, err
+	}
+	// 通过邀请链接登录的新用户加入邀请的组织，已有用户不使用该邀请
+	invite := h.inviteFromCookie(r)
+	if invite != nil {
+		h.setInviteCookie(w, r, "", 0)
+	}
+	user, err := h.findOrCreateUserWithInvite(identity, invite)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Str("user", identity.UserName).
+			Msg("could not find or create user")
+		if errors.Is(err, errIdentityUserLinked) {
+			http.Error(w, "该用户名已绑定其他身份", http.StatusForbidden)
+			return nil, err
+		}
+		http.Error(w, "查找或创建用户失败", http.StatusInternalServerError)
+		return nil, err
 	}
-	verifier := h.oidcProvider.Verifier(&oidc.Config{ClientID: h.cfg.OIDC.ClientID})
-	idToken, err := verifier.Verify(r.Context(), rawIDToken)
+	err = h.startConsoleSession(w, r, user, &ConsoleSession{
+		Provider:     login.provider.cfg.Name,
+		DisplayName:  identity.DisplayName,
+		IDToken:      login.rawIDToken,
+		RefreshToken: login.token.RefreshToken,
+		TokenExpiry:  login.idToken.Expiry,
+	})
 	if err != nil {
-		return "", nil
+		http.Error(w, "创建登录会话失败", http.StatusInternalServerError)
+		return nil, err
+	}
+	if invite == nil || invite.UsedAt == nil {
+		return nil, nil
 	}
-	return rawIDToken, idToken
+
+	return invite, nil
+}
+
+// 校验控制台请求的会话，返回其对应的身份
+func (h *Headscale) getConsoleIdentity(r *http.Request) (*Identity, error) {
+	session, err := h.getConsoleSession(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.identity(), nil
 }
 
 // WebUI控制台鉴权中间件
@@ -113,63 +211,36 @@ func (h *Headscale) ConsoleAuth(next http.Handler) http.Handler {
 			return
 		}
 		//检查是否OIDC callback
-		if rawIDToken, idToken := h.getIDTokenFromOIDCCallback(w, r); idToken != nil {
+		if login := h.getIDTokenFromOIDCCallback(w, r); login != nil {
+			invite, err := h.startConsoleOIDCSession(w, r, login)
+			if err != nil {
+				return
+			}
+			if invite != nil {
+				http.Redirect(w, r, inviteJoinPath, http.StatusFound)
+				return
+			}
 			newQuery := r.URL.Query()
 			newQuery.Del("code")
 			newQuery.Del("state")
 			nextURL := newQuery.Get("next_url")
 			newQuery.Del("next_url")
 			r.URL.RawQuery = newQuery.Encode()
-			tokencookie := &http.Cookie{
-				Name:     "OIDC_Token",
-				Value:    rawIDToken,
-				Secure:   true,
-				HttpOnly: true,
-				Domain:   strings.Split(h.cfg.ServerURL, "://")[1],
-				Expires:  idToken.Expiry,
-				Path:     "/",
-			}
-			http.SetCookie(w, tokencookie)
-			log.Info().Msg("将设置Cookie成功！")
 			http.Redirect(w, r, "/admin#"+nextURL, http.StatusFound)
 		} else {
-			oidc_token, _ := r.Cookie("OIDC_Token")
-			if oidc_token != nil {
-				verifier := h.oidcProvider.Verifier(&oidc.Config{ClientID: h.cfg.OIDC.ClientID})
-				idToken, err := verifier.Verify(r.Context(), oidc_token.Value)
-				if err != nil {
-					log.Error().
-						Caller().
-						Msg("could not verifyIDTokenForOIDCCallback")
-					nextURL := r.URL.Path
-					newQuery := r.URL.Query()
-					newQuery.Add("next_url", nextURL)
-					r.URL.RawQuery = newQuery.Encode()
-					http.Redirect(w, r, "/login?"+r.URL.RawQuery, http.StatusFound)
-					return
-				}
-				var claims IDTokenClaims
-				err = idToken.Claims(&claims)
-				if err != nil {
-					log.Error().
-						Caller().
-						Msg("could not Extra Claims")
-					nextURL := r.URL.Path
-					newQuery := r.URL.Query()
-					newQuery.Add("next_url", nextURL)
-					r.URL.RawQuery = newQuery.Encode()
-					http.Redirect(w, r, "/login?"+r.URL.RawQuery, http.StatusFound)
-					return
-				}
-				next.ServeHTTP(w, r)
-			} else {
-				log.Error().Msg("未能从Cookie读取到OIDC Token！")
+			if _, err := h.getConsoleIdentity(r); err != nil {
+				log.Debug().
+					Caller().
+					Err(err).
+					Msg("could not verify console session")
 				nextURL := r.URL.Path
 				newQuery := r.URL.Query()
 				newQuery.Add("next_url", nextURL)
 				r.URL.RawQuery = newQuery.Encode()
 				http.Redirect(w, r, "/login?"+r.URL.RawQuery, http.StatusFound)
+				return
 			}
+			next.ServeHTTP(w, r)
 		}
 	})
 }
@@ -183,49 +254,31 @@ type APICheckRes struct {
 // API鉴权中间件
 func (h *Headscale) APIAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		oidc_token, _ := r.Cookie("OIDC_Token")
-		if oidc_token != nil {
-			idToken, err := h.verifyIDTokenForOIDCCallback(r.Context(), w, oidc_token.Value)
-			if err != nil {
-				log.Error().
-					Caller().
-					Msg("could not verifyIDTokenForOIDCCallback")
-				renderData := APICheckRes{
-					NeedReauth: true,
-					Reason:     "IDaaS无法校验",
-				}
-				w.Header().Set("Content-Type", "application/json; charset=utf-8")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(&renderData)
-				return
-			}
-			var claims IDTokenClaims
-			err = idToken.Claims(&claims)
-			if err != nil {
-				log.Error().
-					Caller().
-					Msg("could not Extra Claims")
-				http.Error(w, "OIDC Token解析Claim错误！", http.StatusInternalServerError)
-				renderData := APICheckRes{
-					NeedReauth: true,
-					Reason:     "Token解析Claim错误",
-				}
-				w.Header().Set("Content-Type", "application/json; charset=utf-8")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(&renderData)
-				return
+		if _, err := r.Cookie(consoleSessionCookie); err != nil {
+			log.Debug().Msg("未能从Cookie读取到控制台会话！")
+			renderData := APICheckRes{
+				NeedReauth: true,
+				Reason:     "未读取到会话",
 			}
-			next.ServeHTTP(w, r)
-		} else {
-			log.Error().Msg("未能从Cookie读取到OIDC Token！")
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(&renderData)
+			return
+		}
+		if _, err := h.getConsoleIdentity(r); err != nil {
+			log.Debug().
+				Caller().
+				Err(err).
+				Msg("could not verify console session")
 			renderData := APICheckRes{
 				NeedReauth: true,
-				Reason:     "未读取到Token",
+				Reason:     "会话已失效",
 			}
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(&renderData)
 			return
 		}
+		next.ServeHTTP(w, r)
 	})
 }
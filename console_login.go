@@ -3,7 +3,7 @@ package headscale
 import (
 	_ "embed"
 	"net/http"
-	"strings"
+	"net/url"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -13,48 +13,25 @@ func (h *Headscale) ConsoleLogin(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	code, state, err := validateOIDCCallbackParams(w, r)
-	if err != nil {
+	login := h.getIDTokenFromOIDCCallback(w, r)
+	if login == nil {
 		log.Error().
 			Caller().
-			Msg("could not validateOIDCCallbackParams")
-		http.Error(w, "OIDC返回参数错误", http.StatusInternalServerError)
+			Msg("could not getIDTokenFromOIDCCallback")
+		http.Error(w, "OIDC校验IDToken错误", http.StatusInternalServerError)
 
 		return
 	}
 
-	rawIDToken, err := h.getIDTokenForOIDCCallback(r.Context(), w, code, state)
+	invite, err := h.startConsoleOIDCSession(w, r, login)
 	if err != nil {
-		log.Error().
-			Caller().
-			Msg("could not getIDTokenForOIDCCallback")
-		http.Error(w, "OIDC返回获取IDToken错误", http.StatusInternalServerError)
-
 		return
 	}
-
-	idToken, err := h.verifyIDTokenForOIDCCallback(r.Context(), w, rawIDToken)
-	if err != nil {
-		log.Error().
-			Caller().
-			Msg("could not verifyIDTokenForOIDCCallback")
-		http.Error(w, "OIDC校验IDToken错误", http.StatusInternalServerError)
-
+	if invite != nil {
+		http.Redirect(w, r, inviteJoinPath, http.StatusFound)
 		return
 	}
 
-	tokencookie := &http.Cookie{
-		Name:     "OIDC_Token",
-		Value:    rawIDToken,
-		Secure:   true,
-		HttpOnly: true,
-		Domain:   strings.Split(h.cfg.ServerURL, "://")[1],
-		Expires:  idToken.Expiry,
-		Path:     "/",
-	}
-	http.SetCookie(w, tokencookie)
-	log.Info().Msg("将设置Cookie成功！")
-
 	http.Redirect(w, r, "/admin", http.StatusFound)
 
 }
@@ -63,18 +40,26 @@ func (h *Headscale) ConsoleLogout(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	rToken, _ := r.Cookie("OIDC_Token")
-	idtoken := rToken.Value
-	delCookie := &http.Cookie{
-		Name:     "OIDC_Token",
-		Domain:   strings.Split(h.cfg.ServerURL, "://")[1],
-		Expires:  time.Now().Add(time.Minute * 5),
-		MaxAge:   -1,
-		Secure:   true,
-		HttpOnly: true,
-		Path:     "/",
+	idtoken := ""
+	logoutURL := ""
+	if cookie, err := r.Cookie(consoleSessionCookie); err == nil {
+		if session, err := h.GetConsoleSession(cookie.Value); err == nil {
+			idtoken = session.IDToken
+			// 本地账号没有身份源的登出地址
+			if session.Provider != localAccountProvider {
+				logoutURL = h.cfg.OIDC.LogoutURL
+				if provider, err := h.getIdentityProvider(session.Provider); err == nil && provider.cfg.LogoutURL != "" {
+					logoutURL = provider.cfg.LogoutURL
+				}
+			}
+			h.revokeConsoleSession(session)
+		}
 	}
+	h.setConsoleSessionCookie(w, r, "", time.Time{})
 
-	http.SetCookie(w, delCookie)
-	http.Redirect(w, r, h.cfg.OIDC.LogoutURL+"?id_token_hint="+idtoken+"&post_logout_redirect_uri="+h.cfg.ServerURL+"/login", http.StatusFound)
+	if logoutURL == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, logoutURL+"?id_token_hint="+url.QueryEscape(idtoken)+"&post_logout_redirect_uri="+h.cfg.ServerURL+"/login", http.StatusFound)
 }
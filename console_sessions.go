@@ -0,0 +1,391 @@
+package headscale
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	errConsoleSessionNotFound = Error("console session not found")
+	errConsoleSessionExpired  = Error("console session has expired")
+	errConsoleSessionRenewal  = Error("console session could not be renewed")
+
+	errConsoleSessionRevokeTarget = Error("a session id or a user is required")
+
+	// consoleSessionCookie holds the opaque ID of the console session,
+	// the tokens of the identity provider stay on the server.
+	consoleSessionCookie = "Mirage_Session"
+
+	consoleSessionTokenLength = 32
+
+	defaultConsoleSessionIdleTimeout     = 2 * time.Hour
+	defaultConsoleSessionAbsoluteTimeout = 24 * time.Hour
+
+	// consoleSessionTouchInterval limits the writes of LastSeen.
+	consoleSessionTouchInterval = time.Minute
+
+	consoleSessionCleanupInterval = 10 * time.Minute
+)
+
+// ConsoleSession is a login to the web console.
+type ConsoleSession struct {
+	ID          uint64 `gorm:"primary_key"`
+	TokenHash   string `gorm:"uniqueIndex"`
+	UserID      uint
+	User        User
+	Provider    string
+	DisplayName string
+
+	// OrganizationID the console shows, the one of the user when 0
+	OrganizationID uint
+
+	// Tokens of the identity provider, to renew the session and
+	// to log out of the provider
+	IDToken      string
+	RefreshToken string
+	TokenExpiry  time.Time
+
+	IPAddress string
+	UserAgent string
+
+	CreatedAt  time.Time
+	LastSeen   time.Time
+	Expiration time.Time
+}
+
+func hashConsoleSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Headscale) consoleSessionIdleTimeout() time.Duration {
+	if h.cfg.ConsoleSessions.IdleTimeout > 0 {
+		return h.cfg.ConsoleSessions.IdleTimeout
+	}
+
+	return defaultConsoleSessionIdleTimeout
+}
+
+func (h *Headscale) consoleSessionAbsoluteTimeout() time.Duration {
+	if h.cfg.ConsoleSessions.AbsoluteTimeout > 0 {
+		return h.cfg.ConsoleSessions.AbsoluteTimeout
+	}
+
+	return defaultConsoleSessionAbsoluteTimeout
+}
+
+// CreateConsoleSession saves a session of the User and returns its token,
+// the token is only visible _once_.
+func (h *Headscale) CreateConsoleSession(
+	user *User,
+	session *ConsoleSession,
+) (string, error) {
+	token, err := GenerateRandomStringURLSafe(consoleSessionTokenLength)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	session.TokenHash = hashConsoleSessionToken(token)
+	session.UserID = user.ID
+	session.User = *user
+	session.CreatedAt = now
+	session.LastSeen = now
+	session.Expiration = now.Add(h.consoleSessionAbsoluteTimeout())
+	if session.DisplayName == "" {
+		session.DisplayName = user.Display_Name
+	}
+
+	if err := h.db.Omit("User").Create(session).Error; err != nil {
+		return "", fmt.Errorf("failed to save console session in the database: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetConsoleSession returns the live session of a token, expired
+// sessions are removed.
+func (h *Headscale) GetConsoleSession(token string) (*ConsoleSession, error) {
+	session := ConsoleSession{}
+	err := h.db.Preload("User").
+		Where("token_hash = ?", hashConsoleSessionToken(token)).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errConsoleSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if now.After(session.Expiration) ||
+		now.Sub(session.LastSeen) > h.consoleSessionIdleTimeout() {
+		h.revokeConsoleSession(&session)
+
+		return nil, errConsoleSessionExpired
+	}
+
+	if session.User.Disabled {
+		h.revokeConsoleSession(&session)
+
+		return nil, ErrUserDisabled
+	}
+
+	if now.Sub(session.LastSeen) > consoleSessionTouchInterval {
+		session.LastSeen = now
+		if err := h.db.Model(&session).Update("last_seen", now).Error; err != nil {
+			log.Error().Err(err).Uint64("session", session.ID).Msg("Could not update console session")
+		}
+	}
+
+	return &session, nil
+}
+
+// renewConsoleSession refreshes the ID token of a session once it has
+// expired. The provider is asked again, so a user removed or no longer
+// allowed there loses the session.
+func (h *Headscale) renewConsoleSession(ctx context.Context, session *ConsoleSession) error {
+	if session.RefreshToken == "" || time.Now().Before(session.TokenExpiry) {
+		return nil
+	}
+
+	provider, err := h.getIdentityProvider(session.Provider)
+	if err != nil || provider.cfg.Name != session.Provider {
+		return fmt.Errorf("%w: unknown provider %s", errConsoleSessionRenewal, session.Provider)
+	}
+
+	token, err := provider.oauth2Config.TokenSource(ctx, &oauth2.Token{
+		RefreshToken: session.RefreshToken,
+		Expiry:       time.Now().Add(-time.Minute),
+	}).Token()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errConsoleSessionRenewal, err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return fmt.Errorf("%w: no ID token", errConsoleSessionRenewal)
+	}
+	idToken, err := provider.verify(ctx, rawIDToken)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errConsoleSessionRenewal, err)
+	}
+	identity, err := provider.identity(idToken)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errConsoleSessionRenewal, err)
+	}
+	if err := provider.cfg.authorize(identity); err != nil {
+		return fmt.Errorf("%w: %s", errConsoleSessionRenewal, err)
+	}
+
+	session.IDToken = rawIDToken
+	session.TokenExpiry = idToken.Expiry
+	if token.RefreshToken != "" {
+		session.RefreshToken = token.RefreshToken
+	}
+
+	return h.db.Model(session).Updates(map[string]interface{}{
+		"id_token":      session.IDToken,
+		"refresh_token": session.RefreshToken,
+		"token_expiry":  session.TokenExpiry,
+	}).Error
+}
+
+func (h *Headscale) revokeConsoleSession(session *ConsoleSession) {
+	if err := h.db.Delete(session).Error; err != nil {
+		log.Error().Err(err).Uint64("session", session.ID).Msg("Could not delete console session")
+	}
+}
+
+// ListConsoleSessions returns the sessions of a User, or of all the users
+// when the name is empty.
+func (h *Headscale) ListConsoleSessions(userName string) ([]ConsoleSession, error) {
+	query := h.db.Preload("User").Order("last_seen DESC")
+	if userName != "" {
+		user, err := h.GetUser(userName)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("user_id = ?", user.ID)
+	}
+
+	sessions := []ConsoleSession{}
+	if err := query.Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RevokeConsoleSession ends a session.
+func (h *Headscale) RevokeConsoleSession(id uint64) error {
+	result := h.db.Delete(&ConsoleSession{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errConsoleSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeUserConsoleSessions ends all the sessions of a User and returns
+// how many there were.
+func (h *Headscale) RevokeUserConsoleSessions(userName string) (int64, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return 0, err
+	}
+
+	result := h.db.Where("user_id = ?", user.ID).Delete(&ConsoleSession{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	log.Info().
+		Str("user", userName).
+		Int64("sessions", result.RowsAffected).
+		Msg("Console sessions revoked")
+
+	return result.RowsAffected, nil
+}
+
+// expireConsoleSessions removes the sessions past their timeouts.
+func (h *Headscale) expireConsoleSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		h.expireConsoleSessionsWorker()
+	}
+}
+
+func (h *Headscale) expireConsoleSessionsWorker() {
+	now := time.Now().UTC()
+	result := h.db.
+		Where("expiration < ? OR last_seen < ?", now, now.Add(-h.consoleSessionIdleTimeout())).
+		Delete(&ConsoleSession{})
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("Could not remove expired console sessions")
+
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Debug().Int64("sessions", result.RowsAffected).Msg("Removed expired console sessions")
+	}
+}
+
+// 创建控制台会话并设置会话Cookie
+func (h *Headscale) startConsoleSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	user *User,
+	session *ConsoleSession,
+) error {
+	session.IPAddress = h.clientIP(r)
+	session.UserAgent = r.UserAgent()
+	token, err := h.CreateConsoleSession(user, session)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Str("user", user.Name).
+			Msg("could not create console session")
+		return err
+	}
+	h.setConsoleSessionCookie(w, r, token, session.Expiration)
+	log.Info().
+		Str("user", user.Name).
+		Str("provider", session.Provider).
+		Msg("Console session created")
+
+	return nil
+}
+
+func (h *Headscale) setConsoleSessionCookie(
+	w http.ResponseWriter,
+	r *http.Request,
+	token string,
+	expiry time.Time,
+) {
+	domain, path := h.cookieScope()
+	cookie := &http.Cookie{
+		Name:     consoleSessionCookie,
+		Value:    token,
+		Secure:   h.secureCookies(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Domain:   domain,
+		Path:     path,
+	}
+	if expiry.IsZero() {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expiry
+	}
+	http.SetCookie(w, cookie)
+	h.setCSRFCookie(w, r, token, expiry)
+}
+
+// 读取请求Cookie中的控制台会话，必要时向身份源续期
+func (h *Headscale) getConsoleSession(r *http.Request) (*ConsoleSession, error) {
+	cookie, err := r.Cookie(consoleSessionCookie)
+	if err != nil {
+		return nil, err
+	}
+	session, err := h.GetConsoleSession(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.renewConsoleSession(r.Context(), session); err != nil {
+		log.Info().
+			Err(err).
+			Str("user", session.User.Name).
+			Msg("Console session ended")
+		h.revokeConsoleSession(session)
+
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// identity returns the identity the console uses for the session user.
+func (session *ConsoleSession) identity() *Identity {
+	displayName := session.DisplayName
+	if displayName == "" {
+		displayName = session.User.Name
+	}
+
+	return &Identity{
+		Provider:    session.Provider,
+		UserName:    session.User.Name,
+		UID:         session.User.OIDC_UID,
+		DisplayName: displayName,
+		Email:       session.User.Email,
+	}
+}
+
+func (session *ConsoleSession) toProto() *v1.ConsoleSession {
+	return &v1.ConsoleSession{
+		Id:         session.ID,
+		User:       session.User.Name,
+		Provider:   session.Provider,
+		IpAddress:  session.IPAddress,
+		UserAgent:  session.UserAgent,
+		CreatedAt:  timestamppb.New(session.CreatedAt),
+		LastSeen:   timestamppb.New(session.LastSeen),
+		Expiration: timestamppb.New(session.Expiration),
+	}
+}
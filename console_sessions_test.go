@@ -0,0 +1,117 @@
+package headscale
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *Suite) TestConsoleSession(c *check.C) {
+	user, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+
+	token, err := app.CreateConsoleSession(user, &ConsoleSession{Provider: localAccountProvider})
+	c.Assert(err, check.IsNil)
+
+	session, err := app.GetConsoleSession(token)
+	c.Assert(err, check.IsNil)
+	c.Assert(session.User.Name, check.Equals, "alice")
+	c.Assert(session.identity().DisplayName, check.Equals, "Alice")
+
+	// Only the hash of the token is stored
+	c.Assert(session.TokenHash, check.Not(check.Equals), token)
+
+	_, err = app.GetConsoleSession("unknown")
+	c.Assert(err, check.Equals, errConsoleSessionNotFound)
+
+	// Idle timeout
+	app.db.Model(session).Update("last_seen", time.Now().Add(-3*time.Hour))
+	_, err = app.GetConsoleSession(token)
+	c.Assert(err, check.Equals, errConsoleSessionExpired)
+	_, err = app.GetConsoleSession(token)
+	c.Assert(err, check.Equals, errConsoleSessionNotFound)
+
+	// Absolute timeout
+	token, err = app.CreateConsoleSession(user, &ConsoleSession{Provider: localAccountProvider})
+	c.Assert(err, check.IsNil)
+	session, err = app.GetConsoleSession(token)
+	c.Assert(err, check.IsNil)
+	app.db.Model(session).Update("expiration", time.Now().Add(-time.Minute))
+	_, err = app.GetConsoleSession(token)
+	c.Assert(err, check.Equals, errConsoleSessionExpired)
+
+	for i := 0; i < 2; i++ {
+		_, err = app.CreateConsoleSession(user, &ConsoleSession{Provider: localAccountProvider})
+		c.Assert(err, check.IsNil)
+	}
+	sessions, err := app.ListConsoleSessions("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(sessions, check.HasLen, 2)
+
+	revoked, err := app.RevokeUserConsoleSessions("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(revoked, check.Equals, int64(2))
+
+	token, err = app.CreateConsoleSession(user, &ConsoleSession{Provider: localAccountProvider})
+	c.Assert(err, check.IsNil)
+	err = app.DisableUser("alice")
+	c.Assert(err, check.IsNil)
+	_, err = app.GetConsoleSession(token)
+	c.Assert(err, check.Equals, errConsoleSessionNotFound)
+}
+
+func (s *Suite) TestConsoleSessionLoginLogout(c *check.C) {
+	app.cfg.ServerURL = "https://headscale.example.com"
+	app.cfg.LocalAccounts.Enabled = true
+	defer func() {
+		app.cfg.ServerURL = ""
+		app.cfg.LocalAccounts.Enabled = false
+	}()
+
+	_, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	err = app.SetUserPassword("alice", "correct horse")
+	c.Assert(err, check.IsNil)
+
+	body := `{"username": "alice", "password": "correct horse"}`
+	recorder := httptest.NewRecorder()
+	app.LocalLoginAPI(recorder, httptest.NewRequest(http.MethodPost, "/login/local", strings.NewReader(body)))
+
+	res := APIResponse{}
+	c.Assert(json.NewDecoder(recorder.Body).Decode(&res), check.IsNil)
+	c.Assert(res.Status, check.Equals, "success")
+
+	cookies := recorder.Result().Cookies()
+	c.Assert(cookies, check.HasLen, 2)
+	c.Assert(cookies[0].Name, check.Equals, consoleSessionCookie)
+	c.Assert(cookies[0].HttpOnly, check.Equals, true)
+	c.Assert(cookies[1].Name, check.Equals, csrfCookie)
+	c.Assert(cookies[1].Value, check.Equals, consoleCSRFToken(cookies[0].Value))
+
+	protected := app.APIAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/api/self", nil)
+		req.AddCookie(cookies[0])
+		recorder := httptest.NewRecorder()
+		protected.ServeHTTP(recorder, req)
+
+		return recorder.Code
+	}
+	c.Assert(request(), check.Equals, http.StatusNoContent)
+
+	logout := httptest.NewRequest(http.MethodGet, "/admin/logout", nil)
+	logout.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	app.ConsoleLogout(recorder, logout)
+	c.Assert(recorder.Code, check.Equals, http.StatusFound)
+	c.Assert(recorder.Header().Get("Location"), check.Equals, "/login")
+
+	// The cookie no longer opens a session once logged out
+	c.Assert(request(), check.Equals, http.StatusUnauthorized)
+}
@@ -6,6 +6,7 @@ import VueClickAway from "vue3-click-away"
 import Machines from './components/Machines.vue'
 import Machine from './components/Machine.vue'
 import DNS from './components/DNS.vue'
+import Users from './components/Users.vue'
 import Settings from './components/Settings.vue'
 
 
@@ -13,6 +14,7 @@ const routes = [
     { path: '/', redirect: '/machines' },
     { path: '/machines', component: Machines },
     { path: '/machines/:mip', component: Machine },
+    { path: '/users', component: Users },
     { path: '/dns', component: DNS },
     { path: '/settings', redirect: '/settings/general' },
     { path: '/settings/:setpart', component: Settings },
@@ -11,9 +11,6 @@ export default defineConfig({
   mode: 'production',
   plugins: [vue()],
   build: {
-    watch:{
-
-    },
     rollupOptions: {
       input: {
         main: resolve(__dirname, 'index.html'),
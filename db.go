@@ -202,6 +202,11 @@ func (h *Headscale) initDB() error {
 		return err
 	}
 
+	err = hashPlaintextPreAuthKeys(db)
+	if err != nil {
+		return err
+	}
+
 	err = db.AutoMigrate(&PreAuthKeyACLTag{})
 	if err != nil {
 		return err
@@ -214,6 +219,51 @@ func (h *Headscale) initDB() error {
 		return err
 	}
 
+	err = db.AutoMigrate(&MachineHistory{})
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&UserIdentity{})
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&ConsoleSession{})
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&Organization{})
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&SCIMGroup{})
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&Invite{})
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&OAuthClient{})
+	if err != nil {
+		return err
+	}
+
+	err = db.AutoMigrate(&DNSRecord{})
+	if err != nil {
+		return err
+	}
+
+	err = h.ensureDefaultOrganization()
+	if err != nil {
+		return err
+	}
+
 	err = h.setValue("db_version", dbVersion)
 
 	return err
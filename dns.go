@@ -181,7 +181,7 @@ func addNextDNSMetadata(resolvers []*dnstype.Resolver, machine Machine) {
 	}
 }
 
-func getMapResponseDNSConfig(
+func (h *Headscale) getMapResponseDNSConfig(
 	ipPrefixes []netip.Prefix, //
 	//	dnsConfigOrig *tailcfg.DNSConfig,
 	//	baseDomain string,
@@ -189,16 +189,13 @@ func getMapResponseDNSConfig(
 	peers Machines,
 ) *tailcfg.DNSConfig {
 	//cgao6: change to use User's DNSConfig
-	dnsConfig, baseDomain := machine.User.GetDNSConfig(ipPrefixes) //*tailcfg.DNSConfig = dnsConfigOrig.Clone()
-	if dnsConfig.Proxied {                                         // dnsConfigOrig != nil && dnsConfigOrig.Proxied {             // if MagicDNS is enabled
+	dnsConfig := machine.User.GetDNSConfig(ipPrefixes) //*tailcfg.DNSConfig = dnsConfigOrig.Clone()
+	dnsConfig.ExtraRecords = h.organizationDNSRecords(h.machineOrganizationID(&machine))
+	if dnsConfig.Proxied { // dnsConfigOrig != nil && dnsConfigOrig.Proxied {             // if MagicDNS is enabled
 		// Only inject the Search Domain of the current user - shared nodes should use their full FQDN
 		dnsConfig.Domains = append(
 			dnsConfig.Domains,
-			fmt.Sprintf(
-				"%s.%s",
-				machine.User.Name,
-				baseDomain,
-			),
+			h.userMagicDNSDomain(&machine.User),
 		)
 
 		/* cgao6: due to we need to add uncomparable field to User, can't use mapset any more
@@ -214,8 +211,8 @@ func getMapResponseDNSConfig(
 			userSet = append(userSet, p.User)
 		}
 		for _, user := range userSet { //cgao6: same as above .ToSlice() {
-			dnsRoute := fmt.Sprintf("%v.%v", user.Name, baseDomain)
-			dnsConfig.Routes[dnsRoute] = nil
+			// The base domain alone when the user label is dropped
+			dnsConfig.Routes[h.userMagicDNSDomain(&user)] = nil
 		}
 	} /* else {
 		dnsConfig = dnsConfigOrig
@@ -0,0 +1,317 @@
+package headscale
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	ErrDNSRecordNotFound = Error("DNS record not found")
+	ErrDNSRecordExists   = Error("DNS record already exists")
+	ErrInvalidDNSRecord  = Error("invalid DNS record")
+
+	DNSRecordTypeA    = "A"
+	DNSRecordTypeAAAA = "AAAA"
+)
+
+// DNSRecord is a custom record served by MagicDNS to the machines of an
+// Organization. A record with a MachineID points to the addresses of that
+// machine, of the family of its type, and follows them when they change.
+type DNSRecord struct {
+	ID             uint64 `gorm:"primary_key"`
+	OrganizationID uint   `gorm:"index"`
+	Name           string
+	Type           string
+	Value          string
+	MachineID      *uint64
+
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+}
+
+// normalizeDNSRecord checks a record of organizationID before it is saved:
+// the name and the address are normalized, and the target machine must
+// belong to the organization.
+func (h *Headscale) normalizeDNSRecord(organizationID uint, record *DNSRecord) error {
+	name, err := normalizeDomainName(record.Name)
+	if err != nil || name == "" {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidDNSRecord, record.Name)
+	}
+	record.Name = name
+
+	record.Type = strings.ToUpper(strings.TrimSpace(record.Type))
+	if record.Type != DNSRecordTypeA && record.Type != DNSRecordTypeAAAA {
+		return fmt.Errorf("%w: unsupported type %q", ErrInvalidDNSRecord, record.Type)
+	}
+
+	if record.MachineID != nil {
+		machine, err := h.GetMachineByID(*record.MachineID)
+		if err != nil || machine.User.OrganizationID != organizationID {
+			return fmt.Errorf("%w: machine %d not found", ErrInvalidDNSRecord, *record.MachineID)
+		}
+		record.Value = ""
+	} else {
+		addr, err := netip.ParseAddr(strings.TrimSpace(record.Value))
+		if err != nil {
+			return fmt.Errorf("%w: invalid address %q", ErrInvalidDNSRecord, record.Value)
+		}
+		if addr.Is4() != (record.Type == DNSRecordTypeA) {
+			return fmt.Errorf("%w: %s is not an address of a %s record", ErrInvalidDNSRecord, addr, record.Type)
+		}
+		record.Value = addr.String()
+	}
+
+	duplicates := h.db.Model(&DNSRecord{}).
+		Where("organization_id = ? AND name = ? AND type = ? AND value = ? AND id <> ?",
+			organizationID, record.Name, record.Type, record.Value, record.ID)
+	if record.MachineID != nil {
+		duplicates = duplicates.Where("machine_id = ?", *record.MachineID)
+	} else {
+		duplicates = duplicates.Where("machine_id IS NULL")
+	}
+	var count int64
+	if err := duplicates.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDNSRecordExists
+	}
+
+	return nil
+}
+
+// ListDNSRecords returns the DNS records of an Organization.
+func (h *Headscale) ListDNSRecords(organizationID uint) ([]DNSRecord, error) {
+	records := []DNSRecord{}
+	if err := h.db.Where("organization_id = ?", organizationID).
+		Order("name, type, id").
+		Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetDNSRecord returns a DNS record by ID.
+func (h *Headscale) GetDNSRecord(id uint64) (*DNSRecord, error) {
+	record := DNSRecord{}
+	if err := h.db.First(&record, id).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDNSRecordNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// CreateDNSRecord adds a DNS record to an Organization and pushes it to
+// its machines.
+func (h *Headscale) CreateDNSRecord(organizationID uint, record DNSRecord) (*DNSRecord, error) {
+	record.ID = 0
+	record.OrganizationID = organizationID
+	if err := h.normalizeDNSRecord(organizationID, &record); err != nil {
+		return nil, err
+	}
+
+	if err := h.db.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to create DNS record in the database: %w", err)
+	}
+
+	h.setLastStateChangeToNow()
+
+	log.Info().
+		Uint64("record", record.ID).
+		Str("name", record.Name).
+		Str("type", record.Type).
+		Msg("DNS record created")
+
+	return &record, nil
+}
+
+// UpdateDNSRecord replaces the name, type and target of a DNS record.
+func (h *Headscale) UpdateDNSRecord(id uint64, update DNSRecord) (*DNSRecord, error) {
+	record, err := h.GetDNSRecord(id)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Name = update.Name
+	record.Type = update.Type
+	record.Value = update.Value
+	record.MachineID = update.MachineID
+	if err := h.normalizeDNSRecord(record.OrganizationID, record); err != nil {
+		return nil, err
+	}
+
+	if err := h.db.Select("name", "type", "value", "machine_id", "updated_at").
+		Save(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to update DNS record in the database: %w", err)
+	}
+
+	h.setLastStateChangeToNow()
+
+	log.Info().
+		Uint64("record", record.ID).
+		Str("name", record.Name).
+		Str("type", record.Type).
+		Msg("DNS record updated")
+
+	return record, nil
+}
+
+// DeleteDNSRecord removes a DNS record.
+func (h *Headscale) DeleteDNSRecord(id uint64) error {
+	record, err := h.GetDNSRecord(id)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Delete(record).Error; err != nil {
+		return fmt.Errorf("failed to delete DNS record from the database: %w", err)
+	}
+
+	h.setLastStateChangeToNow()
+
+	log.Info().
+		Uint64("record", record.ID).
+		Str("name", record.Name).
+		Msg("DNS record deleted")
+
+	return nil
+}
+
+// dnsRecordMachines returns the machines targeted by records, by ID.
+// Deleted machines are left out.
+func (h *Headscale) dnsRecordMachines(records []DNSRecord) (map[uint64]*Machine, error) {
+	ids := []uint64{}
+	for _, record := range records {
+		if record.MachineID != nil {
+			ids = append(ids, *record.MachineID)
+		}
+	}
+	machines := map[uint64]*Machine{}
+	if len(ids) == 0 {
+		return machines, nil
+	}
+
+	found := []Machine{}
+	if err := h.db.Preload("User").Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for index := range found {
+		machines[found[index].ID] = &found[index]
+	}
+
+	return machines, nil
+}
+
+// resolve returns the addresses of the record, those of its machine when
+// it targets one.
+func (record *DNSRecord) resolve(machines map[uint64]*Machine) []string {
+	if record.MachineID == nil {
+		return []string{record.Value}
+	}
+
+	machine, found := machines[*record.MachineID]
+	if !found || machine.User.OrganizationID != record.OrganizationID {
+		return nil
+	}
+	values := []string{}
+	for _, addr := range machine.IPAddresses {
+		if addr.Is4() == (record.Type == DNSRecordTypeA) {
+			values = append(values, addr.String())
+		}
+	}
+
+	return values
+}
+
+// organizationDNSRecords returns the DNS records sent to the machines of an
+// Organization, after the extra_records of the configuration.
+func (h *Headscale) organizationDNSRecords(organizationID uint) []tailcfg.DNSRecord {
+	extraRecords := []tailcfg.DNSRecord{}
+	if h.cfg.DNSConfig != nil {
+		extraRecords = append(extraRecords, h.cfg.DNSConfig.ExtraRecords...)
+	}
+
+	records, err := h.ListDNSRecords(organizationID)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Uint("organization", organizationID).
+			Msg("Cannot list the DNS records")
+
+		return extraRecords
+	}
+	machines, err := h.dnsRecordMachines(records)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Uint("organization", organizationID).
+			Msg("Cannot find the machines of the DNS records")
+
+		return extraRecords
+	}
+
+	for index := range records {
+		for _, value := range records[index].resolve(machines) {
+			extraRecords = append(extraRecords, tailcfg.DNSRecord{
+				Name:  records[index].Name,
+				Type:  records[index].Type,
+				Value: value,
+			})
+		}
+	}
+
+	return extraRecords
+}
+
+func (h *Headscale) dnsRecordsToProto(records []DNSRecord) ([]*v1.DNSRecord, error) {
+	machines, err := h.dnsRecordMachines(records)
+	if err != nil {
+		return nil, err
+	}
+
+	organizations := map[uint]string{}
+	protoRecords := make([]*v1.DNSRecord, len(records))
+	for index, record := range records {
+		organization, found := organizations[record.OrganizationID]
+		if !found {
+			if o, err := h.getOrganizationByID(record.OrganizationID); err == nil {
+				organization = o.Name
+			}
+			organizations[record.OrganizationID] = organization
+		}
+
+		protoRecord := &v1.DNSRecord{
+			Id:           record.ID,
+			Organization: organization,
+			Name:         record.Name,
+			Type:         record.Type,
+			Value:        strings.Join(record.resolve(machines), ", "),
+			MachineId:    record.MachineID,
+		}
+		if record.MachineID != nil {
+			if machine, found := machines[*record.MachineID]; found {
+				protoRecord.MachineName = machine.GivenName
+			}
+		}
+		if record.CreatedAt != nil {
+			protoRecord.CreatedAt = timestamppb.New(*record.CreatedAt)
+		}
+		protoRecords[index] = protoRecord
+	}
+
+	return protoRecords, nil
+}
@@ -0,0 +1,118 @@
+package headscale
+
+import (
+	"errors"
+	"net/netip"
+
+	"gopkg.in/check.v1"
+	"tailscale.com/tailcfg"
+)
+
+func (s *Suite) TestCreateDNSRecord(c *check.C) {
+	organizationID := app.defaultOrganizationID
+
+	record, err := app.CreateDNSRecord(organizationID, DNSRecord{
+		Name:  "Grafana.Example.com.",
+		Type:  "a",
+		Value: " 100.64.0.3",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(record.Name, check.Equals, "grafana.example.com")
+	c.Assert(record.Type, check.Equals, DNSRecordTypeA)
+	c.Assert(record.Value, check.Equals, "100.64.0.3")
+
+	_, err = app.CreateDNSRecord(organizationID, DNSRecord{
+		Name:  "grafana.example.com",
+		Type:  DNSRecordTypeA,
+		Value: "100.64.0.3",
+	})
+	c.Assert(err, check.Equals, ErrDNSRecordExists)
+
+	invalid := []DNSRecord{
+		{Name: "", Type: DNSRecordTypeA, Value: "100.64.0.3"},
+		{Name: "under_score.example.com", Type: DNSRecordTypeA, Value: "100.64.0.3"},
+		{Name: "grafana.example.com", Type: "CNAME", Value: "100.64.0.3"},
+		{Name: "grafana.example.com", Type: DNSRecordTypeA, Value: "fd7a:115c:a1e0::3"},
+		{Name: "grafana.example.com", Type: DNSRecordTypeAAAA, Value: "100.64.0.3"},
+		{Name: "grafana.example.com", Type: DNSRecordTypeA, Value: "grafana"},
+	}
+	for _, record := range invalid {
+		_, err = app.CreateDNSRecord(organizationID, record)
+		c.Assert(errors.Is(err, ErrInvalidDNSRecord), check.Equals, true, check.Commentf("%+v", record))
+	}
+
+	record, err = app.UpdateDNSRecord(record.ID, DNSRecord{
+		Name:  "prometheus.example.com",
+		Type:  DNSRecordTypeAAAA,
+		Value: "fd7a:115c:a1e0::3",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(record.OrganizationID, check.Equals, organizationID)
+
+	c.Assert(app.organizationDNSRecords(organizationID), check.DeepEquals, []tailcfg.DNSRecord{
+		{Name: "prometheus.example.com", Type: DNSRecordTypeAAAA, Value: "fd7a:115c:a1e0::3"},
+	})
+
+	c.Assert(app.DeleteDNSRecord(record.ID), check.IsNil)
+	c.Assert(app.DeleteDNSRecord(record.ID), check.Equals, ErrDNSRecordNotFound)
+
+	records, err := app.ListDNSRecords(organizationID)
+	c.Assert(err, check.IsNil)
+	c.Assert(records, check.HasLen, 0)
+}
+
+func (s *Suite) TestDNSRecordFollowsMachine(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+	machine := Machine{
+		MachineKey: "foo",
+		NodeKey:    "bar",
+		DiscoKey:   "faa",
+		Hostname:   "grafana",
+		GivenName:  "grafana",
+		UserID:     user.ID,
+		IPAddresses: MachineAddresses{
+			netip.MustParseAddr("100.64.0.3"),
+			netip.MustParseAddr("fd7a:115c:a1e0::3"),
+		},
+	}
+	c.Assert(app.db.Save(&machine).Error, check.IsNil)
+
+	record, err := app.CreateDNSRecord(user.OrganizationID, DNSRecord{
+		Name:      "grafana.example.com",
+		Type:      DNSRecordTypeA,
+		Value:     "100.64.0.9",
+		MachineID: &machine.ID,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(record.Value, check.Equals, "")
+
+	c.Assert(app.organizationDNSRecords(user.OrganizationID), check.DeepEquals, []tailcfg.DNSRecord{
+		{Name: "grafana.example.com", Type: DNSRecordTypeA, Value: "100.64.0.3"},
+	})
+
+	// The record follows the address of the machine
+	machine.IPAddresses = MachineAddresses{netip.MustParseAddr("100.64.0.4")}
+	c.Assert(app.db.Save(&machine).Error, check.IsNil)
+	c.Assert(app.organizationDNSRecords(user.OrganizationID), check.DeepEquals, []tailcfg.DNSRecord{
+		{Name: "grafana.example.com", Type: DNSRecordTypeA, Value: "100.64.0.4"},
+	})
+
+	protoRecords, err := app.dnsRecordsToProto([]DNSRecord{*record})
+	c.Assert(err, check.IsNil)
+	c.Assert(protoRecords[0].GetMachineName(), check.Equals, "grafana")
+	c.Assert(protoRecords[0].GetValue(), check.Equals, "100.64.0.4")
+
+	// Machines of other organizations cannot be targeted
+	acme, err := app.CreateOrganization("acme", "", nil)
+	c.Assert(err, check.IsNil)
+	_, err = app.CreateDNSRecord(acme.ID, DNSRecord{
+		Name:      "grafana.acme.example.com",
+		Type:      DNSRecordTypeA,
+		MachineID: &machine.ID,
+	})
+	c.Assert(errors.Is(err, ErrInvalidDNSRecord), check.Equals, true)
+
+	c.Assert(app.DeleteMachine(&machine), check.IsNil)
+	c.Assert(app.organizationDNSRecords(user.OrganizationID), check.HasLen, 0)
+}
@@ -236,7 +236,7 @@ func (s *Suite) TestDNSConfigMapResponseWithMagicDNS(c *check.C) {
 	prefixes := []netip.Prefix{
 		netip.MustParsePrefix("100.64.0.0/10"),
 	}
-	dnsConfig := getMapResponseDNSConfig(
+	dnsConfig := app.getMapResponseDNSConfig(
 		prefixes,
 		//		&dnsConfigOrig,
 		//		baseDomain,
@@ -387,7 +387,7 @@ func (s *Suite) TestDNSConfigMapResponseWithoutMagicDNS(c *check.C) {
 	prefixes := []netip.Prefix{
 		netip.MustParsePrefix("100.64.0.0/10"),
 	}
-	dnsConfig := getMapResponseDNSConfig(
+	dnsConfig := app.getMapResponseDNSConfig(
 		prefixes, //
 		//		&dnsConfigOrig,
 		//		baseDomain,
@@ -0,0 +1,227 @@
+package headscale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	errExpiryWebhookFailed = Error("expiry warning webhook failed")
+
+	expiryWarningTimeout              = 10 * time.Second
+	defaultExpiryWarningCheckInterval = time.Hour
+	hoursInDay                        = 24
+)
+
+// ExpiryWarning is the payload sent to the owner of a machine whose
+// key is about to expire.
+type ExpiryWarning struct {
+	User      string    `json:"user"`
+	Machine   string    `json:"machine"`
+	GivenName string    `json:"givenName"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// isExpiringSoon returns if the machine key expires within the given duration.
+func (machine Machine) isExpiringSoon(within time.Duration) bool {
+	if within <= 0 || machine.Expiry == nil || machine.Expiry.IsZero() || machine.isExpired() {
+		return false
+	}
+
+	return machine.Expiry.Before(time.Now().UTC().Add(within))
+}
+
+func (h *Headscale) expiryWarningPeriod() time.Duration {
+	return time.Duration(h.cfg.ExpiryWarning.Days) * hoursInDay * time.Hour
+}
+
+// machineHealth returns the health messages shown by the Tailscale client.
+// It returns nil when expiry warnings are disabled, so the client keeps
+// its own health state untouched.
+func (h *Headscale) machineHealth(machine Machine) []string {
+	if h.cfg.ExpiryWarning.Days <= 0 {
+		return nil
+	}
+
+	health := []string{}
+	if machine.isExpiringSoon(h.expiryWarningPeriod()) {
+		health = append(health, fmt.Sprintf(
+			"本设备密钥将于 %s 过期，请在过期前重新登录",
+			Time2SHString(*machine.Expiry),
+		))
+	}
+
+	return health
+}
+
+func (h *Headscale) warnExpiringMachines(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultExpiryWarningCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		h.warnExpiringMachinesWorker()
+	}
+}
+
+// warnedFor returns if a warning has been sent for the given expiry.
+func warnedFor(warned *time.Time, expiry time.Time) bool {
+	return warned != nil && warned.Equal(expiry)
+}
+
+// warnExpiringMachinesWorker notifies the owners of machines expiring soon,
+// once per expiry date and channel. The clients get the warning with the
+// next map response, so the state is marked as changed for them.
+func (h *Headscale) warnExpiringMachinesWorker() {
+	machines, err := h.ListMachines()
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing machines")
+
+		return
+	}
+
+	stateChanged := false
+	for index := range machines {
+		machine := &machines[index]
+		if !machine.isExpiringSoon(h.expiryWarningPeriod()) {
+			continue
+		}
+
+		if !warnedFor(machine.ExpiryWarnedFor, *machine.Expiry) {
+			h.saveExpiryWarning(machine, "expiry_warned_for")
+			stateChanged = true
+		}
+
+		h.notifyMachineExpiry(machine)
+	}
+
+	if stateChanged {
+		h.setLastStateChangeToNow()
+	}
+}
+
+// notifyMachineExpiry sends the warning through the webhook and by email.
+// Each channel is saved on its own, so a failing one is retried on the
+// next check without repeating the others.
+func (h *Headscale) notifyMachineExpiry(machine *Machine) {
+	warning := ExpiryWarning{
+		User:      machine.User.Name,
+		Machine:   machine.Hostname,
+		GivenName: machine.GivenName,
+		Expiry:    *machine.Expiry,
+	}
+
+	if h.cfg.ExpiryWarning.WebhookURL != "" &&
+		!warnedFor(machine.ExpiryWebhookWarnedFor, warning.Expiry) {
+		err := sendExpiryWebhook(h.cfg.ExpiryWarning.WebhookURL, warning)
+		h.logExpiryWarning(machine, "webhook", err)
+		if err == nil {
+			h.saveExpiryWarning(machine, "expiry_webhook_warned_for")
+		}
+	}
+
+	if h.cfg.ExpiryWarning.SMTP.Host != "" && machine.User.Email != "" &&
+		!warnedFor(machine.ExpiryEmailWarnedFor, warning.Expiry) {
+		err := sendExpiryEmail(h.cfg.ExpiryWarning.SMTP, machine.User.Email, warning)
+		h.logExpiryWarning(machine, "email", err)
+		if err == nil {
+			h.saveExpiryWarning(machine, "expiry_email_warned_for")
+		}
+	}
+}
+
+func (h *Headscale) saveExpiryWarning(machine *Machine, column string) {
+	if err := h.db.Model(machine).UpdateColumn(column, machine.Expiry).Error; err != nil {
+		log.Error().
+			Err(err).
+			Str("machine", machine.Hostname).
+			Msg("Cannot save expiry warning")
+	}
+}
+
+func (h *Headscale) logExpiryWarning(machine *Machine, channel string, err error) {
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("machine", machine.Hostname).
+			Str("user", machine.User.Name).
+			Str("channel", channel).
+			Msg("Cannot send expiry warning")
+
+		return
+	}
+
+	log.Info().
+		Str("machine", machine.Hostname).
+		Str("user", machine.User.Name).
+		Str("channel", channel).
+		Time("expiry", *machine.Expiry).
+		Msg("Expiry warning sent")
+}
+
+func sendExpiryWebhook(url string, warning ExpiryWarning) error {
+	body, err := json.Marshal(warning)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), expiryWarningTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: status %d", errExpiryWebhookFailed, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func sendExpiryEmail(cfg SMTPConfig, to string, warning ExpiryWarning) error {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	message := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		cfg.From,
+		to,
+		mime.QEncoding.Encode("utf-8", fmt.Sprintf("设备 %s 的密钥即将过期", warning.GivenName)),
+		fmt.Sprintf(
+			"您的设备 %s (%s) 的密钥将于 %s 过期，请在过期前重新登录以免连接中断。",
+			warning.GivenName,
+			warning.Machine,
+			Time2SHString(warning.Expiry),
+		),
+	)
+
+	return smtp.SendMail(
+		net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		auth,
+		cfg.From,
+		[]string{to},
+		[]byte(message),
+	)
+}
@@ -0,0 +1,82 @@
+package headscale
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *Suite) TestWarnExpiringMachines(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	warnings := []ExpiryWarning{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var warning ExpiryWarning
+		c.Assert(json.NewDecoder(r.Body).Decode(&warning), check.IsNil)
+		warnings = append(warnings, warning)
+	}))
+	defer server.Close()
+
+	// No mail server listens on this port, so every email fails
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	smtpPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	c.Assert(app.db.Model(user).Update("email", "test@example.com").Error, check.IsNil)
+
+	app.cfg.ExpiryWarning = ExpiryWarningConfig{
+		Days:       7,
+		WebhookURL: server.URL,
+		SMTP:       SMTPConfig{Host: "127.0.0.1", Port: smtpPort, From: "mirage@example.com"},
+	}
+	defer func() { app.cfg.ExpiryWarning = ExpiryWarningConfig{} }()
+
+	soon := time.Now().Add(48 * time.Hour)
+	later := time.Now().Add(30 * 24 * time.Hour)
+	for index, expiry := range []time.Time{soon, later} {
+		expiry := expiry
+		machine := Machine{
+			ID:             uint64(index + 1),
+			MachineKey:     "foo",
+			NodeKey:        "bar",
+			DiscoKey:       "faa",
+			Hostname:       "testmachine",
+			GivenName:      "testmachine",
+			UserID:         user.ID,
+			RegisterMethod: RegisterMethodAuthKey,
+			Expiry:         &expiry,
+		}
+		app.db.Save(&machine)
+	}
+
+	app.lastStateChange = nil
+	app.warnExpiringMachinesWorker()
+	c.Assert(len(warnings), check.Equals, 1)
+	changed, ok := app.lastStateChange.Load("test")
+	c.Assert(ok, check.Equals, true)
+	c.Assert(warnings[0].User, check.Equals, "test")
+	c.Assert(warnings[0].GivenName, check.Equals, "testmachine")
+
+	// The owner is only warned once for the same expiry, the failed
+	// email is retried without sending the webhook again
+	app.warnExpiringMachinesWorker()
+	c.Assert(len(warnings), check.Equals, 1)
+	changedAgain, _ := app.lastStateChange.Load("test")
+	c.Assert(changedAgain, check.Equals, changed)
+
+	machine, err := app.GetMachineByID(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(app.machineHealth(*machine)), check.Equals, 1)
+	c.Assert(machine.ExpiryWebhookWarnedFor, check.NotNil)
+	c.Assert(machine.ExpiryEmailWarnedFor, check.IsNil)
+
+	machine, err = app.GetMachineByID(2)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.machineHealth(*machine), check.HasLen, 0)
+	c.Assert(app.machineHealth(*machine), check.NotNil)
+}
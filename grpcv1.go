@@ -4,6 +4,7 @@ package headscale
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"strings"
 	"time"
 
@@ -44,7 +45,7 @@ func (api headscaleV1APIServer) GetUser(
 		return nil, err
 	}
 
-	return &v1.GetUserResponse{User: user.toProto()}, nil
+	return &v1.GetUserResponse{User: api.h.userToProto(user)}, nil
 }
 
 func (api headscaleV1APIServer) CreateUser(
@@ -56,13 +57,31 @@ func (api headscaleV1APIServer) CreateUser(
 		return nil, err
 	}
 
-	return &v1.CreateUserResponse{User: user.toProto()}, nil
+	if request.GetOrganization() != "" {
+		user, err = api.h.SetUserOrganization(user.Name, request.GetOrganization())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if request.GetServiceAccount() {
+		user, err = api.h.SetUserServiceAccount(user.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &v1.CreateUserResponse{User: api.h.userToProto(user)}, nil
 }
 
 func (api headscaleV1APIServer) RenameUser(
 	ctx context.Context,
 	request *v1.RenameUserRequest,
 ) (*v1.RenameUserResponse, error) {
+	if err := api.checkUserTarget(ctx, request.GetOldName()); err != nil {
+		return nil, err
+	}
+
 	err := api.h.RenameUser(request.GetOldName(), request.GetNewName())
 	if err != nil {
 		return nil, err
@@ -73,33 +92,177 @@ func (api headscaleV1APIServer) RenameUser(
 		return nil, err
 	}
 
-	return &v1.RenameUserResponse{User: user.toProto()}, nil
+	return &v1.RenameUserResponse{User: api.h.userToProto(user)}, nil
 }
 
 func (api headscaleV1APIServer) DeleteUser(
 	ctx context.Context,
 	request *v1.DeleteUserRequest,
 ) (*v1.DeleteUserResponse, error) {
-	err := api.h.DestroyUser(request.GetName())
+	if err := api.checkUserTarget(ctx, request.GetName()); err != nil {
+		return nil, err
+	}
+
+	report, err := api.h.DeleteUser(
+		request.GetName(),
+		request.GetTransferTo(),
+		request.GetDeleteMachines(),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &v1.DeleteUserResponse{}, nil
+	return &v1.DeleteUserResponse{
+		TransferredMachines:    report.TransferredMachines,
+		DeletedMachines:        report.DeletedMachines,
+		DeletedRoutes:          report.DeletedRoutes,
+		TransferredPreAuthKeys: report.TransferredPreAuthKeys,
+		DeletedPreAuthKeys:     report.DeletedPreAuthKeys,
+	}, nil
+}
+
+// checkGRPCCallerCan checks a permission and a scope needed by a request
+// on top of the ones of its method.
+func checkGRPCCallerCan(ctx context.Context, permission Permission, scope APIKeyScope) error {
+	caller, ok := grpcCallerFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if !caller.Role.Can(permission) {
+		return status.Error(codes.PermissionDenied, ErrPermissionDenied.Error())
+	}
+	if caller.APIKey != nil && !caller.APIKey.allows(scope) {
+		return status.Error(
+			codes.PermissionDenied,
+			fmt.Sprintf("%s: the %s scope is required", ErrAPIKeyScopeDenied, scope),
+		)
+	}
+
+	return nil
+}
+
+// checkUserTarget refuses changes to an owner from a caller that is not
+// an owner itself.
+func (api headscaleV1APIServer) checkUserTarget(ctx context.Context, name string) error {
+	user, err := api.h.GetUser(name)
+	if err != nil {
+		return err
+	}
+	if api.h.userRole(user) == RoleOwner && grpcCallerRole(ctx) != RoleOwner {
+		return status.Error(codes.PermissionDenied, ErrOwnerRoleRequired.Error())
+	}
+
+	return nil
+}
+
+func (api headscaleV1APIServer) SetUserQuota(
+	ctx context.Context,
+	request *v1.SetUserQuotaRequest,
+) (*v1.SetUserQuotaResponse, error) {
+	user, err := api.h.SetUserQuota(request.GetName(), UserQuota{
+		MaxMachines:            optionalUint(request.GetQuota().MaxMachines),
+		MaxReusablePreAuthKeys: optionalUint(request.GetQuota().MaxReusablePreAuthKeys),
+		MaxRoutes:              optionalUint(request.GetQuota().MaxRoutes),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.SetUserQuotaResponse{Quota: user.quotaToProto()}, nil
+}
+
+func (api headscaleV1APIServer) SetUserPassword(
+	ctx context.Context,
+	request *v1.SetUserPasswordRequest,
+) (*v1.SetUserPasswordResponse, error) {
+	if err := api.checkUserTarget(ctx, request.GetName()); err != nil {
+		return nil, err
+	}
+
+	err := api.h.SetUserPassword(request.GetName(), request.GetPassword())
+	if err != nil {
+		return nil, err
+	}
+
+	if request.GetResetTotp() {
+		err = api.h.ResetUserTOTP(request.GetName())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &v1.SetUserPasswordResponse{}, nil
+}
+
+func (api headscaleV1APIServer) SetUserRole(
+	ctx context.Context,
+	request *v1.SetUserRoleRequest,
+) (*v1.SetUserRoleResponse, error) {
+	role, err := ParseRole(request.GetRole())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := api.h.SetUserRole(grpcCallerRole(ctx), request.GetName(), role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.SetUserRoleResponse{User: api.h.userToProto(user)}, nil
+}
+
+func (api headscaleV1APIServer) SetUserOrganization(
+	ctx context.Context,
+	request *v1.SetUserOrganizationRequest,
+) (*v1.SetUserOrganizationResponse, error) {
+	user, err := api.h.SetUserOrganization(request.GetName(), request.GetOrganization())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.SetUserOrganizationResponse{User: api.h.userToProto(user)}, nil
+}
+
+func (api headscaleV1APIServer) SetUserMagicDNS(
+	ctx context.Context,
+	request *v1.SetUserMagicDNSRequest,
+) (*v1.SetUserMagicDNSResponse, error) {
+	user, err := api.h.SetUserMagicDNS(request.GetName(), MagicDNSSettings{
+		BaseDomain:    request.GetBaseDomain(),
+		OmitUserLabel: request.OmitUserLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.SetUserMagicDNSResponse{User: api.h.userToProto(user)}, nil
 }
 
 func (api headscaleV1APIServer) ListUsers(
 	ctx context.Context,
 	request *v1.ListUsersRequest,
 ) (*v1.ListUsersResponse, error) {
-	users, err := api.h.ListUsers()
-	if err != nil {
-		return nil, err
+	var users []User
+	var err error
+	if request.GetOrganization() != "" {
+		organization, err := api.h.GetOrganization(request.GetOrganization())
+		if err != nil {
+			return nil, err
+		}
+		users, err = api.h.ListOrganizationUsers(organization.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		users, err = api.h.ListUsers()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	response := make([]*v1.User, len(users))
-	for index, user := range users {
-		response[index] = user.toProto()
+	for index := range users {
+		response[index] = api.h.userToProto(&users[index])
 	}
 
 	log.Trace().Caller().Interface("users", response).Msg("")
@@ -125,12 +288,24 @@ func (api headscaleV1APIServer) CreatePreAuthKey(
 		}
 	}
 
-	preAuthKey, err := api.h.CreatePreAuthKey(
+	// The access tokens of OAuth clients only create preauth keys with the
+	// tags of the client
+	if caller, ok := grpcCallerFromContext(ctx); ok {
+		if err := api.h.checkOAuthPreAuthKeyTags(caller.APIKey, request.AclTags); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	preAuthKey, err := api.h.CreatePreAuthKeyWithOptions(
 		request.GetUser(),
 		request.GetReusable(),
 		request.GetEphemeral(),
 		&expiration,
 		request.AclTags,
+		PreAuthKeyOptions{
+			Description: request.GetDescription(),
+			MaxUses:     uint(request.GetMaxUses()),
+		},
 	)
 	if err != nil {
 		return nil, err
@@ -156,6 +331,40 @@ func (api headscaleV1APIServer) ExpirePreAuthKey(
 	return &v1.ExpirePreAuthKeyResponse{}, nil
 }
 
+func (api headscaleV1APIServer) RevokePreAuthKey(
+	ctx context.Context,
+	request *v1.RevokePreAuthKeyRequest,
+) (*v1.RevokePreAuthKeyResponse, error) {
+	preAuthKey, err := api.h.FindPreAuthKey(request.GetUser(), request.GetKey())
+	if err != nil {
+		return nil, err
+	}
+
+	if !request.GetCascade() {
+		if err := api.h.RevokePreAuthKey(preAuthKey); err != nil {
+			return nil, err
+		}
+
+		return &v1.RevokePreAuthKeyResponse{}, nil
+	}
+
+	// Expiring or deleting the machines is managing them
+	if err := checkGRPCCallerCan(ctx, PermissionManageMachines, APIKeyScopeMachinesWrite); err != nil {
+		return nil, err
+	}
+
+	report, err := api.h.RevokePreAuthKeyCascade(preAuthKey, request.GetDeleteMachines())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.RevokePreAuthKeyResponse{
+		ExpiredMachines: report.ExpiredMachines,
+		DeletedMachines: report.DeletedMachines,
+		DeletedRoutes:   report.DeletedRoutes,
+	}, nil
+}
+
 func (api headscaleV1APIServer) ListPreAuthKeys(
 	ctx context.Context,
 	request *v1.ListPreAuthKeysRequest,
@@ -165,9 +374,17 @@ func (api headscaleV1APIServer) ListPreAuthKeys(
 		return nil, err
 	}
 
+	machines, err := api.h.ListPreAuthKeyMachines(preAuthKeys)
+	if err != nil {
+		return nil, err
+	}
+
 	response := make([]*v1.PreAuthKey, len(preAuthKeys))
 	for index, key := range preAuthKeys {
 		response[index] = key.toProto()
+		for _, machine := range machines[key.ID] {
+			response[index].Machines = append(response[index].Machines, machine.GivenName)
+		}
 	}
 
 	return &v1.ListPreAuthKeysResponse{PreAuthKeys: response}, nil
@@ -345,7 +562,7 @@ func (api headscaleV1APIServer) ListMachines(
 	for index, machine := range machines {
 		m := machine.toProto()
 		validTags, invalidTags := getTags(
-			api.h.aclPolicy,
+			api.h.aclFor(api.h.machineOrganizationID(&machine)).policy,
 			machine,
 			api.h.cfg.OIDC.StripEmaildomain,
 		)
@@ -357,6 +574,25 @@ func (api headscaleV1APIServer) ListMachines(
 	return &v1.ListMachinesResponse{Machines: response}, nil
 }
 
+// GetMachineHistory does not look the machine up, the history of
+// deleted machines is kept.
+func (api headscaleV1APIServer) GetMachineHistory(
+	ctx context.Context,
+	request *v1.GetMachineHistoryRequest,
+) (*v1.GetMachineHistoryResponse, error) {
+	history, err := api.h.GetMachineHistory(request.GetMachineId())
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]*v1.MachineHistory, len(history))
+	for index, entry := range history {
+		response[index] = entry.toProto()
+	}
+
+	return &v1.GetMachineHistoryResponse{History: response}, nil
+}
+
 func (api headscaleV1APIServer) MoveMachine(
 	ctx context.Context,
 	request *v1.MoveMachineRequest,
@@ -431,6 +667,24 @@ func (api headscaleV1APIServer) GetMachineRoutes(
 	}, nil
 }
 
+// checkAPIKeyOwner checks that the caller can manage the API keys of user,
+// nil for the keys acting as owners. Owners manage every key, the others
+// their own keys and the keys of the service accounts.
+func checkAPIKeyOwner(ctx context.Context, user *User) error {
+	caller, ok := grpcCallerFromContext(ctx)
+	if !ok || caller.Role == RoleOwner {
+		return nil
+	}
+	if user == nil {
+		return status.Error(codes.PermissionDenied, ErrOwnerRoleRequired.Error())
+	}
+	if caller.User != nil && (user.ID == caller.User.ID || user.ServiceAccount) {
+		return nil
+	}
+
+	return status.Error(codes.PermissionDenied, ErrPermissionDenied.Error())
+}
+
 func (api headscaleV1APIServer) CreateApiKey(
 	ctx context.Context,
 	request *v1.CreateApiKeyRequest,
@@ -440,9 +694,50 @@ func (api headscaleV1APIServer) CreateApiKey(
 		expiration = request.GetExpiration().AsTime()
 	}
 
-	apiKey, _, err := api.h.CreateAPIKey(
-		&expiration,
-	)
+	scopes, err := ParseAPIKeyScopes(request.GetScopes())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// The keys created with an API key have the same user by default and
+	// cannot have more scopes. The short-lived access tokens of the OAuth
+	// clients cannot create long-lived keys.
+	caller, ok := grpcCallerFromContext(ctx)
+	if ok && caller.APIKey.OauthClientID != 0 {
+		return nil, status.Error(codes.PermissionDenied, ErrPermissionDenied.Error())
+	}
+	userName := request.GetUser()
+	if userName == "" && ok && caller.User != nil {
+		userName = caller.User.Name
+	}
+	if ok {
+		for _, scope := range scopes {
+			if !caller.APIKey.allows(scope) {
+				return nil, status.Errorf(
+					codes.PermissionDenied,
+					"%s: %s", ErrAPIKeyScopeDenied, scope,
+				)
+			}
+		}
+	}
+
+	var user *User
+	if userName != "" {
+		user, err = api.h.GetUser(userName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := checkAPIKeyOwner(ctx, user); err != nil {
+		return nil, err
+	}
+
+	var apiKey string
+	if user != nil {
+		apiKey, _, err = api.h.CreateUserAPIKey(user.Name, scopes, &expiration)
+	} else {
+		apiKey, _, err = api.h.CreateAPIKey(&expiration)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -462,6 +757,17 @@ func (api headscaleV1APIServer) ExpireApiKey(
 		return nil, err
 	}
 
+	var user *User
+	if apiKey.UserID != 0 {
+		user = &User{}
+		if err := api.h.db.First(user, apiKey.UserID).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := checkAPIKeyOwner(ctx, user); err != nil {
+		return nil, err
+	}
+
 	err = api.h.ExpireAPIKey(apiKey)
 	if err != nil {
 		return nil, err
@@ -474,19 +780,267 @@ func (api headscaleV1APIServer) ListApiKeys(
 	ctx context.Context,
 	request *v1.ListApiKeysRequest,
 ) (*v1.ListApiKeysResponse, error) {
-	apiKeys, err := api.h.ListAPIKeys()
-	if err != nil {
+	userName := request.GetUser()
+	if caller, ok := grpcCallerFromContext(ctx); ok && userName == "" &&
+		caller.Role != RoleOwner && caller.User != nil {
+		userName = caller.User.Name
+	}
+
+	var apiKeys []APIKey
+	if userName != "" {
+		user, err := api.h.GetUser(userName)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAPIKeyOwner(ctx, user); err != nil {
+			return nil, err
+		}
+		apiKeys, err = api.h.ListUserAPIKeys(user.Name)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		apiKeys, err = api.h.ListAPIKeys()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	users := []User{}
+	if err := api.h.db.Select("id", "name").Find(&users).Error; err != nil {
 		return nil, err
 	}
+	userNames := make(map[uint]string, len(users))
+	for _, user := range users {
+		userNames[user.ID] = user.Name
+	}
 
 	response := make([]*v1.ApiKey, len(apiKeys))
 	for index, key := range apiKeys {
 		response[index] = key.toProto()
+		response[index].User = userNames[key.UserID]
 	}
 
 	return &v1.ListApiKeysResponse{ApiKeys: response}, nil
 }
 
+func (api headscaleV1APIServer) ListConsoleSessions(
+	ctx context.Context,
+	request *v1.ListConsoleSessionsRequest,
+) (*v1.ListConsoleSessionsResponse, error) {
+	sessions, err := api.h.ListConsoleSessions(request.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]*v1.ConsoleSession, len(sessions))
+	for index, session := range sessions {
+		response[index] = session.toProto()
+	}
+
+	return &v1.ListConsoleSessionsResponse{Sessions: response}, nil
+}
+
+func (api headscaleV1APIServer) RevokeConsoleSessions(
+	ctx context.Context,
+	request *v1.RevokeConsoleSessionsRequest,
+) (*v1.RevokeConsoleSessionsResponse, error) {
+	switch {
+	case request.GetId() != 0:
+		if err := api.h.RevokeConsoleSession(request.GetId()); err != nil {
+			return nil, err
+		}
+
+		return &v1.RevokeConsoleSessionsResponse{Revoked: 1}, nil
+	case request.GetUser() != "":
+		revoked, err := api.h.RevokeUserConsoleSessions(request.GetUser())
+		if err != nil {
+			return nil, err
+		}
+
+		return &v1.RevokeConsoleSessionsResponse{Revoked: uint64(revoked)}, nil
+	default:
+		return nil, errConsoleSessionRevokeTarget
+	}
+}
+
+func (api headscaleV1APIServer) CreateOrganization(
+	ctx context.Context,
+	request *v1.CreateOrganizationRequest,
+) (*v1.CreateOrganizationResponse, error) {
+	prefixes := make([]netip.Prefix, 0, len(request.GetIpPrefixes()))
+	for _, prefixStr := range request.GetIpPrefixes() {
+		prefix, err := netip.ParsePrefix(prefixStr)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	organization, err := api.h.CreateOrganization(
+		request.GetName(),
+		request.GetDisplayName(),
+		prefixes,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.CreateOrganizationResponse{Organization: organization.toProto()}, nil
+}
+
+func (api headscaleV1APIServer) ListOrganizations(
+	ctx context.Context,
+	request *v1.ListOrganizationsRequest,
+) (*v1.ListOrganizationsResponse, error) {
+	organizations, err := api.h.ListOrganizations()
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]*v1.Organization, len(organizations))
+	for index := range organizations {
+		response[index] = organizations[index].toProto()
+	}
+
+	return &v1.ListOrganizationsResponse{Organizations: response}, nil
+}
+
+func (api headscaleV1APIServer) DeleteOrganization(
+	ctx context.Context,
+	request *v1.DeleteOrganizationRequest,
+) (*v1.DeleteOrganizationResponse, error) {
+	if err := api.h.DeleteOrganization(request.GetName()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteOrganizationResponse{}, nil
+}
+
+func (api headscaleV1APIServer) SetOrganizationPolicy(
+	ctx context.Context,
+	request *v1.SetOrganizationPolicyRequest,
+) (*v1.SetOrganizationPolicyResponse, error) {
+	if err := api.h.SetOrganizationPolicy(request.GetName(), request.GetPolicy()); err != nil {
+		return nil, err
+	}
+
+	return &v1.SetOrganizationPolicyResponse{}, nil
+}
+
+func (api headscaleV1APIServer) SetOrganizationMagicDNS(
+	ctx context.Context,
+	request *v1.SetOrganizationMagicDNSRequest,
+) (*v1.SetOrganizationMagicDNSResponse, error) {
+	organization, err := api.h.SetOrganizationMagicDNS(
+		request.GetName(),
+		request.GetBaseDomain(),
+		request.GetOmitUserLabel(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.SetOrganizationMagicDNSResponse{Organization: organization.toProto()}, nil
+}
+
+// dnsRecordsOrganizationID returns the ID of the organization named in a
+// DNS records request, the default one when empty.
+func (api headscaleV1APIServer) dnsRecordsOrganizationID(name string) (uint, error) {
+	if name == "" {
+		return api.h.defaultOrganizationID, nil
+	}
+	organization, err := api.h.GetOrganization(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return organization.ID, nil
+}
+
+func (api headscaleV1APIServer) ListDNSRecords(
+	ctx context.Context,
+	request *v1.ListDNSRecordsRequest,
+) (*v1.ListDNSRecordsResponse, error) {
+	organizationID, err := api.dnsRecordsOrganizationID(request.GetOrganization())
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := api.h.ListDNSRecords(organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := api.h.dnsRecordsToProto(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListDNSRecordsResponse{Records: response}, nil
+}
+
+func (api headscaleV1APIServer) CreateDNSRecord(
+	ctx context.Context,
+	request *v1.CreateDNSRecordRequest,
+) (*v1.CreateDNSRecordResponse, error) {
+	organizationID, err := api.dnsRecordsOrganizationID(request.GetOrganization())
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := api.h.CreateDNSRecord(organizationID, DNSRecord{
+		Name:      request.GetName(),
+		Type:      request.GetType(),
+		Value:     request.GetValue(),
+		MachineID: request.MachineId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := api.h.dnsRecordsToProto([]DNSRecord{*record})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.CreateDNSRecordResponse{Record: response[0]}, nil
+}
+
+func (api headscaleV1APIServer) UpdateDNSRecord(
+	ctx context.Context,
+	request *v1.UpdateDNSRecordRequest,
+) (*v1.UpdateDNSRecordResponse, error) {
+	record, err := api.h.UpdateDNSRecord(request.GetId(), DNSRecord{
+		Name:      request.GetName(),
+		Type:      request.GetType(),
+		Value:     request.GetValue(),
+		MachineID: request.MachineId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := api.h.dnsRecordsToProto([]DNSRecord{*record})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.UpdateDNSRecordResponse{Record: response[0]}, nil
+}
+
+func (api headscaleV1APIServer) DeleteDNSRecord(
+	ctx context.Context,
+	request *v1.DeleteDNSRecordRequest,
+) (*v1.DeleteDNSRecordResponse, error) {
+	if err := api.h.DeleteDNSRecord(request.GetId()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteDNSRecordResponse{}, nil
+}
+
 // The following service calls are for testing and debugging
 func (api headscaleV1APIServer) DebugCreateMachine(
 	ctx context.Context,
@@ -0,0 +1,527 @@
+package headscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	errIdentityProviderName      = Error("identity provider name is required")
+	errIdentityProviderDuplicate = Error("identity provider name is used more than once")
+	errIdentityProviderType      = Error("unknown identity provider type")
+	errIdentityProviderIssuer    = Error("identity provider issuer is required")
+	errIdentityProviderNotFound  = Error("identity provider not found")
+	errIdentityUsernameMissing   = Error("ID token has no username claim")
+	errIdentityUserLinked        = Error("user is linked to another identity")
+)
+
+const (
+	// IdentityProviderTypeOIDC is a generic OpenID Connect provider,
+	// users are named after their email address by default.
+	IdentityProviderTypeOIDC = "oidc"
+	// IdentityProviderTypeAliyun is Aliyun IDaaS,
+	// users are named after their phone number by default.
+	IdentityProviderTypeAliyun = "aliyun"
+
+	// legacyIdentityProviderName is the name of the provider built from
+	// the oidc section, it is also the provider value of the old login form.
+	legacyIdentityProviderName = "Ali"
+)
+
+// UserIdentity links a User to the subject of an identity provider,
+// so that renaming a user at the provider keeps its machines.
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint
+	User     User
+	Provider string `gorm:"uniqueIndex:idx_user_identity_provider_subject"`
+	Subject  string `gorm:"uniqueIndex:idx_user_identity_provider_subject"`
+}
+
+// Identity is an authenticated principal of an identity provider,
+// with its claims mapped according to the provider configuration.
+type Identity struct {
+	Provider    string
+	Subject     string
+	UserName    string
+	UID         string
+	DisplayName string
+	Email       string
+	Groups      []string
+}
+
+type identityProvider struct {
+	cfg          IdentityProviderConfig
+	provider     *oidc.Provider
+	oauth2Config *oauth2.Config
+}
+
+// IdentityProviderItem is a provider shown on the login page.
+type IdentityProviderItem struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Type        string `json:"type"`
+}
+
+func (claims ClaimMapping) withDefaults(providerType string) ClaimMapping {
+	if claims.Username == "" {
+		if providerType == IdentityProviderTypeAliyun {
+			claims.Username = "phone_number"
+		} else {
+			claims.Username = "email"
+		}
+	}
+	if claims.DisplayName == "" {
+		claims.DisplayName = "name"
+	}
+	if claims.Email == "" {
+		claims.Email = "email"
+	}
+	if claims.Groups == "" {
+		claims.Groups = "groups"
+	}
+
+	return claims
+}
+
+func validateIdentityProviders(providers []IdentityProviderConfig) error {
+	names := map[string]bool{}
+	for _, provider := range providers {
+		if provider.Name == "" {
+			return errIdentityProviderName
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("%w: %s", errIdentityProviderDuplicate, provider.Name)
+		}
+		names[provider.Name] = true
+
+		switch provider.Type {
+		case IdentityProviderTypeOIDC, IdentityProviderTypeAliyun:
+		default:
+			return fmt.Errorf("%w: %q in provider %s", errIdentityProviderType, provider.Type, provider.Name)
+		}
+
+		if provider.Issuer == "" {
+			return fmt.Errorf("%w: %s", errIdentityProviderIssuer, provider.Name)
+		}
+	}
+
+	return nil
+}
+
+// legacyIdentityProvider turns the single oidc section into a provider,
+// keeping the Aliyun IDaaS behaviour it had.
+func legacyIdentityProvider(oidcConfig OIDCConfig) IdentityProviderConfig {
+	return IdentityProviderConfig{
+		Name:             legacyIdentityProviderName,
+		DisplayName:      "阿里云IDaaS",
+		Type:             IdentityProviderTypeAliyun,
+		Issuer:           oidcConfig.Issuer,
+		ClientID:         oidcConfig.ClientID,
+		ClientSecret:     oidcConfig.ClientSecret,
+		LogoutURL:        oidcConfig.LogoutURL,
+		Scope:            oidcConfig.Scope,
+		ExtraParams:      oidcConfig.ExtraParams,
+		AllowedDomains:   oidcConfig.AllowedDomains,
+		AllowedUsers:     oidcConfig.AllowedUsers,
+		AllowedGroups:    oidcConfig.AllowedGroups,
+		StripEmaildomain: oidcConfig.StripEmaildomain,
+		Claims:           ClaimMapping{}.withDefaults(IdentityProviderTypeAliyun),
+	}
+}
+
+// initIdentityProviders discovers the configured identity providers.
+// The first provider keeps the /oidc/callback redirect URL, the others
+// get /oidc/callback/<name>.
+func (h *Headscale) initIdentityProviders() error {
+	providers := h.cfg.IdentityProviders
+	if len(providers) == 0 && h.cfg.OIDC.Issuer != "" {
+		providers = []IdentityProviderConfig{legacyIdentityProvider(h.cfg.OIDC)}
+	}
+
+	var initErr error
+	for index, providerConfig := range providers {
+		provider, err := oidc.NewProvider(context.Background(), providerConfig.Issuer)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Caller().
+				Str("provider", providerConfig.Name).
+				Msgf("Could not retrieve OIDC Config: %s", err.Error())
+			if initErr == nil {
+				initErr = err
+			}
+
+			continue
+		}
+
+		callbackPath := "/oidc/callback"
+		if index > 0 {
+			callbackPath = "/oidc/callback/" + providerConfig.Name
+		}
+
+		h.identityProviders = append(h.identityProviders, &identityProvider{
+			cfg:      providerConfig,
+			provider: provider,
+			oauth2Config: &oauth2.Config{
+				ClientID:     providerConfig.ClientID,
+				ClientSecret: providerConfig.ClientSecret,
+				Endpoint:     provider.Endpoint(),
+				RedirectURL: fmt.Sprintf(
+					"%s%s",
+					strings.TrimSuffix(h.cfg.ServerURL, "/"),
+					callbackPath,
+				),
+				Scopes: providerConfig.Scope,
+			},
+		})
+	}
+
+	return initErr
+}
+
+// getIdentityProvider returns the provider with the given name,
+// or the first one if the name is empty.
+func (h *Headscale) getIdentityProvider(name string) (*identityProvider, error) {
+	for _, provider := range h.identityProviders {
+		if name == "" || provider.cfg.Name == name {
+			return provider, nil
+		}
+	}
+
+	return nil, errIdentityProviderNotFound
+}
+
+func (provider *identityProvider) authCodeURL(state string, redirectURL string) string {
+	extras := make([]oauth2.AuthCodeOption, 0, len(provider.cfg.ExtraParams))
+	for k, v := range provider.cfg.ExtraParams {
+		extras = append(extras, oauth2.SetAuthURLParam(k, v))
+	}
+
+	oauth2Config := *provider.oauth2Config
+	if redirectURL != "" {
+		oauth2Config.RedirectURL = redirectURL
+	}
+
+	return oauth2Config.AuthCodeURL(state, extras...)
+}
+
+func (provider *identityProvider) verify(ctx context.Context, rawIDToken string) (*oidc.IDToken, error) {
+	verifier := provider.provider.Verifier(&oidc.Config{ClientID: provider.cfg.ClientID})
+
+	return verifier.Verify(ctx, rawIDToken)
+}
+
+// identity maps the claims of a verified ID token.
+func (provider *identityProvider) identity(idToken *oidc.IDToken) (*Identity, error) {
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return provider.cfg.mapClaims(idToken.Subject, claims)
+}
+
+func (cfg IdentityProviderConfig) mapClaims(
+	subject string,
+	claims map[string]interface{},
+) (*Identity, error) {
+	mapping := cfg.Claims.withDefaults(cfg.Type)
+
+	identity := &Identity{
+		Provider:    cfg.Name,
+		Subject:     subject,
+		DisplayName: claimString(claims, mapping.DisplayName),
+		Email:       claimString(claims, mapping.Email),
+		Groups:      claimStrings(claims, mapping.Groups),
+	}
+
+	userName := claimString(claims, mapping.Username)
+	if userName == "" {
+		return nil, fmt.Errorf("%w: %s", errIdentityUsernameMissing, mapping.Username)
+	}
+
+	switch cfg.Type {
+	case IdentityProviderTypeAliyun:
+		identity.UserName = strings.ReplaceAll(strings.TrimPrefix(userName, "+86"), " ", "")
+		identity.UID = claimString(claims, "preferred_username")
+	default:
+		normalized, err := NormalizeToFQDNRules(userName, cfg.StripEmaildomain)
+		if err != nil {
+			return nil, err
+		}
+		identity.UserName = normalized
+		identity.UID = cfg.Name + ":" + subject
+	}
+
+	if identity.DisplayName == "" {
+		identity.DisplayName = identity.UserName
+	}
+
+	return identity, nil
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case float64:
+		return fmt.Sprintf("%.0f", value)
+	default:
+		return ""
+	}
+}
+
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+
+		return values
+	default:
+		return nil
+	}
+}
+
+// authorize checks the identity against the allowed domains, groups and
+// users of the provider. Empty lists allow everybody.
+func (cfg IdentityProviderConfig) authorize(identity *Identity) error {
+	if len(cfg.AllowedDomains) > 0 {
+		if at := strings.LastIndex(identity.Email, "@"); at < 0 ||
+			!IsStringInSlice(cfg.AllowedDomains, identity.Email[at+1:]) {
+			return errOIDCAllowedDomains
+		}
+	}
+
+	if len(cfg.AllowedGroups) > 0 {
+		allowed := false
+		for _, group := range cfg.AllowedGroups {
+			if IsStringInSlice(identity.Groups, group) {
+				allowed = true
+
+				break
+			}
+		}
+		if !allowed {
+			return errOIDCAllowedGroups
+		}
+	}
+
+	if len(cfg.AllowedUsers) > 0 &&
+		!IsStringInSlice(cfg.AllowedUsers, identity.Email) &&
+		!IsStringInSlice(cfg.AllowedUsers, identity.UserName) {
+		return errOIDCAllowedUsers
+	}
+
+	return nil
+}
+
+// findOrCreateUserForIdentity returns the User linked to the identity.
+// Identities without a link yet are linked to the User of the same name
+// when that User has no identity at all, which is how users created
+// before the links existed are migrated, or to a new User. A User already
+// linked to another identity is never taken over by its name.
+func (h *Headscale) findOrCreateUserForIdentity(identity *Identity) (*User, error) {
+	return h.findOrCreateUserWithInvite(identity, nil)
+}
+
+// findOrCreateUserWithInvite is findOrCreateUserForIdentity accepting the
+// invite when it creates the User, the invite is left unused otherwise.
+func (h *Headscale) findOrCreateUserWithInvite(identity *Identity, invite *Invite) (*User, error) {
+	userIdentity := UserIdentity{}
+	err := h.db.Preload("User").
+		Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).
+		First(&userIdentity).Error
+	if err == nil {
+		if userIdentity.User.Disabled {
+			return nil, ErrUserDisabled
+		}
+		if userIdentity.User.ServiceAccount {
+			return nil, ErrServiceAccount
+		}
+		h.syncIdentityGroups(&userIdentity.User, identity)
+
+		return &userIdentity.User, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	user, err := h.GetUser(identity.UserName)
+	if errors.Is(err, ErrUserNotFound) {
+		user, err = h.CreateUser(identity.UserName, identity.UID, identity.DisplayName)
+		if err == nil && invite != nil {
+			err = h.acceptInvite(invite, user)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	if user.ServiceAccount {
+		return nil, ErrServiceAccount
+	}
+
+	var links int64
+	if err := h.db.Model(&UserIdentity{}).
+		Where("user_id = ?", user.ID).
+		Count(&links).Error; err != nil {
+		return nil, err
+	}
+	if links > 0 {
+		log.Warn().
+			Str("user", user.Name).
+			Str("provider", identity.Provider).
+			Str("subject", identity.Subject).
+			Msg("Refusing to link an identity to a user linked to another one")
+
+		return nil, errIdentityUserLinked
+	}
+
+	if err := h.db.Create(&UserIdentity{
+		UserID:   user.ID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Str("provider", identity.Provider).
+		Str("subject", identity.Subject).
+		Msg("Identity linked to user")
+	h.syncIdentityGroups(user, identity)
+
+	return user, nil
+}
+
+// syncIdentityGroups applies the groups of the identity, with the ones of
+// the IDaaS directory for the aliyun providers, to the role of the user
+// and to the group:oidc: ACL aliases.
+func (h *Headscale) syncIdentityGroups(user *User, identity *Identity) {
+	groups := append([]string{}, identity.Groups...)
+	for _, provider := range h.identityProviders {
+		if provider.cfg.Name != identity.Provider ||
+			provider.cfg.Type != IdentityProviderTypeAliyun ||
+			h.userDirectory == nil {
+			continue
+		}
+		directoryGroups, err := h.userDirectory.UserGroups(context.Background(), identity.Subject)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("user", user.Name).
+				Msg("Could not get the groups of the user from the directory")
+
+			break
+		}
+		for _, group := range directoryGroups {
+			if !IsStringInSlice(groups, group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	h.syncUserRole(user, groups)
+	// The groups provisioned by SCIM are kept until the identity provider
+	// pushes them again
+	if !h.cfg.SCIM.Enabled {
+		h.syncUserGroups(user, groups)
+	}
+}
+
+// syncUserGroups stores the groups of the user, the rules are generated
+// again when they changed.
+func (h *Headscale) syncUserGroups(user *User, groups []string) {
+	sort.Strings(groups)
+	if reflect.DeepEqual([]string(user.Groups), groups) ||
+		(len(user.Groups) == 0 && len(groups) == 0) {
+		return
+	}
+
+	user.Groups = groups
+	if err := h.db.Model(user).Update("groups", user.Groups).Error; err != nil {
+		log.Error().
+			Err(err).
+			Str("user", user.Name).
+			Msg("Could not save the groups of the user")
+
+		return
+	}
+	log.Info().
+		Str("user", user.Name).
+		Strs("groups", groups).
+		Msg("User groups changed")
+
+	if err := h.UpdateACLRules(); err != nil && !errors.Is(err, errEmptyPolicy) {
+		log.Error().Err(err).Msg("Could not update the ACL rules")
+	}
+	h.setLastStateChangeToNow()
+}
+
+// resolveIdentityUser replaces the user name mapped from the claims by the
+// name of the linked User, if any.
+func (h *Headscale) resolveIdentityUser(identity *Identity) {
+	userIdentity := UserIdentity{}
+	if err := h.db.Preload("User").
+		Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).
+		First(&userIdentity).Error; err != nil {
+		return
+	}
+
+	identity.UserName = userIdentity.User.Name
+}
+
+// ListIdentityProvidersAPI lists the providers the login page offers.
+func (h *Headscale) ListIdentityProvidersAPI(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	items := make([]IdentityProviderItem, 0, len(h.identityProviders))
+	for _, provider := range h.identityProviders {
+		items = append(items, IdentityProviderItem{
+			Name:        provider.cfg.Name,
+			DisplayName: provider.cfg.DisplayName,
+			Type:        provider.cfg.Type,
+		})
+	}
+	if h.cfg.LocalAccounts.Enabled {
+		items = append(items, IdentityProviderItem{
+			Name:        localAccountProvider,
+			DisplayName: "本地账号",
+			Type:        localAccountProvider,
+		})
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(writer).Encode(&items); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
@@ -0,0 +1,315 @@
+package headscale
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func TestIdentityProviderConfig_mapClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     IdentityProviderConfig
+		claims  map[string]interface{}
+		want    *Identity
+		wantErr bool
+	}{
+		{
+			name: "aliyun uses the phone number",
+			cfg:  IdentityProviderConfig{Name: "ali", Type: IdentityProviderTypeAliyun},
+			claims: map[string]interface{}{
+				"phone_number":       "+86 138 0000 0000",
+				"preferred_username": "zhangsan",
+				"name":               "张三",
+			},
+			want: &Identity{
+				Provider:    "ali",
+				Subject:     "sub",
+				UserName:    "13800000000",
+				UID:         "zhangsan",
+				DisplayName: "张三",
+			},
+		},
+		{
+			name: "oidc normalizes the email",
+			cfg: IdentityProviderConfig{
+				Name:             "corp",
+				Type:             IdentityProviderTypeOIDC,
+				StripEmaildomain: true,
+			},
+			claims: map[string]interface{}{
+				"email":  "Alice.Smith@example.com",
+				"groups": []interface{}{"/headscale", "/dev"},
+			},
+			want: &Identity{
+				Provider:    "corp",
+				Subject:     "sub",
+				UserName:    "alice.smith",
+				UID:         "corp:sub",
+				DisplayName: "alice.smith",
+				Email:       "Alice.Smith@example.com",
+				Groups:      []string{"/headscale", "/dev"},
+			},
+		},
+		{
+			name: "custom claim mapping",
+			cfg: IdentityProviderConfig{
+				Name: "corp",
+				Type: IdentityProviderTypeOIDC,
+				Claims: ClaimMapping{
+					Username:    "preferred_username",
+					DisplayName: "nickname",
+					Groups:      "roles",
+				},
+			},
+			claims: map[string]interface{}{
+				"preferred_username": "bob",
+				"nickname":           "Bobby",
+				"roles":              "admin",
+			},
+			want: &Identity{
+				Provider:    "corp",
+				Subject:     "sub",
+				UserName:    "bob",
+				UID:         "corp:sub",
+				DisplayName: "Bobby",
+				Groups:      []string{"admin"},
+			},
+		},
+		{
+			name:    "missing username claim",
+			cfg:     IdentityProviderConfig{Name: "corp", Type: IdentityProviderTypeOIDC},
+			claims:  map[string]interface{}{"name": "Alice"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.cfg.mapClaims("sub", test.claims)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("mapClaims() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mapClaims() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIdentityProviderConfig_authorize(t *testing.T) {
+	identity := &Identity{
+		UserName: "alice",
+		Email:    "alice@example.com",
+		Groups:   []string{"/headscale"},
+	}
+
+	tests := []struct {
+		name    string
+		cfg     IdentityProviderConfig
+		wantErr error
+	}{
+		{
+			name: "no restriction",
+			cfg:  IdentityProviderConfig{},
+		},
+		{
+			name: "allowed",
+			cfg: IdentityProviderConfig{
+				AllowedDomains: []string{"example.com"},
+				AllowedGroups:  []string{"/admins", "/headscale"},
+				AllowedUsers:   []string{"alice@example.com"},
+			},
+		},
+		{
+			name:    "domain mismatch",
+			cfg:     IdentityProviderConfig{AllowedDomains: []string{"example.org"}},
+			wantErr: errOIDCAllowedDomains,
+		},
+		{
+			name:    "group mismatch",
+			cfg:     IdentityProviderConfig{AllowedGroups: []string{"/admins"}},
+			wantErr: errOIDCAllowedGroups,
+		},
+		{
+			name:    "user mismatch",
+			cfg:     IdentityProviderConfig{AllowedUsers: []string{"bob@example.com"}},
+			wantErr: errOIDCAllowedUsers,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.cfg.authorize(identity); !errors.Is(err, test.wantErr) {
+				t.Errorf("authorize() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateIdentityProviders(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []IdentityProviderConfig
+		wantErr   bool
+	}{
+		{
+			name: "valid",
+			providers: []IdentityProviderConfig{
+				{Name: "ali", Type: IdentityProviderTypeAliyun, Issuer: "https://ali.example.com"},
+				{Name: "corp", Type: IdentityProviderTypeOIDC, Issuer: "https://sso.example.com"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "duplicate name",
+			providers: []IdentityProviderConfig{
+				{Name: "corp", Type: IdentityProviderTypeOIDC, Issuer: "https://a.example.com"},
+				{Name: "corp", Type: IdentityProviderTypeOIDC, Issuer: "https://b.example.com"},
+			},
+			wantErr: true,
+		},
+		{
+			name:      "unknown type",
+			providers: []IdentityProviderConfig{{Name: "corp", Type: "saml", Issuer: "https://sso.example.com"}},
+			wantErr:   true,
+		},
+		{
+			name:      "missing issuer",
+			providers: []IdentityProviderConfig{{Name: "corp", Type: IdentityProviderTypeOIDC}},
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateIdentityProviders(test.providers); (err != nil) != test.wantErr {
+				t.Errorf("validateIdentityProviders() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func (s *Suite) TestFindOrCreateUserForIdentity(c *check.C) {
+	identity := &Identity{
+		Provider:    "corp",
+		Subject:     "subject-1",
+		UserName:    "alice",
+		UID:         "corp:subject-1",
+		DisplayName: "Alice",
+	}
+
+	user, err := app.findOrCreateUserForIdentity(identity)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.Name, check.Equals, "alice")
+
+	// The link survives a change of the username claim
+	renamed := *identity
+	renamed.UserName = "alice-smith"
+	linked, err := app.findOrCreateUserForIdentity(&renamed)
+	c.Assert(err, check.IsNil)
+	c.Assert(linked.ID, check.Equals, user.ID)
+
+	app.resolveIdentityUser(&renamed)
+	c.Assert(renamed.UserName, check.Equals, "alice")
+
+	// The same subject at another provider is another identity
+	legacy, err := app.CreateUser("bob", "bob-uid", "Bob")
+	c.Assert(err, check.IsNil)
+
+	other, err := app.findOrCreateUserForIdentity(&Identity{
+		Provider: "ali",
+		Subject:  "subject-1",
+		UserName: "bob",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(other.ID, check.Equals, legacy.ID)
+
+	// A linked user is not taken over by another identity of the same name
+	_, err = app.findOrCreateUserForIdentity(&Identity{
+		Provider: "evil",
+		Subject:  "subject-2",
+		UserName: "alice",
+	})
+	c.Assert(err, check.Equals, errIdentityUserLinked)
+	_, err = app.findOrCreateUserForIdentity(&Identity{
+		Provider: "ali",
+		Subject:  "subject-3",
+		UserName: "bob",
+	})
+	c.Assert(err, check.Equals, errIdentityUserLinked)
+
+	var links int64
+	app.db.Model(&UserIdentity{}).Count(&links)
+	c.Assert(links, check.Equals, int64(2))
+
+	err = app.DestroyUser("bob")
+	c.Assert(err, check.IsNil)
+
+	app.db.Model(&UserIdentity{}).Count(&links)
+	c.Assert(links, check.Equals, int64(1))
+}
+
+func (s *Suite) TestSyncIdentityGroups(c *check.C) {
+	directory := newFakeUserDirectory()
+	directory.groups["ali-subject"] = []string{"研发部", "eng"}
+	app.userDirectory = directory
+	app.identityProviders = []*identityProvider{
+		{cfg: IdentityProviderConfig{Name: "ali", Type: IdentityProviderTypeAliyun}},
+	}
+	defer func() {
+		app.userDirectory = nil
+		app.identityProviders = nil
+	}()
+
+	identity := &Identity{
+		Provider:    "ali",
+		Subject:     "ali-subject",
+		UserName:    "alice",
+		DisplayName: "Alice",
+		Groups:      []string{"eng", "vpn-users"},
+	}
+	user, err := app.findOrCreateUserForIdentity(identity)
+	c.Assert(err, check.IsNil)
+	c.Assert([]string(user.Groups), check.DeepEquals, []string{"eng", "vpn-users", "研发部"})
+
+	machine := Machine{
+		MachineKey:     "foo",
+		NodeKey:        "bar",
+		DiscoKey:       "faa",
+		Hostname:       "alicemachine",
+		IPAddresses:    MachineAddresses{netip.MustParseAddr("100.64.0.1")},
+		UserID:         user.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+	}
+	app.db.Save(&machine)
+
+	app.aclPolicy = &ACLPolicy{
+		ACLs: []ACL{
+			{
+				Action:       "accept",
+				Sources:      []string{"group:oidc:vpn-users"},
+				Destinations: []string{"group:oidc:研发部:22"},
+			},
+		},
+	}
+	err = app.UpdateACLRules()
+	c.Assert(err, check.IsNil)
+	rules := app.aclFor(app.defaultOrganizationID).rules
+	c.Assert(rules, check.HasLen, 1)
+	c.Assert(rules[0].SrcIPs, check.DeepEquals, []string{"100.64.0.1"})
+	c.Assert(rules[0].DstPorts, check.HasLen, 1)
+	c.Assert(rules[0].DstPorts[0].Ports.First, check.Equals, uint16(22))
+
+	// Leaving a group at the directory takes the alias back at the next login
+	app.identityProviders = nil
+	identity.Groups = []string{"eng"}
+	_, err = app.findOrCreateUserForIdentity(identity)
+	c.Assert(err, check.IsNil)
+	rules = app.aclFor(app.defaultOrganizationID).rules
+	c.Assert(rules, check.HasLen, 1)
+	c.Assert(rules[0].SrcIPs, check.HasLen, 0)
+}
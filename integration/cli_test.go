@@ -182,9 +182,9 @@ func TestPreAuthKeyCommand(t *testing.T) {
 		[]string{listedPreAuthKeys[1].Id, listedPreAuthKeys[2].Id, listedPreAuthKeys[3].Id},
 	)
 
-	assert.NotEmpty(t, listedPreAuthKeys[1].Key)
-	assert.NotEmpty(t, listedPreAuthKeys[2].Key)
-	assert.NotEmpty(t, listedPreAuthKeys[3].Key)
+	assert.NotEmpty(t, listedPreAuthKeys[1].Prefix)
+	assert.NotEmpty(t, listedPreAuthKeys[2].Prefix)
+	assert.NotEmpty(t, listedPreAuthKeys[3].Prefix)
 
 	assert.True(t, listedPreAuthKeys[1].Expiration.AsTime().After(time.Now()))
 	assert.True(t, listedPreAuthKeys[2].Expiration.AsTime().After(time.Now()))
@@ -219,7 +219,7 @@ func TestPreAuthKeyCommand(t *testing.T) {
 			"--user",
 			user,
 			"expire",
-			listedPreAuthKeys[1].Key,
+			listedPreAuthKeys[1].Prefix,
 		},
 	)
 	assert.NoError(t, err)
@@ -311,11 +311,11 @@ func (s *IntegrationCLITestSuite) TestPreAuthKeyCommand() {
 	assert.Equal(s.T(), "4", listedPreAuthKeys[3].Id)
 	assert.Equal(s.T(), "5", listedPreAuthKeys[4].Id)
 
-	assert.NotEmpty(s.T(), listedPreAuthKeys[0].Key)
-	assert.NotEmpty(s.T(), listedPreAuthKeys[1].Key)
-	assert.NotEmpty(s.T(), listedPreAuthKeys[2].Key)
-	assert.NotEmpty(s.T(), listedPreAuthKeys[3].Key)
-	assert.NotEmpty(s.T(), listedPreAuthKeys[4].Key)
+	assert.NotEmpty(s.T(), listedPreAuthKeys[0].Prefix)
+	assert.NotEmpty(s.T(), listedPreAuthKeys[1].Prefix)
+	assert.NotEmpty(s.T(), listedPreAuthKeys[2].Prefix)
+	assert.NotEmpty(s.T(), listedPreAuthKeys[3].Prefix)
+	assert.NotEmpty(s.T(), listedPreAuthKeys[4].Prefix)
 
 	assert.True(s.T(), listedPreAuthKeys[0].Expiration.AsTime().After(time.Now()))
 	assert.True(s.T(), listedPreAuthKeys[1].Expiration.AsTime().After(time.Now()))
@@ -359,7 +359,7 @@ func (s *IntegrationCLITestSuite) TestPreAuthKeyCommand() {
 				"--user",
 				user.Name,
 				"expire",
-				listedPreAuthKeys[i].Key,
+				listedPreAuthKeys[i].Prefix,
 			},
 			[]string{},
 		)
@@ -0,0 +1,370 @@
+package headscale
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	ErrInviteNotFound = Error("Invite not found")
+	ErrInviteExpired  = Error("Invite expired")
+	ErrInviteUsed     = Error("Invite has already been used")
+
+	inviteCookie            = "Mirage_Invite"
+	inviteCookieExpiration  = 30 * time.Minute
+	inviteJoinKeyExpiration = 24 * time.Hour
+	inviteJoinPath          = "/invite/join"
+)
+
+// Invite is a single-use link creating a User at their first login, in the
+// organization of the invite and with its role. The User gets a preauth key
+// carrying the tags of the invite to join their first machine.
+type Invite struct {
+	ID             uint64 `gorm:"primary_key"`
+	Code           string `gorm:"unique"`
+	OrganizationID uint
+	CreatedBy      string
+	Role           Role
+	ACLTags        StringList
+	Revoked        bool `gorm:"default:false"`
+	UsedBy         string
+	UsedAt         *time.Time
+	PreAuthKeyID   uint64
+
+	CreatedAt  *time.Time
+	Expiration *time.Time
+}
+
+// status describes the invite as shown in the console.
+func (invite *Invite) status() string {
+	switch {
+	case invite.UsedAt != nil:
+		return "used"
+	case invite.Revoked:
+		return "revoked"
+	case invite.Expiration != nil && invite.Expiration.Before(time.Now()):
+		return "expired"
+	default:
+		return "pending"
+	}
+}
+
+// link is the URL the invited user opens.
+func (invite *Invite) link(serverURL string) string {
+	return fmt.Sprintf("%s/invite/%s", strings.TrimSuffix(serverURL, "/"), invite.Code)
+}
+
+// CreateInvite creates an Invite to organizationID on behalf of creator,
+// whose role must allow granting the role of the invite.
+func (h *Headscale) CreateInvite(
+	creator *User,
+	actor Role,
+	organizationID uint,
+	role Role,
+	aclTags []string,
+	expiration time.Time,
+) (*Invite, error) {
+	if role != "" && !role.valid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	if role == RoleOwner && actor != RoleOwner {
+		return nil, ErrOwnerRoleRequired
+	}
+	if role != "" && role != RoleMember && !actor.Can(PermissionManageRoles) {
+		return nil, ErrPermissionDenied
+	}
+	for _, tag := range aclTags {
+		if !strings.HasPrefix(tag, "tag:") {
+			return nil, fmt.Errorf("%w: '%s' did not begin with 'tag:'", ErrPreAuthKeyACLTagInvalid, tag)
+		}
+	}
+
+	code, err := h.generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	invite := Invite{
+		Code:           code,
+		OrganizationID: organizationID,
+		CreatedBy:      creator.Name,
+		Role:           role,
+		ACLTags:        aclTags,
+		CreatedAt:      &now,
+		Expiration:     &expiration,
+	}
+	if err := h.db.Create(&invite).Error; err != nil {
+		return nil, fmt.Errorf("failed to create invite in the database: %w", err)
+	}
+
+	log.Info().
+		Str("created_by", creator.Name).
+		Uint64("invite", invite.ID).
+		Str("role", string(role)).
+		Msg("Invite created")
+
+	return &invite, nil
+}
+
+// ListInvites returns the invites to an organization, the newest first.
+func (h *Headscale) ListInvites(organizationID uint) ([]Invite, error) {
+	invites := []Invite{}
+	if err := h.db.Where("organization_id = ?", organizationID).
+		Order("id desc").
+		Find(&invites).Error; err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// RevokeInvite prevents an unused invite to organizationID from being used.
+func (h *Headscale) RevokeInvite(organizationID uint, id uint64) error {
+	invite := Invite{}
+	if err := h.db.Where("id = ? AND organization_id = ?", id, organizationID).
+		First(&invite).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInviteNotFound
+	} else if err != nil {
+		return err
+	}
+	if invite.UsedAt != nil {
+		return ErrInviteUsed
+	}
+
+	if err := h.db.Model(&invite).Update("revoked", true).Error; err != nil {
+		return err
+	}
+
+	log.Info().Uint64("invite", id).Msg("Invite revoked")
+
+	return nil
+}
+
+// checkInvite returns the Invite of a code if it can still be used.
+func (h *Headscale) checkInvite(code string) (*Invite, error) {
+	invite := Invite{}
+	if err := h.db.Where("code = ?", code).First(&invite).Error; errors.Is(
+		err,
+		gorm.ErrRecordNotFound,
+	) {
+		return nil, ErrInviteNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch invite.status() {
+	case "used":
+		return nil, ErrInviteUsed
+	case "revoked":
+		return nil, ErrInviteNotFound
+	case "expired":
+		return nil, ErrInviteExpired
+	}
+
+	return &invite, nil
+}
+
+// acceptInvite moves a User created with an invite to its organization,
+// grants its role and creates the preauth key of their first machine.
+// The invite is claimed first so that it can only be used once.
+func (h *Headscale) acceptInvite(invite *Invite, user *User) error {
+	now := time.Now().UTC()
+	claim := h.db.Model(&Invite{}).
+		Where("id = ? AND used_at IS NULL AND revoked = ?", invite.ID, false).
+		Updates(map[string]interface{}{"used_by": user.Name, "used_at": &now})
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return ErrInviteUsed
+	}
+	invite.UsedBy = user.Name
+	invite.UsedAt = &now
+
+	if _, err := h.getOrganizationByID(invite.OrganizationID); err == nil &&
+		invite.OrganizationID != user.OrganizationID {
+		user.OrganizationID = invite.OrganizationID
+		if err := h.db.Model(user).Update("organization_id", user.OrganizationID).Error; err != nil {
+			return err
+		}
+	}
+
+	if invite.Role != "" && invite.Role != h.userRole(user) {
+		if err := h.setUserRole(RoleOwner, user, invite.Role, false); err != nil {
+			return err
+		}
+	}
+
+	expiration := now.Add(inviteJoinKeyExpiration)
+	key, err := h.CreatePreAuthKey(user.Name, false, false, &expiration, invite.ACLTags)
+	if err != nil {
+		return err
+	}
+	invite.PreAuthKeyID = key.ID
+	if err := h.db.Model(invite).Update("pre_auth_key_id", key.ID).Error; err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Uint64("invite", invite.ID).
+		Str("created_by", invite.CreatedBy).
+		Msg("Invite accepted")
+
+	return nil
+}
+
+// inviteFromCookie returns the invite the browser opened before logging
+// in, if it can still be used.
+func (h *Headscale) inviteFromCookie(r *http.Request) *Invite {
+	cookie, err := r.Cookie(inviteCookie)
+	if err != nil {
+		return nil
+	}
+	invite, err := h.checkInvite(cookie.Value)
+	if err != nil {
+		log.Info().Err(err).Msg("Ignoring the invite of the login")
+
+		return nil
+	}
+
+	return invite
+}
+
+func (h *Headscale) setInviteCookie(
+	w http.ResponseWriter,
+	r *http.Request,
+	code string,
+	maxAge time.Duration,
+) {
+	_, path := h.cookieScope()
+	cookie := &http.Cookie{
+		Name:     inviteCookie,
+		Value:    code,
+		Secure:   h.secureCookies(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     path,
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	if maxAge <= 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+//go:embed templates/invite.html
+var inviteTemplateContent string
+
+var inviteTemplate = template.Must(template.New("invite").Parse(inviteTemplateContent))
+
+type inviteTemplateConfig struct {
+	Error        string
+	URL          string
+	Organization string
+	Key          string
+	KeyExpiry    string
+}
+
+func renderInvite(w http.ResponseWriter, status int, config inviteTemplateConfig) {
+	var payload bytes.Buffer
+	if err := inviteTemplate.Execute(&payload, config); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Could not render invite template")
+		http.Error(w, "Could not render invite template", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(payload.Bytes()); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+// InviteHandler opens /invite/{code}: the invite is kept in a cookie until
+// the login creating the user.
+func (h *Headscale) InviteHandler(w http.ResponseWriter, r *http.Request) {
+	invite, err := h.checkInvite(mux.Vars(r)["code"])
+	switch {
+	case errors.Is(err, ErrInviteNotFound):
+		renderInvite(w, http.StatusNotFound, inviteTemplateConfig{Error: "邀请链接无效或已被撤销"})
+
+		return
+	case errors.Is(err, ErrInviteUsed):
+		renderInvite(w, http.StatusGone, inviteTemplateConfig{Error: "邀请链接已被使用"})
+
+		return
+	case errors.Is(err, ErrInviteExpired):
+		renderInvite(w, http.StatusGone, inviteTemplateConfig{Error: "邀请链接已过期，请联系管理员重新邀请"})
+
+		return
+	case err != nil:
+		renderInvite(w, http.StatusInternalServerError, inviteTemplateConfig{Error: "查询邀请失败"})
+
+		return
+	}
+
+	h.setInviteCookie(w, r, invite.Code, inviteCookieExpiration)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// InviteJoinHandler shows the instructions to join the first machine to
+// the user who just accepted an invite.
+func (h *Headscale) InviteJoinHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := h.getConsoleSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+
+		return
+	}
+
+	invite := Invite{}
+	if err := h.db.Where("used_by = ?", session.User.Name).
+		Order("used_at desc").
+		First(&invite).Error; err != nil {
+		http.Redirect(w, r, "/admin", http.StatusFound)
+
+		return
+	}
+
+	config := inviteTemplateConfig{URL: h.cfg.ServerURL}
+	if organization, err := h.getOrganizationByID(invite.OrganizationID); err == nil {
+		config.Organization = organization.DisplayName
+	}
+	// Only the hash of the join key is stored, it is issued again on each
+	// visit while unused
+	key := PreAuthKey{}
+	if err := h.db.First(&key, invite.PreAuthKeyID).Error; err == nil &&
+		!key.Used && key.RevokedAt == nil &&
+		key.Expiration != nil && key.Expiration.After(time.Now()) {
+		if err := h.RegeneratePreAuthKey(&key); err != nil {
+			log.Error().
+				Caller().
+				Err(err).
+				Msg("Failed to issue the join key of an invite")
+		} else {
+			config.Key = key.Key
+			config.KeyExpiry = Time2SHString(*key.Expiration)
+		}
+	}
+
+	renderInvite(w, http.StatusOK, config)
+}
@@ -0,0 +1,113 @@
+package headscale
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/check.v1"
+)
+
+func (s *Suite) TestInvite(c *check.C) {
+	acme, err := app.CreateOrganization("acme", "", nil)
+	c.Assert(err, check.IsNil)
+	admin, err := app.CreateUser("admin", "admin-uid", "Admin")
+	c.Assert(err, check.IsNil)
+
+	expiration := time.Now().Add(time.Hour)
+	_, err = app.CreateInvite(admin, RoleITAdmin, acme.ID, RoleAuditor, nil, expiration)
+	c.Assert(err, check.Equals, ErrPermissionDenied)
+	_, err = app.CreateInvite(admin, RoleAdmin, acme.ID, RoleOwner, nil, expiration)
+	c.Assert(err, check.Equals, ErrOwnerRoleRequired)
+
+	invite, err := app.CreateInvite(
+		admin, RoleAdmin, acme.ID, RoleAuditor, []string{"tag:laptop"}, expiration,
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(invite.status(), check.Equals, "pending")
+
+	// An existing user logging in leaves the invite unused
+	existing, err := app.findOrCreateUserWithInvite(&Identity{
+		Provider: "default",
+		Subject:  "admin-subject",
+		UserName: "admin",
+	}, invite)
+	c.Assert(err, check.IsNil)
+	c.Assert(existing.OrganizationID, check.Equals, app.defaultOrganizationID)
+	_, err = app.checkInvite(invite.Code)
+	c.Assert(err, check.IsNil)
+
+	user, err := app.findOrCreateUserWithInvite(&Identity{
+		Provider:    "default",
+		Subject:     "alice-subject",
+		UserName:    "alice",
+		UID:         "alice-uid",
+		DisplayName: "Alice",
+	}, invite)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.OrganizationID, check.Equals, acme.ID)
+	c.Assert(app.userRole(user), check.Equals, RoleAuditor)
+
+	keys, err := app.ListPreAuthKeys("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	c.Assert(keys[0].ID, check.Equals, invite.PreAuthKeyID)
+	c.Assert(keys[0].Reusable, check.Equals, false)
+	c.Assert(keys[0].ACLTags, check.HasLen, 1)
+	c.Assert(keys[0].ACLTags[0].Tag, check.Equals, "tag:laptop")
+
+	_, err = app.checkInvite(invite.Code)
+	c.Assert(err, check.Equals, ErrInviteUsed)
+	c.Assert(app.acceptInvite(invite, existing), check.Equals, ErrInviteUsed)
+	c.Assert(app.RevokeInvite(acme.ID, invite.ID), check.Equals, ErrInviteUsed)
+
+	expired, err := app.CreateInvite(admin, RoleAdmin, acme.ID, "", nil, time.Now().Add(-time.Minute))
+	c.Assert(err, check.IsNil)
+	_, err = app.checkInvite(expired.Code)
+	c.Assert(err, check.Equals, ErrInviteExpired)
+
+	revoked, err := app.CreateInvite(admin, RoleAdmin, acme.ID, "", nil, expiration)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.RevokeInvite(app.defaultOrganizationID, revoked.ID), check.Equals, ErrInviteNotFound)
+	c.Assert(app.RevokeInvite(acme.ID, revoked.ID), check.IsNil)
+	_, err = app.checkInvite(revoked.Code)
+	c.Assert(err, check.Equals, ErrInviteNotFound)
+
+	invites, err := app.ListInvites(acme.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(invites, check.HasLen, 3)
+	c.Assert(invites[0].status(), check.Equals, "revoked")
+	c.Assert(invites[1].status(), check.Equals, "expired")
+	c.Assert(invites[2].status(), check.Equals, "used")
+}
+
+func (s *Suite) TestInviteHandler(c *check.C) {
+	admin, err := app.CreateUser("admin", "admin-uid", "Admin")
+	c.Assert(err, check.IsNil)
+	invite, err := app.CreateInvite(
+		admin, RoleOwner, app.defaultOrganizationID, "", nil, time.Now().Add(time.Hour),
+	)
+	c.Assert(err, check.IsNil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/invite/{code}", app.InviteHandler).Methods(http.MethodGet)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/invite/"+invite.Code, nil))
+	c.Assert(recorder.Code, check.Equals, http.StatusFound)
+	c.Assert(recorder.Header().Get("Location"), check.Equals, "/login")
+	cookies := recorder.Result().Cookies()
+	c.Assert(cookies, check.HasLen, 1)
+	c.Assert(cookies[0].Name, check.Equals, inviteCookie)
+	c.Assert(cookies[0].Value, check.Equals, invite.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/login/callback", nil)
+	req.AddCookie(cookies[0])
+	c.Assert(app.inviteFromCookie(req), check.NotNil)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/invite/unknown", nil))
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Result().Cookies(), check.HasLen, 0)
+}
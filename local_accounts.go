@@ -0,0 +1,293 @@
+package headscale
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ErrInvalidCredentials = Error("Invalid user name or password")
+	ErrPasswordTooShort   = Error("Password is too short")
+	ErrTOTPRequired       = Error("TOTP code required")
+	ErrInvalidTOTPCode    = Error("Invalid TOTP code")
+	ErrTOTPNotEnrolled    = Error("TOTP is not enrolled")
+	ErrTOTPAlreadyEnabled = Error("TOTP is already enabled")
+
+	// localAccountProvider is the provider of the console sessions
+	// opened with a local account.
+	localAccountProvider = "local"
+
+	minPasswordLength  = 8
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// SetUserPassword sets the password of the local account of a User.
+func (h *Headscale) SetUserPassword(name string, password string) error {
+	if len(password) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+
+	user, err := h.GetUser(name)
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Model(user).Update("password_hash", hash).Error; err != nil {
+		return fmt.Errorf("failed to save password in the database: %w", err)
+	}
+
+	return nil
+}
+
+// ResetUserTOTP removes the second factor and the recovery codes
+// of a User.
+func (h *Headscale) ResetUserTOTP(name string) error {
+	user, err := h.GetUser(name)
+	if err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.RecoveryCodeHashes = StringList{}
+	if err := h.db.Save(user).Error; err != nil {
+		return fmt.Errorf("failed to reset TOTP in the database: %w", err)
+	}
+
+	return nil
+}
+
+// EnrollUserTOTP generates a TOTP secret for a User. The second factor
+// is only required once a code has been confirmed with ConfirmUserTOTP.
+func (h *Headscale) EnrollUserTOTP(name string) (string, string, error) {
+	user, err := h.GetUser(name)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := h.db.Model(user).Update("totp_secret", secret).Error; err != nil {
+		return "", "", fmt.Errorf("failed to save TOTP secret in the database: %w", err)
+	}
+
+	return secret, totpURI(h.cfg.LocalAccounts.TOTPIssuer, user.Name, secret), nil
+}
+
+// ConfirmUserTOTP enables the second factor of a User and returns
+// its recovery codes, they are only visible _once_.
+func (h *Headscale) ConfirmUserTOTP(name string, code string) ([]string, error) {
+	user, err := h.GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if !validateTOTP(user.TOTPSecret, code, time.Now()) {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make(StringList, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		randomBytes := make([]byte, recoveryCodeLength/2)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(randomBytes)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, string(hash))
+	}
+
+	user.TOTPEnabled = true
+	user.RecoveryCodeHashes = hashes
+	if err := h.db.Save(user).Error; err != nil {
+		return nil, fmt.Errorf("failed to enable TOTP in the database: %w", err)
+	}
+
+	return codes, nil
+}
+
+// AuthenticateLocalAccount checks the password of a User and, if it has
+// enabled TOTP, the code of its authenticator app or a recovery code.
+func (h *Headscale) AuthenticateLocalAccount(
+	name string,
+	password string,
+	code string,
+) (*User, error) {
+	user, err := h.GetUser(name)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(user.PasswordHash) == 0 ||
+		bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	if user.ServiceAccount {
+		return nil, ErrServiceAccount
+	}
+
+	if !user.TOTPEnabled {
+		return user, nil
+	}
+	if code == "" {
+		return nil, ErrTOTPRequired
+	}
+	if validateTOTP(user.TOTPSecret, code, time.Now()) {
+		return user, nil
+	}
+
+	used, err := h.useRecoveryCode(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	return user, nil
+}
+
+// useRecoveryCode consumes a recovery code of a User.
+func (h *Headscale) useRecoveryCode(user *User, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	for index, hash := range user.RecoveryCodeHashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+			continue
+		}
+
+		remaining := make(StringList, 0, len(user.RecoveryCodeHashes)-1)
+		remaining = append(remaining, user.RecoveryCodeHashes[:index]...)
+		remaining = append(remaining, user.RecoveryCodeHashes[index+1:]...)
+		user.RecoveryCodeHashes = remaining
+		if err := h.db.Save(user).Error; err != nil {
+			return false, fmt.Errorf("failed to save recovery codes in the database: %w", err)
+		}
+
+		log.Info().
+			Str("user", user.Name).
+			Int("remaining", len(remaining)).
+			Msg("Recovery code used")
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+type localLoginReq struct {
+	UserName string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+	NextURL  string `json:"next_url"`
+}
+
+type localLoginRes struct {
+	NeedTOTP bool   `json:"needTOTP"`
+	NextURL  string `json:"nextURL"`
+}
+
+// 本地账号登录，成功后创建与OIDC登录相同的控制台会话
+func (h *Headscale) LocalLoginAPI(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if !h.cfg.LocalAccounts.Enabled {
+		h.doAPIResponse(w, "未启用本地账号登录", nil)
+		return
+	}
+
+	reqData := localLoginReq{}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.doAPIResponse(w, "用户请求解析失败", nil)
+		return
+	}
+	if ok, _ := h.rateLimits.allowIdentity("login", reqData.UserName); !ok {
+		h.doAPIResponse(w, "登录尝试过于频繁，请稍后再试", nil)
+		return
+	}
+	// 密码或动态验证码错误次数过多的账号暂时锁定
+	if locked, retryAfter := h.rateLimits.lockedOut("login", reqData.UserName); locked {
+		h.doAPIResponse(w, "登录失败次数过多，请"+strconv.Itoa(int(retryAfter.Minutes())+1)+"分钟后再试", nil)
+		return
+	}
+
+	user, err := h.AuthenticateLocalAccount(reqData.UserName, reqData.Password, reqData.Code)
+	if errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrInvalidTOTPCode) {
+		h.rateLimits.failure("login", reqData.UserName)
+	}
+	switch {
+	case errors.Is(err, ErrTOTPRequired):
+		h.doAPIResponse(w, "", localLoginRes{NeedTOTP: true})
+		return
+	case errors.Is(err, ErrInvalidCredentials):
+		h.doAPIResponse(w, "用户名或密码错误", nil)
+		return
+	case errors.Is(err, ErrInvalidTOTPCode):
+		h.doAPIResponse(w, "动态验证码错误", nil)
+		return
+	case errors.Is(err, ErrUserDisabled):
+		h.doAPIResponse(w, "该账号已被禁用", nil)
+		return
+	case errors.Is(err, ErrServiceAccount):
+		h.doAPIResponse(w, "服务账号不能登录控制台", nil)
+		return
+	case err != nil:
+		log.Error().
+			Caller().
+			Err(err).
+			Str("user", reqData.UserName).
+			Msg("could not authenticate local account")
+		h.doAPIResponse(w, "服务器登录处理出错", nil)
+		return
+	}
+
+	h.rateLimits.success("login", reqData.UserName)
+
+	if err := h.startConsoleSession(w, r, user, &ConsoleSession{Provider: localAccountProvider}); err != nil {
+		h.doAPIResponse(w, "创建登录会话失败", nil)
+		return
+	}
+
+	log.Info().Str("user", user.Name).Msg("Local account logged in")
+	h.doAPIResponse(w, "", localLoginRes{NextURL: "/admin#" + reqData.NextURL})
+}
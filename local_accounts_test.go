@@ -0,0 +1,166 @@
+package headscale
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func Test_totpCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		if got := totpCode(key, uint64(test.unix/30)); got != test.want {
+			t.Errorf("totpCode(%d) = %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func Test_validateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"current period", "005924", true},
+		{"previous period", totpCode([]byte("12345678901234567890"), 1234567890/30-1), true},
+		{"two periods ago", totpCode([]byte("12345678901234567890"), 1234567890/30-2), false},
+		{"wrong code", "123456", false},
+		{"short code", "5924", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := validateTOTP(secret, test.code, now); got != test.want {
+				t.Errorf("validateTOTP() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func (s *Suite) TestLocalAccount(c *check.C) {
+	_, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+
+	err = app.SetUserPassword("alice", "short")
+	c.Assert(err, check.Equals, ErrPasswordTooShort)
+
+	_, err = app.AuthenticateLocalAccount("alice", "", "")
+	c.Assert(err, check.Equals, ErrInvalidCredentials)
+
+	err = app.SetUserPassword("alice", "correct horse")
+	c.Assert(err, check.IsNil)
+
+	_, err = app.AuthenticateLocalAccount("alice", "battery staple", "")
+	c.Assert(err, check.Equals, ErrInvalidCredentials)
+	_, err = app.AuthenticateLocalAccount("bob", "correct horse", "")
+	c.Assert(err, check.Equals, ErrInvalidCredentials)
+
+	user, err := app.AuthenticateLocalAccount("alice", "correct horse", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(user.Name, check.Equals, "alice")
+
+	secret, uri, err := app.EnrollUserTOTP("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(uri, check.Matches, "otpauth://totp/.*alice.*")
+
+	// Enrolling alone does not require the second factor
+	_, err = app.AuthenticateLocalAccount("alice", "correct horse", "")
+	c.Assert(err, check.IsNil)
+
+	_, err = app.ConfirmUserTOTP("alice", "000000")
+	c.Assert(err, check.Equals, ErrInvalidTOTPCode)
+
+	key, err := totpEncoding.DecodeString(secret)
+	c.Assert(err, check.IsNil)
+	code := totpCode(key, uint64(time.Now().Unix()/30))
+
+	recoveryCodes, err := app.ConfirmUserTOTP("alice", code)
+	c.Assert(err, check.IsNil)
+	c.Assert(recoveryCodes, check.HasLen, recoveryCodeCount)
+
+	_, _, err = app.EnrollUserTOTP("alice")
+	c.Assert(err, check.Equals, ErrTOTPAlreadyEnabled)
+
+	_, err = app.AuthenticateLocalAccount("alice", "correct horse", "")
+	c.Assert(err, check.Equals, ErrTOTPRequired)
+	_, err = app.AuthenticateLocalAccount("alice", "correct horse", "000000")
+	c.Assert(err, check.Equals, ErrInvalidTOTPCode)
+	_, err = app.AuthenticateLocalAccount("alice", "correct horse", code)
+	c.Assert(err, check.IsNil)
+
+	// A recovery code works once
+	_, err = app.AuthenticateLocalAccount("alice", "correct horse", recoveryCodes[0])
+	c.Assert(err, check.IsNil)
+	_, err = app.AuthenticateLocalAccount("alice", "correct horse", recoveryCodes[0])
+	c.Assert(err, check.Equals, ErrInvalidTOTPCode)
+
+	user, err = app.GetUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(user.RecoveryCodeHashes, check.HasLen, recoveryCodeCount-1)
+
+	err = app.ResetUserTOTP("alice")
+	c.Assert(err, check.IsNil)
+	_, err = app.AuthenticateLocalAccount("alice", "correct horse", "")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *Suite) TestLocalLoginLockout(c *check.C) {
+	app.cfg.LocalAccounts.Enabled = true
+	app.rateLimits = newRateLimits(RateLimitConfig{
+		Enabled:         true,
+		MaxFailures:     3,
+		FailureWindow:   time.Minute,
+		LockoutDuration: time.Minute,
+	})
+
+	_, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(app.SetUserPassword("alice", "correct horse"), check.IsNil)
+	secret, _, err := app.EnrollUserTOTP("alice")
+	c.Assert(err, check.IsNil)
+	key, err := totpEncoding.DecodeString(secret)
+	c.Assert(err, check.IsNil)
+	_, err = app.ConfirmUserTOTP("alice", totpCode(key, uint64(time.Now().Unix()/30)))
+	c.Assert(err, check.IsNil)
+
+	login := func(code string) string {
+		body, err := json.Marshal(localLoginReq{UserName: "alice", Password: "correct horse", Code: code})
+		c.Assert(err, check.IsNil)
+		recorder := httptest.NewRecorder()
+		app.LocalLoginAPI(recorder, httptest.NewRequest(http.MethodPost, "/login/local", strings.NewReader(string(body))))
+		res := APIResponse{}
+		c.Assert(json.NewDecoder(recorder.Body).Decode(&res), check.IsNil)
+
+		return res.Status
+	}
+
+	for i := 0; i < 3; i++ {
+		c.Assert(login("000000"), check.Equals, "error-动态验证码错误")
+	}
+
+	// Even the right code is refused once the account is locked out
+	c.Assert(
+		login(totpCode(key, uint64(time.Now().Unix()/30))),
+		check.Matches,
+		"error-登录失败次数过多.*",
+	)
+}
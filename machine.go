@@ -73,10 +73,20 @@ type Machine struct {
 	LastSeen             *time.Time
 	LastSuccessfulUpdate *time.Time
 	Expiry               *time.Time
+	// ExpiryWarnedFor is the Expiry the client has last been warned about,
+	// the webhook and email fields track the other channels separately
+	ExpiryWarnedFor        *time.Time
+	ExpiryWebhookWarnedFor *time.Time
+	ExpiryEmailWarnedFor   *time.Time
 
 	HostInfo  HostInfo
 	Endpoints StringList
 
+	// PostureAction is set when the machine does not comply with the
+	// posture rules of the policy, PostureReason explains why.
+	PostureAction string
+	PostureReason string
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt *time.Time
@@ -276,7 +286,10 @@ func (h *Headscale) ListPeers(machine *Machine) (Machines, error) {
 
 	machines := Machines{}
 	if err := h.db.Preload("AuthKey").Preload("AuthKey.User").Preload("User").Where("node_key <> ?",
-		machine.NodeKey).Find(&machines).Error; err != nil {
+		machine.NodeKey).
+		Where("user_id IN (?)", h.db.Model(&User{}).Select("id").
+			Where("organization_id = ?", h.machineOrganizationID(machine))).
+		Find(&machines).Error; err != nil {
 		log.Error().Err(err).Msg("Error accessing db")
 
 		return Machines{}, err
@@ -298,16 +311,18 @@ func (h *Headscale) getPeers(machine *Machine) (Machines, []tailcfg.NodeID, erro
 	var err error
 
 	// If ACLs rules are defined, filter visible host list with the ACLs
-	// else use the classic user scope
-	if h.aclPolicy != nil {
+	// else use the classic user scope. Machines only see the machines of
+	// their organization.
+	organizationID := h.machineOrganizationID(machine)
+	if acl := h.aclFor(organizationID); acl.policy != nil {
 		var machines []Machine
-		machines, err = h.ListMachines()
+		machines, err = h.ListOrganizationMachines(organizationID)
 		if err != nil {
 			log.Error().Err(err).Msg("Error retrieving list of machines")
 
 			return Machines{}, []tailcfg.NodeID{}, err
 		}
-		peers, invalidNodeIDs = getFilteredByACLPeers(machines, h.aclRules, machine)
+		peers, invalidNodeIDs = getFilteredByACLPeers(machines, acl.rules, machine)
 	} else {
 		peers, err = h.ListPeers(machine)
 		if err != nil {
@@ -339,8 +354,13 @@ func (h *Headscale) getValidPeers(machine *Machine) (Machines, []tailcfg.NodeID,
 		return Machines{}, []tailcfg.NodeID{}, err
 	}
 
+	// A machine in quarantine can neither see nor be seen by other machines
+	if machine.isPostureRestricted() {
+		return validPeers, nodeIDs, nil
+	}
+
 	for _, peer := range peers {
-		if !peer.isExpired() {
+		if !peer.isExpired() && !peer.isPostureRestricted() {
 			validPeers = append(validPeers, peer)
 		}
 	}
@@ -483,6 +503,7 @@ func (h *Headscale) SetTags(machine *Machine, tags []string) error {
 			newTags = append(newTags, tag)
 		}
 	}
+	oldTags := stringsToHistoryValue(machine.ForcedTags)
 	machine.ForcedTags = newTags
 	if err := h.UpdateACLRules(); err != nil && !errors.Is(err, errEmptyPolicy) {
 		return err
@@ -493,6 +514,10 @@ func (h *Headscale) SetTags(machine *Machine, tags []string) error {
 		return fmt.Errorf("failed to update tags for machine in the database: %w", err)
 	}
 
+	if newTagsValue := stringsToHistoryValue(newTags); oldTags != newTagsValue {
+		h.recordMachineHistory(machine.ID, MachineHistoryTagsChanged, oldTags, newTagsValue)
+	}
+
 	return nil
 }
 
@@ -508,6 +533,8 @@ func (h *Headscale) ExpireMachine(machine *Machine) error {
 		return fmt.Errorf("failed to expire machine in the database: %w", err)
 	}
 
+	h.recordMachineHistory(machine.ID, MachineHistoryExpired, "", now.UTC().Format(time.RFC3339))
+
 	return nil
 }
 
@@ -621,6 +648,7 @@ func (h *Headscale) DeleteMachine(machine *Machine) error {
 	if err := h.db.Delete(&machine).Error; err != nil {
 		return err
 	}
+	h.recordMachineHistory(machine.ID, MachineHistoryDeleted, machine.GivenName, "")
 
 	return nil
 }
@@ -638,6 +666,7 @@ func (h *Headscale) HardDeleteMachine(machine *Machine) error {
 	if err := h.db.Unscoped().Delete(&machine).Error; err != nil {
 		return err
 	}
+	h.recordMachineHistory(machine.ID, MachineHistoryDeleted, machine.GivenName, "")
 
 	return nil
 }
@@ -801,12 +830,14 @@ func (h *Headscale) toNode(
 
 	var hostname string
 	if machine.User.EnableMagic { //[cgao6 removed] dnsConfig != nil && dnsConfig.Proxied { // MagicDNS
-		_, baseDomain := machine.User.GetDNSConfig(h.cfg.IPPrefixes)
+		domain, err := h.machineMagicDNSName(&machine)
+		if err != nil {
+			return nil, err
+		}
 		hostname = fmt.Sprintf(
-			"%s.%s.%s",
+			"%s.%s",
 			machine.GivenName,
-			machine.User.Name,
-			baseDomain,
+			domain,
 		)
 		if len(hostname) > maxHostnameLength {
 			return nil, fmt.Errorf(
@@ -823,7 +854,7 @@ func (h *Headscale) toNode(
 
 	online := machine.isOnline()
 
-	tags, _ := getTags(h.aclPolicy, machine, h.cfg.OIDC.StripEmaildomain)
+	tags, _ := getTags(h.aclFor(h.machineOrganizationID(&machine)).policy, machine, h.cfg.OIDC.StripEmaildomain)
 	tags = lo.Uniq(append(tags, machine.ForcedTags...))
 
 	node := tailcfg.Node{
@@ -1017,6 +1048,13 @@ func (h *Headscale) RegisterMachineFromAuthCallback(
 					h.registrationCache.Delete(nodeKeyStr)
 				}
 
+				h.recordMachineHistory(
+					registrationMachine.ID,
+					MachineHistoryReauthenticated,
+					oldmachine.NodeKey,
+					registrationMachine.NodeKey,
+				)
+
 				return &registrationMachine, err
 			} else {
 
@@ -1038,6 +1076,7 @@ func (h *Headscale) RegisterMachineFromAuthCallback(
 
 				if err == nil {
 					h.registrationCache.Delete(nodeKeyStr)
+					h.recordMachineHistory(machine.ID, MachineHistoryRegistered, "", registrationMethod)
 				}
 
 				return machine, err
@@ -1079,10 +1118,16 @@ func (h *Headscale) RegisterMachine(machine Machine,
 		return &machine, nil
 	}
 
+	// New machines are saved one at a time under the lock, so that
+	// concurrent registrations cannot all pass the quota
 	h.ipAllocationMutex.Lock()
 	defer h.ipAllocationMutex.Unlock()
 
-	ips, err := h.getAvailableIPs()
+	if err := h.checkMachineQuota(machine.UserID); err != nil {
+		return nil, err
+	}
+
+	ips, err := h.getAvailableIPsIn(h.organizationIPPrefixes(h.machineOrganizationID(&machine)))
 	if err != nil {
 		log.Error().
 			Caller().
@@ -1227,6 +1272,8 @@ func (h *Headscale) enableRoutes(machine *Machine, routeStrs ...string) error {
 			if err != nil {
 				return fmt.Errorf("failed to enable route: %w", err)
 			}
+
+			h.recordMachineHistory(machine.ID, MachineHistoryRouteEnabled, "", prefix.String())
 		} else {
 			return fmt.Errorf("failed to find route: %w", err)
 		}
@@ -1243,6 +1290,11 @@ func (h *Headscale) EnableAutoApprovedRoutes(machine *Machine) error {
 		return nil // This machine has no IPAddresses, so can't possibly match any autoApprovers ACLs
 	}
 
+	aclPolicy := h.aclFor(h.machineOrganizationID(machine)).policy
+	if aclPolicy == nil {
+		return nil
+	}
+
 	routes := []Route{}
 	err := h.db.
 		Preload("Machine").
@@ -1261,7 +1313,7 @@ func (h *Headscale) EnableAutoApprovedRoutes(machine *Machine) error {
 	approvedRoutes := []Route{}
 
 	for _, advertisedRoute := range routes {
-		routeApprovers, err := h.aclPolicy.AutoApprovers.GetRouteApprovers(
+		routeApprovers, err := aclPolicy.AutoApprovers.GetRouteApprovers(
 			netip.Prefix(advertisedRoute.Prefix),
 		)
 		if err != nil {
@@ -1277,7 +1329,7 @@ func (h *Headscale) EnableAutoApprovedRoutes(machine *Machine) error {
 			if approvedAlias == machine.User.Name {
 				approvedRoutes = append(approvedRoutes, advertisedRoute)
 			} else {
-				approvedIps, err := expandAlias([]Machine{*machine}, *h.aclPolicy, approvedAlias, h.cfg.OIDC.StripEmaildomain)
+				approvedIps, err := expandAlias([]Machine{*machine}, *aclPolicy, approvedAlias, h.cfg.OIDC.StripEmaildomain)
 				if err != nil {
 					log.Err(err).
 						Str("alias", approvedAlias).
@@ -0,0 +1,191 @@
+package headscale
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	MachineHistoryNodeKeyRotated    = "node-key-rotated"
+	MachineHistoryDiscoKeyChanged   = "disco-key-changed"
+	MachineHistoryUserChanged       = "user-changed"
+	MachineHistoryTagsChanged       = "tags-changed"
+	MachineHistoryRoutesAdvertised  = "routes-advertised"
+	MachineHistoryRouteEnabled      = "route-enabled"
+	MachineHistoryRouteDisabled     = "route-disabled"
+	MachineHistoryEndpointsChanged  = "endpoints-changed"
+	MachineHistoryHostnameChanged   = "hostname-changed"
+	MachineHistoryOSChanged         = "os-changed"
+	MachineHistoryVersionChanged    = "version-changed"
+	MachineHistoryRegistered        = "registered"
+	MachineHistoryReauthenticated   = "reauthenticated"
+	MachineHistoryExpired           = "expired"
+	MachineHistoryDeleted           = "deleted"
+	machineHistoryValueSeparator    = ","
+	machineHistoryDefaultListLength = 200
+)
+
+// MachineHistory records a meaningful change of a Machine, so that
+// the state of a node can be audited after the fact.
+type MachineHistory struct {
+	ID        uint64 `gorm:"primary_key"`
+	MachineID uint64 `gorm:"index"`
+	Event     string
+	OldValue  string
+	NewValue  string
+
+	CreatedAt time.Time
+}
+
+func (entry *MachineHistory) toProto() *v1.MachineHistory {
+	return &v1.MachineHistory{
+		Id:        entry.ID,
+		MachineId: entry.MachineID,
+		Event:     entry.Event,
+		OldValue:  entry.OldValue,
+		NewValue:  entry.NewValue,
+		CreatedAt: timestamppb.New(entry.CreatedAt),
+	}
+}
+
+// recordMachineHistory stores a history entry for the given machine.
+// Failing to write the history must never break the operation it
+// describes, so errors are only logged.
+func (h *Headscale) recordMachineHistory(
+	machineID uint64,
+	event string,
+	oldValue string,
+	newValue string,
+) {
+	if machineID == 0 {
+		return
+	}
+
+	entry := MachineHistory{
+		MachineID: machineID,
+		Event:     event,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := h.db.Create(&entry).Error; err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Uint64("machine_id", machineID).
+			Str("event", event).
+			Msg("Failed to record machine history")
+	}
+}
+
+// GetMachineHistory returns the recorded changes of a machine, newest first.
+func (h *Headscale) GetMachineHistory(machineID uint64) ([]MachineHistory, error) {
+	history := []MachineHistory{}
+	if err := h.db.
+		Where("machine_id = ?", machineID).
+		Order("created_at desc, id desc").
+		Limit(machineHistoryDefaultListLength).
+		Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("failed to list machine history: %w", err)
+	}
+
+	return history, nil
+}
+
+// recordMapRequestChanges compares the state of a machine stored in the
+// database with what the client reports in a MapRequest and records every
+// meaningful difference. It has to be called before the machine is updated.
+func (h *Headscale) recordMapRequestChanges(
+	machine *Machine,
+	mapRequest tailcfg.MapRequest,
+) {
+	if mapRequest.Hostinfo != nil {
+		newHostInfo := mapRequest.Hostinfo
+
+		if machine.Hostname != newHostInfo.Hostname {
+			h.recordMachineHistory(
+				machine.ID,
+				MachineHistoryHostnameChanged,
+				machine.Hostname,
+				newHostInfo.Hostname,
+			)
+		}
+
+		oldOS := strings.TrimSpace(machine.HostInfo.OS + " " + machine.HostInfo.OSVersion)
+		newOS := strings.TrimSpace(newHostInfo.OS + " " + newHostInfo.OSVersion)
+		if oldOS != newOS {
+			h.recordMachineHistory(machine.ID, MachineHistoryOSChanged, oldOS, newOS)
+		}
+
+		if machine.HostInfo.IPNVersion != newHostInfo.IPNVersion {
+			h.recordMachineHistory(
+				machine.ID,
+				MachineHistoryVersionChanged,
+				machine.HostInfo.IPNVersion,
+				newHostInfo.IPNVersion,
+			)
+		}
+
+		oldRoutes := prefixesToHistoryValue(machine.HostInfo.RoutableIPs)
+		newRoutes := prefixesToHistoryValue(newHostInfo.RoutableIPs)
+		if oldRoutes != newRoutes {
+			h.recordMachineHistory(
+				machine.ID,
+				MachineHistoryRoutesAdvertised,
+				oldRoutes,
+				newRoutes,
+			)
+		}
+	}
+
+	newDiscoKey := DiscoPublicKeyStripPrefix(mapRequest.DiscoKey)
+	if machine.DiscoKey != "" && machine.DiscoKey != newDiscoKey {
+		h.recordMachineHistory(
+			machine.ID,
+			MachineHistoryDiscoKeyChanged,
+			machine.DiscoKey,
+			newDiscoKey,
+		)
+	}
+
+	if !mapRequest.ReadOnly {
+		oldEndpoints := stringsToHistoryValue(machine.Endpoints)
+		newEndpoints := stringsToHistoryValue(mapRequest.Endpoints)
+		if oldEndpoints != newEndpoints {
+			h.recordMachineHistory(
+				machine.ID,
+				MachineHistoryEndpointsChanged,
+				oldEndpoints,
+				newEndpoints,
+			)
+		}
+	}
+}
+
+// stringsToHistoryValue returns an order independent representation of
+// a list of values, to avoid recording reordering as a change.
+func stringsToHistoryValue(values []string) string {
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+	sort.Strings(sorted)
+
+	return strings.Join(sorted, machineHistoryValueSeparator)
+}
+
+func prefixesToHistoryValue(prefixes []netip.Prefix) string {
+	values := make([]string, len(prefixes))
+	for index, prefix := range prefixes {
+		values[index] = prefix.String()
+	}
+
+	return stringsToHistoryValue(values)
+}
@@ -0,0 +1,151 @@
+package headscale
+
+import (
+	"context"
+	"net/netip"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"gopkg.in/check.v1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func (s *Suite) TestRecordMapRequestChanges(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	discoKey := key.NewDisco().Public()
+
+	machine := Machine{
+		ID:             0,
+		MachineKey:     "foo",
+		NodeKey:        "bar",
+		DiscoKey:       DiscoPublicKeyStripPrefix(discoKey),
+		Hostname:       "testmachine",
+		UserID:         user.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+		HostInfo: HostInfo{
+			OS:         "linux",
+			IPNVersion: "1.36.0",
+		},
+		Endpoints: []string{"10.0.0.1:41641", "192.168.0.1:41641"},
+	}
+	app.db.Save(&machine)
+
+	// An unchanged request must not produce any history.
+	app.recordMapRequestChanges(&machine, tailcfg.MapRequest{
+		DiscoKey: discoKey,
+		Hostinfo: &tailcfg.Hostinfo{
+			Hostname:   "testmachine",
+			OS:         "linux",
+			IPNVersion: "1.36.0",
+		},
+		Endpoints: []string{"192.168.0.1:41641", "10.0.0.1:41641"},
+		ReadOnly:  true,
+	})
+
+	history, err := app.GetMachineHistory(machine.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(history), check.Equals, 0)
+
+	app.recordMapRequestChanges(&machine, tailcfg.MapRequest{
+		DiscoKey: key.NewDisco().Public(),
+		Hostinfo: &tailcfg.Hostinfo{
+			Hostname:    "renamed",
+			OS:          "linux",
+			IPNVersion:  "1.38.0",
+			RoutableIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+		},
+		Endpoints: []string{"10.0.0.1:41641", "192.168.0.1:41641"},
+	})
+
+	history, err = app.GetMachineHistory(machine.ID)
+	c.Assert(err, check.IsNil)
+
+	events := map[string]MachineHistory{}
+	for _, entry := range history {
+		events[entry.Event] = entry
+	}
+	c.Assert(events[MachineHistoryHostnameChanged].OldValue, check.Equals, "testmachine")
+	c.Assert(events[MachineHistoryHostnameChanged].NewValue, check.Equals, "renamed")
+	c.Assert(events[MachineHistoryVersionChanged].NewValue, check.Equals, "1.38.0")
+	c.Assert(events[MachineHistoryRoutesAdvertised].NewValue, check.Equals, "10.0.0.0/24")
+	c.Assert(events[MachineHistoryDiscoKeyChanged].OldValue, check.Equals, machine.DiscoKey)
+
+	_, ok := events[MachineHistoryOSChanged]
+	c.Assert(ok, check.Equals, false)
+	_, ok = events[MachineHistoryEndpointsChanged]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *Suite) TestMachineHistoryOrder(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	machine := Machine{
+		ID:             0,
+		MachineKey:     "foo",
+		NodeKey:        "bar",
+		DiscoKey:       "faa",
+		Hostname:       "testmachine",
+		UserID:         user.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+	}
+	app.db.Save(&machine)
+
+	err = app.SetTags(&machine, []string{"tag:foo"})
+	c.Assert(err, check.IsNil)
+
+	err = app.SetTags(&machine, []string{"tag:foo"})
+	c.Assert(err, check.IsNil)
+
+	err = app.ExpireMachine(&machine)
+	c.Assert(err, check.IsNil)
+
+	history, err := app.GetMachineHistory(machine.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(history), check.Equals, 2)
+	c.Assert(history[0].Event, check.Equals, MachineHistoryExpired)
+	c.Assert(history[1].Event, check.Equals, MachineHistoryTagsChanged)
+	c.Assert(history[1].OldValue, check.Equals, "")
+	c.Assert(history[1].NewValue, check.Equals, "tag:foo")
+
+	history, err = app.GetMachineHistory(machine.ID + 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(history), check.Equals, 0)
+}
+
+func (s *Suite) TestDeletedMachineHistory(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	machine := Machine{
+		ID:             0,
+		MachineKey:     "foo",
+		NodeKey:        "bar",
+		DiscoKey:       "faa",
+		Hostname:       "testmachine",
+		GivenName:      "testmachine",
+		UserID:         user.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+	}
+	app.db.Save(&machine)
+
+	err = app.SetTags(&machine, []string{"tag:foo"})
+	c.Assert(err, check.IsNil)
+
+	err = app.DeleteMachine(&machine)
+	c.Assert(err, check.IsNil)
+
+	// The history outlives the machine
+	api := newHeadscaleV1APIServer(&app)
+	response, err := api.GetMachineHistory(
+		context.Background(),
+		&v1.GetMachineHistoryRequest{MachineId: machine.ID},
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(response.GetHistory(), check.HasLen, 2)
+	c.Assert(response.GetHistory()[0].GetEvent(), check.Equals, MachineHistoryDeleted)
+	c.Assert(response.GetHistory()[0].GetOldValue(), check.Equals, "testmachine")
+	c.Assert(response.GetHistory()[1].GetEvent(), check.Equals, MachineHistoryTagsChanged)
+}
@@ -277,8 +277,8 @@ func (s *Suite) TestGetACLFilteredPeers(c *check.C) {
 	machines, err := app.ListMachines()
 	c.Assert(err, check.IsNil)
 
-	peersOfTestMachine, _ := getFilteredByACLPeers(machines, app.aclRules, testMachine)
-	peersOfAdminMachine, _ := getFilteredByACLPeers(machines, app.aclRules, adminMachine)
+	peersOfTestMachine, _ := getFilteredByACLPeers(machines, app.aclFor(app.defaultOrganizationID).rules, testMachine)
+	peersOfAdminMachine, _ := getFilteredByACLPeers(machines, app.aclFor(app.defaultOrganizationID).rules, adminMachine)
 
 	c.Log(peersOfTestMachine)
 	c.Assert(len(peersOfTestMachine), check.Equals, 4)
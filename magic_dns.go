@@ -0,0 +1,274 @@
+package headscale
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/util/dnsname"
+)
+
+const (
+	// defaultBaseDomain is the base domain without dns_config.base_domain,
+	// it does not really matter when MagicDNS is not enabled.
+	defaultBaseDomain = "headscale.net"
+
+	ErrInvalidBaseDomain    = Error("invalid MagicDNS base domain")
+	ErrMagicDNSNameConflict = Error("MagicDNS name used by several machines")
+)
+
+// MagicDNSSettings are the MagicDNS settings of a User, overriding those of
+// their Organization: an empty BaseDomain or a nil OmitUserLabel keeps the
+// setting of the Organization.
+type MagicDNSSettings struct {
+	BaseDomain    string
+	OmitUserLabel *bool
+}
+
+// normalizeDomainName checks a domain name and returns it in lowercase
+// without trailing dot.
+func normalizeDomainName(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+	fqdn, err := dnsname.ToFQDN(domain)
+	if err != nil {
+		return "", err
+	}
+	for _, label := range strings.Split(domain, ".") {
+		// Labels of letters, digits and inner hyphens only
+		if dnsname.SanitizeLabel(label) != label {
+			return "", fmt.Errorf("invalid label %q", label)
+		}
+	}
+	if strings.HasSuffix(domain, ".arpa") {
+		return "", fmt.Errorf("%s is reserved", domain)
+	}
+
+	return fqdn.WithoutTrailingDot(), nil
+}
+
+// normalizeBaseDomain checks a base domain and returns it in lowercase
+// without trailing dot, "" is kept to inherit the setting.
+func normalizeBaseDomain(domain string) (string, error) {
+	if strings.TrimSpace(domain) == "" {
+		return "", nil
+	}
+
+	domain, err := normalizeDomainName(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidBaseDomain, err)
+	}
+
+	return domain, nil
+}
+
+// magicDNSOf resolves the MagicDNS settings of a User in its Organization,
+// then those of the configuration.
+func (h *Headscale) magicDNSOf(user *User, organization *Organization) (string, bool) {
+	baseDomain := h.cfg.BaseDomain
+	omitUserLabel := false
+	if organization != nil {
+		if organization.BaseDomain != "" {
+			baseDomain = organization.BaseDomain
+		}
+		omitUserLabel = organization.OmitUserLabel
+	}
+	if user.BaseDomain != "" {
+		baseDomain = user.BaseDomain
+	}
+	if user.OmitUserLabel != nil {
+		omitUserLabel = *user.OmitUserLabel
+	}
+	if baseDomain == "" {
+		baseDomain = defaultBaseDomain
+	}
+
+	return baseDomain, omitUserLabel
+}
+
+// userMagicDNS returns the MagicDNS base domain of a User and whether the
+// names of its machines leave the user out.
+func (h *Headscale) userMagicDNS(user *User) (string, bool) {
+	// Without organization, the configuration applies
+	organization, _ := h.getOrganizationByID(user.OrganizationID)
+
+	return h.magicDNSOf(user, organization)
+}
+
+// magicDNSDomain returns the domain of the names of the machines of a
+// user, <user>.<base domain> or the base domain alone.
+func magicDNSDomain(userName string, baseDomain string, omitUserLabel bool) string {
+	if omitUserLabel {
+		return baseDomain
+	}
+
+	return userName + "." + baseDomain
+}
+
+// userMagicDNSDomain returns the domain of the names of the machines of a
+// User, also its search domain.
+func (h *Headscale) userMagicDNSDomain(user *User) string {
+	baseDomain, omitUserLabel := h.userMagicDNS(user)
+
+	return magicDNSDomain(user.Name, baseDomain, omitUserLabel)
+}
+
+// machineMagicDNSName returns the MagicDNS name of a machine. Without the
+// user label, the given names of the machines of several users may meet in
+// a domain after a rename: those machines keep the user label then, so that
+// a name never resolves to two machines.
+func (h *Headscale) machineMagicDNSName(machine *Machine) (string, error) {
+	baseDomain, omitUserLabel := h.userMagicDNS(&machine.User)
+	if !omitUserLabel {
+		return magicDNSDomain(machine.User.Name, baseDomain, false), nil
+	}
+
+	namesakes := []Machine{}
+	if err := h.db.Preload("User").
+		Where("given_name = ? AND id <> ?", machine.GivenName, machine.ID).
+		Find(&namesakes).Error; err != nil {
+		return "", err
+	}
+	for index := range namesakes {
+		if h.userMagicDNSDomain(&namesakes[index].User) == baseDomain {
+			log.Warn().
+				Str("machine", machine.GivenName).
+				Str("user", machine.User.Name).
+				Str("domain", baseDomain).
+				Msg("MagicDNS name used by another machine, keeping the user label")
+
+			return magicDNSDomain(machine.User.Name, baseDomain, false), nil
+		}
+	}
+
+	return baseDomain, nil
+}
+
+// checkMagicDNSNames returns ErrMagicDNSNameConflict if the changed User or
+// Organization would give the same MagicDNS name to several machines of
+// the organization. The machines of other organizations never see these
+// names, they may reuse them.
+func (h *Headscale) checkMagicDNSNames(changedUser *User, changedOrganization *Organization) error {
+	var organizationID uint
+	if changedUser != nil {
+		organizationID = changedUser.OrganizationID
+	} else {
+		organizationID = changedOrganization.ID
+	}
+
+	organization := changedOrganization
+	if organization == nil {
+		// Without organization, the configuration applies
+		organization, _ = h.getOrganizationByID(organizationID)
+	}
+
+	machines := []Machine{}
+	if err := h.db.Preload("User").
+		Where("user_id IN (?)", h.db.Model(&User{}).Select("id").Where("organization_id = ?", organizationID)).
+		Find(&machines).Error; err != nil {
+		return err
+	}
+
+	names := map[string]string{}
+	for index := range machines {
+		user := &machines[index].User
+		if changedUser != nil && user.ID == changedUser.ID {
+			user = changedUser
+		}
+
+		baseDomain, omitUserLabel := h.magicDNSOf(user, organization)
+		name := machines[index].GivenName + "." + magicDNSDomain(user.Name, baseDomain, omitUserLabel)
+		if other, taken := names[name]; taken && other != user.Name {
+			return fmt.Errorf("%w: %s", ErrMagicDNSNameConflict, name)
+		}
+		names[name] = user.Name
+	}
+
+	return nil
+}
+
+// SetUserMagicDNS changes the MagicDNS base domain of a User and whether
+// the names of its machines leave the user out, and pushes the new names
+// and DNS configuration to the machines.
+func (h *Headscale) SetUserMagicDNS(userName string, settings MagicDNSSettings) (*User, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDomain, err := normalizeBaseDomain(settings.BaseDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := *user
+	changed.BaseDomain = baseDomain
+	changed.OmitUserLabel = settings.OmitUserLabel
+	if err := h.checkMagicDNSNames(&changed, nil); err != nil {
+		return nil, err
+	}
+
+	if err := h.db.Model(user).Updates(map[string]interface{}{
+		"base_domain":     baseDomain,
+		"omit_user_label": settings.OmitUserLabel,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update user MagicDNS settings in the database: %w", err)
+	}
+	user.BaseDomain = baseDomain
+	user.OmitUserLabel = settings.OmitUserLabel
+
+	// The peers of the machines of the user route its domain
+	h.setLastStateChangeToNow()
+
+	log.Info().
+		Str("user", user.Name).
+		Str("domain", h.userMagicDNSDomain(user)).
+		Msg("User MagicDNS settings updated")
+
+	return user, nil
+}
+
+// SetOrganizationMagicDNS changes the MagicDNS base domain of the users of
+// an Organization, the global dns_config.base_domain when empty, and
+// whether the names of their machines leave the user out.
+func (h *Headscale) SetOrganizationMagicDNS(
+	name string,
+	baseDomain string,
+	omitUserLabel bool,
+) (*Organization, error) {
+	organization, err := h.GetOrganization(name)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDomain, err = normalizeBaseDomain(baseDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := *organization
+	changed.BaseDomain = baseDomain
+	changed.OmitUserLabel = omitUserLabel
+	if err := h.checkMagicDNSNames(nil, &changed); err != nil {
+		return nil, err
+	}
+
+	if err := h.db.Model(organization).Updates(map[string]interface{}{
+		"base_domain":     baseDomain,
+		"omit_user_label": omitUserLabel,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update organization MagicDNS settings in the database: %w", err)
+	}
+	organization.BaseDomain = baseDomain
+	organization.OmitUserLabel = omitUserLabel
+
+	h.setLastStateChangeToNow()
+
+	log.Info().
+		Str("organization", name).
+		Str("base_domain", baseDomain).
+		Bool("omit_user_label", omitUserLabel).
+		Msg("Organization MagicDNS settings updated")
+
+	return organization, nil
+}
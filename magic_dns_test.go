@@ -0,0 +1,163 @@
+package headscale
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func Test_normalizeBaseDomain(t *testing.T) {
+	tests := []struct {
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"  ", "", false},
+		{"example.com", "example.com", false},
+		{"Corp.Example.COM.", "corp.example.com", false},
+		{"under_score.com", "", true},
+		{"-dash.com", "", true},
+		{"a..b", "", true},
+		{"1.168.192.in-addr.arpa", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := normalizeBaseDomain(test.domain)
+		if (err != nil) != test.wantErr {
+			t.Errorf("normalizeBaseDomain(%q) error = %v, wantErr %v", test.domain, err, test.wantErr)
+
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidBaseDomain) {
+			t.Errorf("normalizeBaseDomain(%q) error = %v, want ErrInvalidBaseDomain", test.domain, err)
+		}
+		if got != test.want {
+			t.Errorf("normalizeBaseDomain(%q) = %q, want %q", test.domain, got, test.want)
+		}
+	}
+}
+
+func saveMagicDNSTestMachine(c *check.C, user *User, key string, givenName string) *Machine {
+	machine := &Machine{
+		MachineKey: "mkey-" + key,
+		NodeKey:    "nkey-" + key,
+		DiscoKey:   "dkey-" + key,
+		Hostname:   givenName,
+		GivenName:  givenName,
+		UserID:     user.ID,
+	}
+	c.Assert(app.db.Save(machine).Error, check.IsNil)
+	c.Assert(app.db.Preload("User").First(machine, machine.ID).Error, check.IsNil)
+
+	return machine
+}
+
+func (s *Suite) TestSetUserMagicDNS(c *check.C) {
+	alice, err := app.CreateUser("alice", "alice", "Alice")
+	c.Assert(err, check.IsNil)
+	bob, err := app.CreateUser("bob", "bob", "Bob")
+	c.Assert(err, check.IsNil)
+	aliceLaptop := saveMagicDNSTestMachine(c, alice, "alice", "laptop")
+	bobLaptop := saveMagicDNSTestMachine(c, bob, "bob", "laptop")
+
+	baseDomain, omitUserLabel := app.userMagicDNS(alice)
+	c.Assert(omitUserLabel, check.Equals, false)
+	c.Assert(app.userMagicDNSDomain(alice), check.Equals, "alice."+baseDomain)
+
+	_, err = app.SetUserMagicDNS("alice", MagicDNSSettings{BaseDomain: "under_score"})
+	c.Assert(errors.Is(err, ErrInvalidBaseDomain), check.Equals, true)
+
+	omit := true
+	alice, err = app.SetUserMagicDNS("alice", MagicDNSSettings{OmitUserLabel: &omit})
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userMagicDNSDomain(alice), check.Equals, baseDomain)
+
+	c.Assert(app.db.Preload("User").First(aliceLaptop, aliceLaptop.ID).Error, check.IsNil)
+	domain, err := app.machineMagicDNSName(aliceLaptop)
+	c.Assert(err, check.IsNil)
+	c.Assert(domain, check.Equals, baseDomain)
+
+	// Both laptops would be laptop.<base domain>
+	_, err = app.SetUserMagicDNS("bob", MagicDNSSettings{OmitUserLabel: &omit})
+	c.Assert(errors.Is(err, ErrMagicDNSNameConflict), check.Equals, true)
+
+	bob, err = app.SetUserMagicDNS("bob", MagicDNSSettings{
+		BaseDomain:    "Bob.Example.com.",
+		OmitUserLabel: &omit,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(bob.BaseDomain, check.Equals, "bob.example.com")
+
+	c.Assert(app.db.Preload("User").First(bobLaptop, bobLaptop.ID).Error, check.IsNil)
+	domain, err = app.machineMagicDNSName(bobLaptop)
+	c.Assert(err, check.IsNil)
+	c.Assert(domain, check.Equals, "bob.example.com")
+
+	// A nil OmitUserLabel falls back to the organization
+	bob, err = app.SetUserMagicDNS("bob", MagicDNSSettings{BaseDomain: "bob.example.com"})
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userMagicDNSDomain(bob), check.Equals, "bob.bob.example.com")
+}
+
+func (s *Suite) TestMachineMagicDNSNameConflict(c *check.C) {
+	alice, err := app.CreateUser("alice", "alice", "Alice")
+	c.Assert(err, check.IsNil)
+	bob, err := app.CreateUser("bob", "bob", "Bob")
+	c.Assert(err, check.IsNil)
+	aliceLaptop := saveMagicDNSTestMachine(c, alice, "alice", "laptop")
+	bobLaptop := saveMagicDNSTestMachine(c, bob, "bob", "laptop")
+
+	omit := true
+	c.Assert(app.db.Model(&User{}).
+		Where("id IN ?", []uint{alice.ID, bob.ID}).
+		Update("omit_user_label", &omit).Error, check.IsNil)
+	c.Assert(app.db.Preload("User").First(aliceLaptop, aliceLaptop.ID).Error, check.IsNil)
+	c.Assert(app.db.Preload("User").First(bobLaptop, bobLaptop.ID).Error, check.IsNil)
+
+	// Past the checks, the namesakes keep the user label
+	baseDomain, _ := app.userMagicDNS(alice)
+	domain, err := app.machineMagicDNSName(aliceLaptop)
+	c.Assert(err, check.IsNil)
+	c.Assert(domain, check.Equals, "alice."+baseDomain)
+	domain, err = app.machineMagicDNSName(bobLaptop)
+	c.Assert(err, check.IsNil)
+	c.Assert(domain, check.Equals, "bob."+baseDomain)
+}
+
+func (s *Suite) TestSetOrganizationMagicDNS(c *check.C) {
+	organization, err := app.CreateOrganization("acme", "ACME", nil)
+	c.Assert(err, check.IsNil)
+	user, err := app.CreateUser("alice", "alice", "Alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(app.db.Model(user).Update("organization_id", organization.ID).Error, check.IsNil)
+
+	_, err = app.SetOrganizationMagicDNS("acme", "acme.example.com", true)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userMagicDNSDomain(user), check.Equals, "acme.example.com")
+
+	// The settings of the user take precedence
+	keep := false
+	user, err = app.SetUserMagicDNS("alice", MagicDNSSettings{OmitUserLabel: &keep})
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userMagicDNSDomain(user), check.Equals, "alice.acme.example.com")
+
+	organization, err = app.SetOrganizationMagicDNS("acme", "", false)
+	c.Assert(err, check.IsNil)
+	c.Assert(organization.toProto().GetBaseDomain(), check.Equals, "")
+
+	_, err = app.SetOrganizationMagicDNS("initech", "", false)
+	c.Assert(err, check.NotNil)
+
+	// Only the machines of the organization can conflict
+	saveMagicDNSTestMachine(c, user, "alice", "laptop")
+	bob, err := app.CreateUser("bob", "bob", "Bob")
+	c.Assert(err, check.IsNil)
+	saveMagicDNSTestMachine(c, bob, "bob", "laptop")
+	omit := true
+	_, err = app.SetUserMagicDNS("bob", MagicDNSSettings{BaseDomain: "acme.example.com", OmitUserLabel: &omit})
+	c.Assert(err, check.IsNil)
+	_, err = app.SetUserMagicDNS("alice", MagicDNSSettings{BaseDomain: "acme.example.com", OmitUserLabel: &omit})
+	c.Assert(err, check.IsNil)
+}
@@ -32,6 +32,11 @@ var (
 		Name:      "update_request_sent_to_node_total",
 		Help:      "The number of calls/messages issued on a specific nodes update channel",
 	}, []string{"user", "machine", "status"})
+	rateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "The number of requests rejected by the rate limits or a lockout",
+	}, []string{"endpoint", "reason"})
 	// TODO(kradalby): This is very debugging, we might want to remove it.
 	updateRequestsReceivedOnChannel = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: prometheusNamespace,
@@ -0,0 +1,363 @@
+package headscale
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	ErrOAuthClientNotFound = Error("OAuth client not found")
+	ErrOAuthClientInvalid  = Error("Invalid OAuth client credentials")
+	ErrOAuthScopeDenied    = Error("Scope not granted to the OAuth client")
+	ErrOAuthTagDenied      = Error("Preauth keys of OAuth clients must carry the tags of the client")
+
+	oauthClientIDLength     = 16
+	oauthClientSecretLength = 32
+	oauthTokenExpiration    = time.Hour
+	oauthTokenPath          = "/oauth/token"
+)
+
+// OAuthClient lets automations, such as CI runners, get short-lived access
+// tokens with the client credentials grant. The tokens are API keys of the
+// user owning the client, limited to the scopes of the client, and only
+// create preauth keys carrying the tags of the client.
+type OAuthClient struct {
+	ID          uint64 `gorm:"primary_key"`
+	ClientID    string `gorm:"uniqueIndex"`
+	SecretHash  []byte
+	Description string
+	UserID      uint `gorm:"index"`
+	Scopes      StringList
+	ACLTags     StringList
+
+	CreatedAt *time.Time
+	LastUsed  *time.Time
+	RevokedAt *time.Time
+}
+
+// scopes returns the scopes of the client, all when none is set.
+func (client *OAuthClient) scopes() []APIKeyScope {
+	key := APIKey{Scopes: client.Scopes}
+
+	return key.scopes()
+}
+
+// CreateOAuthClient creates an OAuthClient owned by a user and returns its
+// secret, only visible once.
+func (h *Headscale) CreateOAuthClient(
+	userName string,
+	description string,
+	scopes []APIKeyScope,
+	aclTags []string,
+) (string, *OAuthClient, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return "", nil, err
+	}
+	if user.Disabled {
+		return "", nil, ErrUserDisabled
+	}
+	for _, tag := range aclTags {
+		if err := validateTag(tag); err != nil {
+			return "", nil, err
+		}
+	}
+
+	clientID, err := GenerateRandomStringURLSafe(oauthClientIDLength)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := GenerateRandomStringURLSafe(oauthClientSecretLength)
+	if err != nil {
+		return "", nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	client := OAuthClient{
+		ClientID:    clientID[:oauthClientIDLength],
+		SecretHash:  hash,
+		Description: description,
+		UserID:      user.ID,
+		ACLTags:     aclTags,
+		CreatedAt:   &now,
+	}
+	for _, scope := range scopes {
+		if scope == APIKeyScopeAll {
+			client.Scopes = nil
+
+			break
+		}
+		client.Scopes = append(client.Scopes, string(scope))
+	}
+
+	if err := h.db.Create(&client).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to save OAuth client to database: %w", err)
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Str("client_id", client.ClientID).
+		Msg("OAuth client created")
+
+	return secret, &client, nil
+}
+
+// ListUserOAuthClients returns the OAuth clients of a user.
+func (h *Headscale) ListUserOAuthClients(userName string) ([]OAuthClient, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []OAuthClient{}
+	if err := h.db.Where("user_id = ?", user.ID).Order("id").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// RevokeOAuthClient revokes an OAuth client of a user along with the
+// access tokens it issued.
+func (h *Headscale) RevokeOAuthClient(userName string, clientID string) error {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return err
+	}
+
+	client := OAuthClient{}
+	if err := h.db.Where("client_id = ? AND user_id = ? AND revoked_at IS NULL", clientID, user.ID).
+		First(&client).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrOAuthClientNotFound
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&client).Update("revoked_at", &now).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&APIKey{}).
+			Where("oauth_client_id = ? AND expiration > ?", client.ID, now).
+			Update("expiration", now).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Str("client_id", client.ClientID).
+		Msg("OAuth client revoked")
+
+	return nil
+}
+
+// issueOAuthToken checks the credentials of an OAuth client and returns an
+// access token limited to the requested scopes, all the scopes of the
+// client when none is requested.
+func (h *Headscale) issueOAuthToken(
+	clientID string,
+	secret string,
+	requested []string,
+) (string, *APIKey, error) {
+	client := OAuthClient{}
+	if err := h.db.Where("client_id = ?", clientID).First(&client).Error; errors.Is(
+		err,
+		gorm.ErrRecordNotFound,
+	) {
+		return "", nil, ErrOAuthClientInvalid
+	} else if err != nil {
+		return "", nil, err
+	}
+	if client.RevokedAt != nil {
+		return "", nil, ErrOAuthClientInvalid
+	}
+	if err := bcrypt.CompareHashAndPassword(client.SecretHash, []byte(secret)); err != nil {
+		return "", nil, ErrOAuthClientInvalid
+	}
+
+	user := User{}
+	if err := h.db.First(&user, client.UserID).Error; err != nil || user.Disabled {
+		return "", nil, ErrOAuthClientInvalid
+	}
+
+	scopes := client.scopes()
+	if len(requested) > 0 {
+		parsed, err := ParseAPIKeyScopes(requested)
+		if err != nil {
+			return "", nil, err
+		}
+		granted := APIKey{Scopes: client.Scopes}
+		for _, scope := range parsed {
+			if !granted.allows(scope) {
+				return "", nil, fmt.Errorf("%w: %s", ErrOAuthScopeDenied, scope)
+			}
+		}
+		scopes = parsed
+	}
+
+	// The expired tokens of the client are of no use anymore
+	now := time.Now().UTC()
+	if err := h.db.Unscoped().
+		Where("oauth_client_id = ? AND expiration < ?", client.ID, now).
+		Delete(&APIKey{}).Error; err != nil {
+		return "", nil, err
+	}
+
+	expiration := now.Add(oauthTokenExpiration)
+	token, key, err := h.createAPIKey(&user, &client, scopes, &expiration)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := h.db.Model(&client).Update("last_used", &now).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, key, nil
+}
+
+// checkOAuthPreAuthKeyTags checks that the preauth keys created with the
+// access token of an OAuth client carry tags of the client only.
+func (h *Headscale) checkOAuthPreAuthKeyTags(key *APIKey, aclTags []string) error {
+	if key == nil || key.OauthClientID == 0 {
+		return nil
+	}
+
+	client := OAuthClient{}
+	if err := h.db.First(&client, key.OauthClientID).Error; err != nil {
+		return err
+	}
+	if len(aclTags) == 0 {
+		return ErrOAuthTagDenied
+	}
+	for _, tag := range aclTags {
+		if !containsStr(client.ACLTags, tag) {
+			return fmt.Errorf("%w: %s", ErrOAuthTagDenied, tag)
+		}
+	}
+
+	return nil
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func oauthResponse(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+// OAuthTokenHandler implements the client credentials grant of RFC 6749 at
+// /oauth/token. The client authenticates with HTTP Basic or with the
+// client_id and client_secret form parameters.
+func (h *Headscale) OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthResponse(w, http.StatusBadRequest, oauthErrorResponse{Error: "invalid_request"})
+
+		return
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != "client_credentials" {
+		oauthResponse(w, http.StatusBadRequest, oauthErrorResponse{
+			Error:            "unsupported_grant_type",
+			ErrorDescription: "only client_credentials is supported",
+		})
+
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	clientIP := h.clientIP(r)
+	if locked, retryAfter := h.rateLimits.lockedOut(apiKeyEndpoint, clientIP); locked {
+		setRetryAfter(w, retryAfter)
+		oauthResponse(w, http.StatusTooManyRequests, oauthErrorResponse{Error: "temporarily_unavailable"})
+
+		return
+	}
+	if ok, retryAfter := h.rateLimits.allowIdentity("oauth_token", clientID); !ok {
+		setRetryAfter(w, retryAfter)
+		oauthResponse(w, http.StatusTooManyRequests, oauthErrorResponse{Error: "temporarily_unavailable"})
+
+		return
+	}
+	if clientID == "" || secret == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="mirage"`)
+		oauthResponse(w, http.StatusUnauthorized, oauthErrorResponse{Error: "invalid_client"})
+
+		return
+	}
+
+	token, key, err := h.issueOAuthToken(clientID, secret, strings.Fields(r.PostForm.Get("scope")))
+	switch {
+	case errors.Is(err, ErrOAuthClientInvalid):
+		log.Info().
+			Str("client_id", clientID).
+			Str("client_address", clientIP).
+			Msg("OAuth client authentication failed")
+		h.rateLimits.failure(apiKeyEndpoint, clientIP)
+		w.Header().Set("WWW-Authenticate", `Basic realm="mirage"`)
+		oauthResponse(w, http.StatusUnauthorized, oauthErrorResponse{Error: "invalid_client"})
+
+		return
+	case errors.Is(err, ErrOAuthScopeDenied), errors.Is(err, ErrInvalidAPIKeyScope):
+		oauthResponse(w, http.StatusBadRequest, oauthErrorResponse{
+			Error:            "invalid_scope",
+			ErrorDescription: err.Error(),
+		})
+
+		return
+	case err != nil:
+		log.Error().Caller().Err(err).Msg("Failed to issue OAuth token")
+		oauthResponse(w, http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+
+		return
+	}
+
+	h.rateLimits.success(apiKeyEndpoint, clientIP)
+
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.scopes() {
+		scopes = append(scopes, string(scope))
+	}
+	oauthResponse(w, http.StatusOK, oauthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthTokenExpiration.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	})
+}
@@ -0,0 +1,131 @@
+package headscale
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/check.v1"
+)
+
+func (s *Suite) TestOAuthClient(c *check.C) {
+	user, err := app.CreateUser("ci", "ci-uid", "CI")
+	c.Assert(err, check.IsNil)
+	_, err = app.SetUserRole(RoleOwner, user.Name, RoleITAdmin)
+	c.Assert(err, check.IsNil)
+
+	_, _, err = app.CreateOAuthClient(user.Name, "CI", nil, []string{"ci"})
+	c.Assert(err, check.NotNil)
+
+	secret, client, err := app.CreateOAuthClient(
+		user.Name, "CI", []APIKeyScope{APIKeyScopeKeysWrite}, []string{"tag:ci"},
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(client.ClientID, check.HasLen, oauthClientIDLength)
+
+	_, _, err = app.issueOAuthToken(client.ClientID, "wrong", nil)
+	c.Assert(err, check.Equals, ErrOAuthClientInvalid)
+	_, _, err = app.issueOAuthToken(client.ClientID, secret, []string{"machines:write"})
+	c.Assert(err, check.ErrorMatches, ErrOAuthScopeDenied.Error()+".*")
+
+	token, key, err := app.issueOAuthToken(client.ClientID, secret, []string{"keys:read"})
+	c.Assert(err, check.IsNil)
+	c.Assert(key.OauthClientID, check.Equals, client.ID)
+	c.Assert([]string(key.Scopes), check.DeepEquals, []string{"keys:read"})
+
+	// The access tokens are not listed with the API keys of the user
+	keys, err := app.ListUserAPIKeys(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 0)
+
+	token, _, err = app.issueOAuthToken(client.ClientID, secret, nil)
+	c.Assert(err, check.IsNil)
+	ctx, err := app.authorizeGRPCToken(
+		context.Background(), token, "/headscale.v1.HeadscaleService/CreatePreAuthKey", "test",
+	)
+	c.Assert(err, check.IsNil)
+
+	api := newHeadscaleV1APIServer(&app)
+	_, err = api.CreatePreAuthKey(ctx, &v1.CreatePreAuthKeyRequest{User: user.Name})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+	_, err = api.CreatePreAuthKey(ctx, &v1.CreatePreAuthKeyRequest{
+		User:    user.Name,
+		AclTags: []string{"tag:ci", "tag:prod"},
+	})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+	_, err = api.CreatePreAuthKey(ctx, &v1.CreatePreAuthKeyRequest{
+		User:      user.Name,
+		Ephemeral: true,
+		AclTags:   []string{"tag:ci"},
+	})
+	c.Assert(err, check.IsNil)
+	preAuthKeys, err := app.ListPreAuthKeys(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(preAuthKeys, check.HasLen, 1)
+	c.Assert(preAuthKeys[0].ACLTags, check.HasLen, 1)
+	c.Assert(preAuthKeys[0].ACLTags[0].Tag, check.Equals, "tag:ci")
+
+	_, err = api.CreateApiKey(ctx, &v1.CreateApiKeyRequest{})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+
+	c.Assert(app.RevokeOAuthClient(user.Name, client.ClientID), check.IsNil)
+	c.Assert(app.RevokeOAuthClient(user.Name, client.ClientID), check.Equals, ErrOAuthClientNotFound)
+	valid, err := app.ValidateAPIKey(token)
+	c.Assert(err, check.IsNil)
+	c.Assert(valid, check.Equals, false)
+	_, _, err = app.issueOAuthToken(client.ClientID, secret, nil)
+	c.Assert(err, check.Equals, ErrOAuthClientInvalid)
+}
+
+func (s *Suite) TestOAuthTokenHandler(c *check.C) {
+	user, err := app.CreateUser("ci", "ci-uid", "CI")
+	c.Assert(err, check.IsNil)
+	secret, client, err := app.CreateOAuthClient(
+		user.Name, "CI", []APIKeyScope{APIKeyScopeKeysWrite}, []string{"tag:ci"},
+	)
+	c.Assert(err, check.IsNil)
+
+	request := func(form url.Values, basic bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, oauthTokenPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basic {
+			req.SetBasicAuth(client.ClientID, secret)
+		}
+		recorder := httptest.NewRecorder()
+		app.OAuthTokenHandler(recorder, req)
+
+		return recorder
+	}
+
+	recorder := request(url.Values{"grant_type": {"password"}}, true)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+
+	recorder = request(url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {client.ClientID},
+		"client_secret": {"wrong"},
+	}, false)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+
+	recorder = request(url.Values{"grant_type": {"client_credentials"}, "scope": {"all"}}, true)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+
+	recorder = request(url.Values{"grant_type": {"client_credentials"}}, true)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Cache-Control"), check.Equals, "no-store")
+	response := oauthTokenResponse{}
+	c.Assert(json.NewDecoder(recorder.Body).Decode(&response), check.IsNil)
+	c.Assert(response.TokenType, check.Equals, "Bearer")
+	c.Assert(response.ExpiresIn, check.Equals, 3600)
+	c.Assert(response.Scope, check.Equals, "keys:write")
+
+	valid, err := app.ValidateAPIKey(response.AccessToken)
+	c.Assert(err, check.IsNil)
+	c.Assert(valid, check.Equals, true)
+}
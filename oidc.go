@@ -6,16 +6,13 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"html/template"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/oauth2"
 	"tailscale.com/types/key"
 )
 
@@ -33,44 +30,6 @@ const (
 	errOIDCNodeKeyMissing = Error("could not get node key from cache")
 )
 
-type IDTokenClaims struct {
-	Name     string   `json:"name,omitempty"`
-	Groups   []string `json:"groups,omitempty"`
-	Email    string   `json:"email,omitempty"`
-	Phone    string   `json:"phone_number"`
-	Username string   `json:"preferred_username,omitempty"`
-}
-
-func (h *Headscale) initOIDC() error {
-	var err error
-	// grab oidc config if it hasn't been already
-	if h.oauth2Config == nil {
-		h.oidcProvider, err = oidc.NewProvider(context.Background(), h.cfg.OIDC.Issuer)
-
-		if err != nil {
-			log.Error().
-				Err(err).
-				Caller().
-				Msgf("Could not retrieve OIDC Config: %s", err.Error())
-
-			return err
-		}
-
-		h.oauth2Config = &oauth2.Config{
-			ClientID:     h.cfg.OIDC.ClientID,
-			ClientSecret: h.cfg.OIDC.ClientSecret,
-			Endpoint:     h.oidcProvider.Endpoint(),
-			RedirectURL: fmt.Sprintf(
-				"%s/oidc/callback",
-				strings.TrimSuffix(h.cfg.ServerURL, "/"),
-			),
-			Scopes: h.cfg.OIDC.Scope,
-		}
-	}
-
-	return nil
-}
-
 func (h *Headscale) determineTokenExpiration(idTokenExpiration time.Time) time.Time {
 	if h.cfg.OIDC.UseExpiryFromToken {
 		return idTokenExpiration
@@ -137,6 +96,24 @@ func (h *Headscale) RegisterOIDC(
 		return
 	}
 
+	// Let the user choose when there is more than one identity provider
+	providerName := req.URL.Query().Get("provider")
+	if providerName == "" && len(h.identityProviders) > 1 {
+		renderOIDCProviderChoice(writer, h.identityProviders, nodeKeyStr)
+
+		return
+	}
+
+	provider, err := h.getIdentityProvider(providerName)
+	if err != nil {
+		log.Warn().
+			Str("provider", providerName).
+			Msg("Unknown identity provider passed to registration url")
+		http.Error(writer, "Unknown identity provider", http.StatusNotFound)
+
+		return
+	}
+
 	randomBlob := make([]byte, randomByteSize)
 	if _, err := rand.Read(randomBlob); err != nil {
 		log.Error().
@@ -152,14 +129,7 @@ func (h *Headscale) RegisterOIDC(
 	// place the node key into the state cache, so it can be retrieved later
 	h.registrationCache.Set(stateStr, NodePublicKeyStripPrefix(nodeKey), registerCacheExpiration)
 
-	// Add any extra parameter provided in the configuration to the Authorize Endpoint request
-	extras := make([]oauth2.AuthCodeOption, 0, len(h.cfg.OIDC.ExtraParams))
-
-	for k, v := range h.cfg.OIDC.ExtraParams {
-		extras = append(extras, oauth2.SetAuthURLParam(k, v))
-	}
-
-	authURL := h.oauth2Config.AuthCodeURL(stateStr, extras...)
+	authURL := provider.authCodeURL(stateStr, "")
 	log.Debug().Msgf("Redirecting to %s for authentication", authURL)
 
 	http.Redirect(writer, req, authURL, http.StatusFound)
@@ -170,6 +140,60 @@ type oidcCallbackTemplateConfig struct {
 	Verb string
 }
 
+type oidcProviderChoiceTemplateConfig struct {
+	NodeKey   string
+	Providers []IdentityProviderItem
+}
+
+var oidcProviderChoiceTemplate = template.Must(
+	template.New("oidcproviderchoice").Parse(`<html>
+	<body>
+	<h1>蜃境</h1>
+	<p>请选择登录方式：</p>
+	<ul>
+	{{range .Providers}}
+		<li><a href="/oidc/register/{{$.NodeKey}}?provider={{.Name}}">{{.DisplayName}}</a></li>
+	{{end}}
+	</ul>
+	</body>
+	</html>`),
+)
+
+func renderOIDCProviderChoice(
+	writer http.ResponseWriter,
+	providers []*identityProvider,
+	nodeKey string,
+) {
+	config := oidcProviderChoiceTemplateConfig{NodeKey: nodeKey}
+	for _, provider := range providers {
+		config.Providers = append(config.Providers, IdentityProviderItem{
+			Name:        provider.cfg.Name,
+			DisplayName: provider.cfg.DisplayName,
+			Type:        provider.cfg.Type,
+		})
+	}
+
+	var content bytes.Buffer
+	if err := oidcProviderChoiceTemplate.Execute(&content, config); err != nil {
+		log.Error().
+			Str("func", "RegisterOIDC").
+			Err(err).
+			Msg("Could not render OIDC provider choice template")
+		http.Error(writer, "Could not render OIDC provider choice template", http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write(content.Bytes()); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
 var oidcCallbackTemplate = template.Must(
 	template.New("oidccallback").Parse(`<html>
 	<body>
@@ -185,22 +209,29 @@ var oidcCallbackTemplate = template.Must(
 // Retrieves the nkey from the state cache and adds the machine to the users email user
 // TODO: A confirmation page for new machines should be added to avoid phishing vulnerabilities
 // TODO: Add groups information from OIDC tokens into machine HostInfo
-// Listens in /oidc/callback.
+// Listens in /oidc/callback and /oidc/callback/:provider.
 func (h *Headscale) OIDCCallback(
 	writer http.ResponseWriter,
 	req *http.Request,
 ) {
+	provider, err := h.getIdentityProvider(mux.Vars(req)["provider"])
+	if err != nil {
+		http.Error(writer, "Unknown identity provider", http.StatusNotFound)
+
+		return
+	}
+
 	code, state, err := validateOIDCCallbackParams(writer, req)
 	if err != nil {
 		return
 	}
 
-	rawIDToken, err := h.getIDTokenForOIDCCallback(req.Context(), writer, code, state)
+	rawIDToken, err := getIDTokenForOIDCCallback(req.Context(), writer, provider, code, state)
 	if err != nil {
 		return
 	}
 
-	idToken, err := h.verifyIDTokenForOIDCCallback(req.Context(), writer, rawIDToken)
+	idToken, err := verifyIDTokenForOIDCCallback(req.Context(), writer, provider, rawIDToken)
 	if err != nil {
 		return
 	}
@@ -213,53 +244,48 @@ func (h *Headscale) OIDCCallback(
 	// 	return
 	// }
 
-	claims, err := extractIDTokenClaims(writer, idToken)
-
+	identity, err := extractIdentity(writer, provider, idToken)
 	if err != nil {
 		return
 	}
-	/* cgao6: temp unused part
-
-	if err := validateOIDCAllowedDomains(writer, h.cfg.OIDC.AllowedDomains, claims); err != nil {
-		return
-	}
 
-	if err := validateOIDCAllowedGroups(writer, h.cfg.OIDC.AllowedGroups, claims); err != nil {
+	if err := validateOIDCAllowedIdentity(writer, provider.cfg, identity); err != nil {
 		return
 	}
 
-	if err := validateOIDCAllowedUsers(writer, h.cfg.OIDC.AllowedUsers, claims); err != nil {
-		return
-	}
-	*/
 	nodeKey, machineExists, err := h.validateMachineForOIDCCallback(
 		writer,
 		state,
-		claims,
+		identity,
 		idTokenExpiry,
 	)
 	if err != nil || machineExists {
 		return
 	}
 
-	userName, UID, userDisName, err := getUserName(writer, claims, h.cfg.OIDC.StripEmaildomain)
-	if err != nil {
-		return
-	}
-
 	// register the machine if it's new
 	log.Debug().Msg("Registering new machine (or replace old one) after successful callback")
 
-	user, err := h.findOrCreateNewUserForOIDCCallback(writer, userName, UID, userDisName)
+	user, err := h.findOrCreateNewUserForOIDCCallback(writer, identity)
 	if err != nil {
 		return
 	}
 
+	if identity.Email != "" && user.Email != identity.Email {
+		if err := h.SetUserEmail(user, identity.Email); err != nil {
+			log.Error().
+				Caller().
+				Err(err).
+				Str("user", user.Name).
+				Msg("could not update user email")
+		}
+	}
+
 	if err := h.registerMachineForOIDCCallback(writer, user, nodeKey, idTokenExpiry); err != nil {
 		return
 	}
 
-	content, err := renderOIDCCallbackTemplate(writer, claims)
+	content, err := renderOIDCCallbackTemplate(writer, identity)
 	if err != nil {
 		return
 	}
@@ -298,12 +324,13 @@ func validateOIDCCallbackParams(
 	return code, state, nil
 }
 
-func (h *Headscale) getIDTokenForOIDCCallback(
+func getIDTokenForOIDCCallback(
 	ctx context.Context,
 	writer http.ResponseWriter,
+	provider *identityProvider,
 	code, state string,
 ) (string, error) {
-	oauth2Token, err := h.oauth2Config.Exchange(ctx, code)
+	oauth2Token, err := provider.oauth2Config.Exchange(ctx, code)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -346,13 +373,13 @@ func (h *Headscale) getIDTokenForOIDCCallback(
 	return rawIDToken, nil
 }
 
-func (h *Headscale) verifyIDTokenForOIDCCallback(
+func verifyIDTokenForOIDCCallback(
 	ctx context.Context,
 	writer http.ResponseWriter,
+	provider *identityProvider,
 	rawIDToken string,
 ) (*oidc.IDToken, error) {
-	verifier := h.oidcProvider.Verifier(&oidc.Config{ClientID: h.cfg.OIDC.ClientID})
-	idToken, err := verifier.Verify(ctx, rawIDToken)
+	idToken, err := provider.verify(ctx, rawIDToken)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -374,15 +401,17 @@ func (h *Headscale) verifyIDTokenForOIDCCallback(
 	return idToken, nil
 }
 
-func extractIDTokenClaims(
+func extractIdentity(
 	writer http.ResponseWriter,
+	provider *identityProvider,
 	idToken *oidc.IDToken,
-) (*IDTokenClaims, error) {
-	var claims IDTokenClaims
-	if err := idToken.Claims(&claims); err != nil {
+) (*Identity, error) {
+	identity, err := provider.identity(idToken)
+	if err != nil {
 		log.Error().
 			Err(err).
 			Caller().
+			Str("provider", provider.cfg.Name).
 			Msg("Failed to decode id token claims")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		writer.WriteHeader(http.StatusBadRequest)
@@ -397,94 +426,48 @@ func extractIDTokenClaims(
 		return nil, err
 	}
 
-	return &claims, nil
-}
-
-// validateOIDCAllowedDomains checks that if AllowedDomains is provided,
-// that the authenticated principal ends with @<alloweddomain>.
-func validateOIDCAllowedDomains(
-	writer http.ResponseWriter,
-	allowedDomains []string,
-	claims *IDTokenClaims,
-) error {
-	if len(allowedDomains) > 0 {
-		if at := strings.LastIndex(claims.Email, "@"); at < 0 ||
-			!IsStringInSlice(allowedDomains, claims.Email[at+1:]) {
-			log.Error().Msg("authenticated principal does not match any allowed domain")
-			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			writer.WriteHeader(http.StatusBadRequest)
-			_, err := writer.Write([]byte("unauthorized principal (domain mismatch)"))
-			if err != nil {
-				log.Error().
-					Caller().
-					Err(err).
-					Msg("Failed to write response")
-			}
-
-			return errOIDCAllowedDomains
-		}
-	}
-
-	return nil
-}
-
-// validateOIDCAllowedGroups checks if AllowedGroups is provided,
-// and that the user has one group in the list.
-// claims.Groups can be populated by adding a client scope named
-// 'groups' that contains group membership.
-func validateOIDCAllowedGroups(
-	writer http.ResponseWriter,
-	allowedGroups []string,
-	claims *IDTokenClaims,
-) error {
-	if len(allowedGroups) > 0 {
-		for _, group := range allowedGroups {
-			if IsStringInSlice(claims.Groups, group) {
-				return nil
-			}
-		}
-
-		log.Error().Msg("authenticated principal not in any allowed groups")
-		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unauthorized principal (allowed groups)"))
-		if err != nil {
-			log.Error().
-				Caller().
-				Err(err).
-				Msg("Failed to write response")
-		}
-
-		return errOIDCAllowedGroups
-	}
-
-	return nil
+	return identity, nil
 }
 
-// validateOIDCAllowedUsers checks that if AllowedUsers is provided,
-// that the authenticated principal is part of that list.
-func validateOIDCAllowedUsers(
+// validateOIDCAllowedIdentity checks that the authenticated principal
+// matches the allowed domains, groups and users of its provider.
+// Groups can be populated by adding a client scope named 'groups'
+// that contains group membership.
+func validateOIDCAllowedIdentity(
 	writer http.ResponseWriter,
-	allowedUsers []string,
-	claims *IDTokenClaims,
+	cfg IdentityProviderConfig,
+	identity *Identity,
 ) error {
-	if len(allowedUsers) > 0 &&
-		!IsStringInSlice(allowedUsers, claims.Email) {
-		log.Error().Msg("authenticated principal does not match any allowed user")
-		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unauthorized principal (user mismatch)"))
-		if err != nil {
-			log.Error().
-				Caller().
-				Err(err).
-				Msg("Failed to write response")
-		}
-
-		return errOIDCAllowedUsers
+	err := cfg.authorize(identity)
+	if err == nil {
+		return nil
+	}
+
+	message := "unauthorized principal"
+	switch {
+	case errors.Is(err, errOIDCAllowedDomains):
+		message = "unauthorized principal (domain mismatch)"
+	case errors.Is(err, errOIDCAllowedGroups):
+		message = "unauthorized principal (allowed groups)"
+	case errors.Is(err, errOIDCAllowedUsers):
+		message = "unauthorized principal (user mismatch)"
+	}
+
+	log.Error().
+		Err(err).
+		Str("provider", cfg.Name).
+		Str("user", identity.UserName).
+		Msg("authenticated principal is not allowed")
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.WriteHeader(http.StatusForbidden)
+	if _, werr := writer.Write([]byte(message)); werr != nil {
+		log.Error().
+			Caller().
+			Err(werr).
+			Msg("Failed to write response")
 	}
 
-	return nil
+	return err
 }
 
 // validateMachine retrieves machine information if it exist
@@ -494,7 +477,7 @@ func validateOIDCAllowedUsers(
 func (h *Headscale) validateMachineForOIDCCallback(
 	writer http.ResponseWriter,
 	state string,
-	claims *IDTokenClaims,
+	identity *Identity,
 	expiry time.Time,
 ) (*key.NodePublic, bool, error) {
 	// retrieve machinekey from state cache
@@ -589,7 +572,7 @@ func (h *Headscale) validateMachineForOIDCCallback(
 
 		var content bytes.Buffer
 		if err := oidcCallbackTemplate.Execute(&content, oidcCallbackTemplateConfig{
-			User: claims.Name,
+			User: identity.DisplayName,
 			Verb: "已重认证",
 		}); err != nil {
 			log.Error().
@@ -627,70 +610,17 @@ func (h *Headscale) validateMachineForOIDCCallback(
 	return &nodeKey, false, nil
 }
 
-func getUserName(
-	writer http.ResponseWriter,
-	claims *IDTokenClaims,
-	stripEmaildomain bool,
-) (string, string, string, error) {
-	/* cgao6 change to use phone
-
-	userName, err := NormalizeToFQDNRules(
-		claims.Email,
-		stripEmaildomain,
-	)
-	if err != nil {
-		log.Error().Err(err).Caller().Msgf("couldn't normalize email")
-		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		writer.WriteHeader(http.StatusInternalServerError)
-		_, werr := writer.Write([]byte("couldn't normalize email"))
-		if werr != nil {
-			log.Error().
-				Caller().
-				Err(werr).
-				Msg("Failed to write response")
-		}
-
-		return "", err
-	}
-	*/
-	userName := strings.ReplaceAll(strings.TrimPrefix(claims.Phone, "+86"), " ", "")
-	UID := claims.Username
-	userDisName := claims.Name
-	return userName, UID, userDisName, nil
-}
-
 func (h *Headscale) findOrCreateNewUserForOIDCCallback(
 	writer http.ResponseWriter,
-	userName string,
-	UID string,
-	userDisName string,
+	identity *Identity,
 ) (*User, error) {
-	user, err := h.GetUser(userName)
-	if errors.Is(err, ErrUserNotFound) {
-		user, err = h.CreateUser(userName, UID, userDisName)
-
-		if err != nil {
-			log.Error().
-				Err(err).
-				Caller().
-				Msgf("could not create new user '%s'", userName)
-			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			writer.WriteHeader(http.StatusInternalServerError)
-			_, werr := writer.Write([]byte("could not create user"))
-			if werr != nil {
-				log.Error().
-					Caller().
-					Err(werr).
-					Msg("Failed to write response")
-			}
-
-			return nil, err
-		}
-	} else if err != nil {
+	user, err := h.findOrCreateUserForIdentity(identity)
+	if err != nil {
 		log.Error().
 			Caller().
 			Err(err).
-			Str("user", userName).
+			Str("user", identity.UserName).
+			Str("provider", identity.Provider).
 			Msg("could not find or create user")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -742,11 +672,11 @@ func (h *Headscale) registerMachineForOIDCCallback(
 
 func renderOIDCCallbackTemplate(
 	writer http.ResponseWriter,
-	claims *IDTokenClaims,
+	identity *Identity,
 ) (*bytes.Buffer, error) {
 	var content bytes.Buffer
 	if err := oidcCallbackTemplate.Execute(&content, oidcCallbackTemplateConfig{
-		User: claims.Name,
+		User: identity.DisplayName,
 		Verb: "已认证",
 	}); err != nil {
 		log.Error().
@@ -0,0 +1,391 @@
+package headscale
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	ErrOrganizationExists        = Error("Organization already exists")
+	ErrOrganizationNotFound      = Error("Organization not found")
+	ErrOrganizationNotEmpty      = Error("Organization not empty: user(s) found")
+	ErrOrganizationIsDefault     = Error("Cannot delete the default organization")
+	ErrOrganizationMismatch      = Error("Users belong to different organizations")
+	ErrOrganizationPrefixOverlap = Error("IP prefix overlaps with another organization")
+
+	// defaultOrganizationName names the organization created for the
+	// existing users, its display name is the org_name setting.
+	defaultOrganizationName = "default"
+)
+
+// Organization is an isolated tailnet: the machines of its users only see
+// each other, with their own policy and, optionally, their own IP prefixes.
+// Keys and DNS settings belong to the users of the organization.
+type Organization struct {
+	gorm.Model
+	Name        string `gorm:"unique"`
+	DisplayName string
+	IsDefault   bool
+
+	// IPPrefixes of the machines, the global ip_prefixes when empty.
+	// They never overlap with the prefixes of the other organizations.
+	IPPrefixes StringList
+
+	// ACLPolicy in HuJSON, the global acl_policy_path when empty
+	ACLPolicy string
+
+	// BaseDomain of the MagicDNS names of the machines, the global
+	// dns_config.base_domain when empty. With OmitUserLabel, the names are
+	// <machine>.<base domain> instead of <machine>.<user>.<base domain>.
+	BaseDomain    string
+	OmitUserLabel bool
+}
+
+// ensureDefaultOrganization creates the default organization and moves
+// the users without one into it.
+func (h *Headscale) ensureDefaultOrganization() error {
+	organization := Organization{}
+	err := h.db.Where("is_default = ?", true).First(&organization).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		organization = Organization{
+			Name:        defaultOrganizationName,
+			DisplayName: h.cfg.org_name,
+			IsDefault:   true,
+		}
+		err = h.db.Create(&organization).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if h.cfg.org_name != "" && organization.DisplayName != h.cfg.org_name {
+		organization.DisplayName = h.cfg.org_name
+		if err := h.db.Model(&organization).Update("display_name", h.cfg.org_name).Error; err != nil {
+			return err
+		}
+	}
+	h.defaultOrganizationID = organization.ID
+
+	return h.db.Model(&User{}).
+		Where("organization_id IS NULL OR organization_id = 0").
+		Update("organization_id", organization.ID).Error
+}
+
+// CreateOrganization creates an empty Organization.
+func (h *Headscale) CreateOrganization(
+	name string,
+	displayName string,
+	ipPrefixes []netip.Prefix,
+) (*Organization, error) {
+	if err := CheckForFQDNRules(name); err != nil {
+		return nil, err
+	}
+	if _, err := h.GetOrganization(name); err == nil {
+		return nil, ErrOrganizationExists
+	}
+	if err := h.checkOrganizationPrefixes(0, ipPrefixes); err != nil {
+		return nil, err
+	}
+
+	if displayName == "" {
+		displayName = name
+	}
+	organization := Organization{
+		Name:        name,
+		DisplayName: displayName,
+	}
+	for _, prefix := range ipPrefixes {
+		organization.IPPrefixes = append(organization.IPPrefixes, prefix.String())
+	}
+	if err := h.db.Create(&organization).Error; err != nil {
+		return nil, fmt.Errorf("failed to save organization in the database: %w", err)
+	}
+
+	log.Info().
+		Str("organization", name).
+		Strs("ip_prefixes", organization.IPPrefixes).
+		Msg("Organization created")
+
+	return &organization, nil
+}
+
+// checkOrganizationPrefixes returns ErrOrganizationPrefixOverlap if the
+// prefixes overlap with the global ones or the ones of another
+// organization than organizationID.
+func (h *Headscale) checkOrganizationPrefixes(organizationID uint, ipPrefixes []netip.Prefix) error {
+	taken := append([]netip.Prefix{}, h.cfg.IPPrefixes...)
+
+	organizations, err := h.ListOrganizations()
+	if err != nil {
+		return err
+	}
+	for _, organization := range organizations {
+		if organization.ID == organizationID {
+			continue
+		}
+		taken = append(taken, organization.ipPrefixes()...)
+	}
+
+	for _, prefix := range ipPrefixes {
+		for _, other := range taken {
+			if prefix.Overlaps(other) {
+				return fmt.Errorf("%w: %s and %s", ErrOrganizationPrefixOverlap, prefix, other)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetOrganization returns the Organization named name.
+func (h *Headscale) GetOrganization(name string) (*Organization, error) {
+	organization := Organization{}
+	if err := h.db.First(&organization, "name = ?", name).Error; errors.Is(
+		err,
+		gorm.ErrRecordNotFound,
+	) {
+		return nil, ErrOrganizationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &organization, nil
+}
+
+func (h *Headscale) getOrganizationByID(id uint) (*Organization, error) {
+	organization := Organization{}
+	if err := h.db.First(&organization, id).Error; errors.Is(
+		err,
+		gorm.ErrRecordNotFound,
+	) {
+		return nil, ErrOrganizationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &organization, nil
+}
+
+// ListOrganizations returns all the organizations, the default one first.
+func (h *Headscale) ListOrganizations() ([]Organization, error) {
+	organizations := []Organization{}
+	if err := h.db.Order("is_default DESC, id").Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+
+	return organizations, nil
+}
+
+// DeleteOrganization removes an Organization without users.
+func (h *Headscale) DeleteOrganization(name string) error {
+	organization, err := h.GetOrganization(name)
+	if err != nil {
+		return err
+	}
+	if organization.IsDefault {
+		return ErrOrganizationIsDefault
+	}
+
+	var users int64
+	if err := h.db.Model(&User{}).Where("organization_id = ?", organization.ID).Count(&users).Error; err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrOrganizationNotEmpty
+	}
+
+	if err := h.db.Where("organization_id = ?", organization.ID).Delete(&Invite{}).Error; err != nil {
+		return err
+	}
+	if err := h.db.Unscoped().Delete(organization).Error; err != nil {
+		return err
+	}
+
+	log.Info().Str("organization", name).Msg("Organization deleted")
+
+	return nil
+}
+
+// SetOrganizationPolicy replaces the policy of an Organization, an empty
+// policy falls back to the global one.
+func (h *Headscale) SetOrganizationPolicy(name string, policy string) error {
+	organization, err := h.GetOrganization(name)
+	if err != nil {
+		return err
+	}
+
+	if policy != "" {
+		if _, err := parseACLPolicy([]byte(policy), ""); err != nil {
+			return err
+		}
+	}
+
+	if err := h.db.Model(organization).Update("acl_policy", policy).Error; err != nil {
+		return err
+	}
+
+	if err := h.UpdateACLRules(); err != nil && !errors.Is(err, errEmptyPolicy) {
+		return err
+	}
+	h.setLastStateChangeToNow()
+
+	log.Info().Str("organization", name).Msg("Organization policy updated")
+
+	return nil
+}
+
+// SetUserOrganization moves a User without machines to another
+// Organization, the addresses of the machines depending on it.
+func (h *Headscale) SetUserOrganization(userName string, organizationName string) (*User, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+	organization, err := h.GetOrganization(organizationName)
+	if err != nil {
+		return nil, err
+	}
+	if user.OrganizationID == organization.ID {
+		return user, nil
+	}
+
+	var machines int64
+	if err := h.db.Model(&Machine{}).Where("user_id = ?", user.ID).Count(&machines).Error; err != nil {
+		return nil, err
+	}
+	if machines > 0 {
+		return nil, ErrUserStillHasNodes
+	}
+
+	// The roles do not carry over to the other organization
+	user.OrganizationID = organization.ID
+	user.Role = ""
+	user.RoleSynced = false
+	if err := h.db.Model(user).Updates(map[string]interface{}{
+		"organization_id": user.OrganizationID,
+		"role":            user.Role,
+		"role_synced":     user.RoleSynced,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if _, err := h.RevokeUserConsoleSessions(user.Name); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Str("organization", organization.Name).
+		Msg("User moved to another organization")
+
+	return user, nil
+}
+
+// organizationPolicy returns the policy of an Organization, the global
+// one when it has none, or nil.
+func (h *Headscale) organizationPolicy(organization *Organization) (*ACLPolicy, error) {
+	if organization.ACLPolicy == "" {
+		return h.aclPolicy, nil
+	}
+
+	policy, err := parseACLPolicy([]byte(organization.ACLPolicy), "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy of organization %s: %w", organization.Name, err)
+	}
+
+	return policy, nil
+}
+
+func (organization *Organization) ipPrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(organization.IPPrefixes))
+	for _, prefix := range organization.IPPrefixes {
+		if parsed, err := netip.ParsePrefix(prefix); err == nil {
+			prefixes = append(prefixes, parsed)
+		}
+	}
+
+	return prefixes
+}
+
+// organizationIPPrefixes returns the prefixes the machines of an
+// Organization get their addresses from.
+func (h *Headscale) organizationIPPrefixes(organizationID uint) []netip.Prefix {
+	organization, err := h.getOrganizationByID(organizationID)
+	if err != nil || len(organization.IPPrefixes) == 0 {
+		return h.cfg.IPPrefixes
+	}
+
+	return organization.ipPrefixes()
+}
+
+// organizationRole returns the role of a User in an Organization: their
+// own role in their organization, and owner everywhere for the owners of
+// the default organization, who host the others.
+func (h *Headscale) organizationRole(user *User, organizationID uint) (Role, bool) {
+	role := h.userRole(user)
+	if user.OrganizationID == organizationID {
+		return role, true
+	}
+	if user.OrganizationID == h.defaultOrganizationID && role == RoleOwner {
+		return RoleOwner, true
+	}
+
+	return "", false
+}
+
+// ListOrganizationMachines returns the machines of the users of an Organization.
+func (h *Headscale) ListOrganizationMachines(organizationID uint) ([]Machine, error) {
+	machines := []Machine{}
+	if err := h.db.Preload("AuthKey").Preload("AuthKey.User").Preload("User").
+		Where("user_id IN (?)", h.db.Model(&User{}).Select("id").Where("organization_id = ?", organizationID)).
+		Find(&machines).Error; err != nil {
+		return nil, err
+	}
+
+	return machines, nil
+}
+
+// ListOrganizationUsers returns the users of an Organization.
+func (h *Headscale) ListOrganizationUsers(organizationID uint) ([]User, error) {
+	users := []User{}
+	if err := h.db.Where("organization_id = ?", organizationID).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// machineOrganizationID returns the Organization of the owner of a machine.
+func (h *Headscale) machineOrganizationID(machine *Machine) uint {
+	if machine.User.ID != 0 && machine.User.ID == machine.UserID {
+		return machine.User.OrganizationID
+	}
+
+	user := User{}
+	if err := h.db.Select("organization_id").First(&user, machine.UserID).Error; err != nil {
+		return h.defaultOrganizationID
+	}
+
+	return user.OrganizationID
+}
+
+func (organization *Organization) toProto() *v1.Organization {
+	return &v1.Organization{
+		Id:          strconv.FormatUint(uint64(organization.ID), Base10),
+		Name:        organization.Name,
+		DisplayName: organization.DisplayName,
+		IpPrefixes:  organization.IPPrefixes,
+		HasPolicy:   organization.ACLPolicy != "",
+		IsDefault:   organization.IsDefault,
+		CreatedAt:   timestamppb.New(organization.CreatedAt),
+
+		BaseDomain:    organization.BaseDomain,
+		OmitUserLabel: organization.OmitUserLabel,
+	}
+}
@@ -0,0 +1,187 @@
+package headscale
+
+import (
+	"errors"
+	"net/netip"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *Suite) TestCreateOrganization(c *check.C) {
+	organizations, err := app.ListOrganizations()
+	c.Assert(err, check.IsNil)
+	c.Assert(organizations, check.HasLen, 1)
+	c.Assert(organizations[0].IsDefault, check.Equals, true)
+	c.Assert(organizations[0].ID, check.Equals, app.defaultOrganizationID)
+
+	acme, err := app.CreateOrganization(
+		"acme", "ACME",
+		[]netip.Prefix{netip.MustParsePrefix("100.80.0.0/16")},
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(acme.DisplayName, check.Equals, "ACME")
+	c.Assert(app.organizationIPPrefixes(acme.ID), check.DeepEquals,
+		[]netip.Prefix{netip.MustParsePrefix("100.80.0.0/16")})
+
+	_, err = app.CreateOrganization("acme", "", nil)
+	c.Assert(err, check.Equals, ErrOrganizationExists)
+
+	// The prefixes keep the addresses unique across the organizations
+	_, err = app.CreateOrganization(
+		"globex", "",
+		[]netip.Prefix{netip.MustParsePrefix("10.27.1.0/24")},
+	)
+	c.Assert(errors.Is(err, ErrOrganizationPrefixOverlap), check.Equals, true)
+	_, err = app.CreateOrganization(
+		"globex", "",
+		[]netip.Prefix{netip.MustParsePrefix("100.80.10.0/24")},
+	)
+	c.Assert(errors.Is(err, ErrOrganizationPrefixOverlap), check.Equals, true)
+
+	user, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(user.OrganizationID, check.Equals, app.defaultOrganizationID)
+	_, err = app.SetUserOrganization("alice", "acme")
+	c.Assert(err, check.IsNil)
+
+	err = app.DeleteOrganization(defaultOrganizationName)
+	c.Assert(err, check.Equals, ErrOrganizationIsDefault)
+	err = app.DeleteOrganization("acme")
+	c.Assert(err, check.Equals, ErrOrganizationNotEmpty)
+
+	_, err = app.SetUserOrganization("alice", defaultOrganizationName)
+	c.Assert(err, check.IsNil)
+	err = app.DeleteOrganization("acme")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *Suite) TestOrganizationIsolation(c *check.C) {
+	acme, err := app.CreateOrganization(
+		"acme", "",
+		[]netip.Prefix{netip.MustParsePrefix("100.80.0.0/16")},
+	)
+	c.Assert(err, check.IsNil)
+
+	alice, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	bob, err := app.CreateUser("bob", "bob-uid", "Bob")
+	c.Assert(err, check.IsNil)
+	carol, err := app.CreateUser("carol", "carol-uid", "Carol")
+	c.Assert(err, check.IsNil)
+	_, err = app.SetUserOrganization("carol", "acme")
+	c.Assert(err, check.IsNil)
+
+	for index, user := range []*User{alice, bob, carol} {
+		user, err := app.GetUser(user.Name)
+		c.Assert(err, check.IsNil)
+		ips, err := app.getAvailableIPsIn(app.organizationIPPrefixes(user.OrganizationID))
+		c.Assert(err, check.IsNil)
+		machine := Machine{
+			MachineKey:     "machine-key-" + user.Name,
+			NodeKey:        "node-key-" + user.Name,
+			DiscoKey:       "disco-key-" + user.Name,
+			Hostname:       "machine-" + user.Name,
+			GivenName:      "machine-" + user.Name,
+			UserID:         user.ID,
+			RegisterMethod: RegisterMethodAuthKey,
+			IPAddresses:    ips,
+		}
+		machine.ID = uint64(index + 1)
+		app.db.Save(&machine)
+	}
+
+	carolMachine, err := app.GetMachine("carol", "machine-carol")
+	c.Assert(err, check.IsNil)
+	c.Assert(carolMachine.IPAddresses[0].String(), check.Equals, "100.80.0.1")
+
+	aliceMachine, err := app.GetMachine("alice", "machine-alice")
+	c.Assert(err, check.IsNil)
+	peers, err := app.ListPeers(aliceMachine)
+	c.Assert(err, check.IsNil)
+	c.Assert(peers, check.HasLen, 1)
+	c.Assert(peers[0].User.Name, check.Equals, "bob")
+
+	peers, err = app.ListPeers(carolMachine)
+	c.Assert(err, check.IsNil)
+	c.Assert(peers, check.HasLen, 0)
+
+	// The machines stay with the users of their organization
+	c.Assert(app.SetMachineUser(carolMachine, "alice"), check.Equals, ErrOrganizationMismatch)
+	_, err = app.DeleteUser("carol", "bob", false)
+	c.Assert(err, check.Equals, ErrOrganizationMismatch)
+	_, err = app.SetUserOrganization("carol", defaultOrganizationName)
+	c.Assert(err, check.Equals, ErrUserStillHasNodes)
+
+	// The policy of an organization only sees its own machines
+	err = app.SetOrganizationPolicy("acme", `{
+		"acls": [{"action": "accept", "src": ["carol"], "dst": ["*:*"]}]
+	}`)
+	c.Assert(err, check.IsNil)
+	rules := app.aclFor(acme.ID).rules
+	c.Assert(rules, check.HasLen, 1)
+	c.Assert(rules[0].SrcIPs, check.DeepEquals, []string{"100.80.0.1"})
+	c.Assert(app.aclFor(app.defaultOrganizationID).policy, check.IsNil)
+
+	err = app.SetOrganizationPolicy("acme", `{"acls": [`)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *Suite) TestOrganizationRole(c *check.C) {
+	_, err := app.CreateOrganization("acme", "", nil)
+	c.Assert(err, check.IsNil)
+	acme, err := app.GetOrganization("acme")
+	c.Assert(err, check.IsNil)
+
+	alice, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	_, err = app.CreateUser("carol", "carol-uid", "Carol")
+	c.Assert(err, check.IsNil)
+	carol, err := app.SetUserOrganization("carol", "acme")
+	c.Assert(err, check.IsNil)
+	carol, err = app.SetUserRole(RoleOwner, carol.Name, RoleOwner)
+	c.Assert(err, check.IsNil)
+
+	_, ok := app.organizationRole(alice, acme.ID)
+	c.Assert(ok, check.Equals, false)
+	c.Assert(app.userCan(alice, carol, PermissionReadTailnet), check.Equals, false)
+
+	// The owners of the default organization host the others
+	alice, err = app.SetUserRole(RoleOwner, alice.Name, RoleOwner)
+	c.Assert(err, check.IsNil)
+	role, ok := app.organizationRole(alice, acme.ID)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(role, check.Equals, RoleOwner)
+
+	_, ok = app.organizationRole(carol, app.defaultOrganizationID)
+	c.Assert(ok, check.Equals, false)
+	c.Assert(app.userCan(carol, alice, PermissionReadTailnet), check.Equals, false)
+
+	// Each organization keeps its own owner
+	_, err = app.SetUserRole(RoleOwner, carol.Name, RoleAdmin)
+	c.Assert(err, check.Equals, ErrLastOwner)
+}
+
+func (s *Suite) TestOrganizationPolicyAfterRestart(c *check.C) {
+	acme, err := app.CreateOrganization("acme", "", nil)
+	c.Assert(err, check.IsNil)
+	err = app.SetOrganizationPolicy("acme", `{
+		"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}]
+	}`)
+	c.Assert(err, check.IsNil)
+
+	// Without a policy file, the stored policy applies after a restart
+	restarted, err := NewHeadscale(&Config{
+		PrivateKeyPath:      tmpDir + "/private.key",
+		NoisePrivateKeyPath: tmpDir + "/noise_private.key",
+		DBtype:              Sqlite,
+		DBpath:              tmpDir + "/headscale_test.db",
+		IPPrefixes:          app.cfg.IPPrefixes,
+		SMS:                 SMSConfig{Provider: SMSProviderFile, LogPath: tmpDir + "/sms.log"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(restarted.aclFor(acme.ID).policy, check.NotNil)
+	rules := restarted.aclFor(acme.ID).rules
+	c.Assert(rules, check.HasLen, 1)
+	c.Assert(rules[0].DstPorts[0].Ports.First, check.Equals, uint16(22))
+	c.Assert(restarted.aclFor(app.defaultOrganizationID).policy, check.IsNil)
+}
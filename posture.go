@@ -0,0 +1,225 @@
+package headscale
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	errInvalidPostureAction  = Error("invalid posture action")
+	errInvalidPostureVersion = Error("invalid posture minVersion")
+	errInvalidPostureName    = Error("posture name is required")
+)
+
+const (
+	// PostureActionBlock refuses the registration and the map requests
+	// of a machine failing the posture check.
+	PostureActionBlock = "block"
+	// PostureActionQuarantine keeps the machine connected, but it gets
+	// no peers and is hidden from every other machine.
+	PostureActionQuarantine = "quarantine"
+
+	postureReasonSeparator = "; "
+)
+
+// validatePostures checks the posture rules of a policy before it is used.
+func validatePostures(postures []Posture) error {
+	for _, posture := range postures {
+		if posture.Name == "" {
+			return errInvalidPostureName
+		}
+
+		switch posture.Action {
+		case PostureActionBlock, PostureActionQuarantine:
+		default:
+			return fmt.Errorf("%w: %q in posture %s", errInvalidPostureAction, posture.Action, posture.Name)
+		}
+
+		if posture.MinVersion != "" {
+			if _, ok := parseClientVersion(posture.MinVersion); !ok {
+				return fmt.Errorf("%w: %q in posture %s", errInvalidPostureVersion, posture.MinVersion, posture.Name)
+			}
+		}
+
+		if posture.Hostname != "" {
+			if _, err := regexp.Compile(posture.Hostname); err != nil {
+				return fmt.Errorf("invalid hostname pattern in posture %s: %w", posture.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// evaluatePostures returns the strongest action of all the posture rules the
+// given Hostinfo fails, along with the reasons. An empty action means the
+// machine is compliant.
+func evaluatePostures(postures []Posture, hostInfo *tailcfg.Hostinfo) (string, string) {
+	if hostInfo == nil {
+		hostInfo = &tailcfg.Hostinfo{}
+	}
+
+	action := ""
+	reasons := []string{}
+	for _, posture := range postures {
+		reason := checkPosture(posture, hostInfo)
+		if reason == "" {
+			continue
+		}
+
+		reasons = append(reasons, fmt.Sprintf("%s: %s", posture.Name, reason))
+		if action != PostureActionBlock {
+			action = posture.Action
+		}
+	}
+
+	return action, strings.Join(reasons, postureReasonSeparator)
+}
+
+// checkPosture returns why hostInfo does not comply with posture,
+// or an empty string if it does.
+func checkPosture(posture Posture, hostInfo *tailcfg.Hostinfo) string {
+	if len(posture.OS) > 0 {
+		allowed := false
+		for _, os := range posture.OS {
+			if strings.EqualFold(os, hostInfo.OS) {
+				allowed = true
+
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("os %q is not allowed", hostInfo.OS)
+		}
+	}
+
+	if posture.MinVersion != "" {
+		minVersion, _ := parseClientVersion(posture.MinVersion)
+		version, ok := parseClientVersion(hostInfo.IPNVersion)
+		if !ok || compareClientVersions(version, minVersion) < 0 {
+			return fmt.Sprintf(
+				"client version %q is older than %s",
+				hostInfo.IPNVersion,
+				posture.MinVersion,
+			)
+		}
+	}
+
+	if posture.Hostname != "" {
+		matched, err := regexp.MatchString(posture.Hostname, hostInfo.Hostname)
+		if err != nil || !matched {
+			return fmt.Sprintf("hostname %q does not match %s", hostInfo.Hostname, posture.Hostname)
+		}
+	}
+
+	return ""
+}
+
+// parseClientVersion extracts the numeric part of a Tailscale version,
+// e.g. 1.36.0 from 1.36.0-t2bd5e8a4f-g1234567.
+func parseClientVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if index := strings.IndexAny(version, "-+"); index >= 0 {
+		version = version[:index]
+	}
+	if version == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(version, ".")
+	numbers := make([]int, len(parts))
+	for index, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		numbers[index] = number
+	}
+
+	return numbers, true
+}
+
+func compareClientVersions(a, b []int) int {
+	for index := 0; index < len(a) || index < len(b); index++ {
+		var partA, partB int
+		if index < len(a) {
+			partA = a[index]
+		}
+		if index < len(b) {
+			partB = b[index]
+		}
+		if partA != partB {
+			if partA < partB {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// checkMachinePosture evaluates the posture rules of the policy of an
+// organization.
+func (h *Headscale) checkMachinePosture(organizationID uint, hostInfo *tailcfg.Hostinfo) (string, string) {
+	aclPolicy := h.aclFor(organizationID).policy
+	if aclPolicy == nil || len(aclPolicy.Postures) == 0 {
+		return "", ""
+	}
+
+	return evaluatePostures(aclPolicy.Postures, hostInfo)
+}
+
+// registrationOrganizationID returns the organization a new machine joins,
+// the one of the user of its auth key. Without a valid key the user is
+// not known before the interactive login, the default organization applies.
+func (h *Headscale) registrationOrganizationID(registerRequest tailcfg.RegisterRequest) uint {
+	if registerRequest.Auth.AuthKey == "" {
+		return h.defaultOrganizationID
+	}
+
+	pak, err := h.getPreAuthKeyByKey(registerRequest.Auth.AuthKey)
+	if err != nil {
+		return h.defaultOrganizationID
+	}
+
+	return pak.User.OrganizationID
+}
+
+// setMachinePosture stores the result of a posture check and notifies
+// the other machines when the machine enters or leaves quarantine.
+func (h *Headscale) setMachinePosture(machine *Machine, action string, reason string) error {
+	if machine.PostureAction == action && machine.PostureReason == reason {
+		return nil
+	}
+
+	log.Info().
+		Str("machine", machine.Hostname).
+		Str("action", action).
+		Str("reason", reason).
+		Msg("Machine posture changed")
+
+	if err := h.db.Model(machine).UpdateColumns(map[string]interface{}{
+		"posture_action": action,
+		"posture_reason": reason,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update machine posture in the database: %w", err)
+	}
+
+	machine.PostureAction = action
+	machine.PostureReason = reason
+	h.setLastStateChangeToNow()
+
+	return nil
+}
+
+// isPostureRestricted returns if the machine is blocked or in quarantine.
+func (machine Machine) isPostureRestricted() bool {
+	return machine.PostureAction != ""
+}
@@ -0,0 +1,210 @@
+package headscale
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/check.v1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func Test_evaluatePostures(t *testing.T) {
+	postures := []Posture{
+		{
+			Name:   "managed-os",
+			Action: PostureActionBlock,
+			OS:     []string{"linux", "windows"},
+		},
+		{
+			Name:       "recent-client",
+			Action:     PostureActionQuarantine,
+			MinVersion: "1.36.0",
+		},
+		{
+			Name:     "naming",
+			Action:   PostureActionQuarantine,
+			Hostname: "^prod-",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		hostInfo   *tailcfg.Hostinfo
+		wantAction string
+		wantReason string
+	}{
+		{
+			name: "compliant",
+			hostInfo: &tailcfg.Hostinfo{
+				OS:         "Linux",
+				IPNVersion: "1.36.2-t1234567-g7654321",
+				Hostname:   "prod-db",
+			},
+			wantAction: "",
+			wantReason: "",
+		},
+		{
+			name: "old client is quarantined",
+			hostInfo: &tailcfg.Hostinfo{
+				OS:         "windows",
+				IPNVersion: "1.34.0",
+				Hostname:   "prod-web",
+			},
+			wantAction: PostureActionQuarantine,
+			wantReason: `recent-client: client version "1.34.0" is older than 1.36.0`,
+		},
+		{
+			name: "block wins over quarantine",
+			hostInfo: &tailcfg.Hostinfo{
+				OS:         "android",
+				IPNVersion: "1.36.0",
+				Hostname:   "phone",
+			},
+			wantAction: PostureActionBlock,
+			wantReason: `managed-os: os "android" is not allowed; naming: hostname "phone" does not match ^prod-`,
+		},
+		{
+			name:       "missing hostinfo",
+			hostInfo:   nil,
+			wantAction: PostureActionBlock,
+			wantReason: `managed-os: os "" is not allowed; ` +
+				`recent-client: client version "" is older than 1.36.0; ` +
+				`naming: hostname "" does not match ^prod-`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			action, reason := evaluatePostures(postures, test.hostInfo)
+			if action != test.wantAction {
+				t.Errorf("evaluatePostures() action = %v, want %v", action, test.wantAction)
+			}
+			if reason != test.wantReason {
+				t.Errorf("evaluatePostures() reason = %v, want %v", reason, test.wantReason)
+			}
+		})
+	}
+}
+
+func Test_validatePostures(t *testing.T) {
+	tests := []struct {
+		name     string
+		postures []Posture
+		wantErr  bool
+	}{
+		{
+			name:     "valid",
+			postures: []Posture{{Name: "os", Action: PostureActionBlock, OS: []string{"linux"}}},
+			wantErr:  false,
+		},
+		{
+			name:     "missing name",
+			postures: []Posture{{Action: PostureActionBlock}},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown action",
+			postures: []Posture{{Name: "os", Action: "deny"}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid version",
+			postures: []Posture{{Name: "version", Action: PostureActionBlock, MinVersion: "latest"}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid hostname pattern",
+			postures: []Posture{{Name: "name", Action: PostureActionBlock, Hostname: "prod-("}},
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validatePostures(test.postures); (err != nil) != test.wantErr {
+				t.Errorf("validatePostures() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func (s *Suite) TestQuarantinedMachineHasNoPeers(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	for index, hostname := range []string{"compliant", "quarantined"} {
+		machine := Machine{
+			ID:             uint64(index + 1),
+			MachineKey:     "machine-" + hostname,
+			NodeKey:        "node-" + hostname,
+			DiscoKey:       "disco-" + hostname,
+			Hostname:       hostname,
+			UserID:         user.ID,
+			RegisterMethod: RegisterMethodAuthKey,
+		}
+		app.db.Save(&machine)
+	}
+
+	compliant, err := app.GetMachineByID(1)
+	c.Assert(err, check.IsNil)
+	quarantined, err := app.GetMachineByID(2)
+	c.Assert(err, check.IsNil)
+
+	peers, _, err := app.getValidPeers(compliant)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(peers), check.Equals, 1)
+
+	err = app.setMachinePosture(quarantined, PostureActionQuarantine, "naming: hostname does not match")
+	c.Assert(err, check.IsNil)
+
+	quarantined, err = app.GetMachineByID(2)
+	c.Assert(err, check.IsNil)
+	c.Assert(quarantined.PostureAction, check.Equals, PostureActionQuarantine)
+
+	peers, _, err = app.getValidPeers(compliant)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(peers), check.Equals, 0)
+
+	peers, _, err = app.getValidPeers(quarantined)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(peers), check.Equals, 0)
+
+	err = app.setMachinePosture(quarantined, "", "")
+	c.Assert(err, check.IsNil)
+
+	quarantined, err = app.GetMachineByID(2)
+	c.Assert(err, check.IsNil)
+	c.Assert(quarantined.PostureAction, check.Equals, "")
+}
+
+func (s *Suite) TestRegistrationPosture(c *check.C) {
+	acme, err := app.CreateOrganization("acme", "", nil)
+	c.Assert(err, check.IsNil)
+	user, err := app.CreateUser("alice", "alice", "Alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(app.db.Model(user).Update("organization_id", acme.ID).Error, check.IsNil)
+	err = app.SetOrganizationPolicy("acme", `{
+		"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}],
+		"postures": [{"name": "managed-os", "action": "block", "os": ["linux"]}]
+	}`)
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKey(user.Name, false, false, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	// The machine joins the organization of the user of its key
+	registerRequest := tailcfg.RegisterRequest{}
+	c.Assert(app.registrationOrganizationID(registerRequest), check.Equals, app.defaultOrganizationID)
+	registerRequest.Auth.AuthKey = pak.Key
+	c.Assert(app.registrationOrganizationID(registerRequest), check.Equals, acme.ID)
+
+	action, _ := app.checkMachinePosture(app.registrationOrganizationID(registerRequest), nil)
+	c.Assert(action, check.Equals, PostureActionBlock)
+
+	// Clients may leave the Hostinfo out
+	recorder := httptest.NewRecorder()
+	app.handlePostureBlockedCommon(recorder, registerRequest, "managed-os", key.MachinePublic{}, true)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
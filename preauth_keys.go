@@ -10,22 +10,42 @@ import (
 	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 )
 
 const (
+	// preAuthKeyPrefixLength is the length of the part of a key kept in
+	// clear to find it, the rest is only stored hashed.
+	preAuthKeyPrefixLength = 12
+
 	ErrPreAuthKeyNotFound          = Error("AuthKey not found")
 	ErrPreAuthKeyExpired           = Error("AuthKey expired")
 	ErrSingleUseAuthKeyHasBeenUsed = Error("AuthKey has already been used")
 	ErrUserMismatch                = Error("user mismatch")
 	ErrPreAuthKeyACLTagInvalid     = Error("AuthKey tag is invalid")
+	ErrPreAuthKeyRevoked           = Error("AuthKey has been revoked")
+	ErrPreAuthKeyExhausted         = Error("AuthKey has reached its maximum number of uses")
+	ErrPreAuthKeyFailedToParse     = Error("Failed to parse AuthKey")
+)
+
+// Reasons a PreAuthKey can no longer be used, see PreAuthKey.invalidReason.
+const (
+	PreAuthKeyRevoked   = "revoked"
+	PreAuthKeyExpired   = "expired"
+	PreAuthKeyExhausted = "exhausted"
 )
 
 // PreAuthKey describes a pre-authorization key usable in a particular user.
 type PreAuthKey struct {
-	ID        uint64 `gorm:"primary_key"`
-	Key       string
+	ID uint64 `gorm:"primary_key"`
+	// Key is only set on the key returned by its creation, the database
+	// keeps the prefix of the key and a hash of the rest.
+	Key       string `gorm:"-"`
+	Prefix    string `gorm:"index"`
+	Hash      []byte
 	UserID    uint
 	User      User
 	Reusable  bool
@@ -33,8 +53,22 @@ type PreAuthKey struct {
 	Used      bool `gorm:"default:false"`
 	ACLTags   []PreAuthKeyACLTag
 
+	Description string
+	// MaxUses limits the registrations with a reusable key, 0 for no limit.
+	// A key that is not reusable is used once.
+	MaxUses  uint
+	UseCount uint `gorm:"default:0"`
+
 	CreatedAt  *time.Time
 	Expiration *time.Time
+	LastUsed   *time.Time
+	RevokedAt  *time.Time
+}
+
+// PreAuthKeyOptions are the optional settings of a new PreAuthKey.
+type PreAuthKeyOptions struct {
+	Description string
+	MaxUses     uint
 }
 
 // PreAuthKeyACLTag describes an autmatic tag applied to a node when registered with the associated PreAuthKey.
@@ -51,32 +85,76 @@ func (h *Headscale) CreatePreAuthKey(
 	ephemeral bool,
 	expiration *time.Time,
 	aclTags []string,
+) (*PreAuthKey, error) {
+	return h.CreatePreAuthKeyWithOptions(
+		userName,
+		reusable,
+		ephemeral,
+		expiration,
+		aclTags,
+		PreAuthKeyOptions{},
+	)
+}
+
+// CreatePreAuthKeyWithOptions creates a new PreAuthKey in a user with a
+// description and a maximum number of uses, and returns it.
+func (h *Headscale) CreatePreAuthKeyWithOptions(
+	userName string,
+	reusable bool,
+	ephemeral bool,
+	expiration *time.Time,
+	aclTags []string,
+	options PreAuthKeyOptions,
 ) (*PreAuthKey, error) {
 	user, err := h.GetUser(userName)
 	if err != nil {
 		return nil, err
 	}
 
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+
 	for _, tag := range aclTags {
 		if !strings.HasPrefix(tag, "tag:") {
 			return nil, fmt.Errorf("%w: '%s' did not begin with 'tag:'", ErrPreAuthKeyACLTagInvalid, tag)
 		}
 	}
 
+	// A key usable more than once is reusable, up to its maximum
+	if options.MaxUses > 1 {
+		reusable = true
+	}
+
+	if reusable {
+		if err := h.checkPreAuthKeyQuota(user); err != nil {
+			return nil, err
+		}
+	}
+
 	now := time.Now().UTC()
 	kstr, err := h.generateKey()
 	if err != nil {
 		return nil, err
 	}
 
+	hash, err := hashPreAuthKey(kstr)
+	if err != nil {
+		return nil, err
+	}
+
 	key := PreAuthKey{
-		Key:        kstr,
-		UserID:     user.ID,
-		User:       *user,
-		Reusable:   reusable,
-		Ephemeral:  ephemeral,
-		CreatedAt:  &now,
-		Expiration: expiration,
+		Key:         kstr,
+		Prefix:      kstr[:preAuthKeyPrefixLength],
+		Hash:        hash,
+		UserID:      user.ID,
+		User:        *user,
+		Reusable:    reusable,
+		Ephemeral:   ephemeral,
+		Description: options.Description,
+		MaxUses:     options.MaxUses,
+		CreatedAt:   &now,
+		Expiration:  expiration,
 	}
 
 	err = h.db.Transaction(func(db *gorm.DB) error {
@@ -125,8 +203,36 @@ func (h *Headscale) ListPreAuthKeys(userName string) ([]PreAuthKey, error) {
 	return keys, nil
 }
 
-// GetPreAuthKey returns a PreAuthKey for a given key.
+// ListPreAuthKeyMachines returns the machines registered with each of the
+// keys, by key ID.
+func (h *Headscale) ListPreAuthKeyMachines(keys []PreAuthKey) (map[uint64][]Machine, error) {
+	ids := make([]uint, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, uint(key.ID))
+	}
+
+	machines := []Machine{}
+	if len(ids) > 0 {
+		if err := h.db.Where("auth_key_id IN ?", ids).Order("id").Find(&machines).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	byKey := make(map[uint64][]Machine, len(keys))
+	for _, machine := range machines {
+		byKey[uint64(machine.AuthKeyID)] = append(byKey[uint64(machine.AuthKeyID)], machine)
+	}
+
+	return byKey, nil
+}
+
+// GetPreAuthKey returns a PreAuthKey for a given key, or for the prefix
+// of a key as listed.
 func (h *Headscale) GetPreAuthKey(user string, key string) (*PreAuthKey, error) {
+	if len(key) == preAuthKeyPrefixLength {
+		return h.getPreAuthKeyByPrefix(user, key)
+	}
+
 	pak, err := h.checkKeyValidity(key)
 	if err != nil {
 		return nil, err
@@ -139,6 +245,67 @@ func (h *Headscale) GetPreAuthKey(user string, key string) (*PreAuthKey, error)
 	return pak, nil
 }
 
+func (h *Headscale) getPreAuthKeyByPrefix(userName string, prefix string) (*PreAuthKey, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []PreAuthKey{}
+	if err := h.db.Preload("User").Preload("ACLTags").
+		Where(&PreAuthKey{UserID: user.ID, Prefix: prefix}).
+		Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, ErrPreAuthKeyNotFound
+	}
+
+	return &keys[0], nil
+}
+
+// getPreAuthKeyByKey returns the PreAuthKey matching a full key, whether it
+// can still be used or not.
+func (h *Headscale) getPreAuthKeyByKey(k string) (*PreAuthKey, error) {
+	if len(k) <= preAuthKeyPrefixLength {
+		return nil, ErrPreAuthKeyNotFound
+	}
+
+	candidates := []PreAuthKey{}
+	if err := h.db.Preload("User").Preload("ACLTags").
+		Where(&PreAuthKey{Prefix: k[:preAuthKeyPrefixLength]}).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	for index := range candidates {
+		if bcrypt.CompareHashAndPassword(candidates[index].Hash, []byte(k[preAuthKeyPrefixLength:])) == nil {
+			return &candidates[index], nil
+		}
+	}
+
+	return nil, ErrPreAuthKeyNotFound
+}
+
+// FindPreAuthKey returns the PreAuthKey of a user for a given key, or for
+// the prefix of a key as listed, whether it can still be used or not.
+func (h *Headscale) FindPreAuthKey(user string, key string) (*PreAuthKey, error) {
+	if len(key) == preAuthKeyPrefixLength {
+		return h.getPreAuthKeyByPrefix(user, key)
+	}
+
+	pak, err := h.getPreAuthKeyByKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if pak.User.Name != user {
+		return nil, ErrUserMismatch
+	}
+
+	return pak, nil
+}
+
 // DestroyPreAuthKey destroys a preauthkey. Returns error if the PreAuthKey
 // does not exist.
 func (h *Headscale) DestroyPreAuthKey(pak PreAuthKey) error {
@@ -155,6 +322,31 @@ func (h *Headscale) DestroyPreAuthKey(pak PreAuthKey) error {
 	})
 }
 
+// RegeneratePreAuthKey replaces the secret of a PreAuthKey, whose previous
+// key stops working, and sets the new one on k. Only the hash of a key is
+// stored, this is the way to show a key again.
+func (h *Headscale) RegeneratePreAuthKey(k *PreAuthKey) error {
+	kstr, err := h.generateKey()
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashPreAuthKey(kstr)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Model(k).Updates(map[string]interface{}{
+		"prefix": kstr[:preAuthKeyPrefixLength],
+		"hash":   hash,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update key in the database: %w", err)
+	}
+	k.Key = kstr
+
+	return nil
+}
+
 // MarkExpirePreAuthKey marks a PreAuthKey as expired.
 func (h *Headscale) ExpirePreAuthKey(k *PreAuthKey) error {
 	if err := h.db.Model(&k).Update("Expiration", time.Now()).Error; err != nil {
@@ -164,12 +356,156 @@ func (h *Headscale) ExpirePreAuthKey(k *PreAuthKey) error {
 	return nil
 }
 
-// UsePreAuthKey marks a PreAuthKey as used.
+// RevokePreAuthKey revokes a PreAuthKey, which is kept to show why it can
+// no longer be used.
+func (h *Headscale) RevokePreAuthKey(k *PreAuthKey) error {
+	now := time.Now().UTC()
+	if err := h.db.Model(k).Update("revoked_at", &now).Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokePreAuthKeyReport lists the machines taken down along with a key by
+// RevokePreAuthKeyCascade.
+type RevokePreAuthKeyReport struct {
+	ExpiredMachines []uint64
+	DeletedMachines []uint64
+	DeletedRoutes   []uint64
+}
+
+// RevokePreAuthKeyCascade revokes a PreAuthKey, a leaked one typically, and
+// expires the machines registered with it, or deletes them along with their
+// routes if deleteMachines is set. The machines already expired are left
+// as they are, unless deleted.
+func (h *Headscale) RevokePreAuthKeyCascade(
+	k *PreAuthKey,
+	deleteMachines bool,
+) (*RevokePreAuthKeyReport, error) {
+	machines := []Machine{}
+	if err := h.db.Where("auth_key_id = ?", k.ID).Order("id").Find(&machines).Error; err != nil {
+		return nil, err
+	}
+
+	report := RevokePreAuthKeyReport{
+		ExpiredMachines: []uint64{},
+		DeletedMachines: []uint64{},
+		DeletedRoutes:   []uint64{},
+	}
+
+	now := time.Now().UTC()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(k).Update("revoked_at", &now).Error; err != nil {
+			return err
+		}
+
+		machineIDs := []uint64{}
+		for _, machine := range machines {
+			if deleteMachines || !machine.isExpired() {
+				machineIDs = append(machineIDs, machine.ID)
+			}
+		}
+		if len(machineIDs) == 0 {
+			return nil
+		}
+
+		if !deleteMachines {
+			if err := tx.Model(&Machine{}).
+				Where("id IN ?", machineIDs).
+				Updates(map[string]interface{}{
+					"expiry":    &now,
+					"disco_key": "",
+				}).Error; err != nil {
+				return err
+			}
+			report.ExpiredMachines = machineIDs
+
+			return nil
+		}
+
+		routes := []Route{}
+		if err := tx.Where("machine_id IN ?", machineIDs).Find(&routes).Error; err != nil {
+			return err
+		}
+		for _, route := range routes {
+			report.DeletedRoutes = append(report.DeletedRoutes, uint64(route.ID))
+		}
+
+		if err := tx.Unscoped().
+			Where("machine_id IN ?", machineIDs).
+			Delete(&Route{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().
+			Where("id IN ?", machineIDs).
+			Delete(&Machine{}).Error; err != nil {
+			return err
+		}
+		report.DeletedMachines = machineIDs
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	k.RevokedAt = &now
+
+	for _, id := range report.ExpiredMachines {
+		h.recordMachineHistory(id, MachineHistoryExpired, "", now.Format(time.RFC3339))
+	}
+	if len(report.DeletedMachines) > 0 {
+		for _, machine := range machines {
+			h.recordMachineHistory(machine.ID, MachineHistoryDeleted, machine.GivenName, "")
+		}
+	}
+
+	if len(report.DeletedMachines) > 0 {
+		if err := h.UpdateACLRules(); err != nil && !errors.Is(err, errEmptyPolicy) {
+			log.Error().Err(err).Msg("Failed to update ACL rules after revoking preauth key")
+		}
+
+		if len(report.DeletedRoutes) > 0 {
+			if err := h.handlePrimarySubnetFailover(); err != nil {
+				log.Error().Err(err).Msg("Failed to fail over routes after revoking preauth key")
+			}
+		}
+	}
+
+	if len(report.ExpiredMachines) > 0 || len(report.DeletedMachines) > 0 {
+		h.setLastStateChangeToNow()
+	}
+
+	log.Info().
+		Uint64("key", k.ID).
+		Uints64("expired_machines", report.ExpiredMachines).
+		Uints64("deleted_machines", report.DeletedMachines).
+		Msg("Preauth key revoked with its machines")
+
+	return &report, nil
+}
+
+// UsePreAuthKey marks a PreAuthKey as used and counts the use, unless it
+// has reached its maximum number of uses in the meantime.
 func (h *Headscale) UsePreAuthKey(k *PreAuthKey) error {
-	k.Used = true
-	if err := h.db.Save(k).Error; err != nil {
-		return fmt.Errorf("failed to update key used status in the database: %w", err)
+	now := time.Now().UTC()
+	result := h.db.Model(&PreAuthKey{}).
+		Where("id = ? AND (max_uses = 0 OR use_count < max_uses)", k.ID).
+		Updates(map[string]interface{}{
+			"used":      true,
+			"use_count": gorm.Expr("use_count + 1"),
+			"last_used": &now,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update key used status in the database: %w", result.Error)
 	}
+	if result.RowsAffected == 0 {
+		return ErrPreAuthKeyExhausted
+	}
+	k.Used = true
+	k.UseCount++
+	k.LastUsed = &now
 
 	return nil
 }
@@ -177,18 +513,24 @@ func (h *Headscale) UsePreAuthKey(k *PreAuthKey) error {
 // checkKeyValidity does the heavy lifting for validation of the PreAuthKey coming from a node
 // If returns no error and a PreAuthKey, it can be used.
 func (h *Headscale) checkKeyValidity(k string) (*PreAuthKey, error) {
-	pak := PreAuthKey{}
-	if result := h.db.Preload("User").Preload("ACLTags").First(&pak, "key = ?", k); errors.Is(
-		result.Error,
-		gorm.ErrRecordNotFound,
-	) {
-		return nil, ErrPreAuthKeyNotFound
+	found, err := h.getPreAuthKeyByKey(k)
+	if err != nil {
+		return nil, err
+	}
+	pak := *found
+
+	if pak.RevokedAt != nil {
+		return nil, ErrPreAuthKeyRevoked
 	}
 
 	if pak.Expiration != nil && pak.Expiration.Before(time.Now()) {
 		return nil, ErrPreAuthKeyExpired
 	}
 
+	if pak.MaxUses > 0 && pak.UseCount >= pak.MaxUses {
+		return nil, ErrPreAuthKeyExhausted
+	}
+
 	if pak.Reusable || pak.Ephemeral { // we don't need to check if has been used before
 		return &pak, nil
 	}
@@ -205,6 +547,105 @@ func (h *Headscale) checkKeyValidity(k string) (*PreAuthKey, error) {
 	return &pak, nil
 }
 
+// invalidReason returns why the key can no longer be used, given the
+// number of machines registered with it, or "" while it can.
+func (key *PreAuthKey) invalidReason(now time.Time, machines int) string {
+	switch {
+	case key.RevokedAt != nil:
+		return PreAuthKeyRevoked
+	case key.Expiration != nil && key.Expiration.Before(now):
+		return PreAuthKeyExpired
+	case key.MaxUses > 0 && key.UseCount >= key.MaxUses:
+		return PreAuthKeyExhausted
+	case !key.Reusable && !key.Ephemeral && (key.Used || machines > 0):
+		return PreAuthKeyExhausted
+	}
+
+	return ""
+}
+
+func hashPreAuthKey(key string) ([]byte, error) {
+	if len(key) <= preAuthKeyPrefixLength {
+		return nil, ErrPreAuthKeyFailedToParse
+	}
+
+	return bcrypt.GenerateFromPassword([]byte(key[preAuthKeyPrefixLength:]), bcrypt.DefaultCost)
+}
+
+// hasPlaintextPreAuthKeyColumn reports whether the key column of earlier
+// versions is there. The HasColumn of SQLite also matches the names
+// merely containing "key ".
+func hasPlaintextPreAuthKeyColumn(db *gorm.DB) bool {
+	columns, err := db.Migrator().ColumnTypes(&PreAuthKey{})
+	if err != nil {
+		return false
+	}
+	for _, column := range columns {
+		if column.Name() == "key" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashPlaintextPreAuthKeys replaces the keys stored in clear by earlier
+// versions with their prefix and hash, and clears the key column. The keys
+// keep working.
+func hashPlaintextPreAuthKeys(db *gorm.DB) error {
+	if !hasPlaintextPreAuthKeyColumn(db) {
+		return nil
+	}
+
+	type plaintextKey struct {
+		ID  uint64
+		Key string
+	}
+	keys := []plaintextKey{}
+	if err := db.Table("pre_auth_keys").
+		Select("id", "key").
+		Where("key IS NOT NULL AND key <> ''").
+		Find(&keys).Error; err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			hash, err := hashPreAuthKey(key.Key)
+			if err != nil {
+				// Without its hash the key can no longer be used
+				log.Warn().
+					Uint64("id", key.ID).
+					Msg("Preauth key too short to be hashed in DB migration")
+
+				continue
+			}
+			if err := tx.Model(&PreAuthKey{ID: key.ID}).Updates(map[string]interface{}{
+				"prefix": key.Key[:preAuthKeyPrefixLength],
+				"hash":   hash,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Table("pre_auth_keys").
+			Where("key IS NOT NULL").
+			Update("key", nil).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Int("keys", len(keys)).
+		Msg("Hashed the preauth keys stored in clear")
+
+	return nil
+}
+
 func (h *Headscale) generateKey() (string, error) {
 	size := 24
 	bytes := make([]byte, size)
@@ -220,10 +661,23 @@ func (key *PreAuthKey) toProto() *v1.PreAuthKey {
 		User:      key.User.Name,
 		Id:        strconv.FormatUint(key.ID, Base10),
 		Key:       key.Key,
+		Prefix:    key.Prefix,
 		Ephemeral: key.Ephemeral,
 		Reusable:  key.Reusable,
 		Used:      key.Used,
 		AclTags:   make([]string, len(key.ACLTags)),
+
+		Description: key.Description,
+		MaxUses:     uint32(key.MaxUses),
+		UseCount:    uint32(key.UseCount),
+	}
+
+	if key.LastUsed != nil {
+		protoKey.LastUsed = timestamppb.New(*key.LastUsed)
+	}
+
+	if key.RevokedAt != nil {
+		protoKey.RevokedAt = timestamppb.New(*key.RevokedAt)
 	}
 
 	if key.Expiration != nil {
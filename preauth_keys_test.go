@@ -1,8 +1,16 @@
 package headscale
 
 import (
+	"context"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
 	"time"
 
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gopkg.in/check.v1"
 )
 
@@ -207,3 +215,277 @@ func (*Suite) TestPreAuthKeyACLTags(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(listedPaks[0].toProto().AclTags, check.DeepEquals, tags)
 }
+
+func (*Suite) TestPreAuthKeyMaxUses(c *check.C) {
+	user, err := app.CreateUser("test9", "", "")
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKeyWithOptions(
+		user.Name, false, false, nil, nil,
+		PreAuthKeyOptions{Description: "office printers", MaxUses: 2},
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(pak.Reusable, check.Equals, true)
+	c.Assert(pak.Description, check.Equals, "office printers")
+
+	for index := 0; index < 2; index++ {
+		key, err := app.checkKeyValidity(pak.Key)
+		c.Assert(err, check.IsNil)
+		machine := Machine{
+			ID:             uint64(index + 1),
+			MachineKey:     "foo" + strconv.Itoa(index),
+			NodeKey:        "bar" + strconv.Itoa(index),
+			DiscoKey:       "faa" + strconv.Itoa(index),
+			Hostname:       "printer" + strconv.Itoa(index),
+			GivenName:      "printer" + strconv.Itoa(index),
+			UserID:         user.ID,
+			RegisterMethod: RegisterMethodAuthKey,
+			AuthKeyID:      uint(key.ID),
+		}
+		app.db.Save(&machine)
+		c.Assert(app.UsePreAuthKey(key), check.IsNil)
+	}
+
+	_, err = app.checkKeyValidity(pak.Key)
+	c.Assert(err, check.Equals, ErrPreAuthKeyExhausted)
+	c.Assert(app.UsePreAuthKey(pak), check.Equals, ErrPreAuthKeyExhausted)
+
+	keys, err := app.ListPreAuthKeys(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	c.Assert(keys[0].UseCount, check.Equals, uint(2))
+	c.Assert(keys[0].LastUsed, check.NotNil)
+	c.Assert(keys[0].invalidReason(time.Now(), 2), check.Equals, PreAuthKeyExhausted)
+
+	machines, err := app.ListPreAuthKeyMachines(keys)
+	c.Assert(err, check.IsNil)
+	c.Assert(machines[pak.ID], check.HasLen, 2)
+	c.Assert(machines[pak.ID][1].GivenName, check.Equals, "printer1")
+}
+
+func (*Suite) TestRevokePreAuthKey(c *check.C) {
+	user, err := app.CreateUser("test10", "", "")
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(pak.invalidReason(time.Now(), 0), check.Equals, "")
+
+	c.Assert(app.RevokePreAuthKey(pak), check.IsNil)
+	c.Assert(pak.RevokedAt, check.NotNil)
+	c.Assert(pak.invalidReason(time.Now(), 0), check.Equals, PreAuthKeyRevoked)
+
+	_, err = app.checkKeyValidity(pak.Key)
+	c.Assert(err, check.Equals, ErrPreAuthKeyRevoked)
+}
+
+func (*Suite) TestRevokePreAuthKeyCascade(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+	other, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	past := time.Now().Add(-time.Hour)
+	machines := []Machine{
+		{Hostname: "first", GivenName: "first", AuthKeyID: uint(pak.ID)},
+		{Hostname: "second", GivenName: "second", AuthKeyID: uint(pak.ID)},
+		{Hostname: "expired", GivenName: "expired", AuthKeyID: uint(pak.ID), Expiry: &past},
+		{Hostname: "other", GivenName: "other", AuthKeyID: uint(other.ID)},
+	}
+	for index := range machines {
+		machines[index].MachineKey = "machine" + strconv.Itoa(index)
+		machines[index].NodeKey = "node" + strconv.Itoa(index)
+		machines[index].DiscoKey = "disco" + strconv.Itoa(index)
+		machines[index].UserID = user.ID
+		machines[index].RegisterMethod = RegisterMethodAuthKey
+		c.Assert(app.db.Save(&machines[index]).Error, check.IsNil)
+	}
+
+	report, err := app.RevokePreAuthKeyCascade(pak, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.ExpiredMachines, check.DeepEquals, []uint64{machines[0].ID, machines[1].ID})
+	c.Assert(report.DeletedMachines, check.HasLen, 0)
+	c.Assert(pak.RevokedAt, check.NotNil)
+
+	_, err = app.checkKeyValidity(pak.Key)
+	c.Assert(err, check.Equals, ErrPreAuthKeyRevoked)
+	for _, machine := range machines[:2] {
+		expired, err := app.GetMachineByID(machine.ID)
+		c.Assert(err, check.IsNil)
+		c.Assert(expired.isExpired(), check.Equals, true)
+		c.Assert(expired.DiscoKey, check.Equals, "")
+	}
+	untouched, err := app.GetMachineByID(machines[3].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(untouched.isExpired(), check.Equals, false)
+
+	// The revoked key can still be found to delete its machines
+	revoked, err := app.FindPreAuthKey(user.Name, pak.Prefix)
+	c.Assert(err, check.IsNil)
+
+	// Deleting the machines needs the machines:write scope too
+	api := newHeadscaleV1APIServer(&app)
+	ctx := context.WithValue(context.Background(), grpcCallerKey{}, grpcCaller{
+		Role:   RoleITAdmin,
+		APIKey: &APIKey{Scopes: StringList{string(APIKeyScopeKeysWrite)}},
+	})
+	_, err = api.RevokePreAuthKey(ctx, &v1.RevokePreAuthKeyRequest{
+		User:           user.Name,
+		Key:            pak.Prefix,
+		Cascade:        true,
+		DeleteMachines: true,
+	})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+
+	route := Route{
+		MachineID:  machines[0].ID,
+		Prefix:     IPPrefix(netip.MustParsePrefix("10.0.0.0/24")),
+		Advertised: true,
+	}
+	c.Assert(app.db.Save(&route).Error, check.IsNil)
+
+	report, err = app.RevokePreAuthKeyCascade(revoked, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DeletedMachines, check.DeepEquals, []uint64{machines[0].ID, machines[1].ID, machines[2].ID})
+	c.Assert(report.DeletedRoutes, check.DeepEquals, []uint64{uint64(route.ID)})
+	c.Assert(report.ExpiredMachines, check.HasLen, 0)
+
+	remaining, err := app.ListMachinesByUser(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(remaining, check.HasLen, 1)
+	c.Assert(remaining[0].ID, check.Equals, machines[3].ID)
+
+	history, err := app.GetMachineHistory(machines[0].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(history[0].Event, check.Equals, MachineHistoryDeleted)
+	c.Assert(history[0].OldValue, check.Equals, "first")
+	c.Assert(history[1].Event, check.Equals, MachineHistoryExpired)
+}
+
+func (*Suite) TestFindPreAuthKey(c *check.C) {
+	user, err := app.CreateUser("test", "test", "Test")
+	c.Assert(err, check.IsNil)
+	_, err = app.CreateUser("other", "other", "Other")
+	c.Assert(err, check.IsNil)
+
+	past := time.Now().Add(-time.Hour)
+	pak, err := app.CreatePreAuthKey(user.Name, false, false, &past, nil)
+	c.Assert(err, check.IsNil)
+
+	key, err := app.FindPreAuthKey(user.Name, pak.Key)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.ID, check.Equals, pak.ID)
+
+	_, err = app.FindPreAuthKey("other", pak.Key)
+	c.Assert(err, check.Equals, ErrUserMismatch)
+	_, err = app.FindPreAuthKey("other", pak.Prefix)
+	c.Assert(err, check.Equals, ErrPreAuthKeyNotFound)
+}
+
+func Test_PreAuthKey_invalidReason(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		key      PreAuthKey
+		machines int
+		want     string
+	}{
+		{name: "valid", key: PreAuthKey{Expiration: &future}, want: ""},
+		{name: "revoked", key: PreAuthKey{Expiration: &past, RevokedAt: &past}, want: PreAuthKeyRevoked},
+		{name: "expired", key: PreAuthKey{Expiration: &past, Used: true}, want: PreAuthKeyExpired},
+		{name: "single use used", key: PreAuthKey{Used: true}, want: PreAuthKeyExhausted},
+		{name: "single use registered", key: PreAuthKey{}, machines: 1, want: PreAuthKeyExhausted},
+		{name: "reusable", key: PreAuthKey{Reusable: true, Used: true, UseCount: 5}, machines: 5, want: ""},
+		{
+			name: "max uses reached",
+			key:  PreAuthKey{Reusable: true, MaxUses: 5, UseCount: 5},
+			want: PreAuthKeyExhausted,
+		},
+		{name: "ephemeral", key: PreAuthKey{Ephemeral: true, Used: true}, machines: 1, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.invalidReason(now, tt.machines); got != tt.want {
+				t.Errorf("invalidReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func (*Suite) TestPreAuthKeyHashedAtRest(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(pak.Prefix, check.Equals, pak.Key[:preAuthKeyPrefixLength])
+
+	keys, err := app.ListPreAuthKeys(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	c.Assert(keys[0].Key, check.Equals, "")
+	c.Assert(keys[0].Prefix, check.Equals, pak.Prefix)
+	c.Assert(strings.Contains(string(keys[0].Hash), pak.Key[preAuthKeyPrefixLength:]), check.Equals, false)
+
+	key, err := app.checkKeyValidity(pak.Key)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.ID, check.Equals, pak.ID)
+
+	_, err = app.checkKeyValidity(pak.Prefix + strings.Repeat("0", 36))
+	c.Assert(err, check.Equals, ErrPreAuthKeyNotFound)
+	_, err = app.checkKeyValidity(pak.Prefix)
+	c.Assert(err, check.Equals, ErrPreAuthKeyNotFound)
+
+	key, err = app.GetPreAuthKey(user.Name, pak.Prefix)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.ID, check.Equals, pak.ID)
+}
+
+func (*Suite) TestRegeneratePreAuthKey(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKey(user.Name, false, false, nil, nil)
+	c.Assert(err, check.IsNil)
+	previous := pak.Key
+
+	c.Assert(app.RegeneratePreAuthKey(pak), check.IsNil)
+	c.Assert(pak.Key, check.Not(check.Equals), previous)
+
+	_, err = app.checkKeyValidity(previous)
+	c.Assert(err, check.Equals, ErrPreAuthKeyNotFound)
+	key, err := app.checkKeyValidity(pak.Key)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.ID, check.Equals, pak.ID)
+}
+
+func (*Suite) TestHashPlaintextPreAuthKeys(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	// A key stored in clear by an earlier version
+	c.Assert(app.db.Exec("ALTER TABLE pre_auth_keys ADD COLUMN key text").Error, check.IsNil)
+	legacy := strings.Repeat("ab", 24)
+	c.Assert(app.db.Exec(
+		"INSERT INTO pre_auth_keys (key, user_id, reusable) VALUES (?, ?, ?)",
+		legacy, user.ID, true,
+	).Error, check.IsNil)
+
+	c.Assert(hashPlaintextPreAuthKeys(app.db), check.IsNil)
+	var plaintext int64
+	c.Assert(app.db.Table("pre_auth_keys").Where("key IS NOT NULL").Count(&plaintext).Error, check.IsNil)
+	c.Assert(plaintext, check.Equals, int64(0))
+
+	key, err := app.checkKeyValidity(legacy)
+	c.Assert(err, check.IsNil)
+	c.Assert(key.Prefix, check.Equals, legacy[:preAuthKeyPrefixLength])
+
+	// Nothing left to migrate
+	c.Assert(hashPlaintextPreAuthKeys(app.db), check.IsNil)
+}
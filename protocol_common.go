@@ -105,6 +105,18 @@ func (h *Headscale) handleRegisterCommon(
 	//machine, err := h.GetMachineByAnyKey(machineKey, registerRequest.NodeKey, registerRequest.OldNodeKey)
 	machine, err := h.GetMachineByAnyKey(key.MachinePublic{}, registerRequest.NodeKey, registerRequest.OldNodeKey)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Machines failing a blocking posture rule are not allowed to join,
+		// even when they present a valid auth key. The rules are checked
+		// again at every poll, once the organization of the machine is known.
+		if postureAction, postureReason := h.checkMachinePosture(
+			h.registrationOrganizationID(registerRequest),
+			registerRequest.Hostinfo,
+		); postureAction == PostureActionBlock {
+			h.handlePostureBlockedCommon(writer, registerRequest, postureReason, machineKey, isNoise)
+
+			return
+		}
+
 		// If the machine has AuthKey set, handle registration via PreAuthKeys
 		if registerRequest.Auth.AuthKey != "" {
 			h.handleAuthKeyCommon(writer, registerRequest, machineKey, isNoise)
@@ -384,6 +396,9 @@ func (h *Headscale) handleAuthKeyCommon(
 			Str("machine", machine.Hostname).
 			Msg("machine was already registered before, refreshing with new auth key")
 
+		if machine.NodeKey != nodeKey {
+			h.recordMachineHistory(machine.ID, MachineHistoryNodeKeyRotated, machine.NodeKey, nodeKey)
+		}
 		machine.NodeKey = nodeKey
 		machine.AuthKeyID = uint(pak.ID)
 		err := h.RefreshMachine(machine, registerRequest.Expiry)
@@ -455,10 +470,17 @@ func (h *Headscale) handleAuthKeyCommon(
 				Msg("could not register machine")
 			machineRegistrations.WithLabelValues("new", RegisterMethodAuthKey, "error", pak.User.Name).
 				Inc()
+			if errors.Is(err, ErrUserMachineQuotaExceeded) {
+				http.Error(writer, err.Error(), http.StatusForbidden)
+
+				return
+			}
 			http.Error(writer, "Internal server error", http.StatusInternalServerError)
 
 			return
 		}
+
+		h.recordMachineHistory(machine.ID, MachineHistoryRegistered, "", RegisterMethodAuthKey)
 	}
 
 	err = h.UsePreAuthKey(pak)
@@ -534,7 +556,7 @@ func (h *Headscale) handleNewMachineCommon(
 		Str("machine", registerRequest.Hostinfo.Hostname).
 		Msg("The node seems to be new, sending auth url")
 
-	if h.oauth2Config != nil {
+	if len(h.identityProviders) > 0 {
 		resp.AuthURL = fmt.Sprintf(
 			"%s/oidc/register/%s",
 			strings.TrimSuffix(h.cfg.ServerURL, "/"),
@@ -577,6 +599,53 @@ func (h *Headscale) handleNewMachineCommon(
 		Msg("Successfully sent auth url")
 }
 
+// handlePostureBlockedCommon refuses the registration of a machine
+// failing a blocking posture rule.
+func (h *Headscale) handlePostureBlockedCommon(
+	writer http.ResponseWriter,
+	registerRequest tailcfg.RegisterRequest,
+	reason string,
+	machineKey key.MachinePublic,
+	isNoise bool,
+) {
+	hostname := ""
+	if registerRequest.Hostinfo != nil {
+		hostname = registerRequest.Hostinfo.Hostname
+	}
+	log.Info().
+		Bool("noise", isNoise).
+		Str("machine", hostname).
+		Str("reason", reason).
+		Msg("Machine registration blocked by posture check")
+
+	resp := tailcfg.RegisterResponse{
+		MachineAuthorized: false,
+		Error:             fmt.Sprintf("device posture check failed: %s", reason),
+	}
+	respBody, err := h.marshalResponse(resp, machineKey, isNoise)
+	if err != nil {
+		log.Error().
+			Caller().
+			Bool("noise", isNoise).
+			Err(err).
+			Msg("Cannot encode message")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(http.StatusForbidden)
+	_, err = writer.Write(respBody)
+	if err != nil {
+		log.Error().
+			Bool("noise", isNoise).
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
 func (h *Headscale) handleMachineLogOutCommon(
 	writer http.ResponseWriter,
 	machine Machine,
@@ -719,6 +788,7 @@ func (h *Headscale) handleMachineRefreshKeyCommon(
 		Bool("noise", isNoise).
 		Str("machine", machine.Hostname).
 		Msg("We have the OldNodeKey in the database. This is a key refresh")
+	oldNodeKey := machine.NodeKey
 	machine.NodeKey = NodePublicKeyStripPrefix(registerRequest.NodeKey)
 
 	if err := h.db.Save(&machine).Error; err != nil {
@@ -731,6 +801,8 @@ func (h *Headscale) handleMachineRefreshKeyCommon(
 		return
 	}
 
+	h.recordMachineHistory(machine.ID, MachineHistoryNodeKeyRotated, oldNodeKey, machine.NodeKey)
+
 	resp.AuthURL = ""
 	resp.User = *machine.User.toTailscaleUser()
 	respBody, err := h.marshalResponse(resp, machineKey, isNoise)
@@ -790,7 +862,7 @@ func (h *Headscale) handleMachineExpiredOrLoggedOutCommon(
 		Str("node_key_old", registerRequest.OldNodeKey.ShortString()).
 		Msg("Machine registration has expired or logged out. Sending a auth url to register")
 
-	if h.oauth2Config != nil {
+	if len(h.identityProviders) > 0 {
 		resp.AuthURL = fmt.Sprintf("%s/oidc/register/%s",
 			strings.TrimSuffix(h.cfg.ServerURL, "/"),
 			registerRequest.NodeKey)
@@ -27,12 +27,27 @@ func (h *Headscale) handlePollCommon(
 	mapRequest tailcfg.MapRequest,
 	isNoise bool,
 ) {
+	h.recordMapRequestChanges(machine, mapRequest)
+
 	machine.Hostname = mapRequest.Hostinfo.Hostname
 	machine.HostInfo = HostInfo(*mapRequest.Hostinfo)
 	machine.DiscoKey = DiscoPublicKeyStripPrefix(mapRequest.DiscoKey)
 	now := time.Now().UTC()
 
-	err := h.processMachineRoutes(machine)
+	postureAction, postureReason := h.checkMachinePosture(
+		h.machineOrganizationID(machine),
+		mapRequest.Hostinfo,
+	)
+	err := h.setMachinePosture(machine, postureAction, postureReason)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Str("machine", machine.Hostname).
+			Msg("Error saving machine posture")
+	}
+
+	err = h.processMachineRoutes(machine)
 	if err != nil {
 		log.Error().
 			Caller().
@@ -42,7 +57,7 @@ func (h *Headscale) handlePollCommon(
 	}
 
 	// update ACLRules with peer informations (to update server tags if necessary)
-	if h.aclPolicy != nil {
+	if h.aclFor(h.machineOrganizationID(machine)).policy != nil {
 		err := h.UpdateACLRules()
 		if err != nil {
 			log.Error().
@@ -92,6 +107,18 @@ func (h *Headscale) handlePollCommon(
 		}
 	}
 
+	if postureAction == PostureActionBlock {
+		log.Info().
+			Str("handler", "PollNetMap").
+			Bool("noise", isNoise).
+			Str("machine", machine.Hostname).
+			Str("reason", postureReason).
+			Msg("Machine blocked by posture check")
+		http.Error(writer, "device posture check failed: "+postureReason, http.StatusForbidden)
+
+		return
+	}
+
 	mapResp, err := h.getMapResponseData(mapRequest, machine, isNoise)
 	if err != nil {
 		log.Error().
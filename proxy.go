@@ -0,0 +1,157 @@
+package headscale
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// isTrustedProxy reports whether addr is one of the trusted_proxies, whose
+// forwarding headers are honoured.
+func (h *Headscale) isTrustedProxy(addr netip.Addr) bool {
+	if h.cfg == nil {
+		return false
+	}
+	for _, prefix := range h.cfg.TrustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseForwardedAddr parses an address of X-Forwarded-For or of the for
+// parameter of Forwarded, with or without port, brackets and quotes.
+func parseForwardedAddr(value string) (netip.Addr, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+// forwardedParams returns the values of a parameter of the Forwarded
+// headers (RFC 7239), one per element, in the order of the hops.
+func forwardedParams(r *http.Request, name string) []string {
+	values := []string{}
+	for _, header := range r.Header.Values("Forwarded") {
+		for _, element := range strings.Split(header, ",") {
+			value := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, name) {
+					value = strings.Trim(v, `"`)
+				}
+			}
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// forwardedFor returns the addresses of the hops in front of the proxy, from
+// Forwarded, or else from X-Forwarded-For.
+func forwardedFor(r *http.Request) []string {
+	if hops := forwardedParams(r, "for"); len(hops) > 0 {
+		return hops
+	}
+
+	hops := []string{}
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	return hops
+}
+
+// clientIP returns the address of the client of a request, without the
+// port so that all the connections of a client share their limits. Behind
+// trusted proxies, it is the last address before them in the forwarding
+// headers, the earlier ones are up to the client.
+func (h *Headscale) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !h.isTrustedProxy(addr) {
+		return host
+	}
+
+	client := addr.Unmap()
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			break
+		}
+		client = hop
+		if !h.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// requestScheme returns the scheme the client used, as told by the trusted
+// proxies in X-Forwarded-Proto or Forwarded.
+func (h *Headscale) requestScheme(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !h.isTrustedProxy(addr) {
+		return scheme
+	}
+
+	// The first hop is the proxy the client connected to
+	for _, proto := range forwardedParams(r, "proto") {
+		if proto != "" {
+			return strings.ToLower(proto)
+		}
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		proto, _, _ = strings.Cut(proto, ",")
+
+		return strings.ToLower(strings.TrimSpace(proto))
+	}
+
+	return scheme
+}
+
+// cookieScope returns the domain and the path of the cookies, those of
+// server_url, which may carry a port or a path behind a reverse proxy.
+func (h *Headscale) cookieScope() (string, string) {
+	serverURL, err := url.Parse(h.cfg.ServerURL)
+	if err != nil {
+		return "", "/"
+	}
+	path := strings.TrimSuffix(serverURL.Path, "/")
+	if path == "" {
+		path = "/"
+	}
+
+	return serverURL.Hostname(), path
+}
+
+// secureCookies reports whether the cookies are only sent over https,
+// whenever server_url or the client uses it.
+func (h *Headscale) secureCookies(r *http.Request) bool {
+	return strings.HasPrefix(h.cfg.ServerURL, "https://") || h.requestScheme(r) == "https"
+}
@@ -0,0 +1,158 @@
+package headscale
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func Test_clientIP(t *testing.T) {
+	h := &Headscale{cfg: &Config{TrustedProxies: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct",
+			remoteAddr: "203.0.113.7:4242",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted forwarding headers",
+			remoteAddr: "203.0.113.7:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.2:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "spoofed hop before the client",
+			remoteAddr: "10.0.0.2:4242",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "trusted proxy without header",
+			remoteAddr: "10.0.0.2:4242",
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "garbage hop",
+			remoteAddr: "10.0.0.2:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, garbage"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "[fd00::1]:4242",
+			headers: map[string]string{
+				"Forwarded":       `for="[2001:db8::1]:1234";proto=https, for=10.0.0.3`,
+				"X-Forwarded-For": "1.2.3.4",
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:       "ipv4 mapped proxy",
+			remoteAddr: "[::ffff:10.0.0.2]:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1:5555"},
+			want:       "198.51.100.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			if got := h.clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_requestScheme(t *testing.T) {
+	h := &Headscale{cfg: &Config{TrustedProxies: []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.1/32"),
+	}}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		headers    map[string]string
+		want       string
+	}{
+		{name: "plain", remoteAddr: "127.0.0.1:1", want: "http"},
+		{name: "tls", remoteAddr: "203.0.113.7:1", tls: true, want: "https"},
+		{
+			name:       "untrusted proto",
+			remoteAddr: "203.0.113.7:1",
+			headers:    map[string]string{"X-Forwarded-Proto": "https"},
+			want:       "http",
+		},
+		{
+			name:       "trusted proto",
+			remoteAddr: "127.0.0.1:1",
+			headers:    map[string]string{"X-Forwarded-Proto": "HTTPS, http"},
+			want:       "https",
+		},
+		{
+			name:       "forwarded proto",
+			remoteAddr: "127.0.0.1:1",
+			headers:    map[string]string{"Forwarded": "for=198.51.100.1;proto=https"},
+			want:       "https",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			if got := h.requestScheme(req); got != tt.want {
+				t.Errorf("requestScheme() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cookieScope(t *testing.T) {
+	tests := []struct {
+		serverURL  string
+		wantDomain string
+		wantPath   string
+	}{
+		{serverURL: "https://headscale.example.com", wantDomain: "headscale.example.com", wantPath: "/"},
+		{serverURL: "https://headscale.example.com:8443/", wantDomain: "headscale.example.com", wantPath: "/"},
+		{serverURL: "https://example.com/headscale/", wantDomain: "example.com", wantPath: "/headscale"},
+		{serverURL: "http://[fd00::1]:8080", wantDomain: "fd00::1", wantPath: "/"},
+		{serverURL: "", wantDomain: "", wantPath: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverURL, func(t *testing.T) {
+			h := &Headscale{cfg: &Config{ServerURL: tt.serverURL}}
+			domain, path := h.cookieScope()
+			if domain != tt.wantDomain || path != tt.wantPath {
+				t.Errorf("cookieScope() = %v, %v, want %v, %v", domain, path, tt.wantDomain, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package headscale
+
+import (
+	"net/netip"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+)
+
+const (
+	ErrUserMachineQuotaExceeded    = Error("User machine quota exceeded")
+	ErrUserPreAuthKeyQuotaExceeded = Error("User reusable preauth key quota exceeded")
+	ErrUserRouteQuotaExceeded      = Error("User subnet route quota exceeded")
+)
+
+// UserQuota holds the limits set on a User. A nil limit means the
+// global default from the configuration applies, 0 means unlimited.
+type UserQuota struct {
+	MaxMachines            *uint
+	MaxReusablePreAuthKeys *uint
+	MaxRoutes              *uint
+}
+
+// SetUserQuota replaces the limits of a User.
+func (h *Headscale) SetUserQuota(userName string, quota UserQuota) (*User, error) {
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	user.MaxMachines = quota.MaxMachines
+	user.MaxReusablePreAuthKeys = quota.MaxReusablePreAuthKeys
+	user.MaxRoutes = quota.MaxRoutes
+
+	if err := h.db.Save(user).Error; err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// effectiveQuota returns the limits enforced on a User, 0 meaning unlimited.
+func (h *Headscale) effectiveQuota(user *User) QuotaConfig {
+	quota := h.cfg.Quotas
+	if user.MaxMachines != nil {
+		quota.MaxMachines = *user.MaxMachines
+	}
+	if user.MaxReusablePreAuthKeys != nil {
+		quota.MaxReusablePreAuthKeys = *user.MaxReusablePreAuthKeys
+	}
+	if user.MaxRoutes != nil {
+		quota.MaxRoutes = *user.MaxRoutes
+	}
+
+	return quota
+}
+
+// checkMachineQuota returns ErrUserMachineQuotaExceeded if the User
+// cannot register one more machine.
+func (h *Headscale) checkMachineQuota(userID uint) error {
+	var user User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	limit := h.effectiveQuota(&user).MaxMachines
+	if limit == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := h.db.Model(&Machine{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count >= int64(limit) {
+		return ErrUserMachineQuotaExceeded
+	}
+
+	return nil
+}
+
+// checkPreAuthKeyQuota returns ErrUserPreAuthKeyQuotaExceeded if the User
+// cannot create one more reusable preauth key. Expired keys are not counted.
+func (h *Headscale) checkPreAuthKeyQuota(user *User) error {
+	limit := h.effectiveQuota(user).MaxReusablePreAuthKeys
+	if limit == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := h.db.Model(&PreAuthKey{}).
+		Where("user_id = ? AND reusable = ?", user.ID, true).
+		Where("expiration IS NULL OR expiration > ?", time.Now().UTC()).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count >= int64(limit) {
+		return ErrUserPreAuthKeyQuotaExceeded
+	}
+
+	return nil
+}
+
+// remainingRouteQuota returns how many subnet routes the machines of a User,
+// other than the given machine, leave to it. -1 means unlimited.
+// Exit routes are not subnet routes and are never limited.
+func (h *Headscale) remainingRouteQuota(machine *Machine) (int64, error) {
+	var user User
+	if err := h.db.First(&user, machine.UserID).Error; err != nil {
+		return 0, err
+	}
+
+	limit := h.effectiveQuota(&user).MaxRoutes
+	if limit == 0 {
+		return -1, nil
+	}
+
+	routes := []Route{}
+	if err := h.db.
+		Joins("JOIN machines ON machines.id = routes.machine_id").
+		Where("machines.user_id = ? AND routes.machine_id <> ? AND routes.advertised = ?",
+			machine.UserID, machine.ID, true).
+		Find(&routes).Error; err != nil {
+		return 0, err
+	}
+
+	used := int64(0)
+	for _, route := range routes {
+		if !route.isExitRoute() {
+			used++
+		}
+	}
+
+	if used >= int64(limit) {
+		return 0, nil
+	}
+
+	return int64(limit) - used, nil
+}
+
+func isExitPrefix(prefix netip.Prefix) bool {
+	return prefix == ExitRouteV4 || prefix == ExitRouteV6
+}
+
+func optionalUint(value *uint64) *uint {
+	if value == nil {
+		return nil
+	}
+	converted := uint(*value)
+
+	return &converted
+}
+
+func optionalUint64(value *uint) *uint64 {
+	if value == nil {
+		return nil
+	}
+	converted := uint64(*value)
+
+	return &converted
+}
+
+func (user *User) quotaToProto() *v1.UserQuota {
+	return &v1.UserQuota{
+		MaxMachines:            optionalUint64(user.MaxMachines),
+		MaxReusablePreAuthKeys: optionalUint64(user.MaxReusablePreAuthKeys),
+		MaxRoutes:              optionalUint64(user.MaxRoutes),
+	}
+}
@@ -0,0 +1,118 @@
+package headscale
+
+import (
+	"net/netip"
+
+	"gopkg.in/check.v1"
+	"tailscale.com/tailcfg"
+)
+
+func (s *Suite) TestMachineQuota(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	app.cfg.Quotas = QuotaConfig{MaxMachines: 1}
+	defer func() { app.cfg.Quotas = QuotaConfig{} }()
+
+	_, err = app.RegisterMachine(Machine{
+		MachineKey: "foo",
+		NodeKey:    "bar",
+		DiscoKey:   "faa",
+		Hostname:   "first",
+		UserID:     user.ID,
+	})
+	c.Assert(err, check.IsNil)
+
+	_, err = app.RegisterMachine(Machine{
+		MachineKey: "foo2",
+		NodeKey:    "bar2",
+		DiscoKey:   "faa2",
+		Hostname:   "second",
+		UserID:     user.ID,
+	})
+	c.Assert(err, check.Equals, ErrUserMachineQuotaExceeded)
+
+	// The user limit takes precedence over the global default
+	unlimited := uint(0)
+	_, err = app.SetUserQuota(user.Name, UserQuota{MaxMachines: &unlimited})
+	c.Assert(err, check.IsNil)
+
+	_, err = app.RegisterMachine(Machine{
+		MachineKey: "foo2",
+		NodeKey:    "bar2",
+		DiscoKey:   "faa2",
+		Hostname:   "second",
+		UserID:     user.ID,
+	})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *Suite) TestPreAuthKeyQuota(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	limit := uint(1)
+	_, err = app.SetUserQuota(user.Name, UserQuota{MaxReusablePreAuthKeys: &limit})
+	c.Assert(err, check.IsNil)
+
+	key, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	_, err = app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.Equals, ErrUserPreAuthKeyQuotaExceeded)
+
+	// Single use keys are not limited
+	_, err = app.CreatePreAuthKey(user.Name, false, false, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	// Expired keys do not count
+	err = app.ExpirePreAuthKey(key)
+	c.Assert(err, check.IsNil)
+
+	_, err = app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *Suite) TestRouteQuota(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	limit := uint(1)
+	_, err = app.SetUserQuota(user.Name, UserQuota{MaxRoutes: &limit})
+	c.Assert(err, check.IsNil)
+
+	machine := Machine{
+		ID:         0,
+		MachineKey: "foo",
+		NodeKey:    "bar",
+		DiscoKey:   "faa",
+		Hostname:   "router",
+		UserID:     user.ID,
+		HostInfo: HostInfo{
+			RoutableIPs: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/24"),
+				ExitRouteV4,
+				ExitRouteV6,
+			},
+		},
+	}
+	app.db.Save(&machine)
+
+	err = app.processMachineRoutes(&machine)
+	c.Assert(err, check.IsNil)
+
+	machine.HostInfo = HostInfo(tailcfg.Hostinfo{
+		RoutableIPs: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/24"),
+			netip.MustParsePrefix("10.1.0.0/24"),
+			ExitRouteV4,
+			ExitRouteV6,
+		},
+	})
+	err = app.processMachineRoutes(&machine)
+	c.Assert(err, check.Equals, ErrUserRouteQuotaExceeded)
+
+	routes, err := app.GetMachineRoutes(&machine)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 3)
+}
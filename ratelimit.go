@@ -0,0 +1,279 @@
+package headscale
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	rateLimitSweepInterval = time.Minute
+
+	// apiKeyEndpoint counts the wrong API keys of an address, over HTTP
+	// and gRPC
+	apiKeyEndpoint = "api_key"
+)
+
+// tokenBucket holds the tokens of a key, refilled at the rate of its
+// rateLimiter up to the burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a set of token buckets, one per key.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.RequestsPerMinute <= 0 {
+		return nil
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    limit.RequestsPerMinute / time.Minute.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token of key, or returns how long to wait for the next one.
+func (limiter *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if limiter == nil {
+		return true, 0
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.sweep(now)
+
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.tokens = limiter.refill(bucket, now)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+}
+
+func (limiter *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+}
+
+// sweep drops the buckets refilled to the burst, which are the same as no
+// bucket at all.
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.swept) < rateLimitSweepInterval {
+		return
+	}
+	limiter.swept = now
+
+	for key, bucket := range limiter.buckets {
+		if limiter.refill(bucket, now) >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// lockout locks a key out for a while after too many failures within a
+// window, such as wrong API keys from an address or wrong verification
+// codes for a mobile.
+type lockout struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+	failures    *cache.Cache
+	locked      *cache.Cache
+}
+
+func newLockout(maxFailures int, window time.Duration, duration time.Duration) *lockout {
+	if maxFailures <= 0 || duration <= 0 {
+		return nil
+	}
+
+	return &lockout{
+		maxFailures: maxFailures,
+		window:      window,
+		duration:    duration,
+		failures:    cache.New(window, rateLimitSweepInterval),
+		locked:      cache.New(duration, rateLimitSweepInterval),
+	}
+}
+
+// lockedOut returns how long the key stays locked out, if it is.
+func (l *lockout) lockedOut(key string) (bool, time.Duration) {
+	if l == nil {
+		return false, 0
+	}
+	if _, until, found := l.locked.GetWithExpiration(key); found {
+		return true, time.Until(until)
+	}
+
+	return false, 0
+}
+
+// fail counts a failure of key, and reports whether it is now locked out.
+func (l *lockout) fail(key string) bool {
+	if l == nil {
+		return false
+	}
+	if l.failures.Add(key, 1, l.window) == nil {
+		return l.maxFailures <= 1 && l.lock(key)
+	}
+	count, err := l.failures.IncrementInt(key, 1)
+	if err != nil {
+		return false
+	}
+	if count < l.maxFailures {
+		return false
+	}
+
+	return l.lock(key)
+}
+
+func (l *lockout) lock(key string) bool {
+	l.failures.Delete(key)
+	l.locked.Set(key, true, l.duration)
+
+	return true
+}
+
+// reset forgets the failures of key after a success.
+func (l *lockout) reset(key string) {
+	if l == nil {
+		return
+	}
+	l.failures.Delete(key)
+}
+
+// rateLimits throttles the public endpoints, see RateLimitConfig. A nil
+// rateLimits lets everything through.
+type rateLimits struct {
+	perIP       *rateLimiter
+	perIdentity *rateLimiter
+	lockout     *lockout
+}
+
+func newRateLimits(cfg RateLimitConfig) *rateLimits {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	return &rateLimits{
+		perIP:       newRateLimiter(cfg.PerIP),
+		perIdentity: newRateLimiter(cfg.PerIdentity),
+		lockout:     newLockout(cfg.MaxFailures, cfg.FailureWindow, cfg.LockoutDuration),
+	}
+}
+
+// allowIP takes a token of the client address on an endpoint.
+func (limits *rateLimits) allowIP(endpoint string, ip string) (bool, time.Duration) {
+	if limits == nil {
+		return true, 0
+	}
+	ok, retryAfter := limits.perIP.allow(endpoint+"|"+ip, time.Now())
+	if !ok {
+		rateLimitedRequests.WithLabelValues(endpoint, "ip").Inc()
+	}
+
+	return ok, retryAfter
+}
+
+// allowIdentity takes a token of an identity, the mobile, user name, API
+// key prefix or OAuth client of the request, on an endpoint.
+func (limits *rateLimits) allowIdentity(endpoint string, identity string) (bool, time.Duration) {
+	if limits == nil || identity == "" {
+		return true, 0
+	}
+	ok, retryAfter := limits.perIdentity.allow(endpoint+"|"+identity, time.Now())
+	if !ok {
+		rateLimitedRequests.WithLabelValues(endpoint, "identity").Inc()
+	}
+
+	return ok, retryAfter
+}
+
+// lockedOut checks whether key is locked out of an endpoint.
+func (limits *rateLimits) lockedOut(endpoint string, key string) (bool, time.Duration) {
+	if limits == nil {
+		return false, 0
+	}
+	locked, retryAfter := limits.lockout.lockedOut(endpoint + "|" + key)
+	if locked {
+		rateLimitedRequests.WithLabelValues(endpoint, "lockout").Inc()
+	}
+
+	return locked, retryAfter
+}
+
+// failure counts a failed attempt of key on an endpoint.
+func (limits *rateLimits) failure(endpoint string, key string) {
+	if limits == nil {
+		return
+	}
+	if limits.lockout.fail(endpoint + "|" + key) {
+		log.Warn().
+			Str("endpoint", endpoint).
+			Str("key", key).
+			Msg("Too many failed attempts, locked out")
+	}
+}
+
+// success forgets the failed attempts of key on an endpoint.
+func (limits *rateLimits) success(endpoint string, key string) {
+	if limits == nil {
+		return
+	}
+	limits.lockout.reset(endpoint + "|" + key)
+}
+
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	w.WriteHeader(http.StatusTooManyRequests)
+	if _, err := w.Write([]byte("Too Many Requests")); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+// rateLimited limits the requests of each client address to an endpoint.
+func (h *Headscale) rateLimited(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := h.rateLimits.allowIP(endpoint, h.clientIP(r)); !ok {
+			writeTooManyRequests(w, retryAfter)
+
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,177 @@
+package headscale
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{RequestsPerMinute: 60, Burst: 3})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("10.0.0.1", now); !ok {
+			t.Fatalf("allow() #%d = false, want true within the burst", i)
+		}
+	}
+	ok, retryAfter := limiter.allow("10.0.0.1", now)
+	if ok {
+		t.Fatalf("allow() = true, want false after the burst")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("allow() retry after %v, want at most 1s", retryAfter)
+	}
+	if ok, _ := limiter.allow("10.0.0.2", now); !ok {
+		t.Errorf("allow() of another key = false, want true")
+	}
+
+	if ok, _ := limiter.allow("10.0.0.1", now.Add(time.Second)); !ok {
+		t.Errorf("allow() after a refill = false, want true")
+	}
+	if ok, _ := limiter.allow("10.0.0.1", now.Add(time.Second)); ok {
+		t.Errorf("allow() = true, want false after the refilled token")
+	}
+
+	limiter.allow("10.0.0.1", now.Add(time.Hour))
+	if _, found := limiter.buckets["10.0.0.2"]; found {
+		t.Errorf("sweep() kept a full bucket")
+	}
+}
+
+func Test_newRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit RateLimit
+		want  bool
+	}{
+		{name: "disabled", limit: RateLimit{}, want: false},
+		{name: "burst defaults to 1", limit: RateLimit{RequestsPerMinute: 1}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.limit)
+			if (limiter != nil) != tt.want {
+				t.Fatalf("newRateLimiter() = %v, want limiter %v", limiter, tt.want)
+			}
+			if limiter == nil {
+				if ok, _ := limiter.allow("key", time.Now()); !ok {
+					t.Errorf("allow() of a nil limiter = false, want true")
+				}
+
+				return
+			}
+			now := time.Now()
+			if ok, _ := limiter.allow("key", now); !ok {
+				t.Errorf("allow() = false, want true")
+			}
+			if ok, _ := limiter.allow("key", now); ok {
+				t.Errorf("allow() = true, want false after a burst of 1")
+			}
+		})
+	}
+}
+
+func Test_lockout(t *testing.T) {
+	lock := newLockout(3, time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if lock.fail("10.0.0.1") {
+			t.Fatalf("fail() #%d locked out before the max failures", i)
+		}
+	}
+	lock.reset("10.0.0.1")
+	for i := 0; i < 2; i++ {
+		lock.fail("10.0.0.1")
+	}
+	if locked, _ := lock.lockedOut("10.0.0.1"); locked {
+		t.Fatalf("lockedOut() = true, want the failures forgotten after reset()")
+	}
+
+	if !lock.fail("10.0.0.1") {
+		t.Fatalf("fail() = false, want locked out at the max failures")
+	}
+	locked, retryAfter := lock.lockedOut("10.0.0.1")
+	if !locked || retryAfter <= 0 || retryAfter > time.Minute {
+		t.Errorf("lockedOut() = %v, %v, want locked for at most a minute", locked, retryAfter)
+	}
+	if locked, _ := lock.lockedOut("10.0.0.2"); locked {
+		t.Errorf("lockedOut() of another key = true, want false")
+	}
+}
+
+func Test_rateLimits(t *testing.T) {
+	var disabled *rateLimits
+	if ok, _ := disabled.allowIP("login", "10.0.0.1"); !ok {
+		t.Errorf("allowIP() of nil rate limits = false, want true")
+	}
+	disabled.failure("login", "10.0.0.1")
+	if locked, _ := disabled.lockedOut("login", "10.0.0.1"); locked {
+		t.Errorf("lockedOut() of nil rate limits = true, want false")
+	}
+	if newRateLimits(RateLimitConfig{}) != nil {
+		t.Errorf("newRateLimits() of a disabled config is not nil")
+	}
+
+	limits := newRateLimits(RateLimitConfig{
+		Enabled:         true,
+		PerIP:           RateLimit{RequestsPerMinute: 60, Burst: 1},
+		PerIdentity:     RateLimit{RequestsPerMinute: 60, Burst: 1},
+		MaxFailures:     2,
+		FailureWindow:   time.Minute,
+		LockoutDuration: time.Minute,
+	})
+	limits.allowIP("login", "10.0.0.1")
+	if ok, _ := limits.allowIP("register", "10.0.0.1"); !ok {
+		t.Errorf("allowIP() = false, want the endpoints limited apart")
+	}
+	if ok, _ := limits.allowIP("login", "10.0.0.1"); ok {
+		t.Errorf("allowIP() = true, want false after the burst")
+	}
+	if ok, _ := limits.allowIdentity("login", ""); !ok {
+		t.Errorf("allowIdentity() without identity = false, want true")
+	}
+
+	limits.failure("sms", "13800000000")
+	limits.failure("sms", "13800000000")
+	if locked, _ := limits.lockedOut("sms", "13800000000"); !locked {
+		t.Errorf("lockedOut() = false, want locked out after the max failures")
+	}
+	if locked, _ := limits.lockedOut(apiKeyEndpoint, "13800000000"); locked {
+		t.Errorf("lockedOut() = true, want the endpoints locked apart")
+	}
+}
+
+func Test_rateLimited(t *testing.T) {
+	h := &Headscale{rateLimits: newRateLimits(RateLimitConfig{
+		Enabled: true,
+		PerIP:   RateLimit{RequestsPerMinute: 1, Burst: 2},
+	})}
+	handler := h.rateLimited("login", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login/local", nil)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+
+		return recorder
+	}
+
+	// Each connection of a client comes from another port
+	request("10.0.0.1:1000")
+	request("10.0.0.1:1001")
+	recorder := request("10.0.0.1:1002")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("code = %d, want %d", recorder.Code, http.StatusTooManyRequests)
+	}
+	if recorder.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", recorder.Header().Get("Retry-After"))
+	}
+	if recorder := request("10.0.0.2:1000"); recorder.Code != http.StatusOK {
+		t.Errorf("code of another client = %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
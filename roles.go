@@ -0,0 +1,390 @@
+package headscale
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ErrInvalidRole       = Error("Invalid role")
+	ErrPermissionDenied  = Error("Permission denied")
+	ErrLastOwner         = Error("Cannot remove the last owner of the tailnet")
+	ErrOwnerRoleRequired = Error("Only an owner can grant or revoke the owner role")
+)
+
+// Role is the set of permissions a User has on the tailnet.
+type Role string
+
+const (
+	// RoleOwner can do everything, including managing the other owners.
+	RoleOwner Role = "owner"
+	// RoleAdmin can do everything except granting or revoking the owner role.
+	RoleAdmin Role = "admin"
+	// RoleNetworkAdmin manages the routes and exit nodes of every machine.
+	RoleNetworkAdmin Role = "network-admin"
+	// RoleITAdmin manages the users, their machines and preauth keys.
+	RoleITAdmin Role = "it-admin"
+	// RoleAuditor can see everything but change nothing.
+	RoleAuditor Role = "auditor"
+	// RoleMember only manages their own machines.
+	RoleMember Role = "member"
+)
+
+// roles lists the roles from the most to the least privileged, group
+// mappings giving several roles keep the first one.
+var roles = []Role{
+	RoleOwner,
+	RoleAdmin,
+	RoleNetworkAdmin,
+	RoleITAdmin,
+	RoleAuditor,
+	RoleMember,
+}
+
+// Permission is an action on the resources of other users, every User can
+// always manage their own machines, keys and settings.
+type Permission string
+
+const (
+	PermissionReadTailnet    Permission = "tailnet:read"
+	PermissionManageMachines Permission = "machines:write"
+	PermissionManageRoutes   Permission = "routes:write"
+	PermissionManageKeys     Permission = "keys:write"
+	PermissionManageUsers    Permission = "users:write"
+	PermissionManageRoles    Permission = "roles:write"
+	PermissionManageAPIKeys  Permission = "apikeys:write"
+	PermissionManageSessions Permission = "sessions:write"
+	PermissionManageDNS      Permission = "dns:write"
+)
+
+var permissions = []Permission{
+	PermissionReadTailnet,
+	PermissionManageMachines,
+	PermissionManageRoutes,
+	PermissionManageKeys,
+	PermissionManageUsers,
+	PermissionManageRoles,
+	PermissionManageAPIKeys,
+	PermissionManageSessions,
+	PermissionManageDNS,
+}
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermissionReadTailnet,
+		PermissionManageMachines,
+		PermissionManageRoutes,
+		PermissionManageKeys,
+		PermissionManageUsers,
+		PermissionManageRoles,
+		PermissionManageAPIKeys,
+		PermissionManageSessions,
+		PermissionManageDNS,
+	},
+	RoleNetworkAdmin: {
+		PermissionReadTailnet,
+		PermissionManageRoutes,
+		PermissionManageDNS,
+	},
+	RoleITAdmin: {
+		PermissionReadTailnet,
+		PermissionManageMachines,
+		PermissionManageKeys,
+		PermissionManageUsers,
+		PermissionManageSessions,
+	},
+	RoleAuditor: {
+		PermissionReadTailnet,
+	},
+}
+
+// ParseRole returns the Role named s.
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if !role.valid() {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRole, s)
+	}
+
+	return role, nil
+}
+
+func (role Role) valid() bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Can reports whether the role grants the permission.
+func (role Role) Can(permission Permission) bool {
+	if role == RoleOwner {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Permissions lists the permissions the role grants.
+func (role Role) Permissions() []Permission {
+	granted := []Permission{}
+	for _, permission := range permissions {
+		if role.Can(permission) {
+			granted = append(granted, permission)
+		}
+	}
+
+	return granted
+}
+
+// userRole returns the role of a User, the configured default if none is set.
+func (h *Headscale) userRole(user *User) Role {
+	if user.Role != "" {
+		return Role(user.Role)
+	}
+	if h.cfg.Roles.Default != "" {
+		return h.cfg.Roles.Default
+	}
+
+	return RoleMember
+}
+
+// userCan reports whether the User may act on a resource of owner, which
+// only needs the permission when it belongs to someone else of an
+// organization the User has a role in.
+func (h *Headscale) userCan(user *User, owner *User, permission Permission) bool {
+	if owner == nil {
+		return h.userRole(user).Can(permission)
+	}
+	if owner.ID == user.ID {
+		return true
+	}
+
+	// The machines of another organization stay out of sight
+	role, ok := h.organizationRole(user, owner.OrganizationID)
+
+	return ok && role.Can(permission)
+}
+
+// SetUserRole assigns a role to a User on behalf of actor, the role of
+// actor being checked by the caller. Roles set this way are kept when the
+// user logs in again, unless one of the OIDC group mappings matches.
+func (h *Headscale) SetUserRole(actor Role, userName string, role Role) (*User, error) {
+	if !role.valid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+
+	user, err := h.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.setUserRole(actor, user, role, false); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (h *Headscale) setUserRole(actor Role, user *User, role Role, synced bool) error {
+	current := h.userRole(user)
+	if (current == RoleOwner || role == RoleOwner) && current != role && actor != RoleOwner {
+		return ErrOwnerRoleRequired
+	}
+	if current == RoleOwner && role != RoleOwner {
+		last, err := h.isLastOwner(user)
+		if err != nil {
+			return err
+		}
+		if last {
+			return ErrLastOwner
+		}
+	}
+
+	user.Role = string(role)
+	user.RoleSynced = synced
+	if err := h.db.Model(user).Updates(map[string]interface{}{
+		"role":        user.Role,
+		"role_synced": user.RoleSynced,
+	}).Error; err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Str("role", user.Role).
+		Bool("synced", synced).
+		Msg("User role changed")
+
+	return nil
+}
+
+// isLastOwner reports whether the User is the only owner left in its
+// organization.
+func (h *Headscale) isLastOwner(user *User) (bool, error) {
+	if h.userRole(user) != RoleOwner {
+		return false, nil
+	}
+
+	var owners int64
+	if err := h.db.Model(&User{}).
+		Where("role = ? AND organization_id = ?", RoleOwner, user.OrganizationID).
+		Count(&owners).Error; err != nil {
+		return false, err
+	}
+
+	return owners <= 1, nil
+}
+
+// syncUserRole applies the group mappings to the groups of an identity
+// at login. A role from the mappings replaces any other, and is taken
+// back to the default once the user leaves the groups.
+func (h *Headscale) syncUserRole(user *User, groups []string) {
+	if len(h.cfg.Roles.GroupMappings) == 0 {
+		return
+	}
+
+	role, ok := h.cfg.Roles.roleForGroups(groups)
+	if !ok {
+		if !user.RoleSynced {
+			return
+		}
+		role = h.cfg.Roles.Default
+		if role == "" {
+			role = RoleMember
+		}
+	}
+	if Role(user.Role) == role && user.RoleSynced == ok {
+		return
+	}
+
+	if err := h.setUserRole(RoleOwner, user, role, ok); err != nil {
+		log.Error().
+			Err(err).
+			Str("user", user.Name).
+			Str("role", string(role)).
+			Msg("Could not sync the role of the user from the OIDC groups")
+	}
+}
+
+// roleForGroups returns the most privileged role mapped to the groups.
+func (cfg RolesConfig) roleForGroups(groups []string) (Role, bool) {
+	mapped := make(map[Role]bool)
+	for _, mapping := range cfg.GroupMappings {
+		if IsStringInSlice(groups, mapping.Group) {
+			mapped[mapping.Role] = true
+		}
+	}
+	for _, role := range roles {
+		if mapped[role] {
+			return role, true
+		}
+	}
+
+	return "", false
+}
+
+type grpcCallerKey struct{}
+
+// grpcCaller is who sends a gRPC request, set by the authentication
+// interceptor.
+type grpcCaller struct {
+	Role Role
+
+	// User owning the API key, nil for the keys acting as owners
+	User   *User
+	APIKey *APIKey
+}
+
+// grpcCallerRole returns the role of the gRPC caller. Requests over the
+// unix socket, from the CLI and the gateway, have no caller and are owners.
+func grpcCallerRole(ctx context.Context) Role {
+	if caller, ok := ctx.Value(grpcCallerKey{}).(grpcCaller); ok {
+		return caller.Role
+	}
+
+	return RoleOwner
+}
+
+// grpcCallerFromContext returns the caller of a gRPC request, false for
+// the requests over the unix socket from the CLI.
+func grpcCallerFromContext(ctx context.Context) (grpcCaller, bool) {
+	caller, ok := ctx.Value(grpcCallerKey{}).(grpcCaller)
+
+	return caller, ok
+}
+
+// grpcMethodPermissions is the permission required by each gRPC method,
+// methods not listed are reserved to the owners.
+var grpcMethodPermissions = map[string]Permission{
+	"ACLPingPong":           PermissionReadTailnet,
+	"GetUser":               PermissionReadTailnet,
+	"ListUsers":             PermissionReadTailnet,
+	"CreateUser":            PermissionManageUsers,
+	"RenameUser":            PermissionManageUsers,
+	"DeleteUser":            PermissionManageUsers,
+	"SetUserQuota":          PermissionManageUsers,
+	"SetUserPassword":       PermissionManageUsers,
+	"SetUserMagicDNS":       PermissionManageUsers,
+	"SetUserRole":           PermissionManageRoles,
+	"ListPreAuthKeys":       PermissionReadTailnet,
+	"CreatePreAuthKey":      PermissionManageKeys,
+	"ExpirePreAuthKey":      PermissionManageKeys,
+	"RevokePreAuthKey":      PermissionManageKeys,
+	"GetMachine":            PermissionReadTailnet,
+	"ListMachines":          PermissionReadTailnet,
+	"SetTags":               PermissionManageMachines,
+	"RegisterMachine":       PermissionManageMachines,
+	"DeleteMachine":         PermissionManageMachines,
+	"ExpireMachine":         PermissionManageMachines,
+	"RenameMachine":         PermissionManageMachines,
+	"MoveMachine":           PermissionManageMachines,
+	"GetMachineHistory":     PermissionReadTailnet,
+	"GetRoutes":             PermissionReadTailnet,
+	"GetMachineRoutes":      PermissionReadTailnet,
+	"EnableRoute":           PermissionManageRoutes,
+	"DisableRoute":          PermissionManageRoutes,
+	"ListApiKeys":           PermissionManageAPIKeys,
+	"CreateApiKey":          PermissionManageAPIKeys,
+	"ExpireApiKey":          PermissionManageAPIKeys,
+	"ListConsoleSessions":   PermissionReadTailnet,
+	"RevokeConsoleSessions": PermissionManageSessions,
+	"ListDNSRecords":        PermissionReadTailnet,
+	"CreateDNSRecord":       PermissionManageDNS,
+	"UpdateDNSRecord":       PermissionManageDNS,
+	"DeleteDNSRecord":       PermissionManageDNS,
+}
+
+// authorizeGRPCMethod checks the role of a caller against the permission
+// of the full gRPC method name, /headscale.v1.HeadscaleService/ListUsers.
+func authorizeGRPCMethod(role Role, fullMethod string) error {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+
+	if role == RoleOwner {
+		return nil
+	}
+	permission, ok := grpcMethodPermissions[method]
+	if !ok || !role.Can(permission) {
+		return fmt.Errorf("%w: %s requires %s", ErrPermissionDenied, method, permissionName(permission))
+	}
+
+	return nil
+}
+
+func permissionName(permission Permission) string {
+	if permission == "" {
+		return "the owner role"
+	}
+
+	return string(permission)
+}
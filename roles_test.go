@@ -0,0 +1,245 @@
+package headscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/check.v1"
+)
+
+func Test_authorizeGRPCMethod(t *testing.T) {
+	tests := []struct {
+		role    Role
+		method  string
+		wantErr bool
+	}{
+		{RoleOwner, "/headscale.v1.HeadscaleService/CreateApiKey", false},
+		{RoleOwner, "/headscale.v1.HeadscaleService/DebugCreateMachine", false},
+		{RoleAdmin, "/headscale.v1.HeadscaleService/DebugCreateMachine", true},
+		{RoleAdmin, "/headscale.v1.HeadscaleService/SetUserRole", false},
+		{RoleNetworkAdmin, "/headscale.v1.HeadscaleService/EnableRoute", false},
+		{RoleNetworkAdmin, "/headscale.v1.HeadscaleService/DeleteMachine", true},
+		{RoleITAdmin, "/headscale.v1.HeadscaleService/DeleteMachine", false},
+		{RoleITAdmin, "/headscale.v1.HeadscaleService/EnableRoute", true},
+		{RoleNetworkAdmin, "/headscale.v1.HeadscaleService/CreateDNSRecord", false},
+		{RoleITAdmin, "/headscale.v1.HeadscaleService/DeleteDNSRecord", true},
+		{RoleAuditor, "/headscale.v1.HeadscaleService/ListMachines", false},
+		{RoleAuditor, "/headscale.v1.HeadscaleService/ExpireMachine", true},
+		{RoleMember, "/headscale.v1.HeadscaleService/ListMachines", true},
+	}
+
+	for _, test := range tests {
+		err := authorizeGRPCMethod(test.role, test.method)
+		if (err != nil) != test.wantErr {
+			t.Errorf("authorizeGRPCMethod(%s, %s) error = %v, wantErr %v",
+				test.role, test.method, err, test.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("authorizeGRPCMethod(%s, %s) error = %v, want ErrPermissionDenied",
+				test.role, test.method, err)
+		}
+	}
+}
+
+func TestRolesConfig_roleForGroups(t *testing.T) {
+	cfg := RolesConfig{
+		Default: RoleMember,
+		GroupMappings: []RoleGroupMapping{
+			{Group: "/auditors", Role: RoleAuditor},
+			{Group: "/network", Role: RoleNetworkAdmin},
+			{Group: "/admins", Role: RoleAdmin},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		groups   []string
+		want     Role
+		wantSync bool
+	}{
+		{"no groups", nil, "", false},
+		{"unmapped group", []string{"/dev"}, "", false},
+		{"one group", []string{"/dev", "/auditors"}, RoleAuditor, true},
+		{"most privileged wins", []string{"/auditors", "/admins", "/network"}, RoleAdmin, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := cfg.roleForGroups(test.groups)
+			if got != test.want || ok != test.wantSync {
+				t.Errorf("roleForGroups() = %s, %v, want %s, %v", got, ok, test.want, test.wantSync)
+			}
+		})
+	}
+}
+
+func (s *Suite) TestSetUserRole(c *check.C) {
+	alice, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	_, err = app.CreateUser("bob", "bob-uid", "Bob")
+	c.Assert(err, check.IsNil)
+
+	c.Assert(app.userRole(alice), check.Equals, RoleMember)
+
+	_, err = app.SetUserRole(RoleOwner, "alice", Role("superuser"))
+	c.Assert(errors.Is(err, ErrInvalidRole), check.Equals, true)
+
+	_, err = app.SetUserRole(RoleAdmin, "alice", RoleOwner)
+	c.Assert(err, check.Equals, ErrOwnerRoleRequired)
+
+	alice, err = app.SetUserRole(RoleOwner, "alice", RoleOwner)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userRole(alice), check.Equals, RoleOwner)
+
+	// The tailnet always keeps an owner
+	_, err = app.SetUserRole(RoleOwner, "alice", RoleAdmin)
+	c.Assert(err, check.Equals, ErrLastOwner)
+
+	_, err = app.SetUserRole(RoleOwner, "bob", RoleOwner)
+	c.Assert(err, check.IsNil)
+	_, err = app.SetUserRole(RoleAdmin, "bob", RoleAdmin)
+	c.Assert(err, check.Equals, ErrOwnerRoleRequired)
+	bob, err := app.SetUserRole(RoleOwner, "bob", RoleITAdmin)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userRole(bob), check.Equals, RoleITAdmin)
+}
+
+func (s *Suite) TestGRPCOwnerTarget(c *check.C) {
+	_, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	_, err = app.SetUserRole(RoleOwner, "alice", RoleOwner)
+	c.Assert(err, check.IsNil)
+
+	api := newHeadscaleV1APIServer(&app)
+	ctx := context.WithValue(context.Background(), grpcCallerKey{}, grpcCaller{Role: RoleITAdmin})
+
+	_, err = api.SetUserPassword(ctx, &v1.SetUserPasswordRequest{
+		Name:      "alice",
+		Password:  "correct horse battery",
+		ResetTotp: true,
+	})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+	_, err = api.RenameUser(ctx, &v1.RenameUserRequest{OldName: "alice", NewName: "mallory"})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+	_, err = api.DeleteUser(ctx, &v1.DeleteUserRequest{Name: "alice"})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+
+	// Even an owner cannot delete the last one
+	_, err = api.DeleteUser(context.Background(), &v1.DeleteUserRequest{Name: "alice"})
+	c.Assert(err, check.Equals, ErrLastOwner)
+
+	_, err = app.CreateUser("bob", "bob-uid", "Bob")
+	c.Assert(err, check.IsNil)
+	_, err = api.RenameUser(ctx, &v1.RenameUserRequest{OldName: "bob", NewName: "robert"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *Suite) TestSyncUserRole(c *check.C) {
+	app.cfg.Roles = RolesConfig{
+		Default: RoleMember,
+		GroupMappings: []RoleGroupMapping{
+			{Group: "/admins", Role: RoleAdmin},
+		},
+	}
+	defer func() {
+		app.cfg.Roles = RolesConfig{}
+	}()
+
+	user, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	manual, err := app.CreateUser("bob", "bob-uid", "Bob")
+	c.Assert(err, check.IsNil)
+	manual, err = app.SetUserRole(RoleOwner, manual.Name, RoleAuditor)
+	c.Assert(err, check.IsNil)
+
+	app.syncUserRole(user, []string{"/dev", "/admins"})
+	user, err = app.GetUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userRole(user), check.Equals, RoleAdmin)
+	c.Assert(user.RoleSynced, check.Equals, true)
+
+	// Leaving the groups takes a synced role back
+	app.syncUserRole(user, []string{"/dev"})
+	user, err = app.GetUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userRole(user), check.Equals, RoleMember)
+
+	// A role set by hand is kept when no mapping matches
+	app.syncUserRole(manual, []string{"/dev"})
+	manual, err = app.GetUser("bob")
+	c.Assert(err, check.IsNil)
+	c.Assert(app.userRole(manual), check.Equals, RoleAuditor)
+}
+
+func (s *Suite) TestConsoleRoles(c *check.C) {
+	alice, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	bob, err := app.CreateUser("bob", "bob-uid", "Bob")
+	c.Assert(err, check.IsNil)
+
+	machine := Machine{
+		MachineKey:     "foo",
+		NodeKey:        "bar",
+		DiscoKey:       "faa",
+		Hostname:       "bobmachine",
+		UserID:         bob.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+	}
+	app.db.Save(&machine)
+
+	token, err := app.CreateConsoleSession(alice, &ConsoleSession{Provider: localAccountProvider})
+	c.Assert(err, check.IsNil)
+
+	request := func(handler http.HandlerFunc, req *http.Request) APIResponse {
+		req.AddCookie(&http.Cookie{Name: consoleSessionCookie, Value: token})
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		res := APIResponse{}
+		c.Assert(json.NewDecoder(recorder.Body).Decode(&res), check.IsNil)
+
+		return res
+	}
+	history := func() APIResponse {
+		return request(app.CAPIGetMachineHistory, httptest.NewRequest(http.MethodGet,
+			"/admin/api/machine/history?mid="+strconv.FormatUint(machine.ID, Base10), nil))
+	}
+	users := func() APIResponse {
+		return request(app.CAPIGetUsers, httptest.NewRequest(http.MethodGet, "/admin/api/users", nil))
+	}
+
+	// A member only sees their own machines and no users
+	c.Assert(history().Status, check.Equals, "error-用户没有该权限")
+	c.Assert(users().Status, check.Equals, "error-用户没有该权限")
+
+	_, err = app.SetUserRole(RoleOwner, "alice", RoleAuditor)
+	c.Assert(err, check.IsNil)
+	c.Assert(history().Status, check.Equals, "success")
+	res := users()
+	c.Assert(res.Status, check.Equals, "success")
+	data := res.Data.(map[string]interface{})
+	c.Assert(data["users"], check.HasLen, 2)
+	c.Assert(data["canManageRoles"], check.Equals, false)
+
+	quota := func() APIResponse {
+		return request(app.CAPIPostUserQuota, httptest.NewRequest(http.MethodPost, "/admin/api/users/quota",
+			strings.NewReader(`{"name": "bob", "quota": {"maxMachines": 2, "maxRoutes": null}}`)))
+	}
+	c.Assert(quota().Status, check.Equals, "error-用户没有该权限")
+
+	_, err = app.SetUserRole(RoleOwner, "alice", RoleITAdmin)
+	c.Assert(err, check.IsNil)
+	c.Assert(quota().Status, check.Equals, "success")
+	bob, err = app.GetUser("bob")
+	c.Assert(err, check.IsNil)
+	c.Assert(*bob.MaxMachines, check.Equals, uint(2))
+	c.Assert(bob.MaxRoutes, check.IsNil)
+}
@@ -113,6 +113,13 @@ func (h *Headscale) DisableRoute(id uint64) error {
 		return err
 	}
 
+	h.recordMachineHistory(
+		route.MachineID,
+		MachineHistoryRouteDisabled,
+		netip.Prefix(route.Prefix).String(),
+		"",
+	)
+
 	return h.handlePrimarySubnetFailover()
 }
 
@@ -173,16 +180,46 @@ func (h *Headscale) processMachineRoutes(machine *Machine) error {
 		advertisedRoutes[prefix] = false
 	}
 
+	// Subnet routes already advertised keep their place in the quota,
+	// only newly advertised ones have to fit in what is left.
+	remainingQuota, err := h.remainingRouteQuota(machine)
+	if err != nil {
+		return err
+	}
+	for _, route := range currentRoutes {
+		if _, ok := advertisedRoutes[netip.Prefix(route.Prefix)]; ok &&
+			route.Advertised && !route.isExitRoute() && remainingQuota > 0 {
+			remainingQuota--
+		}
+	}
+	quotaExceeded := false
+	fitsInQuota := func(prefix netip.Prefix) bool {
+		if remainingQuota < 0 || isExitPrefix(prefix) {
+			return true
+		}
+		if remainingQuota == 0 {
+			quotaExceeded = true
+
+			return false
+		}
+		remainingQuota--
+
+		return true
+	}
+
 	for pos, route := range currentRoutes {
 		if _, ok := advertisedRoutes[netip.Prefix(route.Prefix)]; ok {
+			advertisedRoutes[netip.Prefix(route.Prefix)] = true
 			if !route.Advertised {
+				if !fitsInQuota(netip.Prefix(route.Prefix)) {
+					continue
+				}
 				currentRoutes[pos].Advertised = true
 				err := h.db.Save(&currentRoutes[pos]).Error
 				if err != nil {
 					return err
 				}
 			}
-			advertisedRoutes[netip.Prefix(route.Prefix)] = true
 		} else if route.Advertised {
 			currentRoutes[pos].Advertised = false
 			currentRoutes[pos].Enabled = false
@@ -195,6 +232,9 @@ func (h *Headscale) processMachineRoutes(machine *Machine) error {
 
 	for prefix, exists := range advertisedRoutes {
 		if !exists {
+			if !fitsInQuota(prefix) {
+				continue
+			}
 			route := Route{
 				MachineID:  machine.ID,
 				Prefix:     IPPrefix(prefix),
@@ -208,6 +248,10 @@ func (h *Headscale) processMachineRoutes(machine *Machine) error {
 		}
 	}
 
+	if quotaExceeded {
+		return ErrUserRouteQuotaExceeded
+	}
+
 	return nil
 }
 
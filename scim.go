@@ -0,0 +1,1102 @@
+package headscale
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	errSCIMGroupExists   = Error("SCIM group already exists")
+	errSCIMGroupNotFound = Error("SCIM group not found")
+	errSCIMInvalidPatch  = Error("invalid SCIM patch operation")
+	errSCIMInvalidFilter = Error("unsupported SCIM filter")
+
+	scimContentType       = "application/scim+json"
+	scimUserSchema        = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema       = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema        = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema       = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSPConfigSchema    = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimDefaultCount      = 100
+	scimExternalUIDPrefix = "scim:"
+)
+
+// scimFilterRegex matches the only filters the identity providers send
+// before provisioning: attribute eq "value".
+var scimFilterRegex = regexp.MustCompile(`^\s*(\w+)\s+eq\s+"([^"]*)"\s*$`)
+
+// SCIMGroup is a group pushed by the identity provider. Its members are
+// the users with its name in their Groups, the group:oidc: ACL aliases.
+type SCIMGroup struct {
+	gorm.Model
+	DisplayName string `gorm:"unique"`
+	ExternalID  string
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimReference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+type scimUser struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	Name        *scimName       `json:"name,omitempty"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Emails      []scimEmail     `json:"emails,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Groups      []scimReference `json:"groups,omitempty"`
+	Meta        *scimMeta       `json:"meta,omitempty"`
+}
+
+type scimGroup struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	DisplayName string          `json:"displayName"`
+	Members     []scimReference `json:"members"`
+	Meta        *scimMeta       `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type scimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+func (h *Headscale) addSCIMHandlers(router *mux.Router) {
+	scimRouter := router.PathPrefix("/scim/v2").Subrouter()
+	scimRouter.Use(h.SCIMAuth)
+
+	scimRouter.HandleFunc("/ServiceProviderConfig", h.SCIMServiceProviderConfig).Methods(http.MethodGet)
+
+	scimRouter.HandleFunc("/Users", h.SCIMListUsers).Methods(http.MethodGet)
+	scimRouter.HandleFunc("/Users", h.SCIMCreateUser).Methods(http.MethodPost)
+	scimRouter.HandleFunc("/Users/{id}", h.SCIMGetUser).Methods(http.MethodGet)
+	scimRouter.HandleFunc("/Users/{id}", h.SCIMReplaceUser).Methods(http.MethodPut)
+	scimRouter.HandleFunc("/Users/{id}", h.SCIMPatchUser).Methods(http.MethodPatch)
+	scimRouter.HandleFunc("/Users/{id}", h.SCIMDeleteUser).Methods(http.MethodDelete)
+
+	scimRouter.HandleFunc("/Groups", h.SCIMListGroups).Methods(http.MethodGet)
+	scimRouter.HandleFunc("/Groups", h.SCIMCreateGroup).Methods(http.MethodPost)
+	scimRouter.HandleFunc("/Groups/{id}", h.SCIMGetGroup).Methods(http.MethodGet)
+	scimRouter.HandleFunc("/Groups/{id}", h.SCIMReplaceGroup).Methods(http.MethodPut)
+	scimRouter.HandleFunc("/Groups/{id}", h.SCIMPatchGroup).Methods(http.MethodPatch)
+	scimRouter.HandleFunc("/Groups/{id}", h.SCIMDeleteGroup).Methods(http.MethodDelete)
+}
+
+// SCIMAuth checks the bearer token of the SCIM endpoints, they are only
+// served when scim.enabled is set.
+func (h *Headscale) SCIMAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.cfg.SCIM.Enabled {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		clientIP := h.clientIP(r)
+		if locked, retryAfter := h.rateLimits.lockedOut(apiKeyEndpoint, clientIP); locked {
+			setRetryAfter(w, retryAfter)
+			scimErrorResponse(w, http.StatusTooManyRequests, "", "too many failed attempts")
+
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), AuthPrefix)
+		if h.cfg.SCIM.Token == "" || token == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.SCIM.Token)) != 1 {
+			log.Info().
+				Str("client_address", clientIP).
+				Msg("Invalid SCIM token")
+			h.rateLimits.failure(apiKeyEndpoint, clientIP)
+			scimErrorResponse(w, http.StatusUnauthorized, "", "invalid bearer token")
+
+			return
+		}
+		h.rateLimits.success(apiKeyEndpoint, clientIP)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func scimResponse(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	if data == nil {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+func scimErrorResponse(w http.ResponseWriter, status int, scimType string, detail string) {
+	scimResponse(w, status, scimError{
+		Schemas:  []string{scimErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// scimErrorFromErr maps the errors of the users and groups to SCIM errors.
+func scimErrorFromErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, errSCIMGroupNotFound):
+		scimErrorResponse(w, http.StatusNotFound, "", err.Error())
+	case errors.Is(err, ErrUserExists), errors.Is(err, errSCIMGroupExists):
+		scimErrorResponse(w, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, errSCIMInvalidPatch), errors.Is(err, ErrInvalidUserName):
+		scimErrorResponse(w, http.StatusBadRequest, "invalidValue", err.Error())
+	case errors.Is(err, errSCIMInvalidFilter):
+		scimErrorResponse(w, http.StatusBadRequest, "invalidFilter", err.Error())
+	default:
+		log.Error().Caller().Err(err).Msg("SCIM request failed")
+		scimErrorResponse(w, http.StatusInternalServerError, "", err.Error())
+	}
+}
+
+// scimListParams returns the startIndex (from 1) and count of a list request.
+func scimListParams(r *http.Request) (int, int) {
+	startIndex, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 {
+		count = scimDefaultCount
+	}
+
+	return startIndex, count
+}
+
+func scimFilter(r *http.Request) (string, string, error) {
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		return "", "", nil
+	}
+	matches := scimFilterRegex.FindStringSubmatch(filter)
+	if matches == nil {
+		return "", "", fmt.Errorf("%w: %s", errSCIMInvalidFilter, filter)
+	}
+
+	return matches[1], matches[2], nil
+}
+
+func scimPage[T any](items []T, startIndex int, count int) []T {
+	if startIndex > len(items) {
+		return []T{}
+	}
+	items = items[startIndex-1:]
+	if count < len(items) {
+		items = items[:count]
+	}
+
+	return items
+}
+
+func scimTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (h *Headscale) scimLocation(resource string, id uint) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%d", strings.TrimSuffix(h.cfg.ServerURL, "/"), resource, id)
+}
+
+func (h *Headscale) getUserByID(id string) (*User, error) {
+	userID, err := strconv.ParseUint(id, Base10, BitSize64)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	user := User{}
+	if err := h.db.First(&user, userID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (h *Headscale) scimUserFromUser(user *User) scimUser {
+	active := !user.Disabled
+	res := scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          strconv.FormatUint(uint64(user.ID), Base10),
+		UserName:    user.Name,
+		Name:        &scimName{Formatted: user.Display_Name},
+		DisplayName: user.Display_Name,
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      scimTime(user.CreatedAt),
+			LastModified: scimTime(user.UpdatedAt),
+			Location:     h.scimLocation("Users", user.ID),
+		},
+	}
+	if strings.HasPrefix(user.OIDC_UID, scimExternalUIDPrefix) {
+		res.ExternalID = strings.TrimPrefix(user.OIDC_UID, scimExternalUIDPrefix)
+	}
+	if user.Email != "" {
+		res.Emails = []scimEmail{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	for _, name := range user.Groups {
+		group := SCIMGroup{}
+		if err := h.db.Where("display_name = ?", name).First(&group).Error; err == nil {
+			res.Groups = append(res.Groups, scimReference{
+				Value:   strconv.FormatUint(uint64(group.ID), Base10),
+				Display: group.DisplayName,
+			})
+		}
+	}
+
+	return res
+}
+
+// displayName returns the display name of the request, falling back to
+// the name and to the user name.
+func (req *scimUser) displayName() string {
+	switch {
+	case req.DisplayName != "":
+		return req.DisplayName
+	case req.Name != nil && req.Name.Formatted != "":
+		return req.Name.Formatted
+	case req.Name != nil && (req.Name.GivenName != "" || req.Name.FamilyName != ""):
+		return strings.TrimSpace(req.Name.FamilyName + req.Name.GivenName)
+	default:
+		return req.UserName
+	}
+}
+
+func (req *scimUser) email() string {
+	for _, email := range req.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(req.Emails) > 0 {
+		return req.Emails[0].Value
+	}
+
+	return ""
+}
+
+// setUserActive disables the user, expiring their machines, keys and
+// sessions, or enables them again.
+func (h *Headscale) setUserActive(user *User, active bool) error {
+	if active == !user.Disabled {
+		return nil
+	}
+	if !active {
+		if err := h.DisableUser(user.Name); err != nil {
+			return err
+		}
+		user.Disabled = true
+
+		return nil
+	}
+
+	if err := h.EnableUser(user.Name); err != nil {
+		return err
+	}
+	user.Disabled = false
+
+	return nil
+}
+
+// applySCIMUser replaces the attributes of a user by the ones of the request.
+func (h *Headscale) applySCIMUser(user *User, req *scimUser) error {
+	if req.UserName != "" {
+		name, err := NormalizeToFQDNRules(req.UserName, h.cfg.OIDC.StripEmaildomain)
+		if err != nil {
+			return err
+		}
+		if name != user.Name {
+			if err := h.RenameUser(user.Name, name); err != nil {
+				return err
+			}
+			user.Name = name
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if displayName := req.displayName(); displayName != "" && displayName != user.Display_Name {
+		user.Display_Name = displayName
+		updates["Display_Name"] = displayName
+	}
+	if email := req.email(); email != user.Email {
+		user.Email = email
+		updates["email"] = email
+	}
+	if req.ExternalID != "" && scimExternalUIDPrefix+req.ExternalID != user.OIDC_UID {
+		user.OIDC_UID = scimExternalUIDPrefix + req.ExternalID
+		updates["OIDC_UID"] = user.OIDC_UID
+	}
+	if len(updates) > 0 {
+		if err := h.db.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	if req.Active != nil {
+		return h.setUserActive(user, *req.Active)
+	}
+
+	return nil
+}
+
+// SCIMServiceProviderConfig answers /scim/v2/ServiceProviderConfig.
+func (h *Headscale) SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	scimResponse(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimSPConfigSchema},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimDefaultCount},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "The token of the scim.token setting",
+		}},
+	})
+}
+
+// SCIMListUsers answers GET /scim/v2/Users.
+func (h *Headscale) SCIMListUsers(w http.ResponseWriter, r *http.Request) {
+	attribute, value, err := scimFilter(r)
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	query := h.db.Order("id")
+	switch attribute {
+	case "":
+	case "userName":
+		name, err := NormalizeToFQDNRules(value, h.cfg.OIDC.StripEmaildomain)
+		if err != nil {
+			name = value
+		}
+		query = query.Where("name = ?", name)
+	case "externalId":
+		query = query.Where(&User{OIDC_UID: scimExternalUIDPrefix + value})
+	default:
+		scimErrorFromErr(w, fmt.Errorf("%w: %s", errSCIMInvalidFilter, attribute))
+
+		return
+	}
+
+	users := []User{}
+	if err := query.Find(&users).Error; err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	startIndex, count := scimListParams(r)
+	page := scimPage(users, startIndex, count)
+	resources := make([]scimUser, 0, len(page))
+	for index := range page {
+		resources = append(resources, h.scimUserFromUser(&page[index]))
+	}
+	scimResponse(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(users),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// SCIMGetUser answers GET /scim/v2/Users/{id}.
+func (h *Headscale) SCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	scimResponse(w, http.StatusOK, h.scimUserFromUser(user))
+}
+
+// SCIMCreateUser answers POST /scim/v2/Users.
+func (h *Headscale) SCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	req := scimUser{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserName == "" {
+		scimErrorResponse(w, http.StatusBadRequest, "invalidSyntax", "userName is required")
+
+		return
+	}
+
+	name, err := NormalizeToFQDNRules(req.UserName, h.cfg.OIDC.StripEmaildomain)
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	uid := scimExternalUIDPrefix + name
+	if req.ExternalID != "" {
+		uid = scimExternalUIDPrefix + req.ExternalID
+	}
+
+	user, err := h.CreateUser(name, uid, req.displayName())
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	if err := h.applySCIMUser(user, &req); err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Str("external_id", req.ExternalID).
+		Msg("User provisioned by SCIM")
+
+	scimResponse(w, http.StatusCreated, h.scimUserFromUser(user))
+}
+
+// SCIMReplaceUser answers PUT /scim/v2/Users/{id}.
+func (h *Headscale) SCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	req := scimUser{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimErrorResponse(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+
+		return
+	}
+	if err := h.applySCIMUser(user, &req); err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	scimResponse(w, http.StatusOK, h.scimUserFromUser(user))
+}
+
+// SCIMPatchUser answers PATCH /scim/v2/Users/{id}. The operations either
+// have a path, or a value holding the attributes to replace.
+func (h *Headscale) SCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	req := scimPatchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimErrorResponse(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+
+		return
+	}
+
+	for _, operation := range req.Operations {
+		update, err := scimUserPatch(operation)
+		if err != nil {
+			scimErrorFromErr(w, err)
+
+			return
+		}
+		if err := h.applySCIMUser(user, update); err != nil {
+			scimErrorFromErr(w, err)
+
+			return
+		}
+	}
+
+	scimResponse(w, http.StatusOK, h.scimUserFromUser(user))
+}
+
+// scimUserPatch turns a patch operation into the attributes it replaces,
+// the other attributes being left empty so they are kept.
+func scimUserPatch(operation scimPatchOperation) (*scimUser, error) {
+	switch strings.ToLower(operation.Op) {
+	case "replace", "add":
+	default:
+		return nil, fmt.Errorf("%w: %s on users", errSCIMInvalidPatch, operation.Op)
+	}
+
+	values := map[string]json.RawMessage{}
+	if operation.Path == "" {
+		if err := json.Unmarshal(operation.Value, &values); err != nil {
+			return nil, fmt.Errorf("%w: %s", errSCIMInvalidPatch, err)
+		}
+	} else {
+		values[operation.Path] = operation.Value
+	}
+
+	update := &scimUser{}
+	for path, value := range values {
+		var err error
+		switch path {
+		case "active":
+			var active bool
+			active, err = scimBool(value)
+			update.Active = &active
+		case "userName":
+			err = json.Unmarshal(value, &update.UserName)
+		case "displayName", "name.formatted":
+			err = json.Unmarshal(value, &update.DisplayName)
+		case "externalId":
+			err = json.Unmarshal(value, &update.ExternalID)
+		case "emails":
+			err = json.Unmarshal(value, &update.Emails)
+		case `emails[type eq "work"].value`:
+			var email string
+			err = json.Unmarshal(value, &email)
+			update.Emails = []scimEmail{{Value: email, Primary: true}}
+		default:
+			// Attributes headscale does not keep are ignored
+			log.Debug().Str("path", path).Msg("Ignoring SCIM attribute")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", errSCIMInvalidPatch, path, err)
+		}
+	}
+
+	return update, nil
+}
+
+// scimBool reads a boolean, some identity providers send "True" or "False".
+func scimBool(value json.RawMessage) (bool, error) {
+	var boolean bool
+	if err := json.Unmarshal(value, &boolean); err == nil {
+		return boolean, nil
+	}
+	var str string
+	if err := json.Unmarshal(value, &str); err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(strings.ToLower(str))
+}
+
+// SCIMDeleteUser answers DELETE /scim/v2/Users/{id}, the machines of the
+// user are deleted with them.
+func (h *Headscale) SCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	if _, err := h.DeleteUser(user.Name, "", true); err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	scimResponse(w, http.StatusNoContent, nil)
+}
+
+func (h *Headscale) getSCIMGroup(id string) (*SCIMGroup, error) {
+	groupID, err := strconv.ParseUint(id, Base10, BitSize64)
+	if err != nil {
+		return nil, errSCIMGroupNotFound
+	}
+	group := SCIMGroup{}
+	if err := h.db.First(&group, groupID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errSCIMGroupNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// scimGroupMembers returns the users in a group.
+func (h *Headscale) scimGroupMembers(name string) ([]User, error) {
+	users, err := h.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]User, 0)
+	for _, user := range users {
+		if IsStringInSlice(user.Groups, name) {
+			members = append(members, user)
+		}
+	}
+
+	return members, nil
+}
+
+func (h *Headscale) scimGroupFromGroup(group *SCIMGroup) (scimGroup, error) {
+	members, err := h.scimGroupMembers(group.DisplayName)
+	if err != nil {
+		return scimGroup{}, err
+	}
+	res := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          strconv.FormatUint(uint64(group.ID), Base10),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     make([]scimReference, 0, len(members)),
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      scimTime(group.CreatedAt),
+			LastModified: scimTime(group.UpdatedAt),
+			Location:     h.scimLocation("Groups", group.ID),
+		},
+	}
+	for _, member := range members {
+		res.Members = append(res.Members, scimReference{
+			Value:   strconv.FormatUint(uint64(member.ID), Base10),
+			Display: member.Display_Name,
+		})
+	}
+
+	return res, nil
+}
+
+// setUserGroups saves the groups of a user, the caller updates the rules.
+func (h *Headscale) setUserGroups(user *User, groups []string) error {
+	sort.Strings(groups)
+	user.Groups = groups
+
+	return h.db.Model(user).Update("groups", user.Groups).Error
+}
+
+// setSCIMGroupMembers makes the users with the given IDs the only members
+// of the group, add and remove only change the given ones.
+func (h *Headscale) setSCIMGroupMembers(group *SCIMGroup, op string, ids []string) error {
+	users, err := h.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	for index := range users {
+		user := &users[index]
+		id := strconv.FormatUint(uint64(user.ID), Base10)
+		listed := IsStringInSlice(ids, id)
+		member := IsStringInSlice(user.Groups, group.DisplayName)
+
+		var want bool
+		switch op {
+		case "add":
+			want = member || listed
+		case "remove":
+			want = member && !listed
+		default:
+			want = listed
+		}
+		if want == member {
+			continue
+		}
+
+		groups := make([]string, 0, len(user.Groups)+1)
+		for _, name := range user.Groups {
+			if name != group.DisplayName {
+				groups = append(groups, name)
+			}
+		}
+		if want {
+			groups = append(groups, group.DisplayName)
+		}
+		if err := h.setUserGroups(user, groups); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renameSCIMGroup renames the group and the memberships of its users.
+func (h *Headscale) renameSCIMGroup(group *SCIMGroup, name string) error {
+	if name == "" || name == group.DisplayName {
+		return nil
+	}
+	var existing int64
+	if err := h.db.Model(&SCIMGroup{}).Where("display_name = ?", name).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return errSCIMGroupExists
+	}
+
+	members, err := h.scimGroupMembers(group.DisplayName)
+	if err != nil {
+		return err
+	}
+	for index := range members {
+		groups := make([]string, 0, len(members[index].Groups))
+		for _, groupName := range members[index].Groups {
+			if groupName == group.DisplayName {
+				groupName = name
+			}
+			groups = append(groups, groupName)
+		}
+		if err := h.setUserGroups(&members[index], groups); err != nil {
+			return err
+		}
+	}
+
+	group.DisplayName = name
+
+	return h.db.Model(group).Update("display_name", name).Error
+}
+
+// scimGroupsChanged generates the rules again after a change of the
+// memberships.
+func (h *Headscale) scimGroupsChanged() {
+	if err := h.UpdateACLRules(); err != nil && !errors.Is(err, errEmptyPolicy) {
+		log.Error().Err(err).Msg("Could not update the ACL rules")
+	}
+	h.setLastStateChangeToNow()
+}
+
+func scimMemberIDs(members []scimReference) []string {
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.Value)
+	}
+
+	return ids
+}
+
+// SCIMListGroups answers GET /scim/v2/Groups.
+func (h *Headscale) SCIMListGroups(w http.ResponseWriter, r *http.Request) {
+	attribute, value, err := scimFilter(r)
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	query := h.db.Order("id")
+	switch attribute {
+	case "":
+	case "displayName":
+		query = query.Where("display_name = ?", value)
+	case "externalId":
+		query = query.Where("external_id = ?", value)
+	default:
+		scimErrorFromErr(w, fmt.Errorf("%w: %s", errSCIMInvalidFilter, attribute))
+
+		return
+	}
+
+	groups := []SCIMGroup{}
+	if err := query.Find(&groups).Error; err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	startIndex, count := scimListParams(r)
+	page := scimPage(groups, startIndex, count)
+	resources := make([]scimGroup, 0, len(page))
+	for index := range page {
+		group, err := h.scimGroupFromGroup(&page[index])
+		if err != nil {
+			scimErrorFromErr(w, err)
+
+			return
+		}
+		resources = append(resources, group)
+	}
+	scimResponse(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(groups),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// SCIMGetGroup answers GET /scim/v2/Groups/{id}.
+func (h *Headscale) SCIMGetGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.getSCIMGroup(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	res, err := h.scimGroupFromGroup(group)
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+
+	scimResponse(w, http.StatusOK, res)
+}
+
+// SCIMCreateGroup answers POST /scim/v2/Groups.
+func (h *Headscale) SCIMCreateGroup(w http.ResponseWriter, r *http.Request) {
+	req := scimGroup{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DisplayName == "" {
+		scimErrorResponse(w, http.StatusBadRequest, "invalidSyntax", "displayName is required")
+
+		return
+	}
+
+	var existing int64
+	if err := h.db.Model(&SCIMGroup{}).Where("display_name = ?", req.DisplayName).Count(&existing).Error; err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	if existing > 0 {
+		scimErrorFromErr(w, errSCIMGroupExists)
+
+		return
+	}
+
+	group := SCIMGroup{DisplayName: req.DisplayName, ExternalID: req.ExternalID}
+	if err := h.db.Create(&group).Error; err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	if err := h.setSCIMGroupMembers(&group, "add", scimMemberIDs(req.Members)); err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	h.scimGroupsChanged()
+
+	log.Info().Str("group", group.DisplayName).Msg("Group provisioned by SCIM")
+
+	res, err := h.scimGroupFromGroup(&group)
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	scimResponse(w, http.StatusCreated, res)
+}
+
+// SCIMReplaceGroup answers PUT /scim/v2/Groups/{id}.
+func (h *Headscale) SCIMReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.getSCIMGroup(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	req := scimGroup{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimErrorResponse(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+
+		return
+	}
+
+	if err := h.renameSCIMGroup(group, req.DisplayName); err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	if req.ExternalID != group.ExternalID {
+		group.ExternalID = req.ExternalID
+		if err := h.db.Model(group).Update("external_id", req.ExternalID).Error; err != nil {
+			scimErrorFromErr(w, err)
+
+			return
+		}
+	}
+	if err := h.setSCIMGroupMembers(group, "replace", scimMemberIDs(req.Members)); err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	h.scimGroupsChanged()
+
+	res, err := h.scimGroupFromGroup(group)
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	scimResponse(w, http.StatusOK, res)
+}
+
+// scimMemberPathRegex matches the removal of one member: members[value eq "id"]
+var scimMemberPathRegex = regexp.MustCompile(`^members\[value eq "([^"]*)"\]$`)
+
+// SCIMPatchGroup answers PATCH /scim/v2/Groups/{id}, which adds, removes
+// or replaces members, or renames the group.
+func (h *Headscale) SCIMPatchGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.getSCIMGroup(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	req := scimPatchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimErrorResponse(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+
+		return
+	}
+
+	for _, operation := range req.Operations {
+		if err := h.applySCIMGroupPatch(group, operation); err != nil {
+			scimErrorFromErr(w, err)
+
+			return
+		}
+	}
+	h.scimGroupsChanged()
+
+	res, err := h.scimGroupFromGroup(group)
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	scimResponse(w, http.StatusOK, res)
+}
+
+func (h *Headscale) applySCIMGroupPatch(group *SCIMGroup, operation scimPatchOperation) error {
+	op := strings.ToLower(operation.Op)
+
+	if matches := scimMemberPathRegex.FindStringSubmatch(operation.Path); matches != nil {
+		if op != "remove" {
+			return fmt.Errorf("%w: %s on %s", errSCIMInvalidPatch, op, operation.Path)
+		}
+
+		return h.setSCIMGroupMembers(group, "remove", []string{matches[1]})
+	}
+
+	switch operation.Path {
+	case "members":
+		members := []scimReference{}
+		if len(operation.Value) > 0 {
+			if err := json.Unmarshal(operation.Value, &members); err != nil {
+				return fmt.Errorf("%w: %s", errSCIMInvalidPatch, err)
+			}
+		}
+		if op == "remove" && len(members) == 0 {
+			return h.setSCIMGroupMembers(group, "replace", nil)
+		}
+		if op != "add" && op != "remove" && op != "replace" {
+			return fmt.Errorf("%w: %s", errSCIMInvalidPatch, op)
+		}
+
+		return h.setSCIMGroupMembers(group, op, scimMemberIDs(members))
+	case "displayName":
+		var name string
+		if err := json.Unmarshal(operation.Value, &name); err != nil {
+			return fmt.Errorf("%w: %s", errSCIMInvalidPatch, err)
+		}
+
+		return h.renameSCIMGroup(group, name)
+	case "externalId":
+		var externalID string
+		if err := json.Unmarshal(operation.Value, &externalID); err != nil {
+			return fmt.Errorf("%w: %s", errSCIMInvalidPatch, err)
+		}
+		group.ExternalID = externalID
+
+		return h.db.Model(group).Update("external_id", externalID).Error
+	case "":
+		values := scimGroup{}
+		if err := json.Unmarshal(operation.Value, &values); err != nil {
+			return fmt.Errorf("%w: %s", errSCIMInvalidPatch, err)
+		}
+		if err := h.renameSCIMGroup(group, values.DisplayName); err != nil {
+			return err
+		}
+		if values.Members != nil {
+			return h.setSCIMGroupMembers(group, op, scimMemberIDs(values.Members))
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errSCIMInvalidPatch, operation.Path)
+	}
+}
+
+// SCIMDeleteGroup answers DELETE /scim/v2/Groups/{id}, the users leave it.
+func (h *Headscale) SCIMDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.getSCIMGroup(mux.Vars(r)["id"])
+	if err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	if err := h.setSCIMGroupMembers(group, "replace", nil); err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	if err := h.db.Unscoped().Delete(group).Error; err != nil {
+		scimErrorFromErr(w, err)
+
+		return
+	}
+	h.scimGroupsChanged()
+
+	log.Info().Str("group", group.DisplayName).Msg("Group deleted by SCIM")
+
+	scimResponse(w, http.StatusNoContent, nil)
+}
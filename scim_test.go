@@ -0,0 +1,207 @@
+package headscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/check.v1"
+)
+
+func scimTestRequest(
+	c *check.C,
+	method string,
+	path string,
+	body string,
+	res interface{},
+) int {
+	router := mux.NewRouter()
+	app.addSCIMHandlers(router)
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", AuthPrefix+"scim-token")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	if res != nil && recorder.Body.Len() > 0 {
+		c.Assert(json.NewDecoder(recorder.Body).Decode(res), check.IsNil)
+	}
+
+	return recorder.Code
+}
+
+func (s *Suite) TestSCIMAuth(c *check.C) {
+	router := mux.NewRouter()
+	app.addSCIMHandlers(router)
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+		req.Header.Set("Authorization", AuthPrefix+token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		return recorder.Code
+	}
+
+	c.Assert(request("scim-token"), check.Equals, http.StatusNotFound)
+
+	app.cfg.SCIM = SCIMConfig{Enabled: true, Token: "scim-token"}
+	defer func() { app.cfg.SCIM = SCIMConfig{} }()
+
+	c.Assert(request("wrong-token"), check.Equals, http.StatusUnauthorized)
+	c.Assert(request(""), check.Equals, http.StatusUnauthorized)
+	c.Assert(request("scim-token"), check.Equals, http.StatusOK)
+}
+
+func (s *Suite) TestSCIMUsers(c *check.C) {
+	app.cfg.SCIM = SCIMConfig{Enabled: true, Token: "scim-token"}
+	defer func() { app.cfg.SCIM = SCIMConfig{} }()
+
+	created := scimUser{}
+	code := scimTestRequest(c, http.MethodPost, "/scim/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "alice",
+		"externalId": "00u1",
+		"name": {"formatted": "Alice Liddell"},
+		"emails": [{"value": "alice@example.com", "primary": true}],
+		"active": true
+	}`, &created)
+	c.Assert(code, check.Equals, http.StatusCreated)
+	c.Assert(created.UserName, check.Equals, "alice")
+	c.Assert(created.ExternalID, check.Equals, "00u1")
+	c.Assert(created.DisplayName, check.Equals, "Alice Liddell")
+
+	user, err := app.GetUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(user.OIDC_UID, check.Equals, "scim:00u1")
+	c.Assert(user.Email, check.Equals, "alice@example.com")
+
+	code = scimTestRequest(c, http.MethodPost, "/scim/v2/Users",
+		`{"userName": "alice", "externalId": "00u2"}`, nil)
+	c.Assert(code, check.Equals, http.StatusConflict)
+
+	list := scimListResponse{}
+	code = scimTestRequest(c, http.MethodGet,
+		`/scim/v2/Users?filter=userName+eq+%22alice%22`, "", &list)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(list.TotalResults, check.Equals, 1)
+	code = scimTestRequest(c, http.MethodGet,
+		`/scim/v2/Users?filter=externalId+eq+%2200u9%22`, "", &list)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(list.TotalResults, check.Equals, 0)
+	code = scimTestRequest(c, http.MethodGet,
+		`/scim/v2/Users?filter=title+sw+%22a%22`, "", nil)
+	c.Assert(code, check.Equals, http.StatusBadRequest)
+
+	pak, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+	machine := Machine{
+		ID:             0,
+		MachineKey:     "machine-key-alice",
+		NodeKey:        "node-key-alice",
+		DiscoKey:       "disco-key-alice",
+		Hostname:       "machine-alice",
+		GivenName:      "machine-alice",
+		UserID:         user.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+		AuthKeyID:      uint(pak.ID),
+	}
+	app.db.Save(&machine)
+
+	// Deactivating the user expires their machines and keys
+	path := fmt.Sprintf("/scim/v2/Users/%s", created.ID)
+	patched := scimUser{}
+	code = scimTestRequest(c, http.MethodPatch, path, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "Replace", "path": "active", "value": "False"}]
+	}`, &patched)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(*patched.Active, check.Equals, false)
+
+	user, err = app.GetUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(user.Disabled, check.Equals, true)
+	machines, err := app.ListMachinesByUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(machines, check.HasLen, 1)
+	c.Assert(machines[0].isExpired(), check.Equals, true)
+	_, err = app.checkKeyValidity(pak.Key)
+	c.Assert(err, check.NotNil)
+
+	code = scimTestRequest(c, http.MethodPatch, path, `{
+		"Operations": [{"op": "replace", "value": {"active": true, "displayName": "Alice"}}]
+	}`, &patched)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(*patched.Active, check.Equals, true)
+	c.Assert(patched.DisplayName, check.Equals, "Alice")
+
+	replaced := scimUser{}
+	code = scimTestRequest(c, http.MethodPut, path,
+		`{"userName": "alice2", "displayName": "Alice", "active": true}`, &replaced)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(replaced.UserName, check.Equals, "alice2")
+	c.Assert(replaced.Emails, check.HasLen, 0)
+
+	code = scimTestRequest(c, http.MethodDelete, path, "", nil)
+	c.Assert(code, check.Equals, http.StatusNoContent)
+	_, err = app.GetUser("alice2")
+	c.Assert(err, check.Equals, ErrUserNotFound)
+	code = scimTestRequest(c, http.MethodGet, path, "", nil)
+	c.Assert(code, check.Equals, http.StatusNotFound)
+}
+
+func (s *Suite) TestSCIMGroups(c *check.C) {
+	app.cfg.SCIM = SCIMConfig{Enabled: true, Token: "scim-token"}
+	defer func() { app.cfg.SCIM = SCIMConfig{} }()
+
+	alice, err := app.CreateUser("alice", "alice-uid", "Alice")
+	c.Assert(err, check.IsNil)
+	bob, err := app.CreateUser("bob", "bob-uid", "Bob")
+	c.Assert(err, check.IsNil)
+
+	group := scimGroup{}
+	code := scimTestRequest(c, http.MethodPost, "/scim/v2/Groups", fmt.Sprintf(`{
+		"displayName": "engineering",
+		"members": [{"value": "%d"}]
+	}`, alice.ID), &group)
+	c.Assert(code, check.Equals, http.StatusCreated)
+	c.Assert(group.Members, check.HasLen, 1)
+
+	alice, err = app.GetUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert([]string(alice.Groups), check.DeepEquals, []string{"engineering"})
+
+	path := "/scim/v2/Groups/" + group.ID
+	code = scimTestRequest(c, http.MethodPatch, path, fmt.Sprintf(`{
+		"Operations": [
+			{"op": "add", "path": "members", "value": [{"value": "%d"}]},
+			{"op": "remove", "path": "members[value eq \"%d\"]"},
+			{"op": "replace", "path": "displayName", "value": "platform"}
+		]
+	}`, bob.ID, alice.ID), &group)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(group.DisplayName, check.Equals, "platform")
+	c.Assert(group.Members, check.HasLen, 1)
+	c.Assert(group.Members[0].Value, check.Equals, fmt.Sprint(bob.ID))
+
+	alice, err = app.GetUser("alice")
+	c.Assert(err, check.IsNil)
+	c.Assert(alice.Groups, check.HasLen, 0)
+	bob, err = app.GetUser("bob")
+	c.Assert(err, check.IsNil)
+	c.Assert([]string(bob.Groups), check.DeepEquals, []string{"platform"})
+
+	list := scimListResponse{}
+	code = scimTestRequest(c, http.MethodGet,
+		`/scim/v2/Groups?filter=displayName+eq+%22platform%22`, "", &list)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(list.TotalResults, check.Equals, 1)
+
+	code = scimTestRequest(c, http.MethodDelete, path, "", nil)
+	c.Assert(code, check.Equals, http.StatusNoContent)
+	bob, err = app.GetUser("bob")
+	c.Assert(err, check.IsNil)
+	c.Assert(bob.Groups, check.HasLen, 0)
+}
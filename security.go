@@ -0,0 +1,185 @@
+package headscale
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// csrfCookie and csrfHeader are the names axios uses by default, the
+	// console sends the token back without any change to its requests.
+	csrfCookie = "XSRF-TOKEN"
+	csrfHeader = "X-XSRF-TOKEN"
+
+	contentSecurityPolicy = "default-src 'self'; " +
+		"script-src 'self' https://unpkg.com; " +
+		"style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data:; " +
+		"object-src 'none'; " +
+		"base-uri 'self'; " +
+		"frame-ancestors 'none'"
+
+	strictTransportSecurity = "max-age=31536000"
+)
+
+// consoleCSRFToken derives the CSRF token of a console session from the
+// session token, so that a token planted by another site, or by a sibling
+// domain, does not match any session.
+func consoleCSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(sessionToken))
+	mac.Write([]byte(csrfCookie))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setCSRFCookie sets the CSRF token of a console session, readable by the
+// console scripts, or removes it when sessionToken is empty.
+func (h *Headscale) setCSRFCookie(
+	w http.ResponseWriter,
+	r *http.Request,
+	sessionToken string,
+	expiry time.Time,
+) {
+	domain, path := h.cookieScope()
+	cookie := &http.Cookie{
+		Name:     csrfCookie,
+		Secure:   h.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+		Domain:   domain,
+		Path:     path,
+		Expires:  expiry,
+	}
+	if sessionToken == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Value = consoleCSRFToken(sessionToken)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// sameOrigin checks the Origin of a request, or its Referer, against
+// server_url and the host the client connected to. Requests without
+// either header, such as those of scripts, are left to the CSRF token.
+func (h *Headscale) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	origin = strings.ToLower(parsed.Scheme + "://" + parsed.Host)
+
+	if serverURL, err := url.Parse(h.cfg.ServerURL); err == nil &&
+		origin == strings.ToLower(serverURL.Scheme+"://"+serverURL.Host) {
+		return true
+	}
+
+	return origin == strings.ToLower(h.requestScheme(r)+"://"+r.Host)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func writeCSRFRejected(w http.ResponseWriter, r *http.Request, reason string) {
+	log.Info().
+		Str("path", r.URL.Path).
+		Str("origin", r.Header.Get("Origin")).
+		Msg(reason)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	if err := json.NewEncoder(w).Encode(&APIResponse{Status: "error-跨站请求校验失败，请刷新页面后重试"}); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+// ConsoleCSRF rejects the state-changing console requests coming from
+// another origin or without the CSRF token of the session in the
+// X-XSRF-TOKEN header, and hands the token to the console on the other
+// requests.
+func (h *Headscale) ConsoleCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := r.Cookie(consoleSessionCookie)
+		if err != nil || session.Value == "" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+		want := consoleCSRFToken(session.Value)
+
+		if isSafeMethod(r.Method) {
+			// The sessions started before the token existed get it here
+			if cookie, err := r.Cookie(csrfCookie); err != nil || cookie.Value != want {
+				h.setCSRFCookie(w, r, session.Value, time.Time{})
+			}
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if !h.sameOrigin(r) {
+			writeCSRFRejected(w, r, "Cross-origin console request rejected")
+
+			return
+		}
+		if !hmac.Equal([]byte(r.Header.Get(csrfHeader)), []byte(want)) {
+			writeCSRFRejected(w, r, "Console request without a valid CSRF token rejected")
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOriginOnly rejects the requests of another origin to the endpoints
+// used before a console session exists, such as the logins.
+func (h *Headscale) sameOriginOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.sameOrigin(r) {
+			writeCSRFRejected(w, r, "Cross-origin console request rejected")
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// securityHeaders adds the Content-Security-Policy, the framing and the
+// HSTS headers to the responses.
+func (h *Headscale) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "same-origin")
+		if h.requestScheme(r) == "https" {
+			header.Set("Strict-Transport-Security", strictTransportSecurity)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,192 @@
+package headscale
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ConsoleCSRF(t *testing.T) {
+	h := &Headscale{cfg: &Config{ServerURL: "https://headscale.example.com"}}
+	handler := h.ConsoleCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	token := consoleCSRFToken("session")
+
+	tests := []struct {
+		name     string
+		method   string
+		origin   string
+		referer  string
+		session  string
+		header   string
+		wantCode int
+	}{
+		{
+			name:     "same origin with token",
+			method:   http.MethodPost,
+			origin:   "https://headscale.example.com",
+			session:  "session",
+			header:   token,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "cross origin with token",
+			method:   http.MethodPost,
+			origin:   "https://evil.example.com",
+			session:  "session",
+			header:   token,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "cross origin referer",
+			method:   http.MethodDelete,
+			referer:  "https://evil.example.com/page",
+			session:  "session",
+			header:   token,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "opaque origin",
+			method:   http.MethodPost,
+			origin:   "null",
+			session:  "session",
+			header:   token,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "without token",
+			method:   http.MethodPost,
+			origin:   "https://headscale.example.com",
+			session:  "session",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "token of another session",
+			method:   http.MethodPost,
+			session:  "session",
+			header:   consoleCSRFToken("other"),
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "cross origin read",
+			method:   http.MethodGet,
+			origin:   "https://evil.example.com",
+			session:  "session",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "without session",
+			method:   http.MethodPost,
+			origin:   "https://evil.example.com",
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "https://headscale.example.com/admin/api/keys", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			if tt.session != "" {
+				req.AddCookie(&http.Cookie{Name: consoleSessionCookie, Value: tt.session})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeader, tt.header)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", recorder.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_ConsoleCSRF_setsToken(t *testing.T) {
+	h := &Headscale{cfg: &Config{ServerURL: "https://headscale.example.com"}}
+	handler := h.ConsoleCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.AddCookie(&http.Cookie{Name: consoleSessionCookie, Value: "session"})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie {
+		t.Fatalf("cookies = %v, want the CSRF token", cookies)
+	}
+	if cookies[0].Value != consoleCSRFToken("session") || cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Errorf("cookie = %v, want the token of the session readable by scripts", cookies[0])
+	}
+
+	req.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if cookies := recorder.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookies = %v, want the token set once", cookies)
+	}
+}
+
+func Test_sameOrigin(t *testing.T) {
+	h := &Headscale{cfg: &Config{ServerURL: "https://headscale.example.com:8443/mirage"}}
+
+	tests := []struct {
+		name   string
+		host   string
+		tls    bool
+		origin string
+		want   bool
+	}{
+		{name: "no origin", host: "headscale.example.com:8443", want: true},
+		{name: "server url", host: "10.0.0.1:8080", origin: "https://headscale.example.com:8443", want: true},
+		{name: "request host", host: "10.0.0.1:8080", origin: "http://10.0.0.1:8080", want: true},
+		{name: "other port", host: "headscale.example.com:8443", origin: "https://headscale.example.com", want: false},
+		{name: "other scheme", host: "headscale.example.com:8443", tls: true, origin: "http://headscale.example.com:8443", want: false},
+		{name: "other site", host: "headscale.example.com:8443", origin: "https://evil.example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login/local", nil)
+			req.Host = tt.host
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := h.sameOrigin(req); got != tt.want {
+				t.Errorf("sameOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_securityHeaders(t *testing.T) {
+	h := &Headscale{cfg: &Config{}}
+	handler := h.securityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if got := recorder.Header().Get("Content-Security-Policy"); got != contentSecurityPolicy {
+		t.Errorf("Content-Security-Policy = %q, want %q", got, contentSecurityPolicy)
+	}
+	if got := recorder.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options = %q, want DENY", got)
+	}
+	if got := recorder.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Strict-Transport-Security = %q over http, want none", got)
+	}
+
+	req.TLS = &tls.ConnectionState{}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if got := recorder.Header().Get("Strict-Transport-Security"); got != strictTransportSecurity {
+		t.Errorf("Strict-Transport-Security = %q, want %q", got, strictTransportSecurity)
+	}
+}
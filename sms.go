@@ -0,0 +1,269 @@
+package headscale
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	dysmsapi20170525 "github.com/alibabacloud-go/dysmsapi-20170525/v3/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	errUnknownSMSProvider = Error("unknown sms provider")
+	errSMSWebhookURLEmpty = Error("sms.webhook_url is required by the webhook sms provider")
+	errSMSLogPathEmpty    = Error("sms.log_path is required by the file sms provider")
+	errSMSWebhookFailed   = Error("sms webhook failed")
+	errSMSCodeNotFound    = Error("no verification code was sent to this mobile")
+	errSMSCodeMismatch    = Error("verification code does not match")
+	errSMSCodeIPMismatch  = Error("verification code was requested from another address")
+
+	SMSProviderAliyun  = "aliyun"
+	SMSProviderWebhook = "webhook"
+	SMSProviderFile    = "file"
+
+	verifyCodeDigits = 6
+	smsSendTimeout   = 10 * time.Second
+)
+
+// SMSSender sends the verification codes of the self-registration.
+type SMSSender interface {
+	SendVerifyCode(ctx context.Context, mobile string, code string) error
+}
+
+// newSMSSender returns the sender chosen by the sms.provider setting.
+func newSMSSender(cfg *Config) (SMSSender, error) {
+	switch cfg.SMS.Provider {
+	case SMSProviderAliyun:
+		return &aliyunSMSSender{
+			accessKeyID:     cfg.ali_IDaaS.ali_access_id,
+			accessKeySecret: cfg.ali_IDaaS.ali_access_key,
+			signName:        cfg.ali_IDaaS.ali_sms_sign,
+			templateCode:    cfg.ali_IDaaS.ali_sms_template,
+		}, nil
+	case SMSProviderWebhook:
+		if cfg.SMS.WebhookURL == "" {
+			return nil, errSMSWebhookURLEmpty
+		}
+
+		return &webhookSMSSender{url: cfg.SMS.WebhookURL}, nil
+	case SMSProviderFile:
+		if cfg.SMS.LogPath == "" {
+			return nil, errSMSLogPathEmpty
+		}
+
+		return &fileSMSSender{path: cfg.SMS.LogPath}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownSMSProvider, cfg.SMS.Provider)
+	}
+}
+
+// aliyunSMSSender sends the codes with the Aliyun dysmsapi.
+type aliyunSMSSender struct {
+	accessKeyID     string
+	accessKeySecret string
+	signName        string
+	templateCode    string
+}
+
+func (sender *aliyunSMSSender) SendVerifyCode(ctx context.Context, mobile string, code string) (err error) {
+	config := &openapi.Config{
+		AccessKeyId:     &sender.accessKeyID,
+		AccessKeySecret: &sender.accessKeySecret,
+	}
+	// 访问的域名
+	config.Endpoint = tea.String("dysmsapi.aliyuncs.com")
+	client, err := dysmsapi20170525.NewClient(config)
+	if err != nil {
+		return err
+	}
+
+	// The SDK panics on some transport errors
+	defer func() {
+		if r := tea.Recover(recover()); r != nil {
+			err = r
+		}
+	}()
+
+	_, err = client.SendSmsWithOptions(&dysmsapi20170525.SendSmsRequest{
+		PhoneNumbers:  &mobile,
+		SignName:      &sender.signName,
+		TemplateCode:  &sender.templateCode,
+		TemplateParam: tea.String("{\"code\":\"" + code + "\"}"),
+	}, &util.RuntimeOptions{})
+
+	return err
+}
+
+// webhookSMSSender POSTs the mobile and the code as JSON to a URL,
+// to plug in any SMS gateway.
+type webhookSMSSender struct {
+	url string
+}
+
+func (sender *webhookSMSSender) SendVerifyCode(ctx context.Context, mobile string, code string) error {
+	body, err := json.Marshal(map[string]string{
+		"mobile": mobile,
+		"code":   code,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smsSendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sender.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: status %d", errSMSWebhookFailed, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// fileSMSSender appends the codes to a file instead of sending them,
+// for development deployments.
+type fileSMSSender struct {
+	path string
+}
+
+func (sender *fileSMSSender) SendVerifyCode(ctx context.Context, mobile string, code string) error {
+	file, err := os.OpenFile(sender.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s %s %s\n", time.Now().UTC().Format(time.RFC3339), mobile, code)
+
+	return err
+}
+
+// generateVerifyCode returns a random code of verifyCodeDigits digits,
+// never starting with 0.
+func generateVerifyCode() (string, error) {
+	low := new(big.Int).Exp(big.NewInt(10), big.NewInt(verifyCodeDigits-1), nil)
+	span := new(big.Int).Mul(low, big.NewInt(9))
+
+	number, err := rand.Int(rand.Reader, span)
+	if err != nil {
+		return "", err
+	}
+
+	return number.Add(number, low).String(), nil
+}
+
+// SMSThrottledError is returned when a code was sent to the same mobile,
+// or requested from the same address, too recently.
+type SMSThrottledError struct {
+	ByMobile bool
+	RetryAt  time.Time
+}
+
+func (e *SMSThrottledError) Error() string {
+	target := "address"
+	if e.ByMobile {
+		target = "mobile"
+	}
+
+	return fmt.Sprintf("a verification code was sent to this %s recently, retry after %s", target, e.RetryAt)
+}
+
+// smsVerifier issues the verification codes of the self-registration and
+// checks them. A mobile and an address can get one code per expiration.
+type smsVerifier struct {
+	sender       SMSSender
+	codes        *cache.Cache
+	expiration   time.Duration
+	generateCode func() (string, error)
+}
+
+func newSMSVerifier(sender SMSSender, expiration time.Duration, cleanup time.Duration) *smsVerifier {
+	return &smsVerifier{
+		sender:       sender,
+		codes:        cache.New(expiration, cleanup),
+		expiration:   expiration,
+		generateCode: generateVerifyCode,
+	}
+}
+
+// Send generates a code for the mobile and sends it.
+func (verifier *smsVerifier) Send(ctx context.Context, mobile string, name string, reqIP string) error {
+	if _, retryAt, found := verifier.codes.GetWithExpiration(mobile); found {
+		return &SMSThrottledError{ByMobile: true, RetryAt: retryAt}
+	}
+	if _, retryAt, found := verifier.codes.GetWithExpiration(reqIP); found {
+		return &SMSThrottledError{ByMobile: false, RetryAt: retryAt}
+	}
+
+	code, err := verifier.generateCode()
+	if err != nil {
+		return err
+	}
+
+	userReg := UserReg{
+		reqIP:   reqIP,
+		Name:    name,
+		SMSCode: code,
+	}
+	// 记录验证码缓存，避免大量发送
+	verifier.codes.Set(mobile, userReg, verifier.expiration)
+	verifier.codes.Set(reqIP, userReg, verifier.expiration)
+
+	if err := verifier.sender.SendVerifyCode(ctx, mobile, code); err != nil {
+		// Let the user retry right away, nothing has been sent
+		verifier.codes.Delete(mobile)
+		verifier.codes.Delete(reqIP)
+
+		return err
+	}
+
+	log.Info().Str("mobile", mobile).Msg("Verification code sent")
+
+	return nil
+}
+
+// Verify checks the code entered for the mobile.
+func (verifier *smsVerifier) Verify(mobile string, name string, code string, reqIP string) error {
+	cached, found := verifier.codes.Get(mobile)
+	if !found {
+		return errSMSCodeNotFound
+	}
+
+	userReg, ok := cached.(UserReg)
+	if !ok || userReg.SMSCode != code || userReg.Name != name {
+		return errSMSCodeMismatch
+	}
+
+	if userReg.reqIP != reqIP {
+		return errSMSCodeIPMismatch
+	}
+
+	return nil
+}
+
+// Forget drops the code of a mobile once it has been used.
+func (verifier *smsVerifier) Forget(mobile string) {
+	verifier.codes.Delete(mobile)
+}
@@ -0,0 +1,185 @@
+package headscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSMSSender struct {
+	sent map[string]string
+	err  error
+}
+
+func (sender *fakeSMSSender) SendVerifyCode(ctx context.Context, mobile string, code string) error {
+	if sender.err != nil {
+		return sender.err
+	}
+	sender.sent[mobile] = code
+
+	return nil
+}
+
+func Test_generateVerifyCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := generateVerifyCode()
+		if err != nil {
+			t.Fatalf("generateVerifyCode() error = %v", err)
+		}
+		if len(code) != verifyCodeDigits || code[0] == '0' {
+			t.Fatalf("generateVerifyCode() = %q, want %d digits not starting with 0", code, verifyCodeDigits)
+		}
+	}
+}
+
+func Test_smsVerifier(t *testing.T) {
+	sender := &fakeSMSSender{sent: map[string]string{}}
+	verifier := newSMSVerifier(sender, time.Minute, time.Minute)
+	verifier.generateCode = func() (string, error) { return "123456", nil }
+	ctx := context.Background()
+
+	if err := verifier.Send(ctx, "13800000000", "张三", "10.0.0.1"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if sender.sent["13800000000"] != "123456" {
+		t.Fatalf("Send() sent %q, want 123456", sender.sent["13800000000"])
+	}
+
+	var throttled *SMSThrottledError
+	err := verifier.Send(ctx, "13800000000", "张三", "10.0.0.2")
+	if !errors.As(err, &throttled) || !throttled.ByMobile {
+		t.Errorf("Send() to the same mobile error = %v, want throttled by mobile", err)
+	}
+	err = verifier.Send(ctx, "13900000000", "李四", "10.0.0.1")
+	if !errors.As(err, &throttled) || throttled.ByMobile {
+		t.Errorf("Send() from the same address error = %v, want throttled by address", err)
+	}
+
+	tests := []struct {
+		name    string
+		mobile  string
+		user    string
+		code    string
+		reqIP   string
+		wantErr error
+	}{
+		{"unknown mobile", "13900000000", "李四", "123456", "10.0.0.1", errSMSCodeNotFound},
+		{"wrong code", "13800000000", "张三", "654321", "10.0.0.1", errSMSCodeMismatch},
+		{"wrong name", "13800000000", "李四", "123456", "10.0.0.1", errSMSCodeMismatch},
+		{"other address", "13800000000", "张三", "123456", "10.0.0.2", errSMSCodeIPMismatch},
+		{"valid", "13800000000", "张三", "123456", "10.0.0.1", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifier.Verify(test.mobile, test.user, test.code, test.reqIP)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	verifier.Forget("13800000000")
+	if err := verifier.Verify("13800000000", "张三", "123456", "10.0.0.1"); !errors.Is(err, errSMSCodeNotFound) {
+		t.Errorf("Verify() after Forget() error = %v, want %v", err, errSMSCodeNotFound)
+	}
+}
+
+func Test_smsVerifierSendFailure(t *testing.T) {
+	sender := &fakeSMSSender{sent: map[string]string{}, err: errSMSWebhookFailed}
+	verifier := newSMSVerifier(sender, time.Minute, time.Minute)
+	ctx := context.Background()
+
+	if err := verifier.Send(ctx, "13800000000", "张三", "10.0.0.1"); !errors.Is(err, errSMSWebhookFailed) {
+		t.Fatalf("Send() error = %v, want %v", err, errSMSWebhookFailed)
+	}
+
+	// A failed send does not throttle the retry
+	sender.err = nil
+	if err := verifier.Send(ctx, "13800000000", "张三", "10.0.0.1"); err != nil {
+		t.Errorf("Send() retry error = %v", err)
+	}
+}
+
+func Test_webhookSMSSender(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+		if received["mobile"] == "13900000000" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	sender := &webhookSMSSender{url: server.URL}
+	if err := sender.SendVerifyCode(context.Background(), "13800000000", "123456"); err != nil {
+		t.Fatalf("SendVerifyCode() error = %v", err)
+	}
+	if received["mobile"] != "13800000000" || received["code"] != "123456" {
+		t.Errorf("webhook received %v", received)
+	}
+
+	err := sender.SendVerifyCode(context.Background(), "13900000000", "123456")
+	if !errors.Is(err, errSMSWebhookFailed) {
+		t.Errorf("SendVerifyCode() error = %v, want %v", err, errSMSWebhookFailed)
+	}
+}
+
+func Test_fileSMSSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	sender := &fileSMSSender{path: path}
+
+	for _, mobile := range []string{"13800000000", "13900000000"} {
+		if err := sender.SendVerifyCode(context.Background(), mobile, "123456"); err != nil {
+			t.Fatalf("SendVerifyCode() error = %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], " 13900000000 123456") {
+		t.Errorf("sms log = %q", content)
+	}
+}
+
+func Test_newSMSSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		sms     SMSConfig
+		want    SMSSender
+		wantErr bool
+	}{
+		{"aliyun", SMSConfig{Provider: SMSProviderAliyun}, &aliyunSMSSender{}, false},
+		{"webhook", SMSConfig{Provider: SMSProviderWebhook, WebhookURL: "http://sms"}, &webhookSMSSender{url: "http://sms"}, false},
+		{"webhook without url", SMSConfig{Provider: SMSProviderWebhook}, nil, true},
+		{"file", SMSConfig{Provider: SMSProviderFile, LogPath: "/tmp/sms.log"}, &fileSMSSender{path: "/tmp/sms.log"}, false},
+		{"file without path", SMSConfig{Provider: SMSProviderFile}, nil, true},
+		{"unknown", SMSConfig{Provider: "carrier-pigeon"}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := newSMSSender(&Config{SMS: test.sms})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("newSMSSender() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("newSMSSender() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
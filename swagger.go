@@ -48,6 +48,11 @@ func SwaggerUI(
 	</body>
 </html>`))
 
+	// Swagger UI is loaded from unpkg and started by an inline script
+	writer.Header().Set("Content-Security-Policy",
+		"default-src 'self'; script-src 'self' 'unsafe-inline' https://unpkg.com; "+
+			"style-src 'self' 'unsafe-inline' https://unpkg.com; img-src 'self' data:; frame-ancestors 'none'")
+
 	var payload bytes.Buffer
 	if err := swaggerTemplate.Execute(&payload, struct{}{}); err != nil {
 		log.Error().
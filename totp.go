@@ -0,0 +1,84 @@
+package headscale
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpSecretSize = 20
+	totpDigits     = 6
+	totpPeriod     = 30 * time.Second
+	// totpSkew is the number of periods accepted before and after now,
+	// to allow for clock drift of the device
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a new base32 encoded TOTP secret.
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpURI returns the otpauth:// URI shown as a QR code to enroll
+// an authenticator app.
+func totpURI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// totpCode computes the RFC 6238 code of a counter.
+func totpCode(key []byte, counter uint64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// validateTOTP checks a code against the secret at the given time.
+func validateTOTP(secret string, code string, now time.Time) bool {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	counter := now.Unix() / int64(totpPeriod/time.Second)
+	for delta := int64(-totpSkew); delta <= totpSkew; delta++ {
+		expected := totpCode(key, uint64(counter+delta))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
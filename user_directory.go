@@ -0,0 +1,269 @@
+package headscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	eiam_developerapi20220225 "github.com/alibabacloud-go/eiam-developerapi-20220225/v2/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+const (
+	errUnknownUserDirectory     = Error("unknown user directory")
+	errDirectoryUserNotFound    = Error("user not found in the directory")
+	errIDaaSNoToken             = Error("IDaaS returned no access token")
+	errIDaaSDisableNotSupported = Error("IDaaS developer API cannot disable users")
+
+	UserDirectoryIDaaS = "idaas"
+	UserDirectoryLocal = "local"
+)
+
+// DirectoryUser is a user as known by a UserDirectory.
+type DirectoryUser struct {
+	ID     string
+	Name   string
+	Mobile string
+}
+
+// UserDirectory keeps the accounts of the self-registration.
+// LookupUser returns errDirectoryUserNotFound for unknown mobiles.
+// UserGroups returns the groups of the user with the ID given by the
+// identity provider of the directory, for the group:oidc: ACL aliases.
+type UserDirectory interface {
+	CreateUser(ctx context.Context, displayName string, mobile string) (*DirectoryUser, error)
+	LookupUser(ctx context.Context, mobile string) (*DirectoryUser, error)
+	DisableUser(ctx context.Context, mobile string) error
+	UserGroups(ctx context.Context, userID string) ([]string, error)
+}
+
+// newUserDirectory returns the directory chosen by the user_directory
+// setting. When it is empty, IDaaS is used if ali_instance is set.
+func newUserDirectory(h *Headscale) (UserDirectory, error) {
+	kind := h.cfg.UserDirectory
+	if kind == "" {
+		kind = UserDirectoryLocal
+		if h.cfg.ali_IDaaS.ali_instance != "" {
+			kind = UserDirectoryIDaaS
+		}
+	}
+
+	switch kind {
+	case UserDirectoryIDaaS:
+		return &idaasDirectory{cfg: h.cfg.ali_IDaaS}, nil
+	case UserDirectoryLocal:
+		return &localDirectory{h: h}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownUserDirectory, kind)
+	}
+}
+
+// idaasDirectory creates the accounts in Aliyun IDaaS, users then log in
+// through the aliyun identity provider.
+type idaasDirectory struct {
+	cfg ALIConfig
+}
+
+func CreateIDaaSClient() (_result *eiam_developerapi20220225.Client, _err error) {
+	// 支持匿名访问的 API，不需要 AccessKey ID 等鉴权配置
+	config := &openapi.Config{}
+	// 访问的域名
+	config.Endpoint = tea.String("eiam-developerapi.cn-hangzhou.aliyuncs.com")
+	//_result = &eiam_developerapi20220225.Client{}
+	_result, _err = eiam_developerapi20220225.NewClient(config)
+	return _result, _err
+}
+
+// token gets a bearer token of the IDaaS application.
+func (directory *idaasDirectory) token(client *eiam_developerapi20220225.Client) (string, error) {
+	res, err := client.GenerateTokenWithOptions(
+		&directory.cfg.ali_instance,
+		&directory.cfg.ali_app_id,
+		&eiam_developerapi20220225.GenerateTokenRequest{
+			ClientId:     &directory.cfg.ali_cli_id,
+			ClientSecret: &directory.cfg.ali_cli_key,
+			GrantType:    tea.String("client_credentials"),
+		},
+		map[string]*string{},
+		&util.RuntimeOptions{},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to get IDaaS token: %w", err)
+	}
+	if res == nil || res.Body == nil || res.Body.AccessToken == nil {
+		return "", errIDaaSNoToken
+	}
+
+	return "Bearer " + *res.Body.AccessToken, nil
+}
+
+func (directory *idaasDirectory) CreateUser(
+	ctx context.Context,
+	displayName string,
+	mobile string,
+) (*DirectoryUser, error) {
+	client, err := CreateIDaaSClient()
+	if err != nil {
+		return nil, err
+	}
+	bearerToken, err := directory.token(client)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.CreateUserWithOptions(
+		&directory.cfg.ali_instance,
+		&directory.cfg.ali_app_id,
+		&eiam_developerapi20220225.CreateUserRequest{
+			Username:                    &mobile,
+			PhoneRegion:                 tea.String("86"),
+			PhoneNumber:                 &mobile,
+			PhoneNumberVerified:         tea.Bool(true),
+			DisplayName:                 &displayName,
+			PrimaryOrganizationalUnitId: &directory.cfg.ali_org_id,
+		},
+		&eiam_developerapi20220225.CreateUserHeaders{Authorization: &bearerToken},
+		&util.RuntimeOptions{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &DirectoryUser{Name: displayName, Mobile: mobile}
+	if res != nil && res.Body != nil {
+		user.ID = tea.StringValue(res.Body.UserId)
+	}
+
+	return user, nil
+}
+
+func (directory *idaasDirectory) LookupUser(ctx context.Context, mobile string) (*DirectoryUser, error) {
+	client, err := CreateIDaaSClient()
+	if err != nil {
+		return nil, err
+	}
+	bearerToken, err := directory.token(client)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.GetUserIdByPhoneNumberWithOptions(
+		&directory.cfg.ali_instance,
+		&directory.cfg.ali_app_id,
+		&eiam_developerapi20220225.GetUserIdByPhoneNumberRequest{PhoneNumber: &mobile},
+		&eiam_developerapi20220225.GetUserIdByPhoneNumberHeaders{Authorization: &bearerToken},
+		&util.RuntimeOptions{},
+	)
+	var sdkErr *tea.SDKError
+	if errors.As(err, &sdkErr) && tea.IntValue(sdkErr.StatusCode) == http.StatusNotFound {
+		return nil, errDirectoryUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res == nil || res.Body == nil || res.Body.UserId == nil {
+		return nil, errDirectoryUserNotFound
+	}
+
+	return &DirectoryUser{ID: *res.Body.UserId, Mobile: mobile}, nil
+}
+
+func (directory *idaasDirectory) DisableUser(ctx context.Context, mobile string) error {
+	return errIDaaSDisableNotSupported
+}
+
+// UserGroups returns the names of the organizational units of the user.
+func (directory *idaasDirectory) UserGroups(ctx context.Context, userID string) ([]string, error) {
+	client, err := CreateIDaaSClient()
+	if err != nil {
+		return nil, err
+	}
+	bearerToken, err := directory.token(client)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.GetUserWithOptions(
+		&directory.cfg.ali_instance,
+		&directory.cfg.ali_app_id,
+		&userID,
+		&eiam_developerapi20220225.GetUserHeaders{Authorization: &bearerToken},
+		&util.RuntimeOptions{},
+	)
+	var sdkErr *tea.SDKError
+	if errors.As(err, &sdkErr) && tea.IntValue(sdkErr.StatusCode) == http.StatusNotFound {
+		return nil, errDirectoryUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res == nil || res.Body == nil {
+		return nil, errDirectoryUserNotFound
+	}
+
+	groups := make([]string, 0, len(res.Body.OrganizationalUnits))
+	for _, unit := range res.Body.OrganizationalUnits {
+		if name := tea.StringValue(unit.OrganizationalUnitName); name != "" {
+			groups = append(groups, name)
+		}
+	}
+
+	return groups, nil
+}
+
+// localDirectory keeps the accounts in the headscale database,
+// the mobile is the user name.
+type localDirectory struct {
+	h *Headscale
+}
+
+func (directory *localDirectory) CreateUser(
+	ctx context.Context,
+	displayName string,
+	mobile string,
+) (*DirectoryUser, error) {
+	user, err := directory.h.CreateUser(mobile, "local:"+mobile, displayName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirectoryUser{
+		ID:     fmt.Sprint(user.ID),
+		Name:   user.Display_Name,
+		Mobile: user.Name,
+	}, nil
+}
+
+func (directory *localDirectory) LookupUser(ctx context.Context, mobile string) (*DirectoryUser, error) {
+	user, err := directory.h.GetUser(mobile)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, errDirectoryUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirectoryUser{
+		ID:     fmt.Sprint(user.ID),
+		Name:   user.Display_Name,
+		Mobile: user.Name,
+	}, nil
+}
+
+func (directory *localDirectory) DisableUser(ctx context.Context, mobile string) error {
+	err := directory.h.DisableUser(mobile)
+	if errors.Is(err, ErrUserNotFound) {
+		return errDirectoryUserNotFound
+	}
+
+	return err
+}
+
+// UserGroups returns no groups, the local accounts only have the ones
+// of their identity provider.
+func (directory *localDirectory) UserGroups(ctx context.Context, userID string) ([]string, error) {
+	return nil, nil
+}
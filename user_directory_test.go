@@ -0,0 +1,194 @@
+package headscale
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+type fakeUserDirectory struct {
+	users    map[string]*DirectoryUser
+	disabled map[string]bool
+	groups   map[string][]string
+}
+
+func newFakeUserDirectory() *fakeUserDirectory {
+	return &fakeUserDirectory{
+		users:    map[string]*DirectoryUser{},
+		disabled: map[string]bool{},
+		groups:   map[string][]string{},
+	}
+}
+
+func (directory *fakeUserDirectory) CreateUser(
+	ctx context.Context,
+	displayName string,
+	mobile string,
+) (*DirectoryUser, error) {
+	if _, ok := directory.users[mobile]; ok {
+		return nil, ErrUserExists
+	}
+	user := &DirectoryUser{ID: mobile, Name: displayName, Mobile: mobile}
+	directory.users[mobile] = user
+
+	return user, nil
+}
+
+func (directory *fakeUserDirectory) LookupUser(ctx context.Context, mobile string) (*DirectoryUser, error) {
+	user, ok := directory.users[mobile]
+	if !ok {
+		return nil, errDirectoryUserNotFound
+	}
+
+	return user, nil
+}
+
+func (directory *fakeUserDirectory) DisableUser(ctx context.Context, mobile string) error {
+	if _, ok := directory.users[mobile]; !ok {
+		return errDirectoryUserNotFound
+	}
+	directory.disabled[mobile] = true
+
+	return nil
+}
+
+func (directory *fakeUserDirectory) UserGroups(ctx context.Context, userID string) ([]string, error) {
+	return directory.groups[userID], nil
+}
+
+func (s *Suite) TestLocalUserDirectory(c *check.C) {
+	directory := &localDirectory{h: &app}
+	ctx := context.Background()
+
+	_, err := directory.LookupUser(ctx, "13800000000")
+	c.Assert(err, check.Equals, errDirectoryUserNotFound)
+
+	created, err := directory.CreateUser(ctx, "张三", "13800000000")
+	c.Assert(err, check.IsNil)
+	c.Assert(created.Mobile, check.Equals, "13800000000")
+
+	found, err := directory.LookupUser(ctx, "13800000000")
+	c.Assert(err, check.IsNil)
+	c.Assert(found.ID, check.Equals, created.ID)
+	c.Assert(found.Name, check.Equals, "张三")
+
+	user, err := app.GetUser("13800000000")
+	c.Assert(err, check.IsNil)
+	pak, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+	machine := Machine{
+		ID:         1,
+		MachineKey: "foo",
+		NodeKey:    "bar",
+		DiscoKey:   "faa",
+		Hostname:   "testmachine",
+		UserID:     user.ID,
+		AuthKeyID:  uint(pak.ID),
+	}
+	app.db.Save(&machine)
+
+	err = directory.DisableUser(ctx, "13800000000")
+	c.Assert(err, check.IsNil)
+
+	disabledMachine, err := app.GetMachineByID(machine.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(disabledMachine.isExpired(), check.Equals, true)
+
+	keys, err := app.ListPreAuthKeys(user.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	c.Assert(keys[0].Expiration.After(time.Now()), check.Equals, false)
+
+	_, err = app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.Equals, ErrUserDisabled)
+
+	err = directory.DisableUser(ctx, "13900000000")
+	c.Assert(err, check.Equals, errDirectoryUserNotFound)
+}
+
+func (s *Suite) TestRegisterUserAPI(c *check.C) {
+	sender := &fakeSMSSender{sent: map[string]string{}}
+	directory := newFakeUserDirectory()
+	app.smsVerifier = newSMSVerifier(sender, time.Minute, time.Minute)
+	app.userDirectory = directory
+
+	register := func(data map[string]string) APIResponse {
+		body, err := json.Marshal(data)
+		c.Assert(err, check.IsNil)
+		req := httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(string(body)))
+		req.RemoteAddr = "10.0.0.1"
+		recorder := httptest.NewRecorder()
+		app.RegisterUserAPI(recorder, req)
+
+		res := APIResponse{}
+		c.Assert(json.NewDecoder(recorder.Body).Decode(&res), check.IsNil)
+
+		return res
+	}
+
+	res := register(map[string]string{"mobile": "13800000000", "name": "张三"})
+	c.Assert(res.Status, check.Equals, "success")
+	code := sender.sent["13800000000"]
+	c.Assert(code, check.Not(check.Equals), "")
+
+	res = register(map[string]string{"mobile": "13800000000", "name": "张三", "verifyCode": code})
+	c.Assert(res.Status, check.Equals, "success")
+	c.Assert(directory.users["13800000000"].Name, check.Equals, "张三")
+
+	// A registered mobile gets no more codes
+	res = register(map[string]string{"mobile": "13800000000", "name": "张三"})
+	c.Assert(strings.HasPrefix(res.Status, "error-"), check.Equals, true)
+}
+
+func (s *Suite) TestRegisterLocalUserAPI(c *check.C) {
+	sender := &fakeSMSSender{sent: map[string]string{}}
+	app.smsVerifier = newSMSVerifier(sender, time.Minute, time.Minute)
+	app.userDirectory = &localDirectory{h: &app}
+	defer func() { app.cfg.LocalAccounts.Enabled = false }()
+
+	register := func(data map[string]string) APIResponse {
+		body, err := json.Marshal(data)
+		c.Assert(err, check.IsNil)
+		req := httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(string(body)))
+		req.RemoteAddr = "10.0.0.1"
+		recorder := httptest.NewRecorder()
+		app.RegisterUserAPI(recorder, req)
+
+		res := APIResponse{}
+		c.Assert(json.NewDecoder(recorder.Body).Decode(&res), check.IsNil)
+
+		return res
+	}
+
+	// Local accounts must be enabled, or the new user could never log in
+	res := register(map[string]string{"mobile": "13800000000", "name": "张三", "password": "correct horse"})
+	c.Assert(strings.HasPrefix(res.Status, "error-"), check.Equals, true)
+	c.Assert(sender.sent, check.HasLen, 0)
+
+	app.cfg.LocalAccounts.Enabled = true
+
+	res = register(map[string]string{"mobile": "13800000000", "name": "张三", "password": "short"})
+	c.Assert(strings.HasPrefix(res.Status, "error-"), check.Equals, true)
+	c.Assert(sender.sent, check.HasLen, 0)
+
+	res = register(map[string]string{"mobile": "13800000000", "name": "张三", "password": "correct horse"})
+	c.Assert(res.Status, check.Equals, "success")
+	code := sender.sent["13800000000"]
+
+	res = register(map[string]string{
+		"mobile":     "13800000000",
+		"name":       "张三",
+		"password":   "correct horse",
+		"verifyCode": code,
+	})
+	c.Assert(res.Status, check.Equals, "success")
+
+	user, err := app.AuthenticateLocalAccount("13800000000", "correct horse", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(user.Display_Name, check.Equals, "张三")
+}
@@ -11,7 +11,6 @@ import (
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"github.com/rs/zerolog/log"
-	"github.com/spf13/viper"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 	"tailscale.com/tailcfg"
@@ -23,6 +22,10 @@ const (
 	ErrUserNotFound      = Error("User not found")
 	ErrUserStillHasNodes = Error("User not empty: node(s) found")
 	ErrInvalidUserName   = Error("Invalid user name")
+	ErrUserTransferSelf  = Error("Cannot transfer machines to the deleted user")
+	ErrUserDeleteModes   = Error("Cannot both transfer and delete machines")
+	ErrUserDisabled      = Error("User is disabled")
+	ErrServiceAccount    = Error("Service accounts cannot log in")
 )
 
 const (
@@ -43,9 +46,39 @@ type User struct {
 	Display_Name   string `gorm:"unique"`
 	ExpiryDuration uint   `gorm:"default:180"`
 	EnableMagic    bool   `gorm:"default:false"`
-	OverrideLocal  bool   `gorm:"default:false"`
-	Nameservers    StringList
-	SplitDns       SplitDNS
+	// MagicDNS settings overriding those of the organization, see magic_dns.go
+	BaseDomain    string
+	OmitUserLabel *bool
+	OverrideLocal bool `gorm:"default:false"`
+	Nameservers   StringList
+	SplitDns      SplitDNS
+	Email         string
+	Disabled      bool
+
+	// ServiceAccount users only own API keys and preauth keys, for the
+	// automations, and cannot log in
+	ServiceAccount bool
+
+	// Groups of the identity at the last login, the group:oidc: ACL aliases
+	Groups StringList
+
+	// Organization whose tailnet the machines of the user join
+	OrganizationID uint `gorm:"index"`
+
+	// Role in the organization, the configured default when empty, see roles.go
+	Role       string
+	RoleSynced bool
+
+	// Quotas overriding the global defaults, see UserQuota
+	MaxMachines            *uint
+	MaxReusablePreAuthKeys *uint
+	MaxRoutes              *uint
+
+	// Credentials of the local account, see local_accounts.go
+	PasswordHash       []byte
+	TOTPSecret         string
+	TOTPEnabled        bool
+	RecoveryCodeHashes StringList
 }
 
 // CreateUser creates a new User. Returns error if could not be created
@@ -61,6 +94,7 @@ func (h *Headscale) CreateUser(name string, UID string, DisName string) (*User,
 	}
 	user.Name = name
 	user.OIDC_UID = UID
+	user.OrganizationID = h.defaultOrganizationID
 	user.ExpiryDuration = 180
 	user.Display_Name = DisName
 	if err := h.db.Create(&user).Error; err != nil {
@@ -78,37 +112,291 @@ func (h *Headscale) CreateUser(name string, UID string, DisName string) (*User,
 // DestroyUser destroys a User. Returns error if the User does
 // not exist or if there are machines associated with it.
 func (h *Headscale) DestroyUser(name string) error {
+	_, err := h.DeleteUser(name, "", false)
+
+	return err
+}
+
+// DisableUser prevents a User from logging in and from registering new
+// machines. Its machines and preauth keys are expired and its console
+// sessions are revoked.
+func (h *Headscale) DisableUser(name string) error {
 	user, err := h.GetUser(name)
 	if err != nil {
-		return ErrUserNotFound
+		return err
+	}
+
+	if err := h.db.Model(user).Update("disabled", true).Error; err != nil {
+		return fmt.Errorf("failed to disable user in the database: %w", err)
 	}
 
 	machines, err := h.ListMachinesByUser(name)
 	if err != nil {
 		return err
 	}
-	if len(machines) > 0 {
-		return ErrUserStillHasNodes
+	for index := range machines {
+		if machines[index].isExpired() {
+			continue
+		}
+		if err := h.ExpireMachine(&machines[index]); err != nil {
+			return err
+		}
 	}
 
 	keys, err := h.ListPreAuthKeys(name)
 	if err != nil {
 		return err
 	}
-	for _, key := range keys {
-		err = h.DestroyPreAuthKey(key)
-		if err != nil {
+	now := time.Now()
+	for index := range keys {
+		if keys[index].Expiration != nil && keys[index].Expiration.Before(now) {
+			continue
+		}
+		if err := h.ExpirePreAuthKey(&keys[index]); err != nil {
 			return err
 		}
 	}
 
-	if result := h.db.Unscoped().Delete(&user); result.Error != nil {
-		return result.Error
+	if _, err := h.RevokeUserConsoleSessions(name); err != nil {
+		return err
 	}
 
+	log.Info().Str("user", name).Msg("User disabled")
+
 	return nil
 }
 
+// SetUserServiceAccount makes a User a service account, its console
+// sessions are revoked as it cannot log in anymore.
+func (h *Headscale) SetUserServiceAccount(name string) (*User, error) {
+	user, err := h.GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.db.Model(user).Update("service_account", true).Error; err != nil {
+		return nil, fmt.Errorf("failed to update user in the database: %w", err)
+	}
+	user.ServiceAccount = true
+	if _, err := h.RevokeUserConsoleSessions(name); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// EnableUser lets a disabled User log in again. Its expired machines have
+// to log in again to be usable.
+func (h *Headscale) EnableUser(name string) error {
+	user, err := h.GetUser(name)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Model(user).Update("disabled", false).Error; err != nil {
+		return fmt.Errorf("failed to enable user in the database: %w", err)
+	}
+
+	log.Info().Str("user", name).Msg("User enabled")
+
+	return nil
+}
+
+// DeleteUserReport lists what has been moved or removed by DeleteUser.
+type DeleteUserReport struct {
+	TransferredMachines    []uint64
+	DeletedMachines        []uint64
+	DeletedRoutes          []uint64
+	TransferredPreAuthKeys []uint64
+	DeletedPreAuthKeys     []uint64
+}
+
+// DeleteUser deletes a User in a single transaction. Its machines are moved
+// to the transferTo User, or deleted along with their routes if deleteMachines
+// is set. Without any of them, the User must not have machines anymore.
+// Preauth keys follow the machines: they are moved to transferTo and expired,
+// so that they can still be traced but not used anymore, or deleted.
+// The last owner of an organization cannot be deleted.
+func (h *Headscale) DeleteUser(
+	name string,
+	transferTo string,
+	deleteMachines bool,
+) (*DeleteUserReport, error) {
+	user, err := h.GetUser(name)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	last, err := h.isLastOwner(user)
+	if err != nil {
+		return nil, err
+	}
+	if last {
+		return nil, ErrLastOwner
+	}
+
+	if transferTo != "" && deleteMachines {
+		return nil, ErrUserDeleteModes
+	}
+
+	var target *User
+	if transferTo != "" {
+		if transferTo == name {
+			return nil, ErrUserTransferSelf
+		}
+		target, err = h.GetUser(transferTo)
+		if err != nil {
+			return nil, err
+		}
+		if target.OrganizationID != user.OrganizationID {
+			return nil, ErrOrganizationMismatch
+		}
+	}
+
+	machines, err := h.ListMachinesByUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(machines) > 0 && target == nil && !deleteMachines {
+		return nil, ErrUserStillHasNodes
+	}
+
+	keys, err := h.ListPreAuthKeys(name)
+	if err != nil {
+		return nil, err
+	}
+
+	report := DeleteUserReport{
+		TransferredMachines:    []uint64{},
+		DeletedMachines:        []uint64{},
+		DeletedRoutes:          []uint64{},
+		TransferredPreAuthKeys: []uint64{},
+		DeletedPreAuthKeys:     []uint64{},
+	}
+
+	machineIDs := make([]uint64, len(machines))
+	for index, machine := range machines {
+		machineIDs[index] = machine.ID
+	}
+	keyIDs := make([]uint64, len(keys))
+	for index, key := range keys {
+		keyIDs[index] = key.ID
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if target != nil {
+			if err := tx.Model(&Machine{}).
+				Where("user_id = ?", user.ID).
+				Update("user_id", target.ID).Error; err != nil {
+				return err
+			}
+			report.TransferredMachines = machineIDs
+
+			if err := tx.Model(&PreAuthKey{}).
+				Where("user_id = ?", user.ID).
+				Updates(map[string]interface{}{
+					"user_id":    target.ID,
+					"expiration": time.Now().UTC(),
+				}).Error; err != nil {
+				return err
+			}
+			report.TransferredPreAuthKeys = keyIDs
+		} else {
+			if len(machineIDs) > 0 {
+				routes := []Route{}
+				if err := tx.Where("machine_id IN ?", machineIDs).
+					Find(&routes).Error; err != nil {
+					return err
+				}
+				for _, route := range routes {
+					report.DeletedRoutes = append(report.DeletedRoutes, uint64(route.ID))
+				}
+
+				if err := tx.Unscoped().
+					Where("machine_id IN ?", machineIDs).
+					Delete(&Route{}).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Unscoped().
+					Where("user_id = ?", user.ID).
+					Delete(&Machine{}).Error; err != nil {
+					return err
+				}
+				report.DeletedMachines = machineIDs
+			}
+
+			if len(keyIDs) > 0 {
+				if err := tx.Unscoped().
+					Where("pre_auth_key_id IN ?", keyIDs).
+					Delete(&PreAuthKeyACLTag{}).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Unscoped().
+					Where("user_id = ?", user.ID).
+					Delete(&PreAuthKey{}).Error; err != nil {
+					return err
+				}
+				report.DeletedPreAuthKeys = keyIDs
+			}
+		}
+
+		if err := tx.Unscoped().
+			Where("user_id = ?", user.ID).
+			Delete(&UserIdentity{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.
+			Where("user_id = ?", user.ID).
+			Delete(&ConsoleSession{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().
+			Where("user_id = ?", user.ID).
+			Delete(&APIKey{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.
+			Where("user_id = ?", user.ID).
+			Delete(&OAuthClient{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, machine := range machines {
+		if target != nil {
+			h.recordMachineHistory(machine.ID, MachineHistoryUserChanged, name, target.Name)
+		} else {
+			h.recordMachineHistory(machine.ID, MachineHistoryDeleted, machine.GivenName, "")
+		}
+	}
+
+	if len(machines) > 0 {
+		if err := h.UpdateACLRules(); err != nil && !errors.Is(err, errEmptyPolicy) {
+			log.Error().Err(err).Msg("Failed to update ACL rules after deleting user")
+		}
+
+		if len(report.DeletedRoutes) > 0 {
+			if err := h.handlePrimarySubnetFailover(); err != nil {
+				log.Error().Err(err).Msg("Failed to fail over routes after deleting user")
+			}
+		}
+
+		h.setLastStateChangeToNow()
+	}
+
+	return &report, nil
+}
+
 // Update User's node key expiry duration.
 func (h *Headscale) UpdateUserKeyExpiry(name string, newDuration uint) error {
 	var err error
@@ -124,6 +412,17 @@ func (h *Headscale) UpdateUserKeyExpiry(name string, newDuration uint) error {
 	return nil
 }
 
+// SetUserEmail stores the email address the user can be notified at.
+func (h *Headscale) SetUserEmail(user *User, email string) error {
+	user.Email = email
+
+	if result := h.db.Save(user); result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
 // RenameUser renames a User. Returns error if the User does
 // not exist or if another User exists with the new name.
 func (h *Headscale) RenameUser(oldName, newName string) error {
@@ -205,11 +504,20 @@ func (h *Headscale) SetMachineUser(machine *Machine, username string) error {
 	if err != nil {
 		return err
 	}
+	// The address of the machine belongs to the prefixes of its organization
+	if user.OrganizationID != h.machineOrganizationID(machine) {
+		return ErrOrganizationMismatch
+	}
+	oldUserName := machine.User.Name
 	machine.User = *user
 	if result := h.db.Save(&machine); result.Error != nil {
 		return result.Error
 	}
 
+	if oldUserName != user.Name {
+		h.recordMachineHistory(machine.ID, MachineHistoryUserChanged, oldUserName, user.Name)
+	}
+
 	return nil
 }
 
@@ -272,12 +580,27 @@ func (h *Headscale) getMapResponseUserProfiles(
 
 func (n *User) toProto() *v1.User {
 	return &v1.User{
-		Id:        strconv.FormatUint(uint64(n.ID), Base10),
-		Name:      n.Name,
-		CreatedAt: timestamppb.New(n.CreatedAt),
+		Id:             strconv.FormatUint(uint64(n.ID), Base10),
+		Name:           n.Name,
+		CreatedAt:      timestamppb.New(n.CreatedAt),
+		Role:           n.Role,
+		ServiceAccount: n.ServiceAccount,
+		BaseDomain:     n.BaseDomain,
+		OmitUserLabel:  n.OmitUserLabel,
 	}
 }
 
+// userToProto also fills the role of users left with the default one.
+func (h *Headscale) userToProto(user *User) *v1.User {
+	protoUser := user.toProto()
+	protoUser.Role = string(h.userRole(user))
+	if organization, err := h.getOrganizationByID(user.OrganizationID); err == nil {
+		protoUser.Organization = organization.Name
+	}
+
+	return protoUser
+}
+
 // NormalizeToFQDNRules will replace forbidden chars in user
 // it can also return an error if the user doesn't respect RFC 952 and 1123.
 func NormalizeToFQDNRules(name string, stripEmailDomain bool) (string, error) {
@@ -330,8 +653,8 @@ func CheckForFQDNRules(name string) error {
 	return nil
 }
 
-// 增加User独立配置的DNS设置读取
-func (me *User) GetDNSConfig(ipPrefixesCfg []netip.Prefix) (*tailcfg.DNSConfig, string) {
+// 增加User独立配置的DNS设置读取，幻域基础域名见magic_dns.go
+func (me *User) GetDNSConfig(ipPrefixesCfg []netip.Prefix) *tailcfg.DNSConfig {
 	dnsConfig := &tailcfg.DNSConfig{}
 
 	nameserversStr := me.Nameservers
@@ -409,20 +732,8 @@ func (me *User) GetDNSConfig(ipPrefixesCfg []netip.Prefix) (*tailcfg.DNSConfig,
 			}
 		}*/
 
-	//cgao6: TODO
-	if viper.IsSet("dns_config.extra_records") {
-		var extraRecords []tailcfg.DNSRecord
-
-		err := viper.UnmarshalKey("dns_config.extra_records", &extraRecords)
-		if err != nil {
-			log.Error().
-				Str("func", "getDNSConfig").
-				Err(err).
-				Msgf("Could not parse dns_config.extra_records")
-		}
-
-		dnsConfig.ExtraRecords = extraRecords
-	}
+	// The extra records of the tailnet are added by getMapResponseDNSConfig,
+	// see dns_records.go
 
 	dnsConfig.Proxied = me.EnableMagic
 
@@ -437,14 +748,7 @@ func (me *User) GetDNSConfig(ipPrefixesCfg []netip.Prefix) (*tailcfg.DNSConfig,
 		}
 	}
 
-	var baseDomain string
-	if viper.IsSet("dns_config.base_domain") {
-		baseDomain = viper.GetString("dns_config.base_domain")
-	} else {
-		baseDomain = "headscale.net" // does not really matter when MagicDNS is not enabled
-	}
-
-	return dnsConfig, baseDomain
+	return dnsConfig
 }
 
 func (h *Headscale) UpdateDNSConfig(userName string, newDNSCfg DNSData) error {
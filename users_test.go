@@ -37,7 +37,7 @@ func (s *Suite) TestDestroyUserErrors(c *check.C) {
 	err = app.DestroyUser("test")
 	c.Assert(err, check.IsNil)
 
-	result := app.db.Preload("User").First(&pak, "key = ?", pak.Key)
+	result := app.db.Preload("User").First(&pak, "id = ?", pak.ID)
 	// destroying a user also deletes all associated preauthkeys
 	c.Assert(result.Error, check.Equals, gorm.ErrRecordNotFound)
 
@@ -413,3 +413,100 @@ func (s *Suite) TestSetMachineUser(c *check.C) {
 	c.Assert(machine.UserID, check.Equals, newUser.ID)
 	c.Assert(machine.User.Name, check.Equals, newUser.Name)
 }
+
+func (s *Suite) TestDeleteUserTransferMachines(c *check.C) {
+	user, err := app.CreateUser("leaver", "leaver", "leaver")
+	c.Assert(err, check.IsNil)
+
+	target, err := app.CreateUser("keeper", "keeper", "keeper")
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	machine := Machine{
+		ID:             0,
+		MachineKey:     "foo",
+		NodeKey:        "bar",
+		DiscoKey:       "faa",
+		Hostname:       "testmachine",
+		UserID:         user.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+		AuthKeyID:      uint(pak.ID),
+	}
+	app.db.Save(&machine)
+
+	_, err = app.DeleteUser(user.Name, "", false)
+	c.Assert(err, check.Equals, ErrUserStillHasNodes)
+
+	_, err = app.DeleteUser(user.Name, target.Name, true)
+	c.Assert(err, check.Equals, ErrUserDeleteModes)
+
+	_, err = app.DeleteUser(user.Name, user.Name, false)
+	c.Assert(err, check.Equals, ErrUserTransferSelf)
+
+	report, err := app.DeleteUser(user.Name, target.Name, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.TransferredMachines, check.DeepEquals, []uint64{machine.ID})
+	c.Assert(report.TransferredPreAuthKeys, check.DeepEquals, []uint64{pak.ID})
+	c.Assert(report.DeletedMachines, check.HasLen, 0)
+
+	_, err = app.GetUser(user.Name)
+	c.Assert(err, check.Equals, ErrUserNotFound)
+
+	machines, err := app.ListMachinesByUser(target.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(machines, check.HasLen, 1)
+
+	// The transferred keys are kept for reference, but cannot be used anymore
+	keys, err := app.ListPreAuthKeys(target.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	_, err = app.checkKeyValidity(pak.Key)
+	c.Assert(err, check.Equals, ErrPreAuthKeyExpired)
+}
+
+func (s *Suite) TestDeleteUserDeleteMachines(c *check.C) {
+	user, err := app.CreateUser("test", "", "")
+	c.Assert(err, check.IsNil)
+
+	pak, err := app.CreatePreAuthKey(user.Name, false, false, nil, []string{"tag:test"})
+	c.Assert(err, check.IsNil)
+
+	machine := Machine{
+		ID:             0,
+		MachineKey:     "foo",
+		NodeKey:        "bar",
+		DiscoKey:       "faa",
+		Hostname:       "testmachine",
+		UserID:         user.ID,
+		RegisterMethod: RegisterMethodAuthKey,
+		AuthKeyID:      uint(pak.ID),
+	}
+	app.db.Save(&machine)
+
+	route := Route{
+		MachineID:  machine.ID,
+		Prefix:     IPPrefix(netip.MustParsePrefix("10.0.0.0/24")),
+		Advertised: true,
+	}
+	app.db.Save(&route)
+
+	report, err := app.DeleteUser(user.Name, "", true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DeletedMachines, check.DeepEquals, []uint64{machine.ID})
+	c.Assert(report.DeletedRoutes, check.DeepEquals, []uint64{uint64(route.ID)})
+	c.Assert(report.DeletedPreAuthKeys, check.DeepEquals, []uint64{pak.ID})
+	c.Assert(report.TransferredMachines, check.HasLen, 0)
+
+	_, err = app.GetMachineByID(machine.ID)
+	c.Assert(err, check.NotNil)
+
+	routes, err := app.GetRoutes()
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 0)
+
+	var tags int64
+	app.db.Model(&PreAuthKeyACLTag{}).Count(&tags)
+	c.Assert(tags, check.Equals, int64(0))
+}
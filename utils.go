@@ -140,9 +140,14 @@ func decode(
 }
 
 func (h *Headscale) getAvailableIPs() (MachineAddresses, error) {
+	return h.getAvailableIPsIn(h.cfg.IPPrefixes)
+}
+
+// getAvailableIPsIn returns a free address in each of the ipPrefixes,
+// the ones of the Organization of the machine.
+func (h *Headscale) getAvailableIPsIn(ipPrefixes []netip.Prefix) (MachineAddresses, error) {
 	var ips MachineAddresses
 	var err error
-	ipPrefixes := h.cfg.IPPrefixes
 	for _, ipPrefix := range ipPrefixes {
 		var ip *netip.Addr
 		ip, err = h.getAvailableIP(ipPrefix)